@@ -0,0 +1,40 @@
+-- Grading policies, their bands and where they're assigned.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS grading_policies (
+    id                    BIGSERIAL PRIMARY KEY,
+    name                  TEXT             NOT NULL,
+    most_percent          DOUBLE PRECISION NOT NULL,
+    drop_worst_score      BOOLEAN          NOT NULL,
+    drop_worst_min_scores BIGINT           NOT NULL,
+    inserted_at           TIMESTAMPTZ      NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS grading_policy_bands (
+    grading_policy_id  BIGINT           NOT NULL REFERENCES grading_policies (id) ON DELETE CASCADE,
+    grade              TEXT             NOT NULL,
+    rank               SMALLINT         NOT NULL,
+    most_above         DOUBLE PRECISION NOT NULL,
+    all_above          DOUBLE PRECISION NOT NULL,
+    gpa_value          DOUBLE PRECISION NOT NULL,
+    subgrade_gpa_value DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (grading_policy_id, grade)
+);
+
+-- an assignment is to exactly one scope, and each scope has at most one assignment
+CREATE TABLE IF NOT EXISTS grading_policy_assignments (
+    id                 BIGSERIAL PRIMARY KEY,
+    grading_policy_id  BIGINT      NOT NULL REFERENCES grading_policies (id) ON DELETE CASCADE,
+    root_account_id    BIGINT UNIQUE,
+    enrollment_term_id BIGINT UNIQUE,
+    course_id          BIGINT UNIQUE,
+    inserted_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK (num_nonnulls(root_account_id, enrollment_term_id, course_id) = 1)
+);
+
+-- policies are picked by a course's term and root account
+ALTER TABLE courses ADD COLUMN IF NOT EXISTS enrollment_term_id BIGINT;
+ALTER TABLE courses ADD COLUMN IF NOT EXISTS root_account_id BIGINT;
+
+COMMIT;
@@ -0,0 +1,14 @@
+-- Course weights for weighted GPAs. A weight is for a course ID or for course codes matching a pattern.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS course_weights (
+    id                  BIGSERIAL PRIMARY KEY,
+    course_id           BIGINT,
+    course_code_pattern TEXT,
+    weight              DOUBLE PRECISION NOT NULL,
+    inserted_at         TIMESTAMPTZ      NOT NULL DEFAULT NOW(),
+    CHECK (num_nonnulls(course_id, course_code_pattern) = 1)
+);
+
+COMMIT;
@@ -0,0 +1,30 @@
+-- Terms, and the final grades frozen at the end of each one.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS terms (
+    id                        BIGSERIAL PRIMARY KEY,
+    name                      TEXT        NOT NULL,
+    canvas_enrollment_term_id BIGINT      NOT NULL UNIQUE,
+    start_at                  TIMESTAMPTZ NOT NULL,
+    end_at                    TIMESTAMPTZ NOT NULL,
+    inserted_at               TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK (start_at < end_at)
+);
+
+CREATE TABLE IF NOT EXISTS final_grades (
+    id                 BIGSERIAL PRIMARY KEY,
+    term_id            BIGINT           NOT NULL REFERENCES terms (id),
+    canvas_user_id     BIGINT           NOT NULL,
+    course_id          BIGINT           NOT NULL,
+    grade              TEXT             NOT NULL,
+    gpa_value          DOUBLE PRECISION NOT NULL,
+    subgrade_gpa_value DOUBLE PRECISION NOT NULL,
+    weight             DOUBLE PRECISION NOT NULL,
+    inserted_at        TIMESTAMPTZ      NOT NULL DEFAULT NOW(),
+    UNIQUE (term_id, canvas_user_id, course_id)
+);
+
+CREATE INDEX IF NOT EXISTS final_grades_canvas_user_id_idx ON final_grades (canvas_user_id);
+
+COMMIT;
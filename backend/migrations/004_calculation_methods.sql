@@ -0,0 +1,12 @@
+-- Canvas outcome calculation methods, and the grading policy switch that respects them.
+
+BEGIN;
+
+ALTER TABLE grading_policies
+    ADD COLUMN IF NOT EXISTS calculation_method TEXT NOT NULL DEFAULT 'cbl_legacy';
+
+ALTER TABLE outcomes
+    ADD COLUMN IF NOT EXISTS calculation_method TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS calculation_int    BIGINT NOT NULL DEFAULT 0;
+
+COMMIT;
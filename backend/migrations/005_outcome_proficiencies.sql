@@ -0,0 +1,22 @@
+-- Canvas outcome proficiency ratings, cached per root account, and rating-based band thresholds.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS outcome_proficiency_ratings (
+    id              BIGSERIAL PRIMARY KEY,
+    root_account_id BIGINT           NOT NULL,
+    description     TEXT             NOT NULL,
+    points          DOUBLE PRECISION NOT NULL,
+    mastery         BOOLEAN          NOT NULL DEFAULT FALSE,
+    color           TEXT             NOT NULL DEFAULT '',
+    inserted_at     TIMESTAMPTZ      NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS outcome_proficiency_ratings_root_account_id_idx
+    ON outcome_proficiency_ratings (root_account_id);
+
+ALTER TABLE grading_policy_bands
+    ADD COLUMN IF NOT EXISTS most_above_rating TEXT,
+    ADD COLUMN IF NOT EXISTS all_above_rating  TEXT;
+
+COMMIT;
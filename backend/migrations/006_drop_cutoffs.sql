@@ -0,0 +1,16 @@
+-- Drop cutoffs, per enrollment term or course: after one starts, lowest scores aren't dropped anymore.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS drop_cutoffs (
+    id                 BIGSERIAL PRIMARY KEY,
+    name               TEXT        NOT NULL,
+    enrollment_term_id BIGINT,
+    course_id          BIGINT,
+    starts_at          TIMESTAMPTZ NOT NULL,
+    ends_at            TIMESTAMPTZ,
+    inserted_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK (num_nonnulls(enrollment_term_id, course_id) = 1)
+);
+
+COMMIT;
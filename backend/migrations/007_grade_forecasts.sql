@@ -0,0 +1,22 @@
+-- Grade drop forecast notifications.
+
+BEGIN;
+
+INSERT INTO notification_types (id, name, short_name, description)
+VALUES (2, 'Grade Drop Forecast', 'grade_drop_forecast',
+        'Sent when a grade is projected to drop by the end of the term.')
+ON CONFLICT (id) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS grade_forecast_notifications (
+    id              BIGSERIAL PRIMARY KEY,
+    canvas_user_id  BIGINT      NOT NULL,
+    course_id       BIGINT      NOT NULL,
+    grade           TEXT        NOT NULL,
+    projected_grade TEXT        NOT NULL,
+    inserted_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS grade_forecast_notifications_canvas_user_id_course_id_idx
+    ON grade_forecast_notifications (canvas_user_id, course_id, inserted_at DESC);
+
+COMMIT;
@@ -0,0 +1,17 @@
+-- Cached Canvas responses, for CANVAS_RESPONSE_CACHE=postgres. Keys are a token ID and a URL.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS canvas_responses (
+    key           TEXT PRIMARY KEY,
+    etag          TEXT        NOT NULL DEFAULT '',
+    last_modified TEXT        NOT NULL DEFAULT '',
+    link          TEXT        NOT NULL DEFAULT '',
+    content_type  TEXT        NOT NULL DEFAULT '',
+    body          BYTEA       NOT NULL,
+    stored_at     TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS canvas_responses_stored_at_idx ON canvas_responses (stored_at);
+
+COMMIT;
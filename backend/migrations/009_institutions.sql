@@ -0,0 +1,160 @@
+-- Institutions, each with their own Canvas instance and developer key.
+--
+-- Canvas IDs are only unique within a Canvas instance, so once there's more than one institution, rows keyed by
+-- them have to be keyed by their institution too. institution_id is NULL for the default Canvas instance. NULLs
+-- are never equal in unique or foreign keys, so every scoped table also gets institution_key, which is
+-- COALESCE(institution_id, 0), and the keys use that. Upserts name the same columns in ON CONFLICT.
+--
+-- Generated columns need PostgreSQL 12 or newer.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS institutions (
+    id                          BIGSERIAL PRIMARY KEY,
+    name                        TEXT        NOT NULL,
+    canvas_domain               TEXT        NOT NULL UNIQUE,
+    canvas_oauth2_client_id     TEXT        NOT NULL,
+    canvas_oauth2_client_secret TEXT        NOT NULL,
+    current_enrollment_term_id  BIGINT      NOT NULL DEFAULT 0,
+    inserted_at                 TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+DO
+$$
+    DECLARE
+        t TEXT;
+    BEGIN
+        FOREACH t IN ARRAY ARRAY [
+            'users', 'sessions', 'canvas_tokens', 'courses', 'enrollments', 'grades', 'gpas', 'submissions',
+            'outcome_results', 'observees', 'terms', 'final_grades', 'grading_policy_assignments', 'course_weights',
+            'drop_cutoffs', 'grade_forecast_notifications'
+            ]
+            LOOP
+                EXECUTE format('ALTER TABLE %I ADD COLUMN IF NOT EXISTS institution_id BIGINT ' ||
+                               'REFERENCES institutions (id)', t);
+                EXECUTE format('ALTER TABLE %I ADD COLUMN IF NOT EXISTS institution_key BIGINT ' ||
+                               'GENERATED ALWAYS AS (COALESCE(institution_id, 0)) STORED', t);
+            END LOOP;
+    END
+$$;
+
+-- Swaps the unique keys below for ones that start with institution_key. Foreign keys that reference an old key are
+-- dropped first, then recreated against the new one if the referencing table has an institution_key. Otherwise,
+-- they're dropped for good, with a notice.
+DO
+$$
+    DECLARE
+        k               RECORD;
+        fk              RECORD;
+        key_name        TEXT;
+        fks_to_recreate TEXT[] := '{}';
+    BEGIN
+        FOR k IN SELECT *
+                 FROM (VALUES ('users', '{canvas_user_id}'::TEXT[]),
+                              ('courses', '{course_id}'),
+                              ('enrollments', '{course_id,user_canvas_id}'),
+                              ('submissions', '{canvas_id}'),
+                              ('outcome_results', '{canvas_id}'),
+                              ('observees', '{observer_canvas_user_id,observee_canvas_user_id}'),
+                              ('terms', '{canvas_enrollment_term_id}'),
+                              ('grading_policy_assignments', '{root_account_id}'),
+                              ('grading_policy_assignments', '{enrollment_term_id}'),
+                              ('grading_policy_assignments', '{course_id}')) AS keys (tbl, cols)
+            LOOP
+                -- foreign keys referencing exactly these columns
+                FOR fk IN
+                    SELECT c.conname,
+                           c.conrelid::regclass                                                AS referencing,
+                           (SELECT array_agg(a.attname::TEXT ORDER BY u.ord)
+                            FROM unnest(c.conkey) WITH ORDINALITY u(attnum, ord)
+                                     JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = u.attnum) AS cols,
+                           c.confdeltype,
+                           EXISTS(SELECT 1
+                                  FROM pg_attribute a
+                                  WHERE a.attrelid = c.conrelid
+                                    AND a.attname = 'institution_key'
+                                    AND NOT a.attisdropped)                                    AS scoped
+                    FROM pg_constraint c
+                    WHERE c.contype = 'f'
+                      AND c.confrelid = k.tbl::regclass
+                      AND (SELECT array_agg(a.attname::TEXT ORDER BY u.ord)
+                           FROM unnest(c.confkey) WITH ORDINALITY u(attnum, ord)
+                                    JOIN pg_attribute a ON a.attrelid = c.confrelid AND a.attnum = u.attnum) = k.cols
+                    LOOP
+                        EXECUTE format('ALTER TABLE %s DROP CONSTRAINT %I', fk.referencing, fk.conname);
+
+                        IF fk.scoped THEN
+                            fks_to_recreate := fks_to_recreate || format(
+                                    'ALTER TABLE %s ADD CONSTRAINT %I FOREIGN KEY (institution_key, %s) ' ||
+                                    'REFERENCES %I (institution_key, %s) %s',
+                                    fk.referencing, fk.conname,
+                                    array_to_string(fk.cols, ', '),
+                                    k.tbl, array_to_string(k.cols, ', '),
+                                    CASE fk.confdeltype
+                                        WHEN 'c' THEN 'ON DELETE CASCADE'
+                                        WHEN 'r' THEN 'ON DELETE RESTRICT'
+                                        ELSE ''
+                                        END);
+                        ELSE
+                            RAISE NOTICE 'dropped foreign key % on %: it has no institution_key', fk.conname,
+                                fk.referencing;
+                        END IF;
+                    END LOOP;
+
+                -- the old unique constraints and indexes
+                FOR fk IN
+                    SELECT c.conname
+                    FROM pg_constraint c
+                    WHERE c.contype = 'u'
+                      AND c.conrelid = k.tbl::regclass
+                      AND (SELECT array_agg(a.attname::TEXT ORDER BY a.attname)
+                           FROM pg_attribute a
+                           WHERE a.attrelid = c.conrelid
+                             AND a.attnum = ANY (c.conkey)) = (SELECT array_agg(x ORDER BY x) FROM unnest(k.cols) x)
+                    LOOP
+                        EXECUTE format('ALTER TABLE %I DROP CONSTRAINT %I', k.tbl, fk.conname);
+                    END LOOP;
+
+                FOR fk IN
+                    SELECT i.indexrelid::regclass AS idx
+                    FROM pg_index i
+                    WHERE i.indrelid = k.tbl::regclass
+                      AND i.indisunique
+                      AND NOT i.indisprimary
+                      AND i.indexprs IS NULL
+                      AND (SELECT array_agg(a.attname::TEXT ORDER BY a.attname)
+                           FROM pg_attribute a
+                           WHERE a.attrelid = i.indrelid
+                             AND a.attnum = ANY (i.indkey)) = (SELECT array_agg(x ORDER BY x) FROM unnest(k.cols) x)
+                    LOOP
+                        EXECUTE format('DROP INDEX %s', fk.idx);
+                    END LOOP;
+
+                -- identifiers are cut off at 63 characters
+                key_name := left(k.tbl || '_institution_key_' || array_to_string(k.cols, '_') || '_key', 63);
+                IF NOT EXISTS(SELECT 1 FROM pg_constraint WHERE conrelid = k.tbl::regclass AND conname = key_name) THEN
+                    EXECUTE format('ALTER TABLE %I ADD CONSTRAINT %I UNIQUE (institution_key, %s)',
+                                   k.tbl, key_name, array_to_string(k.cols, ', '));
+                END IF;
+            END LOOP;
+
+        FOR i IN 1..coalesce(array_length(fks_to_recreate, 1), 0)
+            LOOP
+                EXECUTE fks_to_recreate[i];
+            END LOOP;
+    END
+$$;
+
+CREATE INDEX IF NOT EXISTS canvas_tokens_institution_key_canvas_user_id_idx
+    ON canvas_tokens (institution_key, canvas_user_id, inserted_at DESC);
+CREATE INDEX IF NOT EXISTS grades_institution_key_user_canvas_id_course_id_idx
+    ON grades (institution_key, user_canvas_id, course_id, inserted_at DESC);
+CREATE INDEX IF NOT EXISTS gpas_institution_key_canvas_user_id_idx
+    ON gpas (institution_key, canvas_user_id, inserted_at DESC);
+CREATE INDEX IF NOT EXISTS final_grades_institution_key_canvas_user_id_idx
+    ON final_grades (institution_key, canvas_user_id);
+CREATE INDEX IF NOT EXISTS grade_forecast_notifications_institution_key_canvas_user_id_idx
+    ON grade_forecast_notifications (institution_key, canvas_user_id, course_id, inserted_at DESC);
+DROP INDEX IF EXISTS grade_forecast_notifications_canvas_user_id_course_id_idx;
+
+COMMIT;
@@ -0,0 +1,35 @@
+-- The fetch_all job queue. Each run has a job for every user with a token and a summary job.
+--
+-- Workers claim jobs with SELECT ... FOR UPDATE SKIP LOCKED, in priority order within a run.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS fetch_jobs (
+    id              BIGSERIAL PRIMARY KEY,
+    run_id          TEXT        NOT NULL,
+    kind            TEXT        NOT NULL CHECK (kind IN ('teacher', 'student', 'observer', 'summary')),
+    -- 0 for summary jobs
+    canvas_user_id  BIGINT      NOT NULL DEFAULT 0,
+    -- NULL for the default Canvas instance
+    institution_id  BIGINT REFERENCES institutions (id),
+    institution_key BIGINT GENERATED ALWAYS AS (COALESCE(institution_id, 0)) STORED,
+    priority        INT         NOT NULL,
+    status          TEXT        NOT NULL DEFAULT 'queued'
+        CHECK (status IN ('queued', 'running', 'succeeded', 'failed')),
+    attempts        INT         NOT NULL DEFAULT 0,
+    max_attempts    INT         NOT NULL DEFAULT 1,
+    run_after       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until    TIMESTAMPTZ,
+    error           TEXT,
+    error_transient BOOLEAN     NOT NULL DEFAULT FALSE,
+    -- JSON from a successful job
+    result          BYTEA,
+    inserted_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS fetch_jobs_run_id_priority_idx ON fetch_jobs (run_id, priority, status);
+CREATE INDEX IF NOT EXISTS fetch_jobs_claimable_idx ON fetch_jobs (priority, id)
+    WHERE status IN ('queued', 'running');
+
+COMMIT;
@@ -0,0 +1,43 @@
+-- fetch_all runs and the users in them, for the admin fetch_runs endpoints.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS fetch_runs (
+    id            TEXT PRIMARY KEY,
+    -- the run whose failed users this run fetches again
+    rerun_of      TEXT REFERENCES fetch_runs (id),
+    started_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    -- NULL until every user in the run is done. The counts are set then.
+    finished_at   TIMESTAMPTZ,
+    num_teachers  INT         NOT NULL DEFAULT 0,
+    num_both      INT         NOT NULL DEFAULT 0,
+    num_students  INT         NOT NULL DEFAULT 0,
+    num_observers INT         NOT NULL DEFAULT 0,
+    num_succeeded INT         NOT NULL DEFAULT 0,
+    num_errors    INT         NOT NULL DEFAULT 0,
+    num_retried   INT         NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS fetch_runs_started_at_idx ON fetch_runs (started_at DESC);
+
+CREATE TABLE IF NOT EXISTS fetch_run_users (
+    run_id          TEXT        NOT NULL REFERENCES fetch_runs (id) ON DELETE CASCADE,
+    canvas_user_id  BIGINT      NOT NULL,
+    -- NULL for the default Canvas instance
+    institution_id  BIGINT REFERENCES institutions (id),
+    institution_key BIGINT GENERATED ALWAYS AS (COALESCE(institution_id, 0)) STORED,
+    kind            TEXT        NOT NULL CHECK (kind IN ('teacher', 'student', 'observer')),
+    status          TEXT        NOT NULL DEFAULT 'queued'
+        CHECK (status IN ('queued', 'running', 'succeeded', 'failed')),
+    attempts        INT         NOT NULL DEFAULT 0,
+    error           TEXT,
+    error_category  TEXT CHECK (error_category IN ('token', 'rate_limited', 'timeout', 'canvas', 'internal')),
+    inserted_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (run_id, institution_key, canvas_user_id, kind)
+);
+
+CREATE INDEX IF NOT EXISTS fetch_run_users_institution_key_canvas_user_id_idx
+    ON fetch_run_users (institution_key, canvas_user_id, inserted_at DESC);
+
+COMMIT;
@@ -0,0 +1,23 @@
+-- Jobs the backend runs on a schedule, with the server leading each one, for GET /api/admin/scheduled_jobs.
+--
+-- Leadership itself is a Postgres advisory lock, so nothing here decides who runs a job.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS scheduled_jobs (
+    name              TEXT PRIMARY KEY,
+    schedule          TEXT        NOT NULL,
+    leader            TEXT        NOT NULL,
+    leader_since      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    next_run_at       TIMESTAMPTZ,
+    last_started_at   TIMESTAMPTZ,
+    last_finished_at  TIMESTAMPTZ,
+    last_succeeded_at TIMESTAMPTZ,
+    last_error        TEXT,
+    updated_at        TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- reconcile_fetch_runs looks for unfinished runs
+CREATE INDEX IF NOT EXISTS fetch_runs_unfinished_idx ON fetch_runs (started_at) WHERE finished_at IS NULL;
+
+COMMIT;
@@ -0,0 +1,22 @@
+-- Weekly grade digest emails, sent by the grade_digests scheduled job.
+
+BEGIN;
+
+INSERT INTO notification_types (id, name, short_name, description)
+VALUES (3, 'Grade Digest', 'grade_digest',
+        'A weekly summary of grades and how they changed.')
+ON CONFLICT (id) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS grade_digest_notifications (
+    id              BIGSERIAL PRIMARY KEY,
+    canvas_user_id  BIGINT      NOT NULL,
+    -- NULL for the default Canvas instance
+    institution_id  BIGINT REFERENCES institutions (id),
+    institution_key BIGINT GENERATED ALWAYS AS (COALESCE(institution_id, 0)) STORED,
+    inserted_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS grade_digest_notifications_institution_key_canvas_user_id_idx
+    ON grade_digest_notifications (institution_key, canvas_user_id, inserted_at DESC);
+
+COMMIT;
@@ -0,0 +1,157 @@
+package admin
+
+import (
+	"encoding/json"
+	"github.com/iamtheyammer/canvascbl/backend/src/db"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/course_weights"
+	"github.com/iamtheyammer/canvascbl/backend/src/middlewares"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+type courseWeight struct {
+	ID                uint64    `json:"id"`
+	CourseID          uint64    `json:"courseId,omitempty"`
+	CourseCodePattern string    `json:"courseCodePattern,omitempty"`
+	Weight            float64   `json:"weight"`
+	InstitutionID     uint64    `json:"institutionId,omitempty"`
+	InsertedAt        time.Time `json:"insertedAt"`
+}
+
+func courseWeightFromDB(cw course_weights.CourseWeight) courseWeight {
+	return courseWeight{
+		ID:                cw.ID,
+		CourseID:          cw.CourseID,
+		CourseCodePattern: cw.CourseCodePattern,
+		Weight:            cw.Weight,
+		InstitutionID:     cw.InstitutionID,
+		InsertedAt:        cw.InsertedAt,
+	}
+}
+
+func ListCourseWeightsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	session := middlewares.Session(w, r, true)
+	if session == nil {
+		return
+	}
+
+	if middlewares.IsAdmin(w, r, session) {
+		return
+	}
+
+	cws, err := db.ListCourseWeights(&course_weights.ListRequest{})
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error listing course weights"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	weights := []courseWeight{}
+	for _, cw := range *cws {
+		weights = append(weights, courseWeightFromDB(cw))
+	}
+
+	jWeights, err := json.Marshal(&weights)
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error marshaling list course weights response"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	util.SendJSONResponse(w, jWeights)
+	return
+}
+
+func CreateCourseWeightHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	session := middlewares.Session(w, r, true)
+	if session == nil {
+		return
+	}
+
+	if middlewares.IsAdmin(w, r, session) {
+		return
+	}
+
+	var body courseWeight
+	err := middlewares.DecodeJSONBody(r.Body, &body)
+	if err != nil {
+		util.SendBadRequest(w, "malformed body")
+		return
+	}
+
+	if (body.CourseID > 0) == (len(body.CourseCodePattern) > 0) {
+		util.SendBadRequest(w, "exactly one of courseId and courseCodePattern must be specified")
+		return
+	}
+
+	if len(body.CourseCodePattern) > 0 {
+		_, err := regexp.Compile(body.CourseCodePattern)
+		if err != nil {
+			util.SendBadRequest(w, "courseCodePattern is not a valid regular expression")
+			return
+		}
+	}
+
+	if body.Weight == 0 {
+		util.SendBadRequest(w, "missing weight")
+		return
+	}
+
+	if invalidInstitutionID(w, body.InstitutionID) {
+		return
+	}
+
+	cw, err := db.InsertCourseWeight(&course_weights.InsertRequest{
+		CourseID:          body.CourseID,
+		CourseCodePattern: body.CourseCodePattern,
+		Weight:            body.Weight,
+		InstitutionID:     body.InstitutionID,
+	})
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error inserting course weight"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	jWeight, err := json.Marshal(courseWeightFromDB(*cw))
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error marshaling create course weight response"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	util.SendJSONResponse(w, jWeight)
+	return
+}
+
+func DeleteCourseWeightHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	courseWeightID, err := strconv.Atoi(ps.ByName("courseWeightID"))
+	if err != nil || courseWeightID < 1 {
+		util.SendBadRequest(w, "invalid courseWeightID as url param")
+		return
+	}
+
+	session := middlewares.Session(w, r, true)
+	if session == nil {
+		return
+	}
+
+	if middlewares.IsAdmin(w, r, session) {
+		return
+	}
+
+	err = db.DeleteCourseWeight(uint64(courseWeightID))
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error deleting course weight"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	util.SendNoContent(w)
+	return
+}
@@ -0,0 +1,164 @@
+package admin
+
+import (
+	"encoding/json"
+	"github.com/iamtheyammer/canvascbl/backend/src/db"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/drop_cutoffs"
+	"github.com/iamtheyammer/canvascbl/backend/src/middlewares"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type dropCutoff struct {
+	ID               uint64     `json:"id"`
+	Name             string     `json:"name"`
+	EnrollmentTermID uint64     `json:"enrollmentTermId,omitempty"`
+	CourseID         uint64     `json:"courseId,omitempty"`
+	StartsAt         time.Time  `json:"startsAt"`
+	EndsAt           *time.Time `json:"endsAt,omitempty"`
+	InstitutionID    uint64     `json:"institutionId,omitempty"`
+	InsertedAt       time.Time  `json:"insertedAt"`
+}
+
+func dropCutoffFromDB(c drop_cutoffs.DropCutoff) dropCutoff {
+	return dropCutoff{
+		ID:               c.ID,
+		Name:             c.Name,
+		EnrollmentTermID: c.EnrollmentTermID,
+		CourseID:         c.CourseID,
+		StartsAt:         c.StartsAt,
+		EndsAt:           c.EndsAt,
+		InstitutionID:    c.InstitutionID,
+		InsertedAt:       c.InsertedAt,
+	}
+}
+
+func ListDropCutoffsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	session := middlewares.Session(w, r, true)
+	if session == nil {
+		return
+	}
+
+	if middlewares.IsAdmin(w, r, session) {
+		return
+	}
+
+	cs, err := db.ListDropCutoffs(&drop_cutoffs.ListRequest{})
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error listing drop cutoffs"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	cutoffs := []dropCutoff{}
+	for _, c := range *cs {
+		cutoffs = append(cutoffs, dropCutoffFromDB(c))
+	}
+
+	jCutoffs, err := json.Marshal(&cutoffs)
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error marshaling list drop cutoffs response"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	util.SendJSONResponse(w, jCutoffs)
+	return
+}
+
+func CreateDropCutoffHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	session := middlewares.Session(w, r, true)
+	if session == nil {
+		return
+	}
+
+	if middlewares.IsAdmin(w, r, session) {
+		return
+	}
+
+	var body dropCutoff
+	err := middlewares.DecodeJSONBody(r.Body, &body)
+	if err != nil {
+		util.SendBadRequest(w, "malformed body")
+		return
+	}
+
+	if len(body.Name) < 1 {
+		util.SendBadRequest(w, "missing name")
+		return
+	}
+
+	if (body.EnrollmentTermID > 0) == (body.CourseID > 0) {
+		util.SendBadRequest(w, "exactly one of enrollmentTermId and courseId must be specified")
+		return
+	}
+
+	if body.StartsAt.IsZero() {
+		util.SendBadRequest(w, "missing startsAt")
+		return
+	}
+
+	if body.EndsAt != nil && !body.EndsAt.After(body.StartsAt) {
+		util.SendBadRequest(w, "endsAt must be after startsAt")
+		return
+	}
+
+	if invalidInstitutionID(w, body.InstitutionID) {
+		return
+	}
+
+	c, err := db.InsertDropCutoff(&drop_cutoffs.InsertRequest{
+		Name:             body.Name,
+		EnrollmentTermID: body.EnrollmentTermID,
+		CourseID:         body.CourseID,
+		StartsAt:         body.StartsAt,
+		EndsAt:           body.EndsAt,
+		InstitutionID:    body.InstitutionID,
+	})
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error inserting drop cutoff"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	jCutoff, err := json.Marshal(dropCutoffFromDB(*c))
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error marshaling create drop cutoff response"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	util.SendJSONResponse(w, jCutoff)
+	return
+}
+
+func DeleteDropCutoffHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	dropCutoffID, err := strconv.Atoi(ps.ByName("dropCutoffID"))
+	if err != nil || dropCutoffID < 1 {
+		util.SendBadRequest(w, "invalid dropCutoffID as url param")
+		return
+	}
+
+	session := middlewares.Session(w, r, true)
+	if session == nil {
+		return
+	}
+
+	if middlewares.IsAdmin(w, r, session) {
+		return
+	}
+
+	err = db.DeleteDropCutoff(uint64(dropCutoffID))
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error deleting drop cutoff"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	util.SendNoContent(w)
+	return
+}
@@ -0,0 +1,258 @@
+package admin
+
+import (
+	"encoding/json"
+	"github.com/iamtheyammer/canvascbl/backend/src/db"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/fetch_jobs"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/fetch_runs"
+	"github.com/iamtheyammer/canvascbl/backend/src/gradesapi"
+	"github.com/iamtheyammer/canvascbl/backend/src/middlewares"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type fetchRun struct {
+	ID           string     `json:"id"`
+	RerunOf      string     `json:"rerunOf,omitempty"`
+	StartedAt    time.Time  `json:"startedAt"`
+	FinishedAt   *time.Time `json:"finishedAt,omitempty"`
+	NumTeachers  int        `json:"numTeachers"`
+	NumBoth      int        `json:"numBoth"`
+	NumStudents  int        `json:"numStudents"`
+	NumObservers int        `json:"numObservers"`
+	NumSucceeded int        `json:"numSucceeded"`
+	NumErrors    int        `json:"numErrors"`
+	NumRetried   int        `json:"numRetried"`
+}
+
+func fetchRunFromDB(r fetch_runs.Run) fetchRun {
+	ret := fetchRun{
+		ID:           r.ID,
+		RerunOf:      r.RerunOf,
+		StartedAt:    r.StartedAt,
+		NumTeachers:  r.NumTeachers,
+		NumBoth:      r.NumBoth,
+		NumStudents:  r.NumStudents,
+		NumObservers: r.NumObservers,
+		NumSucceeded: r.NumSucceeded,
+		NumErrors:    r.NumErrors,
+		NumRetried:   r.NumRetried,
+	}
+
+	if r.Finished() {
+		ret.FinishedAt = &r.FinishedAt
+	}
+
+	return ret
+}
+
+type fetchRunUser struct {
+	RunID         string    `json:"runId"`
+	CanvasUserID  uint64    `json:"canvasUserId"`
+	InstitutionID uint64    `json:"institutionId,omitempty"`
+	Kind          string    `json:"kind"`
+	Status        string    `json:"status"`
+	Attempts      int       `json:"attempts"`
+	Error         string    `json:"error,omitempty"`
+	ErrorCategory string    `json:"errorCategory,omitempty"`
+	InsertedAt    time.Time `json:"insertedAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+func fetchRunUserFromDB(u fetch_runs.User) fetchRunUser {
+	return fetchRunUser{
+		RunID:         u.RunID,
+		CanvasUserID:  u.CanvasUserID,
+		InstitutionID: u.InstitutionID,
+		Kind:          string(u.Kind),
+		Status:        string(u.Status),
+		Attempts:      u.Attempts,
+		Error:         u.Error,
+		ErrorCategory: string(u.ErrorCategory),
+		InsertedAt:    u.InsertedAt,
+		UpdatedAt:     u.UpdatedAt,
+	}
+}
+
+// validFetchRunID is whether id is a UUID, like run IDs.
+func validFetchRunID(id string) bool {
+	_, err := uuid.FromString(id)
+	return err == nil
+}
+
+func ListFetchRunsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var limit uint64
+	if l := r.URL.Query().Get("limit"); len(l) > 0 {
+		var err error
+		limit, err = strconv.ParseUint(l, 10, 64)
+		if err != nil {
+			util.SendBadRequest(w, "invalid limit as query param")
+			return
+		}
+	}
+
+	session := middlewares.Session(w, r, true)
+	if session == nil {
+		return
+	}
+
+	if middlewares.IsAdmin(w, r, session) {
+		return
+	}
+
+	rs, err := db.ListFetchRuns(&fetch_runs.ListRequest{Limit: limit})
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error listing fetch runs"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	ret := []fetchRun{}
+	for _, fr := range *rs {
+		ret = append(ret, fetchRunFromDB(fr))
+	}
+
+	jRuns, err := json.Marshal(&ret)
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error marshaling list fetch runs response"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	util.SendJSONResponse(w, jRuns)
+	return
+}
+
+// ListFetchRunUsersHandler lists users in a run (with :runID), or across runs.
+func ListFetchRunUsersHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	q := r.URL.Query()
+	req := fetch_runs.ListUsersRequest{RunID: ps.ByName("runID")}
+	if len(req.RunID) > 0 && !validFetchRunID(req.RunID) {
+		util.SendBadRequest(w, "invalid runID as url param")
+		return
+	}
+
+	if cuID := q.Get("canvas_user_id"); len(cuID) > 0 {
+		id, err := strconv.ParseUint(cuID, 10, 64)
+		if err != nil {
+			util.SendBadRequest(w, "invalid canvas_user_id as query param")
+			return
+		}
+
+		req.CanvasUserID = id
+	}
+
+	if iID := q.Get("institution_id"); len(iID) > 0 {
+		id, err := strconv.ParseUint(iID, 10, 64)
+		if err != nil {
+			util.SendBadRequest(w, "invalid institution_id as query param")
+			return
+		}
+
+		req.InstitutionID = &id
+	}
+
+	if len(req.RunID) < 1 && req.CanvasUserID < 1 {
+		util.SendBadRequest(w, "missing canvas_user_id as query param")
+		return
+	}
+
+	for _, s := range q["status"] {
+		req.Statuses = append(req.Statuses, fetch_jobs.Status(s))
+	}
+
+	for _, c := range q["error_category"] {
+		req.ErrorCategories = append(req.ErrorCategories, fetch_runs.ErrorCategory(c))
+	}
+
+	if l := q.Get("limit"); len(l) > 0 {
+		limit, err := strconv.ParseUint(l, 10, 64)
+		if err != nil {
+			util.SendBadRequest(w, "invalid limit as query param")
+			return
+		}
+
+		req.Limit = limit
+	}
+
+	session := middlewares.Session(w, r, true)
+	if session == nil {
+		return
+	}
+
+	if middlewares.IsAdmin(w, r, session) {
+		return
+	}
+
+	us, err := db.ListFetchRunUsers(&req)
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error listing fetch run users"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	ret := []fetchRunUser{}
+	for _, u := range *us {
+		ret = append(ret, fetchRunUserFromDB(u))
+	}
+
+	jUsers, err := json.Marshal(&ret)
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error marshaling list fetch run users response"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	util.SendJSONResponse(w, jUsers)
+	return
+}
+
+func RerunFetchRunHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	runID := ps.ByName("runID")
+	if !validFetchRunID(runID) {
+		util.SendBadRequest(w, "invalid runID as url param")
+		return
+	}
+
+	session := middlewares.Session(w, r, true)
+	if session == nil {
+		return
+	}
+
+	if middlewares.IsAdmin(w, r, session) {
+		return
+	}
+
+	newRunID, err := gradesapi.RerunFetchAllRun(runID)
+	if errors.Is(err, gradesapi.ErrFetchAllRunNotFound) {
+		util.SendNotFoundWithReason(w, "no fetch run with that id")
+		return
+	} else if errors.Is(err, gradesapi.ErrFetchAllRunNotFinished) {
+		util.SendBadRequest(w, "the fetch run isn't finished yet")
+		return
+	} else if errors.Is(err, gradesapi.ErrFetchAllRunNoFailedUsers) {
+		util.SendBadRequest(w, "no users failed in the fetch run")
+		return
+	} else if err != nil {
+		util.HandleError(errors.Wrap(err, "error re-running fetch run"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	jRet, err := json.Marshal(struct {
+		RunID string `json:"runId"`
+	}{newRunID})
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error marshaling re-run fetch run response"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	util.SendJSONResponse(w, jRet)
+	return
+}
@@ -0,0 +1,336 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/iamtheyammer/canvascbl/backend/src/db"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/grading_policies"
+	"github.com/iamtheyammer/canvascbl/backend/src/middlewares"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type gradingPolicy struct {
+	ID                 uint64                    `json:"id"`
+	Name               string                    `json:"name"`
+	MostPercent        float64                   `json:"mostPercent"`
+	DropWorstScore     bool                      `json:"dropWorstScore"`
+	DropWorstMinScores uint64                    `json:"dropWorstMinScores"`
+	CalculationMethod  string                    `json:"calculationMethod"`
+	Bands              []gradingPolicyBand       `json:"bands"`
+	Assignments        []gradingPolicyAssignment `json:"assignments,omitempty"`
+	InsertedAt         time.Time                 `json:"insertedAt"`
+}
+
+type gradingPolicyBand struct {
+	Grade          string  `json:"grade"`
+	Rank           int8    `json:"rank"`
+	MostAbove      float64 `json:"mostAbove"`
+	AllAbove       float64 `json:"allAbove"`
+	GPAValue       float64 `json:"gpaValue"`
+	SubgradeGPAVal float64 `json:"subgradeGpaValue"`
+	// MostAboveRating and AllAboveRating are proficiency rating descriptions that override the numbers
+	MostAboveRating string `json:"mostAboveRating,omitempty"`
+	AllAboveRating  string `json:"allAboveRating,omitempty"`
+}
+
+type gradingPolicyAssignment struct {
+	ID               uint64    `json:"id"`
+	GradingPolicyID  uint64    `json:"gradingPolicyId"`
+	RootAccountID    uint64    `json:"rootAccountId,omitempty"`
+	EnrollmentTermID uint64    `json:"enrollmentTermId,omitempty"`
+	CourseID         uint64    `json:"courseId,omitempty"`
+	InstitutionID    uint64    `json:"institutionId,omitempty"`
+	InsertedAt       time.Time `json:"insertedAt,omitempty"`
+}
+
+func gradingPolicyFromDB(p grading_policies.Policy) gradingPolicy {
+	gp := gradingPolicy{
+		ID:                 p.ID,
+		Name:               p.Name,
+		MostPercent:        p.MostPercent,
+		DropWorstScore:     p.DropWorstScore,
+		DropWorstMinScores: p.DropWorstMinScores,
+		CalculationMethod:  p.CalculationMethod,
+		InsertedAt:         p.InsertedAt,
+	}
+
+	for _, b := range p.Bands {
+		gp.Bands = append(gp.Bands, gradingPolicyBand{
+			Grade:           b.Grade,
+			Rank:            b.Rank,
+			MostAbove:       b.MostAbove,
+			AllAbove:        b.AllAbove,
+			GPAValue:        b.GPAVal,
+			SubgradeGPAVal:  b.SubgradeGPAVal,
+			MostAboveRating: b.MostAboveRating,
+			AllAboveRating:  b.AllAboveRating,
+		})
+	}
+
+	return gp
+}
+
+func gradingPolicyAssignmentFromDB(a grading_policies.Assignment) gradingPolicyAssignment {
+	return gradingPolicyAssignment{
+		ID:               a.ID,
+		GradingPolicyID:  a.GradingPolicyID,
+		RootAccountID:    a.RootAccountID,
+		EnrollmentTermID: a.EnrollmentTermID,
+		CourseID:         a.CourseID,
+		InstitutionID:    a.InstitutionID,
+		InsertedAt:       a.InsertedAt,
+	}
+}
+
+func ListGradingPoliciesHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	session := middlewares.Session(w, r, true)
+	if session == nil {
+		return
+	}
+
+	if middlewares.IsAdmin(w, r, session) {
+		return
+	}
+
+	ps, err := db.ListGradingPolicies(&grading_policies.ListRequest{})
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error listing grading policies"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	as, err := db.ListGradingPolicyAssignments(&grading_policies.ListAssignmentsRequest{})
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error listing grading policy assignments"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	// map[policyID][]gradingPolicyAssignment
+	assignments := make(map[uint64][]gradingPolicyAssignment)
+	for _, a := range *as {
+		assignments[a.GradingPolicyID] = append(assignments[a.GradingPolicyID], gradingPolicyAssignmentFromDB(a))
+	}
+
+	policies := []gradingPolicy{}
+	for _, p := range *ps {
+		gp := gradingPolicyFromDB(p)
+		gp.Assignments = assignments[p.ID]
+		policies = append(policies, gp)
+	}
+
+	jPolicies, err := json.Marshal(&policies)
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error marshaling list grading policies response"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	util.SendJSONResponse(w, jPolicies)
+	return
+}
+
+func CreateGradingPolicyHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	session := middlewares.Session(w, r, true)
+	if session == nil {
+		return
+	}
+
+	if middlewares.IsAdmin(w, r, session) {
+		return
+	}
+
+	var body gradingPolicy
+	err := middlewares.DecodeJSONBody(r.Body, &body)
+	if err != nil {
+		util.SendBadRequest(w, "malformed body")
+		return
+	}
+
+	if len(body.Name) < 1 {
+		util.SendBadRequest(w, "missing name")
+		return
+	}
+
+	if body.MostPercent <= 0 || body.MostPercent > 100 {
+		util.SendBadRequest(w, "mostPercent must be over 0 and at most 100")
+		return
+	}
+
+	switch body.CalculationMethod {
+	case "":
+		body.CalculationMethod = grading_policies.CalculationMethodCBLLegacy
+	case grading_policies.CalculationMethodCBLLegacy, grading_policies.CalculationMethodCanvas:
+	default:
+		util.SendBadRequest(w, fmt.Sprintf(
+			"calculationMethod must be %s or %s",
+			grading_policies.CalculationMethodCBLLegacy,
+			grading_policies.CalculationMethodCanvas,
+		))
+		return
+	}
+
+	if len(body.Bands) < 1 {
+		util.SendBadRequest(w, "a grading policy needs at least one band")
+		return
+	}
+
+	ranks := make(map[int8]struct{}, len(body.Bands))
+	for _, b := range body.Bands {
+		if len(b.Grade) < 1 {
+			util.SendBadRequest(w, "every band needs a grade")
+			return
+		}
+
+		if _, ok := ranks[b.Rank]; ok {
+			util.SendBadRequest(w, fmt.Sprintf("more than one band has rank %d", b.Rank))
+			return
+		}
+		ranks[b.Rank] = struct{}{}
+	}
+
+	req := grading_policies.Policy{
+		Name:               body.Name,
+		MostPercent:        body.MostPercent,
+		DropWorstScore:     body.DropWorstScore,
+		DropWorstMinScores: body.DropWorstMinScores,
+		CalculationMethod:  body.CalculationMethod,
+	}
+
+	for _, b := range body.Bands {
+		req.Bands = append(req.Bands, grading_policies.Band{
+			Grade:           b.Grade,
+			Rank:            b.Rank,
+			MostAbove:       b.MostAbove,
+			AllAbove:        b.AllAbove,
+			GPAVal:          b.GPAValue,
+			SubgradeGPAVal:  b.SubgradeGPAVal,
+			MostAboveRating: b.MostAboveRating,
+			AllAboveRating:  b.AllAboveRating,
+		})
+	}
+
+	p, err := db.InsertGradingPolicy(&req)
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error inserting grading policy"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	jPolicy, err := json.Marshal(gradingPolicyFromDB(*p))
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error marshaling create grading policy response"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	util.SendJSONResponse(w, jPolicy)
+	return
+}
+
+func AssignGradingPolicyHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	policyID, err := strconv.Atoi(ps.ByName("policyID"))
+	if err != nil || policyID < 1 {
+		util.SendBadRequest(w, "invalid policyID as url param")
+		return
+	}
+
+	session := middlewares.Session(w, r, true)
+	if session == nil {
+		return
+	}
+
+	if middlewares.IsAdmin(w, r, session) {
+		return
+	}
+
+	var body gradingPolicyAssignment
+	err = middlewares.DecodeJSONBody(r.Body, &body)
+	if err != nil {
+		util.SendBadRequest(w, "malformed body")
+		return
+	}
+
+	numScopes := 0
+	for _, id := range []uint64{body.RootAccountID, body.EnrollmentTermID, body.CourseID} {
+		if id > 0 {
+			numScopes++
+		}
+	}
+
+	if numScopes != 1 {
+		util.SendBadRequest(w, "exactly one of rootAccountId, enrollmentTermId and courseId must be specified")
+		return
+	}
+
+	pols, err := db.ListGradingPolicies(&grading_policies.ListRequest{IDs: []uint64{uint64(policyID)}})
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error listing grading policies to assign"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	if len(*pols) < 1 {
+		util.SendNotFoundWithReason(w, "no grading policy with that id")
+		return
+	}
+
+	if invalidInstitutionID(w, body.InstitutionID) {
+		return
+	}
+
+	a, err := db.AssignGradingPolicy(&grading_policies.AssignRequest{
+		GradingPolicyID:  uint64(policyID),
+		RootAccountID:    body.RootAccountID,
+		EnrollmentTermID: body.EnrollmentTermID,
+		CourseID:         body.CourseID,
+		InstitutionID:    body.InstitutionID,
+	})
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error assigning grading policy"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	jAssignment, err := json.Marshal(gradingPolicyAssignmentFromDB(*a))
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error marshaling assign grading policy response"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	util.SendJSONResponse(w, jAssignment)
+	return
+}
+
+func UnassignGradingPolicyHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	assignmentID, err := strconv.Atoi(ps.ByName("assignmentID"))
+	if err != nil || assignmentID < 1 {
+		util.SendBadRequest(w, "invalid assignmentID as url param")
+		return
+	}
+
+	session := middlewares.Session(w, r, true)
+	if session == nil {
+		return
+	}
+
+	if middlewares.IsAdmin(w, r, session) {
+		return
+	}
+
+	err = db.UnassignGradingPolicy(uint64(assignmentID))
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error unassigning grading policy"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	util.SendNoContent(w)
+	return
+}
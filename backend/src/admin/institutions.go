@@ -0,0 +1,147 @@
+package admin
+
+import (
+	"encoding/json"
+	"github.com/iamtheyammer/canvascbl/backend/src/db"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/institutions"
+	"github.com/iamtheyammer/canvascbl/backend/src/middlewares"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// institution never includes the client secret in responses.
+type institution struct {
+	ID                       uint64    `json:"id"`
+	Name                     string    `json:"name"`
+	CanvasDomain             string    `json:"canvasDomain"`
+	CanvasOAuth2ClientID     string    `json:"canvasOAuth2ClientId"`
+	CanvasOAuth2ClientSecret string    `json:"canvasOAuth2ClientSecret,omitempty"`
+	CurrentEnrollmentTermID  uint64    `json:"currentEnrollmentTermId,omitempty"`
+	InsertedAt               time.Time `json:"insertedAt"`
+}
+
+func institutionFromDB(i institutions.Institution) institution {
+	return institution{
+		ID:                      i.ID,
+		Name:                    i.Name,
+		CanvasDomain:            i.CanvasDomain,
+		CanvasOAuth2ClientID:    i.CanvasOAuth2ClientID,
+		CurrentEnrollmentTermID: i.CurrentEnrollmentTermID,
+		InsertedAt:              i.InsertedAt,
+	}
+}
+
+func ListInstitutionsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	session := middlewares.Session(w, r, true)
+	if session == nil {
+		return
+	}
+
+	if middlewares.IsAdmin(w, r, session) {
+		return
+	}
+
+	is, err := db.ListInstitutions(&institutions.ListRequest{})
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error listing institutions"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	ret := []institution{}
+	for _, i := range *is {
+		ret = append(ret, institutionFromDB(i))
+	}
+
+	jInstitutions, err := json.Marshal(&ret)
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error marshaling list institutions response"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	util.SendJSONResponse(w, jInstitutions)
+	return
+}
+
+func UpsertInstitutionHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	session := middlewares.Session(w, r, true)
+	if session == nil {
+		return
+	}
+
+	if middlewares.IsAdmin(w, r, session) {
+		return
+	}
+
+	var body institution
+	err := middlewares.DecodeJSONBody(r.Body, &body)
+	if err != nil {
+		util.SendBadRequest(w, "malformed body")
+		return
+	}
+
+	if len(body.Name) < 1 {
+		util.SendBadRequest(w, "missing name")
+		return
+	}
+
+	if len(body.CanvasDomain) < 1 || strings.Contains(body.CanvasDomain, "/") {
+		util.SendBadRequest(w, "missing or invalid canvasDomain (must be a domain, like canvas.example.edu)")
+		return
+	}
+
+	if len(body.CanvasOAuth2ClientID) < 1 || len(body.CanvasOAuth2ClientSecret) < 1 {
+		util.SendBadRequest(w, "missing canvasOAuth2ClientId or canvasOAuth2ClientSecret")
+		return
+	}
+
+	i, err := db.UpsertInstitution(&institutions.UpsertRequest{
+		Name:                     body.Name,
+		CanvasDomain:             strings.ToLower(body.CanvasDomain),
+		CanvasOAuth2ClientID:     body.CanvasOAuth2ClientID,
+		CanvasOAuth2ClientSecret: body.CanvasOAuth2ClientSecret,
+		CurrentEnrollmentTermID:  body.CurrentEnrollmentTermID,
+	})
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error upserting institution"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	jInstitution, err := json.Marshal(institutionFromDB(*i))
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error marshaling upsert institution response"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	util.SendJSONResponse(w, jInstitution)
+	return
+}
+
+// invalidInstitutionID sends a bad request if institutionID isn't 0 (the default Canvas instance) or a stored
+// institution's ID. If it returns true, return your handler.
+func invalidInstitutionID(w http.ResponseWriter, institutionID uint64) bool {
+	if institutionID == 0 {
+		return false
+	}
+
+	is, err := db.ListInstitutions(&institutions.ListRequest{IDs: []uint64{institutionID}})
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error listing institutions to validate institution id"))
+		util.SendInternalServerError(w)
+		return true
+	}
+
+	if len(*is) < 1 {
+		util.SendBadRequest(w, "no institution with that institutionId")
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,86 @@
+package admin
+
+import (
+	"encoding/json"
+	"github.com/iamtheyammer/canvascbl/backend/src/db"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/scheduled_jobs"
+	"github.com/iamtheyammer/canvascbl/backend/src/middlewares"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	"net/http"
+	"time"
+)
+
+type scheduledJob struct {
+	Name            string     `json:"name"`
+	Schedule        string     `json:"schedule"`
+	Leader          string     `json:"leader"`
+	LeaderSince     time.Time  `json:"leaderSince"`
+	Running         bool       `json:"running"`
+	NextRunAt       *time.Time `json:"nextRunAt,omitempty"`
+	LastStartedAt   *time.Time `json:"lastStartedAt,omitempty"`
+	LastFinishedAt  *time.Time `json:"lastFinishedAt,omitempty"`
+	LastSucceededAt *time.Time `json:"lastSucceededAt,omitempty"`
+	LastError       string     `json:"lastError,omitempty"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
+}
+
+// optionalTime is nil if t is zero, so it's left out of JSON.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
+func scheduledJobFromDB(j scheduled_jobs.Job) scheduledJob {
+	return scheduledJob{
+		Name:            j.Name,
+		Schedule:        j.Schedule,
+		Leader:          j.Leader,
+		LeaderSince:     j.LeaderSince,
+		Running:         j.Running(),
+		NextRunAt:       optionalTime(j.NextRunAt),
+		LastStartedAt:   optionalTime(j.LastStartedAt),
+		LastFinishedAt:  optionalTime(j.LastFinishedAt),
+		LastSucceededAt: optionalTime(j.LastSucceededAt),
+		LastError:       j.LastError,
+		UpdatedAt:       j.UpdatedAt,
+	}
+}
+
+// ListScheduledJobsHandler lists scheduled jobs, with their leaders and last runs.
+func ListScheduledJobsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	session := middlewares.Session(w, r, true)
+	if session == nil {
+		return
+	}
+
+	if middlewares.IsAdmin(w, r, session) {
+		return
+	}
+
+	js, err := db.ListScheduledJobs(&scheduled_jobs.ListRequest{})
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error listing scheduled jobs"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	ret := []scheduledJob{}
+	for _, j := range *js {
+		ret = append(ret, scheduledJobFromDB(j))
+	}
+
+	jJobs, err := json.Marshal(&ret)
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error marshaling list scheduled jobs response"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	util.SendJSONResponse(w, jJobs)
+	return
+}
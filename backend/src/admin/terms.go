@@ -0,0 +1,177 @@
+package admin
+
+import (
+	"encoding/json"
+	"github.com/iamtheyammer/canvascbl/backend/src/db"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/terms"
+	"github.com/iamtheyammer/canvascbl/backend/src/middlewares"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type term struct {
+	ID                     uint64    `json:"id"`
+	Name                   string    `json:"name"`
+	CanvasEnrollmentTermID uint64    `json:"canvasEnrollmentTermId"`
+	StartAt                time.Time `json:"startAt"`
+	EndAt                  time.Time `json:"endAt"`
+	InstitutionID          uint64    `json:"institutionId,omitempty"`
+	InsertedAt             time.Time `json:"insertedAt"`
+}
+
+func termFromDB(t terms.Term) term {
+	return term{
+		ID:                     t.ID,
+		Name:                   t.Name,
+		CanvasEnrollmentTermID: t.CanvasEnrollmentTermID,
+		StartAt:                t.StartAt,
+		EndAt:                  t.EndAt,
+		InstitutionID:          t.InstitutionID,
+		InsertedAt:             t.InsertedAt,
+	}
+}
+
+func ListTermsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	session := middlewares.Session(w, r, true)
+	if session == nil {
+		return
+	}
+
+	if middlewares.IsAdmin(w, r, session) {
+		return
+	}
+
+	ts, err := db.ListTerms(&terms.ListRequest{})
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error listing terms"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	ret := []term{}
+	for _, t := range *ts {
+		ret = append(ret, termFromDB(t))
+	}
+
+	jTerms, err := json.Marshal(&ret)
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error marshaling list terms response"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	util.SendJSONResponse(w, jTerms)
+	return
+}
+
+func UpsertTermHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	session := middlewares.Session(w, r, true)
+	if session == nil {
+		return
+	}
+
+	if middlewares.IsAdmin(w, r, session) {
+		return
+	}
+
+	var body term
+	err := middlewares.DecodeJSONBody(r.Body, &body)
+	if err != nil {
+		util.SendBadRequest(w, "malformed body")
+		return
+	}
+
+	if len(body.Name) < 1 {
+		util.SendBadRequest(w, "missing name")
+		return
+	}
+
+	if body.CanvasEnrollmentTermID < 1 {
+		util.SendBadRequest(w, "missing canvasEnrollmentTermId")
+		return
+	}
+
+	if body.StartAt.IsZero() || body.EndAt.IsZero() || !body.EndAt.After(body.StartAt) {
+		util.SendBadRequest(w, "startAt and endAt are required, and endAt must be after startAt")
+		return
+	}
+
+	if invalidInstitutionID(w, body.InstitutionID) {
+		return
+	}
+
+	t, err := db.UpsertTerm(&terms.UpsertRequest{
+		Name:                   body.Name,
+		CanvasEnrollmentTermID: body.CanvasEnrollmentTermID,
+		StartAt:                body.StartAt,
+		EndAt:                  body.EndAt,
+		InstitutionID:          body.InstitutionID,
+	})
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error upserting term"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	jTerm, err := json.Marshal(termFromDB(*t))
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error marshaling upsert term response"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	util.SendJSONResponse(w, jTerm)
+	return
+}
+
+func FreezeTermHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	termID, err := strconv.Atoi(ps.ByName("termID"))
+	if err != nil || termID < 1 {
+		util.SendBadRequest(w, "invalid termID as url param")
+		return
+	}
+
+	session := middlewares.Session(w, r, true)
+	if session == nil {
+		return
+	}
+
+	if middlewares.IsAdmin(w, r, session) {
+		return
+	}
+
+	ts, err := db.ListTerms(&terms.ListRequest{IDs: []uint64{uint64(termID)}})
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error listing term to freeze"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	if len(*ts) < 1 {
+		util.SendNotFoundWithReason(w, "no term with that id")
+		return
+	}
+
+	numFrozen, err := db.FreezeTermFinalGrades((*ts)[0])
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error freezing term final grades"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	jRet, err := json.Marshal(struct {
+		NumFinalGrades int `json:"numFinalGrades"`
+	}{numFrozen})
+	if err != nil {
+		util.HandleError(errors.Wrap(err, "error marshaling freeze term response"))
+		util.SendInternalServerError(w)
+		return
+	}
+
+	util.SendJSONResponse(w, jRet)
+	return
+}
@@ -0,0 +1,71 @@
+/*
+Package canvasfake is a fake Canvas for development and tests. It serves the parts of the
+Canvas API that gradesapi uses from fixture data, and can simulate revoked tokens,
+rate limits and outages.
+*/
+package canvasfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+/*
+Fixtures is the data the fake serves. Everything from Canvas is kept as raw JSON, exactly as
+Canvas would send it, so fixtures can be made by saving real responses.
+
+Maps are keyed by the ID in the URL (as a string), like a course ID for assignments.
+*/
+type Fixtures struct {
+	// Users can log in and make requests with their tokens
+	Users []User `json:"users"`
+
+	// Profiles are GET /api/v1/users/:userID/profile
+	Profiles map[string]json.RawMessage `json:"profiles"`
+	// Courses are GET /api/v1/courses for each user ID
+	Courses map[string][]json.RawMessage `json:"courses"`
+	// Observees are GET /api/v1/users/:userID/observees
+	Observees map[string][]json.RawMessage `json:"observees"`
+	// OutcomeResults are GET /api/v1/courses/:courseID/outcome_results, filtered by user_ids[]
+	OutcomeResults map[string][]json.RawMessage `json:"outcome_results"`
+	// Outcomes are linked to outcome results, and are GET /api/v1/outcomes/:outcomeID
+	Outcomes map[string]json.RawMessage `json:"outcomes"`
+	// OutcomeAlignments are GET /api/v1/courses/:courseID/outcome_alignments
+	OutcomeAlignments map[string][]json.RawMessage `json:"outcome_alignments"`
+	// OutcomeProficiencies are GET /api/v1/accounts/:accountID/outcome_proficiency
+	OutcomeProficiencies map[string]json.RawMessage `json:"outcome_proficiencies"`
+	// Assignments are GET /api/v1/courses/:courseID/assignments, filtered by assignment_ids[]
+	Assignments map[string][]json.RawMessage `json:"assignments"`
+	// Enrollments are GET /api/v1/courses/:courseID/enrollments, filtered by user_id, type[] and state[]
+	Enrollments map[string][]json.RawMessage `json:"enrollments"`
+	// Submissions are GET /api/v1/courses/:courseID/students/submissions, filtered by student_ids[]
+	Submissions map[string][]json.RawMessage `json:"submissions"`
+}
+
+// User is someone who can use the fake.
+type User struct {
+	ID   uint64 `json:"id"`
+	Name string `json:"name"`
+	// AccessToken works until it's refreshed or revoked
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	// Code is the OAuth2 authorization code for the user. GET /login/oauth2/auth sends it back.
+	Code string `json:"code"`
+}
+
+// LoadFixtures reads fixtures from a JSON file.
+func LoadFixtures(path string) (*Fixtures, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading canvas fake fixtures: %w", err)
+	}
+
+	var f Fixtures
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding canvas fake fixtures: %w", err)
+	}
+
+	return &f, nil
+}
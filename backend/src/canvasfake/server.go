@@ -0,0 +1,542 @@
+package canvasfake
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// defaultPerPage is Canvas's default page size
+	defaultPerPage = 10
+	// maxPerPage is the biggest page size Canvas allows
+	maxPerPage = 100
+	// tokenExpiresIn is how long the fake says access tokens last, in seconds
+	tokenExpiresIn = 3600
+)
+
+var (
+	profileRoute             = regexp.MustCompile(`^/api/v1/users/(\w+)/profile$`)
+	observeesRoute           = regexp.MustCompile(`^/api/v1/users/(\w+)/observees$`)
+	coursesRoute             = regexp.MustCompile(`^/api/v1/courses$`)
+	outcomeResultsRoute      = regexp.MustCompile(`^/api/v1/courses/(\d+)/outcome_results$`)
+	outcomeAlignmentsRoute   = regexp.MustCompile(`^/api/v1/courses/(\d+)/outcome_alignments$`)
+	assignmentsRoute         = regexp.MustCompile(`^/api/v1/courses/(\d+)/assignments$`)
+	enrollmentsRoute         = regexp.MustCompile(`^/api/v1/courses/(\d+)/enrollments$`)
+	submissionsRoute         = regexp.MustCompile(`^/api/v1/courses/(\d+)/students/submissions$`)
+	outcomeRoute             = regexp.MustCompile(`^/api/v1/outcomes/(\d+)$`)
+	outcomeProficiencyRoute  = regexp.MustCompile(`^/api/v1/accounts/(\d+)/outcome_proficiency$`)
+	invalidAccessTokenBody   = []byte(`{"errors":[{"message":"Invalid access token."}]}`)
+	resourceDoesNotExistBody = []byte(`{"errors":[{"message":"The specified resource does not exist."}]}`)
+)
+
+/*
+Server is a fake Canvas. It's an http.Handler, so it can be run with httptest.NewServer or http.ListenAndServe.
+
+Responses have ETags, and requests with a matching If-None-Match get 304 Not Modified, like Canvas.
+Lists are paginated with Link headers, using per_page (default 10, max 100) and page.
+
+It's safe for concurrent use.
+*/
+type Server struct {
+	fixtures Fixtures
+
+	mutex sync.Mutex
+	// map[accessToken]userID
+	accessTokens map[string]uint64
+	// map[refreshToken]userID
+	refreshTokens map[string]uint64
+	// map[code]userID
+	codes map[string]uint64
+	// map[accessToken]struct{}
+	rateLimited map[string]struct{}
+	// failures is how many of the next API requests fail with failureStatus
+	failures      int
+	failureStatus int
+	// issued is how many tokens have been issued, for unique tokens
+	issued   int
+	requests int
+}
+
+// NewServer makes a fake Canvas serving f.
+func NewServer(f Fixtures) *Server {
+	s := &Server{
+		fixtures:      f,
+		accessTokens:  map[string]uint64{},
+		refreshTokens: map[string]uint64{},
+		codes:         map[string]uint64{},
+		rateLimited:   map[string]struct{}{},
+	}
+
+	for _, u := range f.Users {
+		if len(u.AccessToken) > 0 {
+			s.accessTokens[u.AccessToken] = u.ID
+		}
+		if len(u.RefreshToken) > 0 {
+			s.refreshTokens[u.RefreshToken] = u.ID
+		}
+		if len(u.Code) > 0 {
+			s.codes[u.Code] = u.ID
+		}
+	}
+
+	return s
+}
+
+// RevokeToken makes an access token invalid, like it expired. It can still be refreshed.
+func (s *Server) RevokeToken(accessToken string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.accessTokens, accessToken)
+}
+
+// RevokeRefreshToken makes a refresh token invalid, like the user removed CanvasCBL from Canvas.
+func (s *Server) RevokeRefreshToken(refreshToken string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.refreshTokens, refreshToken)
+}
+
+// RateLimit makes every request with accessToken fail with 403 Forbidden (Rate Limit Exceeded), or stops it.
+func (s *Server) RateLimit(accessToken string, limited bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if limited {
+		s.rateLimited[accessToken] = struct{}{}
+	} else {
+		delete(s.rateLimited, accessToken)
+	}
+}
+
+// FailRequests makes the next n API requests fail with status, like 503 Service Unavailable.
+func (s *Server) FailRequests(n int, status int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.failures = n
+	s.failureStatus = status
+}
+
+// Requests is how many requests the fake has gotten.
+func (s *Server) Requests() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.requests
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	s.requests++
+	s.mutex.Unlock()
+
+	switch r.URL.Path {
+	case "/login/oauth2/auth":
+		s.serveAuth(w, r)
+		return
+	case "/login/oauth2/token":
+		s.serveToken(w, r)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, "/api/v1/") || r.Method != http.MethodGet {
+		sendJSON(w, r, http.StatusNotFound, resourceDoesNotExistBody)
+		return
+	}
+
+	userID, ok := s.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	s.serveAPI(w, r, userID)
+}
+
+// authorize checks the request's token, and sends an error if it's invalid, rate limited or failing.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.failures > 0 {
+		s.failures--
+		sendJSON(w, r, s.failureStatus, []byte(fmt.Sprintf(
+			`{"errors":[{"message":"%s"}]}`,
+			http.StatusText(s.failureStatus),
+		)))
+		return 0, false
+	}
+
+	userID, ok := s.accessTokens[token]
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="canvas-lms"`)
+		sendJSON(w, r, http.StatusUnauthorized, invalidAccessTokenBody)
+		return 0, false
+	}
+
+	if _, ok := s.rateLimited[token]; ok {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("X-Rate-Limit-Remaining", "0.0")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte("403 Forbidden (Rate Limit Exceeded)\n"))
+		return 0, false
+	}
+
+	return userID, true
+}
+
+func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request, userID uint64) {
+	p := r.URL.Path
+	q := r.URL.Query()
+	f := s.fixtures
+
+	// resolves "self" to the user making the request
+	id := func(m []string) string {
+		if m[1] == "self" {
+			return strconv.FormatUint(userID, 10)
+		}
+		return m[1]
+	}
+
+	if m := profileRoute.FindStringSubmatch(p); m != nil {
+		sendFixture(w, r, f.Profiles[id(m)])
+	} else if m := observeesRoute.FindStringSubmatch(p); m != nil {
+		sendPage(w, r, f.Observees[id(m)])
+	} else if coursesRoute.MatchString(p) {
+		sendPage(w, r, f.Courses[strconv.FormatUint(userID, 10)])
+	} else if m := outcomeResultsRoute.FindStringSubmatch(p); m != nil {
+		s.serveOutcomeResults(w, r, filter(f.OutcomeResults[m[1]], []string{"links", "user"}, q["user_ids[]"]))
+	} else if m := outcomeAlignmentsRoute.FindStringSubmatch(p); m != nil {
+		sendPage(w, r, f.OutcomeAlignments[m[1]])
+	} else if m := assignmentsRoute.FindStringSubmatch(p); m != nil {
+		sendPage(w, r, filter(f.Assignments[m[1]], []string{"id"}, q["assignment_ids[]"]))
+	} else if m := enrollmentsRoute.FindStringSubmatch(p); m != nil {
+		es := f.Enrollments[m[1]]
+		if uID := q.Get("user_id"); len(uID) > 0 {
+			es = filter(es, []string{"user_id"}, []string{uID})
+		}
+		es = filter(es, []string{"type"}, q["type[]"])
+		es = filter(es, []string{"enrollment_state"}, q["state[]"])
+		sendPage(w, r, es)
+	} else if m := submissionsRoute.FindStringSubmatch(p); m != nil {
+		sendPage(w, r, filter(f.Submissions[m[1]], []string{"user_id"}, q["student_ids[]"]))
+	} else if m := outcomeRoute.FindStringSubmatch(p); m != nil {
+		sendFixture(w, r, f.Outcomes[m[1]])
+	} else if m := outcomeProficiencyRoute.FindStringSubmatch(p); m != nil {
+		sendFixture(w, r, f.OutcomeProficiencies[m[1]])
+	} else {
+		sendJSON(w, r, http.StatusNotFound, resourceDoesNotExistBody)
+	}
+}
+
+// serveOutcomeResults sends a page of results, with the outcomes linked to them.
+func (s *Server) serveOutcomeResults(w http.ResponseWriter, r *http.Request, results []json.RawMessage) {
+	page := paginate(w, r, results)
+
+	linked := []json.RawMessage{}
+	seen := map[string]struct{}{}
+	for _, res := range page {
+		oID := field(res, []string{"links", "learning_outcome"})
+		if _, ok := seen[oID]; ok {
+			continue
+		}
+		seen[oID] = struct{}{}
+
+		if o, ok := s.fixtures.Outcomes[oID]; ok {
+			linked = append(linked, o)
+		}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"outcome_results": page,
+		"linked": map[string]interface{}{
+			"outcomes": linked,
+		},
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sendJSON(w, r, http.StatusOK, body)
+}
+
+/*
+serveAuth is where users are sent to log in. The fake logs in the user from the user_id query param,
+or the first user, and sends them right back to redirect_uri with their code.
+*/
+func (s *Server) serveAuth(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || len(redirectURI.Host) < 1 {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	var code string
+	for _, u := range s.fixtures.Users {
+		if len(u.Code) > 0 && (len(q.Get("user_id")) < 1 || q.Get("user_id") == strconv.FormatUint(u.ID, 10)) {
+			code = u.Code
+			break
+		}
+	}
+
+	rq := redirectURI.Query()
+	if len(code) > 0 {
+		rq.Set("code", code)
+	} else {
+		rq.Set("error", "access_denied")
+	}
+	rq.Set("state", q.Get("state"))
+	redirectURI.RawQuery = rq.Encode()
+
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// serveToken exchanges codes and refresh tokens for access tokens.
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendJSON(w, r, http.StatusNotFound, resourceDoesNotExistBody)
+		return
+	}
+
+	// gradesapi sends these in the query string, but Canvas takes them in the body too
+	_ = r.ParseForm()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var (
+		userID uint64
+		ok     bool
+	)
+
+	grantType := r.Form.Get("grant_type")
+	switch grantType {
+	case "authorization_code":
+		userID, ok = s.codes[r.Form.Get("code")]
+		if !ok {
+			sendOAuth2Error(w, r, "invalid_grant", "authorization_code not found")
+			return
+		}
+	case "refresh_token":
+		userID, ok = s.refreshTokens[r.Form.Get("refresh_token")]
+		if !ok {
+			sendOAuth2Error(w, r, "invalid_request", "refresh_token not found")
+			return
+		}
+	default:
+		sendOAuth2Error(w, r, "unsupported_grant_type", "The grant_type you requested is not currently supported")
+		return
+	}
+
+	var name string
+	for _, u := range s.fixtures.Users {
+		if u.ID == userID {
+			name = u.Name
+		}
+	}
+
+	s.issued++
+	accessToken := fmt.Sprintf("fake~%d~%d", userID, s.issued)
+	s.accessTokens[accessToken] = userID
+
+	resp := map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   tokenExpiresIn,
+		"user": map[string]interface{}{
+			"id":   userID,
+			"name": name,
+		},
+	}
+
+	if grantType == "authorization_code" {
+		refreshToken := fmt.Sprintf("fake-refresh~%d~%d", userID, s.issued)
+		s.refreshTokens[refreshToken] = userID
+		resp["refresh_token"] = refreshToken
+	}
+
+	body, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sendJSON(w, r, http.StatusOK, body)
+}
+
+func sendOAuth2Error(w http.ResponseWriter, r *http.Request, err string, description string) {
+	body, _ := json.Marshal(map[string]string{
+		"error":             err,
+		"error_description": description,
+	})
+
+	sendJSON(w, r, http.StatusBadRequest, body)
+}
+
+// sendFixture sends a single object, or 404 if it's not in the fixtures.
+func sendFixture(w http.ResponseWriter, r *http.Request, f json.RawMessage) {
+	if f == nil {
+		sendJSON(w, r, http.StatusNotFound, resourceDoesNotExistBody)
+		return
+	}
+
+	var body bytes.Buffer
+	if err := json.Compact(&body, f); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sendJSON(w, r, http.StatusOK, body.Bytes())
+}
+
+// sendPage sends a page of items as an array.
+func sendPage(w http.ResponseWriter, r *http.Request, items []json.RawMessage) {
+	body, err := json.Marshal(paginate(w, r, items))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sendJSON(w, r, http.StatusOK, body)
+}
+
+/*
+sendJSON sends body with status. Successful responses get an ETag and rate limit headers, and
+304 Not Modified if the request's If-None-Match matches.
+*/
+func sendJSON(w http.ResponseWriter, r *http.Request, status int, body []byte) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if status == http.StatusOK {
+		sum := sha1.Sum(body)
+		etag := `W/"` + hex.EncodeToString(sum[:]) + `"`
+
+		w.Header().Set("ETag", etag)
+		w.Header().Set("X-Rate-Limit-Remaining", "700.0")
+		w.Header().Set("X-Request-Cost", "1.0")
+
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+// paginate returns the requested page of items, and sets the Link header.
+func paginate(w http.ResponseWriter, r *http.Request, items []json.RawMessage) []json.RawMessage {
+	q := r.URL.Query()
+
+	perPage, err := strconv.Atoi(q.Get("per_page"))
+	if err != nil || perPage < 1 {
+		perPage = defaultPerPage
+	} else if perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+
+	page, err := strconv.Atoi(q.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	pageURL := func(p int) string {
+		q.Set("page", strconv.Itoa(p))
+		q.Set("per_page", strconv.Itoa(perPage))
+
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+
+		return scheme + "://" + r.Host + r.URL.Path + "?" + q.Encode()
+	}
+
+	links := []string{
+		fmt.Sprintf(`<%s>; rel="current"`, pageURL(page)),
+		fmt.Sprintf(`<%s>; rel="first"`, pageURL(1)),
+	}
+
+	start := (page - 1) * perPage
+	end := start + perPage
+	if end < len(items) {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, pageURL(page+1)))
+	}
+
+	w.Header().Set("Link", strings.Join(links, ","))
+
+	if start >= len(items) {
+		return []json.RawMessage{}
+	}
+	if end > len(items) {
+		end = len(items)
+	}
+
+	return items[start:end]
+}
+
+// filter keeps items whose value at path is in allowed. If allowed is empty, every item is kept.
+func filter(items []json.RawMessage, path []string, allowed []string) []json.RawMessage {
+	if len(allowed) < 1 {
+		return items
+	}
+
+	a := make(map[string]struct{}, len(allowed))
+	for _, v := range allowed {
+		a[v] = struct{}{}
+	}
+
+	var kept []json.RawMessage
+	for _, i := range items {
+		if _, ok := a[field(i, path)]; ok {
+			kept = append(kept, i)
+		}
+	}
+
+	return kept
+}
+
+// field gets the value at path in a JSON object as a string, or "" if it isn't there.
+func field(item json.RawMessage, path []string) string {
+	d := json.NewDecoder(bytes.NewReader(item))
+	d.UseNumber()
+
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return ""
+	}
+
+	for _, p := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+
+		v = m[p]
+	}
+
+	switch val := v.(type) {
+	case string:
+		return val
+	case json.Number:
+		return val.String()
+	default:
+		return ""
+	}
+}
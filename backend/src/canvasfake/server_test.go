@@ -0,0 +1,223 @@
+package canvasfake
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newTestServer(t *testing.T) (*Server, *httptest.Server) {
+	f, err := LoadFixtures("testdata/fixtures.json")
+	if err != nil {
+		t.Fatalf("error loading fixtures: %v", err)
+	}
+
+	s := NewServer(*f)
+	return s, httptest.NewServer(s)
+}
+
+func get(t *testing.T, u string, token string, header http.Header) (*http.Response, string) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		t.Fatalf("error creating request: %v", err)
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("error reading body: %v", err)
+	}
+
+	return resp, string(body)
+}
+
+func TestServer_Endpoints(t *testing.T) {
+	_, ts := newTestServer(t)
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		wantStatus int
+		// wantBody is a substring of the body
+		wantBody string
+	}{
+		{"self profile", "/api/v1/users/self/profile", "student-token", http.StatusOK, `"name":"Sam Student"`},
+		{"profile by id", "/api/v1/users/101/profile", "parent-token", http.StatusOK, `"id":101`},
+		{"observees", "/api/v1/users/self/observees", "parent-token", http.StatusOK, `"id":101`},
+		{"observer courses", "/api/v1/courses", "parent-token", http.StatusOK, `"associated_user_id":101`},
+		{"outcome results for user", "/api/v1/courses/1001/outcome_results?user_ids[]=101", "student-token", http.StatusOK, `"title":"Scientific method"`},
+		{"outcome results for other user", "/api/v1/courses/1001/outcome_results?user_ids[]=999", "student-token", http.StatusOK, `{"linked":{"outcomes":[]},"outcome_results":[]}`},
+		{"assignments by id", "/api/v1/courses/1001/assignments?assignment_ids[]=2002", "student-token", http.StatusOK, `"name":"Experiment write-up"`},
+		{"enrollments by type", "/api/v1/courses/1001/enrollments?type[]=ObserverEnrollment", "student-token", http.StatusOK, `"id":3002`},
+		{"submissions", "/api/v1/courses/1001/students/submissions?student_ids[]=101", "student-token", http.StatusOK, `"id":4002`},
+		{"outcome", "/api/v1/outcomes/501", "student-token", http.StatusOK, `"calculation_method":"highest"`},
+		{"outcome proficiency", "/api/v1/accounts/1/outcome_proficiency", "student-token", http.StatusOK, `"description":"Mastery"`},
+		{"missing outcome", "/api/v1/outcomes/999", "student-token", http.StatusNotFound, "does not exist"},
+		{"invalid token", "/api/v1/courses", "not-a-token", http.StatusUnauthorized, "Invalid access token."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := get(t, ts.URL+tt.path, tt.token, nil)
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if !strings.Contains(body, tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestServer_Pagination(t *testing.T) {
+	_, ts := newTestServer(t)
+	defer ts.Close()
+
+	var (
+		ids  []uint64
+		u    = ts.URL + "/api/v1/courses?per_page=2"
+		reqs int
+	)
+	for len(u) > 0 {
+		reqs++
+		resp, body := get(t, u, "student-token", nil)
+
+		var courses []struct {
+			ID uint64 `json:"id"`
+		}
+		if err := json.Unmarshal([]byte(body), &courses); err != nil {
+			t.Fatalf("error decoding courses: %v", err)
+		}
+		for _, c := range courses {
+			ids = append(ids, c.ID)
+		}
+
+		u = ""
+		for _, l := range strings.Split(resp.Header.Get("Link"), ",") {
+			if strings.HasSuffix(l, `rel="next"`) {
+				u = strings.TrimSuffix(strings.TrimPrefix(strings.Split(l, ";")[0], "<"), ">")
+			}
+		}
+	}
+
+	if reqs != 2 {
+		t.Errorf("made %d requests, want 2", reqs)
+	}
+	if len(ids) != 3 || ids[0] != 1001 || ids[2] != 1003 {
+		t.Errorf("got course ids %v, want [1001 1002 1003]", ids)
+	}
+}
+
+func TestServer_ETag(t *testing.T) {
+	_, ts := newTestServer(t)
+	defer ts.Close()
+
+	resp, _ := get(t, ts.URL+"/api/v1/users/self/profile", "student-token", nil)
+	etag := resp.Header.Get("ETag")
+	if len(etag) < 1 {
+		t.Fatal("no ETag")
+	}
+
+	resp, body := get(t, ts.URL+"/api/v1/users/self/profile", "student-token", http.Header{"If-None-Match": {etag}})
+	if resp.StatusCode != http.StatusNotModified || len(body) > 0 {
+		t.Errorf("status = %d with body %q, want 304 with no body", resp.StatusCode, body)
+	}
+}
+
+func TestServer_SimulatedErrors(t *testing.T) {
+	s, ts := newTestServer(t)
+	defer ts.Close()
+
+	s.RateLimit("student-token", true)
+	resp, body := get(t, ts.URL+"/api/v1/courses", "student-token", nil)
+	if resp.StatusCode != http.StatusForbidden || resp.Header.Get("X-Rate-Limit-Remaining") != "0.0" ||
+		!strings.Contains(body, "Rate Limit Exceeded") {
+		t.Errorf("rate limited: status = %d, body = %s", resp.StatusCode, body)
+	}
+	s.RateLimit("student-token", false)
+
+	s.FailRequests(1, http.StatusServiceUnavailable)
+	if resp, _ := get(t, ts.URL+"/api/v1/courses", "student-token", nil); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("failing: status = %d, want 503", resp.StatusCode)
+	}
+	if resp, _ := get(t, ts.URL+"/api/v1/courses", "student-token", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("after failing: status = %d, want 200", resp.StatusCode)
+	}
+
+	s.RevokeToken("student-token")
+	if resp, _ := get(t, ts.URL+"/api/v1/courses", "student-token", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("revoked: status = %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestServer_OAuth2(t *testing.T) {
+	s, ts := newTestServer(t)
+	defer ts.Close()
+
+	token := func(q url.Values) (int, map[string]interface{}) {
+		resp, err := http.Post(ts.URL+"/login/oauth2/token?"+q.Encode(), "", nil)
+		if err != nil {
+			t.Fatalf("error making token request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var body map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("error decoding token response: %v", err)
+		}
+
+		return resp.StatusCode, body
+	}
+
+	status, body := token(url.Values{"grant_type": {"authorization_code"}, "code": {"student-code"}})
+	if status != http.StatusOK || body["refresh_token"] == nil {
+		t.Fatalf("code exchange: status = %d, body = %v", status, body)
+	}
+
+	s.RevokeToken("student-token")
+	status, body = token(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"student-refresh"}})
+	if status != http.StatusOK {
+		t.Fatalf("refresh: status = %d, body = %v", status, body)
+	}
+
+	if resp, _ := get(t, ts.URL+"/api/v1/users/self/profile", body["access_token"].(string), nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("refreshed token: status = %d, want 200", resp.StatusCode)
+	}
+
+	s.RevokeRefreshToken("student-refresh")
+	status, body = token(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"student-refresh"}})
+	if status != http.StatusBadRequest || body["error_description"] != "refresh_token not found" {
+		t.Errorf("revoked refresh: status = %d, body = %v", status, body)
+	}
+
+	client := http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(ts.URL + "/login/oauth2/auth?user_id=102&state=abc&redirect_uri=" +
+		url.QueryEscape("http://localhost:8000/api/canvas/oauth2/response"))
+	if err != nil {
+		t.Fatalf("error making auth request: %v", err)
+	}
+	resp.Body.Close()
+
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || loc.Query().Get("code") != "parent-code" || loc.Query().Get("state") != "abc" {
+		t.Errorf("auth redirected to %s, want a code of parent-code and a state of abc", resp.Header.Get("Location"))
+	}
+}
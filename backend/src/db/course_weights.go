@@ -0,0 +1,34 @@
+package db
+
+import (
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/course_weights"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"github.com/pkg/errors"
+)
+
+func ListCourseWeights(req *course_weights.ListRequest) (*[]course_weights.CourseWeight, error) {
+	cws, err := course_weights.List(util.DB, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing course weights")
+	}
+
+	return cws, nil
+}
+
+func InsertCourseWeight(req *course_weights.InsertRequest) (*course_weights.CourseWeight, error) {
+	cw, err := course_weights.Insert(util.DB, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error inserting course weight")
+	}
+
+	return cw, nil
+}
+
+func DeleteCourseWeight(id uint64) error {
+	err := course_weights.Delete(util.DB, id)
+	if err != nil {
+		return errors.Wrap(err, "error deleting course weight")
+	}
+
+	return nil
+}
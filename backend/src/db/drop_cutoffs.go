@@ -0,0 +1,34 @@
+package db
+
+import (
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/drop_cutoffs"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"github.com/pkg/errors"
+)
+
+func ListDropCutoffs(req *drop_cutoffs.ListRequest) (*[]drop_cutoffs.DropCutoff, error) {
+	cs, err := drop_cutoffs.List(util.DB, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing drop cutoffs")
+	}
+
+	return cs, nil
+}
+
+func InsertDropCutoff(req *drop_cutoffs.InsertRequest) (*drop_cutoffs.DropCutoff, error) {
+	c, err := drop_cutoffs.Insert(util.DB, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error inserting drop cutoff")
+	}
+
+	return c, nil
+}
+
+func DeleteDropCutoff(id uint64) error {
+	err := drop_cutoffs.Delete(util.DB, id)
+	if err != nil {
+		return errors.Wrap(err, "error deleting drop cutoff")
+	}
+
+	return nil
+}
@@ -0,0 +1,25 @@
+package db
+
+import (
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/fetch_runs"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"github.com/pkg/errors"
+)
+
+func ListFetchRuns(req *fetch_runs.ListRequest) (*[]fetch_runs.Run, error) {
+	rs, err := fetch_runs.List(util.DB, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing fetch runs")
+	}
+
+	return rs, nil
+}
+
+func ListFetchRunUsers(req *fetch_runs.ListUsersRequest) (*[]fetch_runs.User, error) {
+	us, err := fetch_runs.ListUsers(util.DB, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing fetch run users")
+	}
+
+	return us, nil
+}
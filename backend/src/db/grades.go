@@ -8,18 +8,33 @@ import (
 	"time"
 )
 
-var memoizedGradeAverages = map[uint64]struct {
+// courseAverageKey is a course ID at an institution, as course IDs are only unique within a Canvas instance.
+type courseAverageKey struct {
+	institutionID uint64
+	courseID      uint64
+}
+
+var memoizedGradeAverages = map[courseAverageKey]struct {
 	Result     float64
 	NumInputs  uint64
 	ValidUntil time.Time
 }{}
 
-func GetAverageGradeForCourse(courseID uint64) (*gradessvc.CourseGradeAverage, error) {
-	return gradessvc.GetAverageForCourse(util.DB, courseID)
+func GetAverageGradeForCourse(institutionID uint64, courseID uint64) (*gradessvc.CourseGradeAverage, error) {
+	p, err := GetGradingPolicyForCourse(institutionID, courseID)
+	if err != nil {
+		return nil, err
+	}
+
+	return gradessvc.GetAverageForCourse(util.DB, institutionID, courseID, p.Ranks())
 }
 
-func GetMemoizedAverageGradeForCourse(courseID uint64, userIDs []uint64) (*float64, *uint64, error) {
-	cs, err := courses.GetForUser(util.DB, userIDs)
+func GetMemoizedAverageGradeForCourse(
+	institutionID uint64,
+	courseID uint64,
+	userIDs []uint64,
+) (*float64, *uint64, error) {
+	cs, err := courses.GetForUser(util.DB, institutionID, userIDs)
 	if err != nil {
 		return nil, nil, nil
 	}
@@ -36,14 +51,15 @@ func GetMemoizedAverageGradeForCourse(courseID uint64, userIDs []uint64) (*float
 		return nil, nil, nil
 	}
 
-	v, ok := memoizedGradeAverages[courseID]
+	key := courseAverageKey{institutionID, courseID}
+	v, ok := memoizedGradeAverages[key]
 
 	if !ok {
-		avg, err := gradessvc.GetAverageForCourse(util.DB, courseID)
+		avg, err := GetAverageGradeForCourse(institutionID, courseID)
 		if err != nil {
 			return nil, nil, errors.Wrap(err, "error getting grade average for course")
 		}
-		memoizedGradeAverages[courseID] = struct {
+		memoizedGradeAverages[key] = struct {
 			Result     float64
 			NumInputs  uint64
 			ValidUntil time.Time
@@ -53,26 +69,27 @@ func GetMemoizedAverageGradeForCourse(courseID uint64, userIDs []uint64) (*float
 	}
 
 	if v.ValidUntil.Before(time.Now()) {
-		avg, err := gradessvc.GetAverageForCourse(util.DB, courseID)
+		avg, err := GetAverageGradeForCourse(institutionID, courseID)
 		if err != nil {
 			return nil, nil, errors.Wrap(err, "error getting grade average for course")
 		}
-		memoizedGradeAverages[courseID] = struct {
+		memoizedGradeAverages[key] = struct {
 			Result     float64
 			NumInputs  uint64
 			ValidUntil time.Time
 		}{Result: avg.Average, ValidUntil: time.Now().Add(time.Minute * 5), NumInputs: avg.NumInputs}
 	}
 
-	v, _ = memoizedGradeAverages[courseID]
+	v, _ = memoizedGradeAverages[key]
 
 	return &v.Result, &v.NumInputs, nil
 }
 
-func GetGradesForUserBeforeDate(userIDs []uint64, before time.Time) (*[]gradessvc.Grade, error) {
+func GetGradesForUserBeforeDate(institutionID uint64, userIDs []uint64, before time.Time) (*[]gradessvc.Grade, error) {
 	mf := true
 	gs, err := gradessvc.List(util.DB, &gradessvc.ListRequest{
 		UserCanvasIDs: &userIDs,
+		InstitutionID: &institutionID,
 		Before:        &before,
 		// since this is used for previous grades, we only want grades the user fetched manually
 		ManualFetch: &mf,
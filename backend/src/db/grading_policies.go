@@ -0,0 +1,67 @@
+package db
+
+import (
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/grading_policies"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"github.com/pkg/errors"
+)
+
+func ListGradingPolicies(req *grading_policies.ListRequest) (*[]grading_policies.Policy, error) {
+	ps, err := grading_policies.List(util.DB, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing grading policies")
+	}
+
+	return ps, nil
+}
+
+func InsertGradingPolicy(req *grading_policies.Policy) (*grading_policies.Policy, error) {
+	p, err := grading_policies.Insert(util.DB, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error inserting grading policy")
+	}
+
+	return p, nil
+}
+
+func ListGradingPolicyAssignments(req *grading_policies.ListAssignmentsRequest) (*[]grading_policies.Assignment, error) {
+	as, err := grading_policies.ListAssignments(util.DB, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing grading policy assignments")
+	}
+
+	return as, nil
+}
+
+func AssignGradingPolicy(req *grading_policies.AssignRequest) (*grading_policies.Assignment, error) {
+	a, err := grading_policies.Assign(util.DB, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error assigning grading policy")
+	}
+
+	return a, nil
+}
+
+func UnassignGradingPolicy(assignmentID uint64) error {
+	err := grading_policies.Unassign(util.DB, assignmentID)
+	if err != nil {
+		return errors.Wrap(err, "error unassigning grading policy")
+	}
+
+	return nil
+}
+
+// GetGradingPolicyForCourse gets the grading policy for a course at an institution (0 for the default Canvas
+// instance), falling back to the default policy.
+func GetGradingPolicyForCourse(institutionID uint64, courseID uint64) (*grading_policies.Policy, error) {
+	p, err := grading_policies.GetForCourse(util.DB, institutionID, courseID)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting grading policy for course")
+	}
+
+	if p == nil {
+		return &grading_policies.Default, nil
+	}
+
+	return p, nil
+}
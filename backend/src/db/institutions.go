@@ -0,0 +1,25 @@
+package db
+
+import (
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/institutions"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"github.com/pkg/errors"
+)
+
+func ListInstitutions(req *institutions.ListRequest) (*[]institutions.Institution, error) {
+	is, err := institutions.List(util.DB, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing institutions")
+	}
+
+	return is, nil
+}
+
+func UpsertInstitution(req *institutions.UpsertRequest) (*institutions.Institution, error) {
+	i, err := institutions.Upsert(util.DB, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error upserting institution")
+	}
+
+	return i, nil
+}
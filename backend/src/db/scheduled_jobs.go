@@ -0,0 +1,16 @@
+package db
+
+import (
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/scheduled_jobs"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"github.com/pkg/errors"
+)
+
+func ListScheduledJobs(req *scheduled_jobs.ListRequest) (*[]scheduled_jobs.Job, error) {
+	js, err := scheduled_jobs.List(util.DB, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing scheduled jobs")
+	}
+
+	return js, nil
+}
@@ -0,0 +1,27 @@
+package canvas_responses
+
+import (
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"time"
+)
+
+// DeleteStoredBefore deletes every response that hasn't been fetched or revalidated since before.
+func DeleteStoredBefore(db services.DB, before time.Time) error {
+	query, args, err := util.Sq.
+		Delete("canvas_responses").
+		Where(sq.Lt{"stored_at": before}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error building delete canvas responses sql: %w", err)
+	}
+
+	_, err = db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error executing delete canvas responses sql: %w", err)
+	}
+
+	return nil
+}
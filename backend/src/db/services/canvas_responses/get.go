@@ -0,0 +1,64 @@
+package canvas_responses
+
+import (
+	"database/sql"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"time"
+)
+
+// CanvasResponse is a cached response from Canvas.
+type CanvasResponse struct {
+	// Key is the token ID and URL the response is for
+	Key          string
+	ETag         string
+	LastModified string
+	// Link is the Link header, for pagination
+	Link        string
+	ContentType string
+	Body        []byte
+	// StoredAt is when the response was last fetched or revalidated
+	StoredAt time.Time
+}
+
+// Get gets the cached response with the specified key. It returns nil if there isn't one.
+func Get(db services.DB, key string) (*CanvasResponse, error) {
+	query, args, err := util.Sq.
+		Select(
+			"key",
+			"etag",
+			"last_modified",
+			"link",
+			"content_type",
+			"body",
+			"stored_at",
+		).
+		From("canvas_responses").
+		Where(sq.Eq{"key": key}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building get canvas response sql: %w", err)
+	}
+
+	var r CanvasResponse
+	err = db.QueryRow(query, args...).Scan(
+		&r.Key,
+		&r.ETag,
+		&r.LastModified,
+		&r.Link,
+		&r.ContentType,
+		&r.Body,
+		&r.StoredAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("error scanning get canvas response sql: %w", err)
+	}
+
+	return &r, nil
+}
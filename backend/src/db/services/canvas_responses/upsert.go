@@ -0,0 +1,40 @@
+package canvas_responses
+
+import (
+	"fmt"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+)
+
+// Upsert stores a response, replacing any response already stored with the same key.
+func Upsert(db services.DB, r *CanvasResponse) error {
+	query, args, err := util.Sq.
+		Insert("canvas_responses").
+		SetMap(map[string]interface{}{
+			"key":           r.Key,
+			"etag":          r.ETag,
+			"last_modified": r.LastModified,
+			"link":          r.Link,
+			"content_type":  r.ContentType,
+			"body":          r.Body,
+			"stored_at":     r.StoredAt,
+		}).
+		Suffix("ON CONFLICT (key) DO UPDATE SET " +
+			"etag = EXCLUDED.etag, " +
+			"last_modified = EXCLUDED.last_modified, " +
+			"link = EXCLUDED.link, " +
+			"content_type = EXCLUDED.content_type, " +
+			"body = EXCLUDED.body, " +
+			"stored_at = EXCLUDED.stored_at").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error building upsert canvas response sql: %w", err)
+	}
+
+	_, err = db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error executing upsert canvas response sql: %w", err)
+	}
+
+	return nil
+}
@@ -12,9 +12,16 @@ type InsertRequest struct {
 	Token        string
 	RefreshToken string
 	ExpiresAt    *time.Time
+	// InstitutionID is the institution the token is for, or 0 for the default Canvas instance
+	InstitutionID uint64
 }
 
 func Insert(db services.DB, req *InsertRequest) error {
+	var institutionID interface{}
+	if req.InstitutionID > 0 {
+		institutionID = req.InstitutionID
+	}
+
 	query, args, err := util.Sq.
 		Insert("canvas_tokens").
 		SetMap(map[string]interface{}{
@@ -22,6 +29,7 @@ func Insert(db services.DB, req *InsertRequest) error {
 			"token":          req.Token,
 			"refresh_token":  req.RefreshToken,
 			"expires_at":     req.ExpiresAt,
+			"institution_id": institutionID,
 		}).
 		Suffix("ON CONFLICT DO NOTHING").
 		ToSql()
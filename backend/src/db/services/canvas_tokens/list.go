@@ -16,16 +16,21 @@ type CanvasToken struct {
 	RefreshToken string
 	ScopeVersion uint64
 	ExpiresAt    time.Time
-	InsertedAt   time.Time
+	// InstitutionID is 0 for tokens from the default Canvas instance
+	InstitutionID uint64
+	InsertedAt    time.Time
 }
 
 type ListRequest struct {
 	ID           uint64
 	UserID       uint64
 	CanvasUserID uint64
-	Token        string
-	RefreshToken string
-	ScopeVersion uint64
+	// InstitutionID, if not nil, only lists tokens from that institution (0 for the default Canvas instance).
+	// Use it with CanvasUserID, as Canvas user IDs are only unique within an institution.
+	InstitutionID *uint64
+	Token         string
+	RefreshToken  string
+	ScopeVersion  uint64
 
 	Limit      uint64
 	Offset     uint64
@@ -48,6 +53,7 @@ func List(db services.DB, req *ListRequest) (*[]CanvasToken, error) {
 			"canvas_tokens.refresh_token",
 			"canvas_tokens.scope_version",
 			"canvas_tokens.expires_at",
+			"canvas_tokens.institution_id",
 			"canvas_tokens.inserted_at",
 		).
 		From("canvas_tokens")
@@ -57,7 +63,8 @@ func List(db services.DB, req *ListRequest) (*[]CanvasToken, error) {
 	}
 
 	if req.UserID > 0 {
-		q = q.Join("users ON canvas_tokens.canvas_user_id = users.canvas_user_id").
+		q = q.Join("users ON canvas_tokens.canvas_user_id = users.canvas_user_id AND " +
+			"canvas_tokens.institution_key = users.institution_key").
 			Where(sq.Eq{"users.id": req.UserID})
 	}
 
@@ -65,6 +72,10 @@ func List(db services.DB, req *ListRequest) (*[]CanvasToken, error) {
 		q = q.Where(sq.Eq{"canvas_user_id": req.CanvasUserID})
 	}
 
+	if req.InstitutionID != nil {
+		q = q.Where(sq.Eq{"canvas_tokens.institution_key": *req.InstitutionID})
+	}
+
 	if len(req.Token) > 0 {
 		q = q.Where(sq.Eq{"token": req.Token})
 	}
@@ -109,8 +120,9 @@ func List(db services.DB, req *ListRequest) (*[]CanvasToken, error) {
 
 	for rows.Next() {
 		var (
-			ct        CanvasToken
-			expiresAt sql.NullTime
+			ct            CanvasToken
+			expiresAt     sql.NullTime
+			institutionID sql.NullInt64
 		)
 
 		err := rows.Scan(
@@ -120,6 +132,7 @@ func List(db services.DB, req *ListRequest) (*[]CanvasToken, error) {
 			&ct.RefreshToken,
 			&ct.ScopeVersion,
 			&expiresAt,
+			&institutionID,
 			&ct.InsertedAt,
 		)
 		if err != nil {
@@ -130,6 +143,10 @@ func List(db services.DB, req *ListRequest) (*[]CanvasToken, error) {
 			ct.ExpiresAt = expiresAt.Time
 		}
 
+		if institutionID.Valid {
+			ct.InstitutionID = uint64(institutionID.Int64)
+		}
+
 		cts = append(cts, ct)
 	}
 
@@ -0,0 +1,82 @@
+package course_weights
+
+import (
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+)
+
+// InsertRequest is the request for Insert. Exactly one of CourseID and CourseCodePattern should be set.
+type InsertRequest struct {
+	CourseID          uint64
+	CourseCodePattern string
+	Weight            float64
+	// InstitutionID is the institution the weight applies to, or 0 for the default Canvas instance
+	InstitutionID uint64
+}
+
+func Insert(db services.DB, req *InsertRequest) (*CourseWeight, error) {
+	var (
+		courseID      interface{}
+		pattern       interface{}
+		institutionID interface{}
+	)
+	if req.CourseID > 0 {
+		courseID = req.CourseID
+	} else {
+		pattern = req.CourseCodePattern
+	}
+
+	if req.InstitutionID > 0 {
+		institutionID = req.InstitutionID
+	}
+
+	query, args, err := util.Sq.
+		Insert("course_weights").
+		SetMap(map[string]interface{}{
+			"course_id":           courseID,
+			"course_code_pattern": pattern,
+			"weight":              req.Weight,
+			"institution_id":      institutionID,
+		}).
+		Suffix("RETURNING id, inserted_at").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building insert course weight sql: %w", err)
+	}
+
+	cw := CourseWeight{
+		CourseID:          req.CourseID,
+		CourseCodePattern: req.CourseCodePattern,
+		Weight:            req.Weight,
+		InstitutionID:     req.InstitutionID,
+	}
+	if req.CourseID > 0 {
+		cw.CourseCodePattern = ""
+	}
+
+	err = db.QueryRow(query, args...).Scan(&cw.ID, &cw.InsertedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error executing insert course weight sql: %w", err)
+	}
+
+	return &cw, nil
+}
+
+func Delete(db services.DB, id uint64) error {
+	query, args, err := util.Sq.
+		Delete("course_weights").
+		Where(sq.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error building delete course weight sql: %w", err)
+	}
+
+	_, err = db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error executing delete course weight sql: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package course_weights
+
+import (
+	"database/sql"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"regexp"
+	"time"
+)
+
+// CourseWeight adds Weight to the GPA value of a course, like 0.5 for honors or 1 for AP.
+// It matches either a single course by CourseID or every course whose course code matches CourseCodePattern.
+type CourseWeight struct {
+	ID       uint64
+	CourseID uint64
+	// CourseCodePattern is a regular expression matched against course codes.
+	CourseCodePattern string
+	Weight            float64
+	// InstitutionID is the institution the weight applies to, or 0 for the default Canvas instance
+	InstitutionID uint64
+	InsertedAt    time.Time
+}
+
+type ListRequest struct {
+	IDs []uint64
+}
+
+// Set is a list of course weights with their patterns compiled.
+type Set struct {
+	byCourseID map[courseKey]float64
+	patterns   []compiledPattern
+}
+
+// courseKey is a course ID at an institution, as course IDs are only unique within a Canvas instance.
+type courseKey struct {
+	institutionID uint64
+	courseID      uint64
+}
+
+type compiledPattern struct {
+	institutionID uint64
+	pattern       *regexp.Regexp
+	weight        float64
+}
+
+// NewSet compiles course weights into a Set. Weights with invalid patterns are skipped.
+func NewSet(cws []CourseWeight) Set {
+	s := Set{byCourseID: make(map[courseKey]float64)}
+
+	for _, cw := range cws {
+		if cw.CourseID > 0 {
+			s.byCourseID[courseKey{cw.InstitutionID, cw.CourseID}] = cw.Weight
+			continue
+		}
+
+		p, err := regexp.Compile(cw.CourseCodePattern)
+		if err != nil {
+			continue
+		}
+
+		s.patterns = append(s.patterns, compiledPattern{
+			institutionID: cw.InstitutionID,
+			pattern:       p,
+			weight:        cw.Weight,
+		})
+	}
+
+	return s
+}
+
+// WeightForCourse returns the weight for a course at an institution (0 for the default Canvas instance).
+// Weights set for a course ID beat patterns, and the oldest matching pattern wins.
+// Courses without a weight have a weight of 0.
+func (s Set) WeightForCourse(institutionID uint64, courseID uint64, courseCode string) float64 {
+	if w, ok := s.byCourseID[courseKey{institutionID, courseID}]; ok {
+		return w
+	}
+
+	for _, p := range s.patterns {
+		if p.institutionID == institutionID && p.pattern.MatchString(courseCode) {
+			return p.weight
+		}
+	}
+
+	return 0
+}
+
+func List(db services.DB, req *ListRequest) (*[]CourseWeight, error) {
+	q := util.Sq.
+		Select(
+			"id",
+			"course_id",
+			"course_code_pattern",
+			"weight",
+			"institution_id",
+			"inserted_at",
+		).
+		From("course_weights").
+		OrderBy("id")
+
+	if len(req.IDs) > 0 {
+		q = q.Where(sq.Eq{"id": req.IDs})
+	}
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building list course weights sql: %w", err)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing list course weights sql: %w", err)
+	}
+
+	defer rows.Close()
+
+	var cws []CourseWeight
+	for rows.Next() {
+		var (
+			cw            CourseWeight
+			courseID      sql.NullInt64
+			pattern       sql.NullString
+			institutionID sql.NullInt64
+		)
+		err := rows.Scan(
+			&cw.ID,
+			&courseID,
+			&pattern,
+			&cw.Weight,
+			&institutionID,
+			&cw.InsertedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning list course weights sql: %w", err)
+		}
+
+		if courseID.Valid {
+			cw.CourseID = uint64(courseID.Int64)
+		}
+
+		if pattern.Valid {
+			cw.CourseCodePattern = pattern.String
+		}
+
+		if institutionID.Valid {
+			cw.InstitutionID = uint64(institutionID.Int64)
+		}
+
+		cws = append(cws, cw)
+	}
+
+	return &cws, nil
+}
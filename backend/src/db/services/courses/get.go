@@ -18,10 +18,102 @@ type Course struct {
 	UUID       string
 	CourseID   uint64
 	InsertedAt time.Time
+	// Only filled by List
+	EnrollmentTermID uint64
+	RootAccountID    uint64
 }
 
-// GetForUser returns all courses the user has a grade in
-func GetForUser(db services.DB, userIDs []uint64) (*[]Course, error) {
+// ListRequest is the request for List.
+type ListRequest struct {
+	CourseIDs         []uint64
+	EnrollmentTermIDs []uint64
+	// InstitutionID is the courses' institution, or 0 for the default Canvas instance
+	InstitutionID uint64
+}
+
+// List lists stored courses.
+func List(db services.DB, req *ListRequest) (*[]Course, error) {
+	q := util.Sq.
+		Select(
+			"id",
+			"name",
+			"course_code",
+			"state",
+			"uuid",
+			"course_id",
+			"inserted_at",
+			"enrollment_term_id",
+			"root_account_id",
+		).
+		From("courses").
+		Where(sq.Eq{"institution_key": req.InstitutionID}).
+		OrderBy("course_id")
+
+	if len(req.CourseIDs) > 0 {
+		q = q.Where(sq.Eq{"course_id": req.CourseIDs})
+	}
+
+	if len(req.EnrollmentTermIDs) > 0 {
+		q = q.Where(sq.Eq{"enrollment_term_id": req.EnrollmentTermIDs})
+	}
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building list courses sql: %w", err)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing list courses sql: %w", err)
+	}
+
+	defer rows.Close()
+
+	var courses []Course
+	for rows.Next() {
+		var (
+			c                               Course
+			courseCode                      sql.NullString
+			enrollmentTermID, rootAccountID sql.NullInt64
+		)
+
+		err := rows.Scan(
+			&c.ID,
+			&c.Name,
+			&courseCode,
+			&c.State,
+			&c.UUID,
+			&c.CourseID,
+			&c.InsertedAt,
+			&enrollmentTermID,
+			&rootAccountID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning list courses sql: %w", err)
+		}
+
+		if courseCode.Valid {
+			c.CourseCode = courseCode.String
+		} else {
+			c.CourseCode = c.Name
+		}
+
+		if enrollmentTermID.Valid {
+			c.EnrollmentTermID = uint64(enrollmentTermID.Int64)
+		}
+
+		if rootAccountID.Valid {
+			c.RootAccountID = uint64(rootAccountID.Int64)
+		}
+
+		courses = append(courses, c)
+	}
+
+	return &courses, nil
+}
+
+// GetForUser returns all courses the users at an institution (0 for the default Canvas instance) have a grade in
+func GetForUser(db services.DB, institutionID uint64, userIDs []uint64) (*[]Course, error) {
 	query, args, err := util.Sq.
 		Select(
 			"courses.id",
@@ -34,8 +126,9 @@ func GetForUser(db services.DB, userIDs []uint64) (*[]Course, error) {
 		).
 		From("courses").
 		Distinct().
-		LeftJoin("grades ON grades.course_id = courses.course_id").
+		LeftJoin("grades ON grades.course_id = courses.course_id AND grades.institution_key = courses.institution_key").
 		Where(sq.Eq{"grades.user_canvas_id": userIDs}).
+		Where(sq.Eq{"courses.institution_key": institutionID}).
 		ToSql()
 	if err != nil {
 		return nil, errors.Wrap(err, "error building get courses for user sql")
@@ -25,6 +25,8 @@ type OutcomeResultInsertRequest struct {
 	Score           float64
 	Possible        float64
 	SubmissionTime  string
+	// InstitutionID is the course's institution, or 0 for the default Canvas instance
+	InstitutionID uint64
 }
 
 // HideRequest serves as the request for Hide.
@@ -73,7 +75,7 @@ func InsertMultipleOutcomeRollups(db services.DB, req *[]OutcomeRollupInsertRequ
 }
 
 // MultipleOutcomeResultsUpsertChunkSize represents the number of outcome results per chunk.
-var MultipleOutcomeResultsUpsertChunkSize = services.CalculateChunkSize(9)
+var MultipleOutcomeResultsUpsertChunkSize = services.CalculateChunkSize(10)
 
 func InsertMultipleOutcomeResults(db services.DB, req *[]OutcomeResultInsertRequest) error {
 	q := util.Sq.
@@ -88,9 +90,11 @@ func InsertMultipleOutcomeResults(db services.DB, req *[]OutcomeResultInsertRequ
 			"score",
 			"possible",
 			"submission_time",
+			"institution_id",
 		).
 		Suffix(
-			"ON CONFLICT (canvas_id) DO UPDATE SET " +
+			// outcome result IDs are only unique within an institution (see migrations/009_institutions.sql)
+			"ON CONFLICT (institution_key, canvas_id) DO UPDATE SET " +
 				"achieved_mastery = excluded.achieved_mastery, " +
 				"score = excluded.score, " +
 				"possible = excluded.possible, " +
@@ -98,6 +102,11 @@ func InsertMultipleOutcomeResults(db services.DB, req *[]OutcomeResultInsertRequ
 		)
 
 	for _, or := range *req {
+		var institutionID interface{}
+		if or.InstitutionID > 0 {
+			institutionID = or.InstitutionID
+		}
+
 		q = q.Values(
 			or.ID,
 			or.CourseID,
@@ -108,6 +117,7 @@ func InsertMultipleOutcomeResults(db services.DB, req *[]OutcomeResultInsertRequ
 			or.Score,
 			or.Possible,
 			or.SubmissionTime,
+			institutionID,
 		)
 	}
 
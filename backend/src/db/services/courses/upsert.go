@@ -12,6 +12,11 @@ type UpsertRequest struct {
 	State      string
 	UUID       string
 	CourseID   int64
+	// EnrollmentTermID and RootAccountID are used to pick a grading policy
+	EnrollmentTermID uint64
+	RootAccountID    uint64
+	// InstitutionID is the course's institution, or 0 for the default Canvas instance
+	InstitutionID uint64
 }
 
 type AssignmentUpsertRequest struct {
@@ -22,7 +27,8 @@ type AssignmentUpsertRequest struct {
 	DueAt    string
 }
 
-// UpsertMultiple takes a course and if it already exists in the database, it ignores it (otherwise it's inserted)
+// UpsertMultiple takes a course and if it already exists in the database, it only updates its
+// enrollment term and root account (otherwise it's inserted)
 func UpsertMultiple(db services.DB, c *[]UpsertRequest) error {
 	q := util.Sq.
 		Insert("courses").
@@ -32,27 +38,35 @@ func UpsertMultiple(db services.DB, c *[]UpsertRequest) error {
 			"state",
 			"uuid",
 			"course_id",
+			"enrollment_term_id",
+			"root_account_id",
+			"institution_id",
 		).
-		Suffix("ON CONFLICT DO NOTHING")
+		// course IDs are only unique within an institution (see migrations/009_institutions.sql)
+		Suffix("ON CONFLICT (institution_key, course_id) DO UPDATE SET " +
+			"enrollment_term_id = EXCLUDED.enrollment_term_id, " +
+			"root_account_id = EXCLUDED.root_account_id")
 
 	for _, course := range *c {
+		var courseCode interface{}
 		if course.Name != course.CourseCode {
-			q = q.Values(
-				course.Name,
-				course.CourseCode,
-				course.State,
-				course.UUID,
-				course.CourseID,
-			)
-			continue
+			courseCode = course.CourseCode
+		}
+
+		var institutionID interface{}
+		if course.InstitutionID > 0 {
+			institutionID = course.InstitutionID
 		}
 
 		q = q.Values(
 			course.Name,
-			nil,
+			courseCode,
 			course.State,
 			course.UUID,
 			course.CourseID,
+			course.EnrollmentTermID,
+			course.RootAccountID,
+			institutionID,
 		)
 	}
 
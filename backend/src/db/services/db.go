@@ -1,6 +1,9 @@
 package services
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+)
 
 const DefaultSelectLimit = 1000
 
@@ -11,3 +14,50 @@ type DB interface {
 	Prepare(query string) (*sql.Stmt, error)
 	Exec(query string, args ...interface{}) (sql.Result, error)
 }
+
+// contextDB is the context-aware half of sql.Tx and sql.DB.
+type contextDB interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// withContext runs every query on a contextDB with ctx.
+type withContext struct {
+	ctx context.Context
+	db  contextDB
+}
+
+/*
+WithContext returns a DB that runs its queries with ctx, so they're canceled with it.
+Any service can be given one without changing its signature, like:
+
+	users.List(services.WithContext(ctx, db), &users.ListRequest{})
+
+If db doesn't support contexts, it's returned as-is.
+*/
+func WithContext(ctx context.Context, db DB) DB {
+	cdb, ok := db.(contextDB)
+	if !ok || ctx == nil {
+		return db
+	}
+
+	return withContext{ctx: ctx, db: cdb}
+}
+
+func (w withContext) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return w.db.QueryContext(w.ctx, query, args...)
+}
+
+func (w withContext) QueryRow(query string, args ...interface{}) *sql.Row {
+	return w.db.QueryRowContext(w.ctx, query, args...)
+}
+
+func (w withContext) Prepare(query string) (*sql.Stmt, error) {
+	return w.db.PrepareContext(w.ctx, query)
+}
+
+func (w withContext) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return w.db.ExecContext(w.ctx, query, args...)
+}
@@ -0,0 +1,89 @@
+package drop_cutoffs
+
+import (
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"time"
+)
+
+// InsertRequest is the request for Insert. Exactly one of EnrollmentTermID and CourseID should be set.
+type InsertRequest struct {
+	Name             string
+	EnrollmentTermID uint64
+	CourseID         uint64
+	StartsAt         time.Time
+	EndsAt           *time.Time
+	// InstitutionID is the institution the cutoff applies to, or 0 for the default Canvas instance
+	InstitutionID uint64
+}
+
+func Insert(db services.DB, req *InsertRequest) (*DropCutoff, error) {
+	var (
+		enrollmentTermID interface{}
+		courseID         interface{}
+		institutionID    interface{}
+	)
+	if req.CourseID > 0 {
+		courseID = req.CourseID
+	} else {
+		enrollmentTermID = req.EnrollmentTermID
+	}
+
+	if req.InstitutionID > 0 {
+		institutionID = req.InstitutionID
+	}
+
+	query, args, err := util.Sq.
+		Insert("drop_cutoffs").
+		SetMap(map[string]interface{}{
+			"name":               req.Name,
+			"enrollment_term_id": enrollmentTermID,
+			"course_id":          courseID,
+			"starts_at":          req.StartsAt,
+			"ends_at":            req.EndsAt,
+			"institution_id":     institutionID,
+		}).
+		Suffix("RETURNING id, inserted_at").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building insert drop cutoff sql: %w", err)
+	}
+
+	c := DropCutoff{
+		Name:             req.Name,
+		EnrollmentTermID: req.EnrollmentTermID,
+		CourseID:         req.CourseID,
+		StartsAt:         req.StartsAt,
+		EndsAt:           req.EndsAt,
+		InstitutionID:    req.InstitutionID,
+	}
+	if req.CourseID > 0 {
+		c.EnrollmentTermID = 0
+	}
+
+	err = db.QueryRow(query, args...).Scan(&c.ID, &c.InsertedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error executing insert drop cutoff sql: %w", err)
+	}
+
+	return &c, nil
+}
+
+func Delete(db services.DB, id uint64) error {
+	query, args, err := util.Sq.
+		Delete("drop_cutoffs").
+		Where(sq.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error building delete drop cutoff sql: %w", err)
+	}
+
+	_, err = db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error executing delete drop cutoff sql: %w", err)
+	}
+
+	return nil
+}
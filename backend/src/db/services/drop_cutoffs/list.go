@@ -0,0 +1,168 @@
+package drop_cutoffs
+
+import (
+	"database/sql"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"time"
+)
+
+// DropCutoff is a window, usually the end of a grading period, when an outcome's lowest score
+// can't be dropped anymore. It applies to either every course in a Canvas enrollment term or
+// a single course.
+type DropCutoff struct {
+	ID               uint64
+	Name             string
+	EnrollmentTermID uint64
+	CourseID         uint64
+	// StartsAt is when scores stop being dropped.
+	StartsAt time.Time
+	// EndsAt is when scores can be dropped again, like the start of the next grading period.
+	// If nil, the cutoff never ends.
+	EndsAt *time.Time
+	// InstitutionID is the institution the cutoff applies to, or 0 for the default Canvas instance
+	InstitutionID uint64
+	InsertedAt    time.Time
+}
+
+type ListRequest struct {
+	IDs []uint64
+}
+
+// Set holds every drop cutoff so that cutoffs can be picked without hitting the db.
+type Set struct {
+	Cutoffs []DropCutoff
+}
+
+// InEffect is whether the cutoff is in effect at t.
+func (c DropCutoff) InEffect(t time.Time) bool {
+	return !t.Before(c.StartsAt) && (c.EndsAt == nil || t.Before(*c.EndsAt))
+}
+
+/*
+ForCourse picks the cutoff for a course at an institution (0 for the default Canvas instance) at t:
+the one in effect, or if there isn't one, the next one to start. Cutoffs for the course beat cutoffs
+for its enrollment term.
+
+If there's no current or upcoming cutoff, it returns nil.
+*/
+func (s Set) ForCourse(institutionID uint64, courseID uint64, enrollmentTermID uint64, t time.Time) *DropCutoff {
+	var courseCutoffs, termCutoffs []DropCutoff
+	for _, c := range s.Cutoffs {
+		if c.InstitutionID != institutionID {
+			continue
+		}
+
+		switch {
+		case c.CourseID > 0:
+			if c.CourseID == courseID {
+				courseCutoffs = append(courseCutoffs, c)
+			}
+		case c.EnrollmentTermID > 0:
+			if c.EnrollmentTermID == enrollmentTermID {
+				termCutoffs = append(termCutoffs, c)
+			}
+		}
+	}
+
+	if c := currentOrNext(courseCutoffs, t); c != nil {
+		return c
+	}
+
+	return currentOrNext(termCutoffs, t)
+}
+
+func currentOrNext(cs []DropCutoff, t time.Time) *DropCutoff {
+	var next *DropCutoff
+	for _, c := range cs {
+		if c.InEffect(t) {
+			cc := c
+			return &cc
+		}
+
+		if c.StartsAt.After(t) && (next == nil || c.StartsAt.Before(next.StartsAt)) {
+			cc := c
+			next = &cc
+		}
+	}
+
+	return next
+}
+
+func List(db services.DB, req *ListRequest) (*[]DropCutoff, error) {
+	q := util.Sq.
+		Select(
+			"id",
+			"name",
+			"enrollment_term_id",
+			"course_id",
+			"starts_at",
+			"ends_at",
+			"institution_id",
+			"inserted_at",
+		).
+		From("drop_cutoffs").
+		OrderBy("starts_at")
+
+	if len(req.IDs) > 0 {
+		q = q.Where(sq.Eq{"id": req.IDs})
+	}
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building list drop cutoffs sql: %w", err)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing list drop cutoffs sql: %w", err)
+	}
+
+	defer rows.Close()
+
+	var cs []DropCutoff
+	for rows.Next() {
+		var (
+			c                DropCutoff
+			enrollmentTermID sql.NullInt64
+			courseID         sql.NullInt64
+			endsAt           sql.NullTime
+			institutionID    sql.NullInt64
+		)
+		err := rows.Scan(
+			&c.ID,
+			&c.Name,
+			&enrollmentTermID,
+			&courseID,
+			&c.StartsAt,
+			&endsAt,
+			&institutionID,
+			&c.InsertedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning list drop cutoffs sql: %w", err)
+		}
+
+		if enrollmentTermID.Valid {
+			c.EnrollmentTermID = uint64(enrollmentTermID.Int64)
+		}
+
+		if courseID.Valid {
+			c.CourseID = uint64(courseID.Int64)
+		}
+
+		if endsAt.Valid {
+			c.EndsAt = &endsAt.Time
+		}
+
+		if institutionID.Valid {
+			c.InstitutionID = uint64(institutionID.Int64)
+		}
+
+		cs = append(cs, c)
+	}
+
+	return &cs, nil
+}
@@ -1,6 +1,7 @@
 package enrollments
 
 import (
+	"database/sql"
 	"fmt"
 	sq "github.com/Masterminds/squirrel"
 	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
@@ -189,7 +190,9 @@ type Enrollment struct {
 	Type         Type
 	Role         Role
 	State        State
-	InsertedAt   time.Time
+	// InstitutionID is 0 for enrollments from the default Canvas instance
+	InstitutionID uint64
+	InsertedAt    time.Time
 }
 
 // ListRequest is the request for enrollments.List.
@@ -199,9 +202,11 @@ type ListRequest struct {
 	CourseIDs    []uint64
 	UserCanvasID uint64
 	UserID       uint64
-	Type         Type
-	Role         Role
-	State        State
+	// InstitutionID, if not nil, only lists enrollments from that institution (0 for the default Canvas instance).
+	InstitutionID *uint64
+	Type          Type
+	Role          Role
+	State         State
 }
 
 // List lists enrollments.
@@ -214,6 +219,7 @@ func List(db services.DB, req *ListRequest) (*[]Enrollment, error) {
 			"enrollments.enrollment_type",
 			"enrollments.enrollment_role",
 			"enrollments.enrollment_state",
+			"enrollments.institution_id",
 			"enrollments.inserted_at",
 		).
 		From("enrollments")
@@ -236,10 +242,15 @@ func List(db services.DB, req *ListRequest) (*[]Enrollment, error) {
 
 	if req.UserID > 0 {
 		q = q.
-			Join("users ON enrollments.user_canvas_id = users.canvas_user_id").
+			Join("users ON enrollments.user_canvas_id = users.canvas_user_id AND " +
+				"enrollments.institution_key = users.institution_key").
 			Where(sq.Eq{"users.id": req.UserID})
 	}
 
+	if req.InstitutionID != nil {
+		q = q.Where(sq.Eq{"enrollments.institution_key": *req.InstitutionID})
+	}
+
 	if len(req.Type) > 0 {
 		q = q.Where(sq.Eq{"enrollments.enrollment_type": req.Type})
 	}
@@ -266,7 +277,11 @@ func List(db services.DB, req *ListRequest) (*[]Enrollment, error) {
 
 	var es []Enrollment
 	for rows.Next() {
-		var e Enrollment
+		var (
+			e             Enrollment
+			institutionID sql.NullInt64
+		)
+
 		err := rows.Scan(
 			&e.ID,
 			&e.CourseID,
@@ -274,12 +289,17 @@ func List(db services.DB, req *ListRequest) (*[]Enrollment, error) {
 			&e.Type,
 			&e.Role,
 			&e.State,
+			&institutionID,
 			&e.InsertedAt,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning list enrollments sql: %w", err)
 		}
 
+		if institutionID.Valid {
+			e.InstitutionID = uint64(institutionID.Int64)
+		}
+
 		es = append(es, e)
 	}
 
@@ -17,6 +17,8 @@ type UpsertRequest struct {
 	State                  string
 	CreatedAt              string
 	UpdatedAt              string
+	// InstitutionID is the course's institution, or 0 for the default Canvas instance
+	InstitutionID uint64
 }
 
 // Upsert upserts an enrollment.
@@ -33,9 +35,10 @@ func Upsert(db services.DB, req *[]UpsertRequest) error {
 			"enrollment_state",
 			"created_at",
 			"updated_at",
+			"institution_id",
 		).
 		Suffix(
-			"ON CONFLICT (course_id, user_canvas_id) DO UPDATE SET " +
+			"ON CONFLICT (institution_key, course_id, user_canvas_id) DO UPDATE SET " +
 				"enrollment_type = EXCLUDED.enrollment_type, " +
 				"enrollment_role = EXCLUDED.enrollment_role, " +
 				"enrollment_state = EXCLUDED.enrollment_state, " +
@@ -51,7 +54,7 @@ func Upsert(db services.DB, req *[]UpsertRequest) error {
 		}
 
 		var (
-			canvasID, associatedUserCanvasID, createdAt, updatedAt interface{}
+			canvasID, associatedUserCanvasID, createdAt, updatedAt, institutionID interface{}
 		)
 
 		if r.CanvasID > 0 {
@@ -70,6 +73,10 @@ func Upsert(db services.DB, req *[]UpsertRequest) error {
 			updatedAt = r.UpdatedAt
 		}
 
+		if r.InstitutionID > 0 {
+			institutionID = r.InstitutionID
+		}
+
 		q = q.Values(
 			canvasID,
 			r.CourseID,
@@ -80,6 +87,7 @@ func Upsert(db services.DB, req *[]UpsertRequest) error {
 			r.State,
 			createdAt,
 			updatedAt,
+			institutionID,
 		)
 	}
 
@@ -0,0 +1,105 @@
+package fetch_jobs
+
+import (
+	"database/sql"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"strings"
+	"time"
+)
+
+/*
+claimableJobSQL picks the next job to run. A job can run if it's queued and due, or if it's running but its
+lock expired, which means its worker died, and it has attempts left (see FailExpired for ones that don't).
+Jobs wait until every job in their run with a lower priority is done.
+
+SKIP LOCKED lets many workers claim jobs at once without getting the same one.
+*/
+const claimableJobSQL = `SELECT j.id FROM fetch_jobs j
+WHERE ((j.status = 'queued' AND j.run_after <= now())
+	OR (j.status = 'running' AND j.locked_until < now() AND j.attempts < j.max_attempts))
+AND NOT EXISTS (
+	SELECT 1 FROM fetch_jobs b
+	WHERE b.run_id = j.run_id AND b.priority < j.priority AND b.status IN ('queued', 'running')
+)
+ORDER BY j.priority, j.id
+LIMIT 1
+FOR UPDATE SKIP LOCKED`
+
+/*
+Claim takes the next job to run, marking it as running for lease. If the job isn't finished by then,
+another worker can take it.
+
+If there isn't a job to run, the returned job is nil.
+*/
+func Claim(db services.DB, lease time.Duration) (*Job, error) {
+	query, args, err := util.Sq.
+		Update("fetch_jobs").
+		Set("status", StatusRunning).
+		Set("attempts", sq.Expr("attempts + 1")).
+		Set("locked_until", time.Now().Add(lease)).
+		Set("updated_at", sq.Expr("now()")).
+		Where("id = (" + claimableJobSQL + ")").
+		Suffix("RETURNING " + strings.Join(jobColumns, ", ")).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building claim fetch job sql: %w", err)
+	}
+
+	j, err := scanJob(db.QueryRow(query, args...))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error executing claim fetch job sql: %w", err)
+	}
+
+	return j, nil
+}
+
+// expiredLeaseError is the error of a job that FailExpired fails.
+const expiredLeaseError = "the job's lease expired on its last attempt, so its worker probably died"
+
+/*
+FailExpired marks running jobs whose lock expired and that are out of attempts as failed, as Claim won't take them
+again. It returns the jobs it failed.
+
+Without it, a job that crashes its worker every time would stay running forever, and hold up the rest of its run.
+*/
+func FailExpired(db services.DB) (*[]Job, error) {
+	query, args, err := util.Sq.
+		Update("fetch_jobs").
+		Set("status", StatusFailed).
+		Set("locked_until", nil).
+		Set("error", expiredLeaseError).
+		Set("error_transient", true).
+		Set("updated_at", sq.Expr("now()")).
+		Where(sq.Eq{"status": StatusRunning}).
+		Where("locked_until < now()").
+		Where("attempts >= max_attempts").
+		Suffix("RETURNING " + strings.Join(jobColumns, ", ")).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building fail expired fetch jobs sql: %w", err)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing fail expired fetch jobs sql: %w", err)
+	}
+
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning fail expired fetch jobs sql: %w", err)
+		}
+
+		jobs = append(jobs, *j)
+	}
+
+	return &jobs, nil
+}
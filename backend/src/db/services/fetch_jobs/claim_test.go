@@ -0,0 +1,200 @@
+package fetch_jobs
+
+import (
+	"database/sql"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"os"
+	"testing"
+	"time"
+)
+
+/*
+The claim is all SQL, so these tests need a real database with the migrations applied, at TEST_DATABASE_DSN.
+They're skipped without one. Each test runs in a transaction that empties fetch_jobs and is rolled back, so the
+database is left as it was.
+*/
+
+// testJob is a job to insert for a test. Durations are from now.
+type testJob struct {
+	runID        string
+	canvasUserID uint64
+	priority     int
+	status       Status
+	attempts     int
+	maxAttempts  int
+	runAfter     time.Duration
+	// lockedFor is only used for running jobs
+	lockedFor time.Duration
+}
+
+func insertTestJob(t *testing.T, db *sql.Tx, j testJob) {
+	var lockedUntil interface{}
+	if j.status == StatusRunning {
+		lockedUntil = time.Now().Add(j.lockedFor)
+	}
+
+	query, args, err := util.Sq.
+		Insert("fetch_jobs").
+		SetMap(map[string]interface{}{
+			"run_id":         j.runID,
+			"kind":           KindStudent,
+			"canvas_user_id": j.canvasUserID,
+			"priority":       j.priority,
+			"status":         j.status,
+			"attempts":       j.attempts,
+			"max_attempts":   j.maxAttempts,
+			"run_after":      time.Now().Add(j.runAfter),
+			"locked_until":   lockedUntil,
+		}).
+		ToSql()
+	if err != nil {
+		t.Fatalf("error building insert test fetch job sql: %v", err)
+	}
+
+	_, err = db.Exec(query, args...)
+	if err != nil {
+		t.Fatalf("error inserting test fetch job: %v", err)
+	}
+}
+
+func TestClaim(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if len(dsn) < 1 {
+		t.Skip("TEST_DATABASE_DSN isn't set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("error opening test database: %v", err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name string
+		jobs []testJob
+		// wantCanvasUserID is the claimed job's user, or 0 if nothing should be claimed
+		wantCanvasUserID uint64
+		wantAttempts     int
+	}{
+		{
+			name: "lowest_priority_first",
+			jobs: []testJob{
+				{runID: "a", canvasUserID: 2, priority: 1, status: StatusQueued, maxAttempts: 1},
+				{runID: "a", canvasUserID: 1, priority: 0, status: StatusQueued, maxAttempts: 1},
+			},
+			wantCanvasUserID: 1,
+			wantAttempts:     1,
+		},
+		{
+			name: "gated_by_running_lower_priority",
+			jobs: []testJob{
+				{runID: "a", canvasUserID: 1, priority: 0, status: StatusRunning, attempts: 1, maxAttempts: 1,
+					lockedFor: time.Minute},
+				{runID: "a", canvasUserID: 2, priority: 1, status: StatusQueued, maxAttempts: 1},
+			},
+		},
+		{
+			name: "gated_by_queued_lower_priority_that_cant_run_yet",
+			jobs: []testJob{
+				{runID: "a", canvasUserID: 1, priority: 0, status: StatusQueued, maxAttempts: 1, runAfter: time.Minute},
+				{runID: "a", canvasUserID: 2, priority: 1, status: StatusQueued, maxAttempts: 1},
+			},
+		},
+		{
+			name: "not_gated_by_finished_lower_priority",
+			jobs: []testJob{
+				{runID: "a", canvasUserID: 1, priority: 0, status: StatusSucceeded, attempts: 1, maxAttempts: 1},
+				{runID: "a", canvasUserID: 3, priority: 0, status: StatusFailed, attempts: 1, maxAttempts: 1},
+				{runID: "a", canvasUserID: 2, priority: 1, status: StatusQueued, maxAttempts: 1},
+			},
+			wantCanvasUserID: 2,
+			wantAttempts:     1,
+		},
+		{
+			name: "not_gated_by_another_run",
+			jobs: []testJob{
+				{runID: "a", canvasUserID: 1, priority: 0, status: StatusRunning, attempts: 1, maxAttempts: 1,
+					lockedFor: time.Minute},
+				{runID: "b", canvasUserID: 2, priority: 1, status: StatusQueued, maxAttempts: 1},
+			},
+			wantCanvasUserID: 2,
+			wantAttempts:     1,
+		},
+		{
+			name: "not_before_run_after",
+			jobs: []testJob{
+				{runID: "a", canvasUserID: 1, priority: 0, status: StatusQueued, maxAttempts: 2, runAfter: time.Minute},
+			},
+		},
+		{
+			name: "expired_lease_reclaimed_with_attempts_left",
+			jobs: []testJob{
+				{runID: "a", canvasUserID: 1, priority: 0, status: StatusRunning, attempts: 1, maxAttempts: 2,
+					lockedFor: -time.Minute},
+			},
+			wantCanvasUserID: 1,
+			wantAttempts:     2,
+		},
+		{
+			name: "no_reclaim_after_max_attempts",
+			jobs: []testJob{
+				{runID: "a", canvasUserID: 1, priority: 0, status: StatusRunning, attempts: 2, maxAttempts: 2,
+					lockedFor: -time.Minute},
+			},
+		},
+		{
+			name: "out_of_attempts_job_still_gates_its_run",
+			jobs: []testJob{
+				{runID: "a", canvasUserID: 1, priority: 0, status: StatusRunning, attempts: 1, maxAttempts: 1,
+					lockedFor: -time.Minute},
+				{runID: "a", canvasUserID: 2, priority: 1, status: StatusQueued, maxAttempts: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trx, err := db.Begin()
+			if err != nil {
+				t.Fatalf("error beginning transaction: %v", err)
+			}
+			defer trx.Rollback()
+
+			_, err = trx.Exec("DELETE FROM fetch_jobs")
+			if err != nil {
+				t.Fatalf("error emptying fetch_jobs: %v", err)
+			}
+
+			for _, j := range tt.jobs {
+				insertTestJob(t, trx, j)
+			}
+
+			got, err := Claim(trx, time.Minute)
+			if err != nil {
+				t.Fatalf("Claim() error = %v", err)
+			}
+
+			if tt.wantCanvasUserID == 0 {
+				if got != nil {
+					t.Errorf("Claim() claimed user %d's job, want no job", got.CanvasUserID)
+				}
+				return
+			}
+
+			if got == nil {
+				t.Fatalf("Claim() claimed no job, want user %d's", tt.wantCanvasUserID)
+			}
+			if got.CanvasUserID != tt.wantCanvasUserID {
+				t.Errorf("Claim() claimed user %d's job, want user %d's", got.CanvasUserID, tt.wantCanvasUserID)
+			}
+			if got.Status != StatusRunning {
+				t.Errorf("Claim() status = %s, want %s", got.Status, StatusRunning)
+			}
+			if got.Attempts != tt.wantAttempts {
+				t.Errorf("Claim() attempts = %d, want %d", got.Attempts, tt.wantAttempts)
+			}
+			if !got.LockedUntil.After(time.Now()) {
+				t.Errorf("Claim() locked until %s, want the future", got.LockedUntil)
+			}
+		})
+	}
+}
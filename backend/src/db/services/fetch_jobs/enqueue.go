@@ -0,0 +1,55 @@
+package fetch_jobs
+
+import (
+	"fmt"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+)
+
+type EnqueueRequest struct {
+	RunID        string
+	Kind         Kind
+	CanvasUserID uint64
+	// InstitutionID is CanvasUserID's institution, or 0 for the default Canvas instance
+	InstitutionID uint64
+	Priority      int
+	MaxAttempts   int
+}
+
+// enqueueChunkSize fits an insert under postgres's placeholder limit, with 6 placeholders per job.
+var enqueueChunkSize = services.CalculateChunkSize(6)
+
+// Enqueue queues jobs to run as soon as possible. Use a transaction so that a run is queued all at once.
+func Enqueue(db services.DB, req *[]EnqueueRequest) error {
+	for start := 0; start < len(*req); start += enqueueChunkSize {
+		end := start + enqueueChunkSize
+		if end > len(*req) {
+			end = len(*req)
+		}
+
+		q := util.Sq.
+			Insert("fetch_jobs").
+			Columns("run_id", "kind", "canvas_user_id", "institution_id", "priority", "max_attempts")
+
+		for _, r := range (*req)[start:end] {
+			var institutionID interface{}
+			if r.InstitutionID > 0 {
+				institutionID = r.InstitutionID
+			}
+
+			q = q.Values(r.RunID, r.Kind, r.CanvasUserID, institutionID, r.Priority, r.MaxAttempts)
+		}
+
+		query, args, err := q.ToSql()
+		if err != nil {
+			return fmt.Errorf("error building enqueue fetch jobs sql: %w", err)
+		}
+
+		_, err = db.Exec(query, args...)
+		if err != nil {
+			return fmt.Errorf("error executing enqueue fetch jobs sql: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+package fetch_jobs
+
+import (
+	"database/sql"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"time"
+)
+
+// Kind is what a job fetches.
+type Kind string
+
+const (
+	// KindTeacher fetches grades for all of a teacher's students
+	KindTeacher = Kind("teacher")
+	// KindStudent fetches a student's grades, except for courses a teacher job fetched
+	KindStudent = Kind("student")
+	// KindObserver fetches an observer's observees' grades, except for courses a teacher job fetched
+	KindObserver = Kind("observer")
+	// KindSummary runs after every other job in the run is done
+	KindSummary = Kind("summary")
+)
+
+// Status is where a job is in the queue.
+type Status string
+
+const (
+	StatusQueued    = Status("queued")
+	StatusRunning   = Status("running")
+	StatusSucceeded = Status("succeeded")
+	StatusFailed    = Status("failed")
+)
+
+// Job is a single user's fetch in a fetch_all run.
+type Job struct {
+	ID    uint64
+	RunID string
+	Kind  Kind
+	// CanvasUserID is 0 for summary jobs
+	CanvasUserID uint64
+	// InstitutionID is CanvasUserID's institution, or 0 for the default Canvas instance
+	InstitutionID uint64
+	// Priority orders jobs in a run: no job starts until every job in its run with a lower priority is done.
+	Priority    int
+	Status      Status
+	Attempts    int
+	MaxAttempts int
+	RunAfter    time.Time
+	// LockedUntil is when a running job can be taken by another worker, as its worker probably died.
+	// It's zero unless the job is running.
+	LockedUntil time.Time
+	// Error is the last attempt's error, if there was one
+	Error          string
+	ErrorTransient bool
+	// Result is JSON from a successful job
+	Result     []byte
+	InsertedAt time.Time
+	UpdatedAt  time.Time
+}
+
+type ListRequest struct {
+	RunID    string
+	Kinds    []Kind
+	Statuses []Status
+}
+
+var jobColumns = []string{
+	"id",
+	"run_id",
+	"kind",
+	"canvas_user_id",
+	"institution_id",
+	"priority",
+	"status",
+	"attempts",
+	"max_attempts",
+	"run_after",
+	"locked_until",
+	"error",
+	"error_transient",
+	"result",
+	"inserted_at",
+	"updated_at",
+}
+
+// List lists jobs, ordered by priority and then ID.
+func List(db services.DB, req *ListRequest) (*[]Job, error) {
+	q := util.Sq.
+		Select(jobColumns...).
+		From("fetch_jobs").
+		OrderBy("priority", "id")
+
+	if len(req.RunID) > 0 {
+		q = q.Where(sq.Eq{"run_id": req.RunID})
+	}
+
+	if len(req.Kinds) > 0 {
+		q = q.Where(sq.Eq{"kind": req.Kinds})
+	}
+
+	if len(req.Statuses) > 0 {
+		q = q.Where(sq.Eq{"status": req.Statuses})
+	}
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building list fetch jobs sql: %w", err)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing list fetch jobs sql: %w", err)
+	}
+
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning list fetch jobs sql: %w", err)
+		}
+
+		jobs = append(jobs, *j)
+	}
+
+	return &jobs, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanJob scans jobColumns into a Job.
+func scanJob(s scanner) (*Job, error) {
+	var (
+		j             Job
+		institutionID sql.NullInt64
+		lockedUntil   sql.NullTime
+		jobError      sql.NullString
+	)
+
+	err := s.Scan(
+		&j.ID,
+		&j.RunID,
+		&j.Kind,
+		&j.CanvasUserID,
+		&institutionID,
+		&j.Priority,
+		&j.Status,
+		&j.Attempts,
+		&j.MaxAttempts,
+		&j.RunAfter,
+		&lockedUntil,
+		&jobError,
+		&j.ErrorTransient,
+		&j.Result,
+		&j.InsertedAt,
+		&j.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if institutionID.Valid {
+		j.InstitutionID = uint64(institutionID.Int64)
+	}
+
+	j.LockedUntil = lockedUntil.Time
+	j.Error = jobError.String
+
+	return &j, nil
+}
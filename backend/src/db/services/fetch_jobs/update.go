@@ -0,0 +1,108 @@
+package fetch_jobs
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"time"
+)
+
+/*
+ErrLostLease is returned when a job's attempt can't be recorded because the job isn't on that attempt anymore:
+its lock expired and another worker claimed it. That worker records it instead.
+*/
+var ErrLostLease = errors.New("fetch job was claimed by another worker")
+
+// leaseHolder only matches a job if it's still running on attempt, so a worker that lost the job can't update it.
+func leaseHolder(id uint64, attempt int) sq.Eq {
+	return sq.Eq{"id": id, "status": StatusRunning, "attempts": attempt}
+}
+
+// checkLease returns ErrLostLease if an update didn't match a job.
+func checkLease(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	if n < 1 {
+		return ErrLostLease
+	}
+
+	return nil
+}
+
+type SucceedRequest struct {
+	ID uint64
+	// Attempt is the job's attempts when it was claimed.
+	Attempt int
+	// Result is JSON, or nil.
+	Result []byte
+}
+
+// Succeed marks a job as succeeded, with its result. It returns ErrLostLease if another worker claimed the job.
+func Succeed(db services.DB, req *SucceedRequest) error {
+	query, args, err := util.Sq.
+		Update("fetch_jobs").
+		Set("status", StatusSucceeded).
+		Set("locked_until", nil).
+		Set("error", nil).
+		Set("error_transient", false).
+		Set("result", req.Result).
+		Set("updated_at", sq.Expr("now()")).
+		Where(leaseHolder(req.ID, req.Attempt)).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error building succeed fetch job sql: %w", err)
+	}
+
+	res, err := db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error executing succeed fetch job sql: %w", err)
+	}
+
+	return checkLease(res)
+}
+
+type FailRequest struct {
+	ID uint64
+	// Attempt is the job's attempts when it was claimed.
+	Attempt   int
+	Error     string
+	Transient bool
+	// RetryAt, if not nil, queues the job to run again then. Otherwise, it's failed for good.
+	RetryAt *time.Time
+}
+
+// Fail records a failed attempt at a job, and either queues it again or marks it as failed.
+// Like Succeed, it returns ErrLostLease if another worker claimed the job.
+func Fail(db services.DB, req *FailRequest) error {
+	q := util.Sq.
+		Update("fetch_jobs").
+		Set("locked_until", nil).
+		Set("error", req.Error).
+		Set("error_transient", req.Transient).
+		Set("updated_at", sq.Expr("now()")).
+		Where(leaseHolder(req.ID, req.Attempt))
+
+	if req.RetryAt != nil {
+		q = q.Set("status", StatusQueued).Set("run_after", *req.RetryAt)
+	} else {
+		q = q.Set("status", StatusFailed)
+	}
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return fmt.Errorf("error building fail fetch job sql: %w", err)
+	}
+
+	res, err := db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error executing fail fetch job sql: %w", err)
+	}
+
+	return checkLease(res)
+}
@@ -0,0 +1,186 @@
+package fetch_runs
+
+import (
+	"database/sql"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"time"
+)
+
+// Run is a fetch_all run.
+type Run struct {
+	ID string
+	// RerunOf is the run whose failed users this run fetches again, if it's a re-run.
+	RerunOf   string
+	StartedAt time.Time
+	// FinishedAt is zero until every user in the run is done.
+	FinishedAt time.Time
+	// Counts are only set once the run is finished.
+	NumTeachers  int
+	NumBoth      int
+	NumStudents  int
+	NumObservers int
+	NumSucceeded int
+	NumErrors    int
+	NumRetried   int
+}
+
+// Finished is whether every user in the run is done.
+func (r Run) Finished() bool {
+	return !r.FinishedAt.IsZero()
+}
+
+type InsertRequest struct {
+	ID      string
+	RerunOf string
+}
+
+// Insert records a new run, started now.
+func Insert(db services.DB, req *InsertRequest) error {
+	var rerunOf *string
+	if len(req.RerunOf) > 0 {
+		rerunOf = &req.RerunOf
+	}
+
+	query, args, err := util.Sq.
+		Insert("fetch_runs").
+		Columns("id", "rerun_of").
+		Values(req.ID, rerunOf).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error building insert fetch run sql: %w", err)
+	}
+
+	_, err = db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error executing insert fetch run sql: %w", err)
+	}
+
+	return nil
+}
+
+type FinishRequest struct {
+	ID           string
+	NumTeachers  int
+	NumBoth      int
+	NumStudents  int
+	NumObservers int
+	NumSucceeded int
+	NumErrors    int
+	NumRetried   int
+}
+
+// Finish records that every user in a run is done, with the run's counts.
+func Finish(db services.DB, req *FinishRequest) error {
+	query, args, err := util.Sq.
+		Update("fetch_runs").
+		SetMap(map[string]interface{}{
+			"finished_at":   sq.Expr("now()"),
+			"num_teachers":  req.NumTeachers,
+			"num_both":      req.NumBoth,
+			"num_students":  req.NumStudents,
+			"num_observers": req.NumObservers,
+			"num_succeeded": req.NumSucceeded,
+			"num_errors":    req.NumErrors,
+			"num_retried":   req.NumRetried,
+		}).
+		Where(sq.Eq{"id": req.ID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error building finish fetch run sql: %w", err)
+	}
+
+	_, err = db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error executing finish fetch run sql: %w", err)
+	}
+
+	return nil
+}
+
+type ListRequest struct {
+	ID string
+	// Unfinished only lists runs that aren't finished.
+	Unfinished bool
+	Limit      uint64
+}
+
+// List lists runs, newest first.
+func List(db services.DB, req *ListRequest) (*[]Run, error) {
+	q := util.Sq.
+		Select(
+			"id",
+			"rerun_of",
+			"started_at",
+			"finished_at",
+			"num_teachers",
+			"num_both",
+			"num_students",
+			"num_observers",
+			"num_succeeded",
+			"num_errors",
+			"num_retried",
+		).
+		From("fetch_runs").
+		OrderBy("started_at DESC")
+
+	if len(req.ID) > 0 {
+		q = q.Where(sq.Eq{"id": req.ID})
+	}
+
+	if req.Unfinished {
+		q = q.Where(sq.Eq{"finished_at": nil})
+	}
+
+	if req.Limit > 0 {
+		q = q.Limit(req.Limit)
+	} else {
+		q = q.Limit(services.DefaultSelectLimit)
+	}
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building list fetch runs sql: %w", err)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing list fetch runs sql: %w", err)
+	}
+
+	defer rows.Close()
+
+	var runs []Run
+	for rows.Next() {
+		var (
+			r          Run
+			rerunOf    sql.NullString
+			finishedAt sql.NullTime
+		)
+
+		err := rows.Scan(
+			&r.ID,
+			&rerunOf,
+			&r.StartedAt,
+			&finishedAt,
+			&r.NumTeachers,
+			&r.NumBoth,
+			&r.NumStudents,
+			&r.NumObservers,
+			&r.NumSucceeded,
+			&r.NumErrors,
+			&r.NumRetried,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning list fetch runs sql: %w", err)
+		}
+
+		r.RerunOf = rerunOf.String
+		r.FinishedAt = finishedAt.Time
+		runs = append(runs, r)
+	}
+
+	return &runs, nil
+}
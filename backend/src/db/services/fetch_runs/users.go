@@ -0,0 +1,244 @@
+package fetch_runs
+
+import (
+	"database/sql"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/fetch_jobs"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"time"
+)
+
+// ErrorCategory is what kind of error a user's fetch failed with.
+type ErrorCategory string
+
+const (
+	// ErrorCategoryToken means the user's token was revoked or can't do what's needed, so they need to log in again.
+	ErrorCategoryToken = ErrorCategory("token")
+	// ErrorCategoryRateLimited means Canvas rate limited the user.
+	ErrorCategoryRateLimited = ErrorCategory("rate_limited")
+	// ErrorCategoryTimeout means fetching took longer than CANVAS_FETCH_ALL_USER_TIMEOUT.
+	ErrorCategoryTimeout = ErrorCategory("timeout")
+	// ErrorCategoryCanvas means Canvas had an error, or couldn't be reached.
+	ErrorCategoryCanvas = ErrorCategory("canvas")
+	// ErrorCategoryInternal means the error was on our side.
+	ErrorCategoryInternal = ErrorCategory("internal")
+)
+
+// User is a user's fetch in a run. Users who are both teachers and students have one for each.
+type User struct {
+	RunID        string
+	CanvasUserID uint64
+	// InstitutionID is CanvasUserID's institution, or 0 for the default Canvas instance
+	InstitutionID uint64
+	Kind          fetch_jobs.Kind
+	// Status is fetch_jobs.StatusQueued until the user's job is claimed. Users being retried are queued again.
+	Status   fetch_jobs.Status
+	Attempts int
+	// Error and ErrorCategory are from the last attempt, if it failed.
+	Error         string
+	ErrorCategory ErrorCategory
+	InsertedAt    time.Time
+	UpdatedAt     time.Time
+}
+
+type InsertUserRequest struct {
+	RunID         string
+	CanvasUserID  uint64
+	InstitutionID uint64
+	Kind          fetch_jobs.Kind
+}
+
+// insertUsersChunkSize fits an insert under postgres's placeholder limit, with 4 placeholders per user.
+var insertUsersChunkSize = services.CalculateChunkSize(4)
+
+// InsertUsers records users as queued in their runs.
+func InsertUsers(db services.DB, req *[]InsertUserRequest) error {
+	for start := 0; start < len(*req); start += insertUsersChunkSize {
+		end := start + insertUsersChunkSize
+		if end > len(*req) {
+			end = len(*req)
+		}
+
+		q := util.Sq.
+			Insert("fetch_run_users").
+			Columns("run_id", "canvas_user_id", "institution_id", "kind")
+
+		for _, r := range (*req)[start:end] {
+			var institutionID interface{}
+			if r.InstitutionID > 0 {
+				institutionID = r.InstitutionID
+			}
+
+			q = q.Values(r.RunID, r.CanvasUserID, institutionID, r.Kind)
+		}
+
+		query, args, err := q.ToSql()
+		if err != nil {
+			return fmt.Errorf("error building insert fetch run users sql: %w", err)
+		}
+
+		_, err = db.Exec(query, args...)
+		if err != nil {
+			return fmt.Errorf("error executing insert fetch run users sql: %w", err)
+		}
+	}
+
+	return nil
+}
+
+type UpdateUserRequest struct {
+	RunID         string
+	CanvasUserID  uint64
+	InstitutionID uint64
+	Kind          fetch_jobs.Kind
+	Status        fetch_jobs.Status
+	Attempts      int
+	Error         string
+	ErrorCategory ErrorCategory
+}
+
+// UpdateUser records where a user's fetch is. An empty Error clears the last one.
+func UpdateUser(db services.DB, req *UpdateUserRequest) error {
+	var (
+		e        *string
+		category *ErrorCategory
+	)
+
+	if len(req.Error) > 0 {
+		e = &req.Error
+		category = &req.ErrorCategory
+	}
+
+	query, args, err := util.Sq.
+		Update("fetch_run_users").
+		SetMap(map[string]interface{}{
+			"status":         req.Status,
+			"attempts":       req.Attempts,
+			"error":          e,
+			"error_category": category,
+			"updated_at":     sq.Expr("now()"),
+		}).
+		Where(sq.Eq{
+			"run_id":          req.RunID,
+			"canvas_user_id":  req.CanvasUserID,
+			"kind":            req.Kind,
+			"institution_key": req.InstitutionID,
+		}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error building update fetch run user sql: %w", err)
+	}
+
+	_, err = db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error executing update fetch run user sql: %w", err)
+	}
+
+	return nil
+}
+
+type ListUsersRequest struct {
+	RunID        string
+	CanvasUserID uint64
+	// InstitutionID, if not nil, only lists users from that institution (0 for the default Canvas instance).
+	// Use it with CanvasUserID, as Canvas user IDs are only unique within an institution.
+	InstitutionID   *uint64
+	Statuses        []fetch_jobs.Status
+	ErrorCategories []ErrorCategory
+	Limit           uint64
+}
+
+// ListUsers lists users in runs, newest runs first.
+func ListUsers(db services.DB, req *ListUsersRequest) (*[]User, error) {
+	q := util.Sq.
+		Select(
+			"fetch_run_users.run_id",
+			"fetch_run_users.canvas_user_id",
+			"fetch_run_users.institution_id",
+			"fetch_run_users.kind",
+			"fetch_run_users.status",
+			"fetch_run_users.attempts",
+			"fetch_run_users.error",
+			"fetch_run_users.error_category",
+			"fetch_run_users.inserted_at",
+			"fetch_run_users.updated_at",
+		).
+		From("fetch_run_users").
+		OrderBy("fetch_run_users.inserted_at DESC", "fetch_run_users.canvas_user_id", "fetch_run_users.kind")
+
+	if len(req.RunID) > 0 {
+		q = q.Where(sq.Eq{"fetch_run_users.run_id": req.RunID})
+	}
+
+	if req.CanvasUserID > 0 {
+		q = q.Where(sq.Eq{"fetch_run_users.canvas_user_id": req.CanvasUserID})
+	}
+
+	if req.InstitutionID != nil {
+		q = q.Where(sq.Eq{"fetch_run_users.institution_key": *req.InstitutionID})
+	}
+
+	if len(req.Statuses) > 0 {
+		q = q.Where(sq.Eq{"fetch_run_users.status": req.Statuses})
+	}
+
+	if len(req.ErrorCategories) > 0 {
+		q = q.Where(sq.Eq{"fetch_run_users.error_category": req.ErrorCategories})
+	}
+
+	if req.Limit > 0 {
+		q = q.Limit(req.Limit)
+	} else {
+		q = q.Limit(services.DefaultSelectLimit)
+	}
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building list fetch run users sql: %w", err)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing list fetch run users sql: %w", err)
+	}
+
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var (
+			u             User
+			institutionID sql.NullInt64
+			e             sql.NullString
+			category      sql.NullString
+		)
+
+		err := rows.Scan(
+			&u.RunID,
+			&u.CanvasUserID,
+			&institutionID,
+			&u.Kind,
+			&u.Status,
+			&u.Attempts,
+			&e,
+			&category,
+			&u.InsertedAt,
+			&u.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning list fetch run users sql: %w", err)
+		}
+
+		if institutionID.Valid {
+			u.InstitutionID = uint64(institutionID.Int64)
+		}
+
+		u.Error = e.String
+		u.ErrorCategory = ErrorCategory(category.String)
+		users = append(users, u)
+	}
+
+	return &users, nil
+}
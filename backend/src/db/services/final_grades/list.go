@@ -0,0 +1,103 @@
+package final_grades
+
+import (
+	"database/sql"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"time"
+)
+
+// FinalGrade is a grade frozen at the end of a term. GPA values and the course weight are
+// frozen with it so that later grading policy or weight changes don't rewrite transcripts.
+type FinalGrade struct {
+	ID               uint64
+	TermID           uint64
+	CanvasUserID     uint64
+	CourseID         uint64
+	Grade            string
+	GPAValue         float64
+	SubgradeGPAValue float64
+	Weight           float64
+	// InstitutionID is the user's institution, or 0 for the default Canvas instance
+	InstitutionID uint64
+	InsertedAt    time.Time
+}
+
+type ListRequest struct {
+	TermIDs       []uint64
+	CanvasUserIDs []uint64
+	// InstitutionID is the users' institution, or 0 for the default Canvas instance
+	InstitutionID uint64
+}
+
+func List(db services.DB, req *ListRequest) (*[]FinalGrade, error) {
+	q := util.Sq.
+		Select(
+			"id",
+			"term_id",
+			"canvas_user_id",
+			"course_id",
+			"grade",
+			"gpa_value",
+			"subgrade_gpa_value",
+			"weight",
+			"institution_id",
+			"inserted_at",
+		).
+		From("final_grades").
+		Where(sq.Eq{"institution_key": req.InstitutionID}).
+		OrderBy("term_id", "course_id")
+
+	if len(req.TermIDs) > 0 {
+		q = q.Where(sq.Eq{"term_id": req.TermIDs})
+	}
+
+	if len(req.CanvasUserIDs) > 0 {
+		q = q.Where(sq.Eq{"canvas_user_id": req.CanvasUserIDs})
+	}
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building list final grades sql: %w", err)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing list final grades sql: %w", err)
+	}
+
+	defer rows.Close()
+
+	var fgs []FinalGrade
+	for rows.Next() {
+		var (
+			fg            FinalGrade
+			institutionID sql.NullInt64
+		)
+		err := rows.Scan(
+			&fg.ID,
+			&fg.TermID,
+			&fg.CanvasUserID,
+			&fg.CourseID,
+			&fg.Grade,
+			&fg.GPAValue,
+			&fg.SubgradeGPAValue,
+			&fg.Weight,
+			&institutionID,
+			&fg.InsertedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning list final grades sql: %w", err)
+		}
+
+		if institutionID.Valid {
+			fg.InstitutionID = uint64(institutionID.Int64)
+		}
+
+		fgs = append(fgs, fg)
+	}
+
+	return &fgs, nil
+}
@@ -0,0 +1,71 @@
+package final_grades
+
+import (
+	"fmt"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+)
+
+type UpsertRequest struct {
+	TermID           uint64
+	CanvasUserID     uint64
+	CourseID         uint64
+	Grade            string
+	GPAValue         float64
+	SubgradeGPAValue float64
+	Weight           float64
+	// InstitutionID is the user's institution, or 0 for the default Canvas instance
+	InstitutionID uint64
+}
+
+// UpsertChunkSize is the max number of final grades per upsert.
+var UpsertChunkSize = services.CalculateChunkSize(8)
+
+// UpsertMultiple inserts final grades, replacing any already frozen for the same term, user and course.
+func UpsertMultiple(db services.DB, req *[]UpsertRequest) error {
+	q := util.Sq.
+		Insert("final_grades").
+		Columns(
+			"term_id",
+			"canvas_user_id",
+			"course_id",
+			"grade",
+			"gpa_value",
+			"subgrade_gpa_value",
+			"weight",
+			"institution_id",
+		).
+		Suffix("ON CONFLICT (term_id, canvas_user_id, course_id) DO UPDATE SET grade = EXCLUDED.grade, " +
+			"gpa_value = EXCLUDED.gpa_value, subgrade_gpa_value = EXCLUDED.subgrade_gpa_value, " +
+			"weight = EXCLUDED.weight")
+
+	for _, fg := range *req {
+		var institutionID interface{}
+		if fg.InstitutionID > 0 {
+			institutionID = fg.InstitutionID
+		}
+
+		q = q.Values(
+			fg.TermID,
+			fg.CanvasUserID,
+			fg.CourseID,
+			fg.Grade,
+			fg.GPAValue,
+			fg.SubgradeGPAValue,
+			fg.Weight,
+			institutionID,
+		)
+	}
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return fmt.Errorf("error building upsert final grades sql: %w", err)
+	}
+
+	_, err = db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error executing upsert final grades sql: %w", err)
+	}
+
+	return nil
+}
@@ -12,6 +12,8 @@ type InsertRequest struct {
 	GPA              float64
 	GPAWithSubgrades float64
 	ManualFetch      bool
+	// InstitutionID is the user's institution, or 0 for the default Canvas instance
+	InstitutionID uint64
 }
 
 func InsertMultiple(db services.DB, req *[]InsertRequest) error {
@@ -23,15 +25,22 @@ func InsertMultiple(db services.DB, req *[]InsertRequest) error {
 			"gpa",
 			"gpa_with_subgrades",
 			"manual_fetch",
+			"institution_id",
 		)
 
 	for _, g := range *req {
+		var institutionID interface{}
+		if g.InstitutionID > 0 {
+			institutionID = g.InstitutionID
+		}
+
 		q = q.Values(
 			g.CanvasUserID,
 			g.Weighted,
 			g.GPA,
 			g.GPAWithSubgrades,
 			g.ManualFetch,
+			institutionID,
 		)
 	}
 
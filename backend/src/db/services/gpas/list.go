@@ -0,0 +1,91 @@
+package gpas
+
+import (
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"time"
+)
+
+type GPA struct {
+	ID               uint64
+	CanvasUserID     uint64
+	Weighted         bool
+	GPA              float64
+	GPAWithSubgrades float64
+	ManualFetch      bool
+	InsertedAt       time.Time
+}
+
+type ListRequest struct {
+	CanvasUserIDs []uint64
+	// InstitutionID is the users' institution, or 0 for the default Canvas instance
+	InstitutionID uint64
+	// After is inclusive
+	After *time.Time
+	// Before is exclusive
+	Before *time.Time
+}
+
+// List lists every stored GPA, oldest first.
+func List(db services.DB, req *ListRequest) (*[]GPA, error) {
+	q := util.Sq.
+		Select(
+			"id",
+			"canvas_user_id",
+			"weighted",
+			"gpa",
+			"gpa_with_subgrades",
+			"manual_fetch",
+			"inserted_at",
+		).
+		From("gpas").
+		Where(sq.Eq{"institution_key": req.InstitutionID}).
+		OrderBy("inserted_at", "id")
+
+	if len(req.CanvasUserIDs) > 0 {
+		q = q.Where(sq.Eq{"canvas_user_id": req.CanvasUserIDs})
+	}
+
+	if req.After != nil {
+		q = q.Where(sq.GtOrEq{"inserted_at": *req.After})
+	}
+
+	if req.Before != nil {
+		q = q.Where(sq.Lt{"inserted_at": *req.Before})
+	}
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building list gpas sql: %w", err)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing list gpas sql: %w", err)
+	}
+
+	defer rows.Close()
+
+	var gs []GPA
+	for rows.Next() {
+		var g GPA
+		err := rows.Scan(
+			&g.ID,
+			&g.CanvasUserID,
+			&g.Weighted,
+			&g.GPA,
+			&g.GPAWithSubgrades,
+			&g.ManualFetch,
+			&g.InsertedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning list gpas sql: %w", err)
+		}
+
+		gs = append(gs, g)
+	}
+
+	return &gs, nil
+}
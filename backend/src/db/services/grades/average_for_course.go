@@ -5,28 +5,47 @@ import (
 	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
 	"github.com/iamtheyammer/canvascbl/backend/src/util"
 	"github.com/pkg/errors"
+	"strings"
 )
 
 // CourseGradeAverage represents the average grade for a course, represented as a float.
 type CourseGradeAverage struct {
 	// NumInputs is the number of students' grades factored into the average
 	NumInputs uint64
-	// Average is the average grade in the class, as a float. 0 represents all I's and 6 represents all A's.
-	// See src/db/canvas/grades/calculate_grade_from_outcomes.go for the rank of each grade.
+	// Average is the average grade rank in the class, as a float. With the default grading policy,
+	// 0 represents all I's and 6 represents all A's.
 	Average float64
 }
 
-func GetAverageForCourse(db services.DB, courseID uint64) (*CourseGradeAverage, error) {
+// GetAverageForCourse gets the average grade rank for a course at an institution (0 for the default Canvas instance).
+// ranks should map each grade to its rank, like grading_policies.Policy.Ranks() returns.
+// Grades not in ranks are counted as 0.
+func GetAverageForCourse(
+	db services.DB,
+	institutionID uint64,
+	courseID uint64,
+	ranks map[string]int8,
+) (*CourseGradeAverage, error) {
+	var (
+		gradeCase strings.Builder
+		args      []interface{}
+	)
+	gradeCase.WriteString("CASE")
+	for g, r := range ranks {
+		gradeCase.WriteString(" WHEN grade=? THEN ?")
+		args = append(args, g, r)
+	}
+	gradeCase.WriteString(" ELSE 0 END")
+	args = append(args, courseID, institutionID)
+
 	query, args, err := util.Sq.
 		Select("COUNT(*) AS num_inputs", "AVG(grade_ints.grade_int) AS avg").
 		Prefix(
-			"WITH grade_ints AS(SELECT DISTINCT ON(user_canvas_id)(CASE WHEN grade='I' "+
-				"THEN 0 WHEN grade='C' THEN 1 WHEN grade='B-' THEN 2 WHEN grade='B' THEN 3 "+
-				"WHEN grade='B+' THEN 4 WHEN grade='A-' THEN 5 WHEN grade='A' THEN 6 ELSE 0 END)"+
-				"AS grade_int FROM grades WHERE inserted_at>NOW()-interval'24 hours' AND course_id=? "+
+			"WITH grade_ints AS(SELECT DISTINCT ON(user_canvas_id)("+gradeCase.String()+")"+
+				"AS grade_int FROM grades WHERE inserted_at>NOW()-interval'24 hours' AND course_id=? AND institution_key=? "+
 				"GROUP BY grades.grade,grades.user_canvas_id,grades.inserted_at ORDER BY "+
 				"user_canvas_id,inserted_at DESC)",
-			courseID).
+			args...).
 		From("grade_ints").
 		ToSql()
 	if err != nil {
@@ -0,0 +1,88 @@
+package grades
+
+import (
+	sq "github.com/Masterminds/squirrel"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"github.com/pkg/errors"
+	"time"
+)
+
+type ListHistoryRequest struct {
+	UserCanvasID uint64
+	// InstitutionID, if not nil, only lists grades from that institution (0 for the default Canvas instance).
+	InstitutionID *uint64
+	CourseIDs     []uint64
+	// After is inclusive
+	After *time.Time
+	// Before is exclusive
+	Before *time.Time
+}
+
+/*
+ListHistory lists every stored grade for a user, unlike List, which only gets the latest.
+
+Grades are ordered by course, then oldest first.
+*/
+func ListHistory(db services.DB, req *ListHistoryRequest) (*[]Grade, error) {
+	q := util.Sq.
+		Select(
+			"id",
+			"user_canvas_id",
+			"course_id",
+			"grade",
+			"manual_fetch",
+			"inserted_at",
+		).
+		From("grades").
+		Where(sq.Eq{"user_canvas_id": req.UserCanvasID}).
+		OrderBy("course_id", "inserted_at", "id")
+
+	if req.InstitutionID != nil {
+		q = q.Where(sq.Eq{"institution_key": *req.InstitutionID})
+	}
+
+	if len(req.CourseIDs) > 0 {
+		q = q.Where(sq.Eq{"course_id": req.CourseIDs})
+	}
+
+	if req.After != nil {
+		q = q.Where(sq.GtOrEq{"inserted_at": *req.After})
+	}
+
+	if req.Before != nil {
+		q = q.Where(sq.Lt{"inserted_at": *req.Before})
+	}
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "error building list grade history sql")
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening rows in list grade history sql")
+	}
+
+	defer rows.Close()
+
+	var grades []Grade
+	for rows.Next() {
+		var g Grade
+		err := rows.Scan(
+			&g.ID,
+			&g.UserCanvasID,
+			&g.CourseID,
+			&g.Grade,
+			&g.ManualFetch,
+			&g.InsertedAt,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "error scanning list grade history sql")
+		}
+
+		grades = append(grades, g)
+	}
+
+	return &grades, nil
+}
@@ -11,19 +11,27 @@ type InsertRequest struct {
 	ManualFetch  bool
 	CourseID     int
 	UserCanvasID int
+	// InstitutionID is the course's institution, or 0 for the default Canvas instance
+	InstitutionID uint64
 }
 
 func Insert(db services.DB, req *[]InsertRequest) error {
 	q := util.Sq.
 		Insert("grades").
-		Columns("course_id", "grade", "user_canvas_id", "manual_fetch")
+		Columns("course_id", "grade", "user_canvas_id", "manual_fetch", "institution_id")
 
 	for _, r := range *req {
+		var institutionID interface{}
+		if r.InstitutionID > 0 {
+			institutionID = r.InstitutionID
+		}
+
 		q = q.Values(
 			r.CourseID,
 			r.Grade,
 			r.UserCanvasID,
 			r.ManualFetch,
+			institutionID,
 		)
 	}
 
@@ -14,6 +14,9 @@ type ListRequest struct {
 	After         *time.Time
 	CourseIDs     *[]uint64
 	ManualFetch   *bool
+	// InstitutionID, if not nil, only lists grades from that institution (0 for the default Canvas instance).
+	// Use it with UserCanvasIDs or CourseIDs, as Canvas IDs are only unique within an institution.
+	InstitutionID *uint64
 }
 
 type Grade struct {
@@ -41,8 +44,11 @@ func List(db services.DB, req *ListRequest) (*[]Grade, error) {
 			"inserted_at",
 		).
 		From("grades").
-		OrderBy("grades.course_id, grades.user_canvas_id, grades.inserted_at DESC").
-		Where(sq.Eq{"user_canvas_id": req.UserCanvasIDs})
+		OrderBy("grades.course_id, grades.user_canvas_id, grades.inserted_at DESC")
+
+	if req.UserCanvasIDs != nil {
+		q = q.Where(sq.Eq{"user_canvas_id": *req.UserCanvasIDs})
+	}
 
 	if req.Before != nil {
 		// using this weird workaround because it doesn't work any other way
@@ -61,6 +67,10 @@ func List(db services.DB, req *ListRequest) (*[]Grade, error) {
 		q = q.Where(sq.Eq{"manual_fetch": *req.ManualFetch})
 	}
 
+	if req.InstitutionID != nil {
+		q = q.Where(sq.Eq{"institution_key": *req.InstitutionID})
+	}
+
 	query, args, err := q.ToSql()
 	if err != nil {
 		return nil, errors.Wrap(err, "error building list grades sql")
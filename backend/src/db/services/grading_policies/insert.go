@@ -0,0 +1,174 @@
+package grading_policies
+
+import (
+	"database/sql"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+)
+
+// AssignRequest is the request for Assign. Exactly one of RootAccountID, EnrollmentTermID and CourseID should be set.
+type AssignRequest struct {
+	GradingPolicyID  uint64
+	RootAccountID    uint64
+	EnrollmentTermID uint64
+	CourseID         uint64
+	// InstitutionID is the institution whose Canvas IDs the scope uses, or 0 for the default Canvas instance
+	InstitutionID uint64
+}
+
+// Insert inserts a grading policy and its bands, returning the inserted policy.
+func Insert(db services.DB, req *Policy) (*Policy, error) {
+	query, args, err := util.Sq.
+		Insert("grading_policies").
+		SetMap(map[string]interface{}{
+			"name":                  req.Name,
+			"most_percent":          req.MostPercent,
+			"drop_worst_score":      req.DropWorstScore,
+			"drop_worst_min_scores": req.DropWorstMinScores,
+			"calculation_method":    req.CalculationMethod,
+		}).
+		Suffix("RETURNING id, inserted_at").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building insert grading policy sql: %w", err)
+	}
+
+	p := *req
+	err = db.QueryRow(query, args...).Scan(&p.ID, &p.InsertedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error executing insert grading policy sql: %w", err)
+	}
+
+	if len(p.Bands) < 1 {
+		return &p, nil
+	}
+
+	q := util.Sq.
+		Insert("grading_policy_bands").
+		Columns(
+			"grading_policy_id",
+			"grade",
+			"rank",
+			"most_above",
+			"all_above",
+			"gpa_value",
+			"subgrade_gpa_value",
+			"most_above_rating",
+			"all_above_rating",
+		)
+
+	// nullString turns empty ratings into NULLs
+	nullString := func(s string) sql.NullString {
+		return sql.NullString{String: s, Valid: len(s) > 0}
+	}
+
+	for _, b := range p.Bands {
+		q = q.Values(
+			p.ID,
+			b.Grade,
+			b.Rank,
+			b.MostAbove,
+			b.AllAbove,
+			b.GPAVal,
+			b.SubgradeGPAVal,
+			nullString(b.MostAboveRating),
+			nullString(b.AllAboveRating),
+		)
+	}
+
+	query, args, err = q.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building insert grading policy bands sql: %w", err)
+	}
+
+	_, err = db.Exec(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing insert grading policy bands sql: %w", err)
+	}
+
+	return &p, nil
+}
+
+// Assign assigns a grading policy to a scope, replacing any policy already assigned to that scope.
+func Assign(db services.DB, req *AssignRequest) (*Assignment, error) {
+	var (
+		column string
+		id     uint64
+	)
+	switch {
+	case req.CourseID > 0:
+		column, id = "course_id", req.CourseID
+	case req.EnrollmentTermID > 0:
+		column, id = "enrollment_term_id", req.EnrollmentTermID
+	case req.RootAccountID > 0:
+		column, id = "root_account_id", req.RootAccountID
+	default:
+		return nil, fmt.Errorf("no scope specified for grading policy assignment")
+	}
+
+	query, args, err := util.Sq.
+		Delete("grading_policy_assignments").
+		Where(sq.Eq{column: id}).
+		Where(sq.Eq{"institution_key": req.InstitutionID}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building delete existing grading policy assignment sql: %w", err)
+	}
+
+	_, err = db.Exec(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing delete existing grading policy assignment sql: %w", err)
+	}
+
+	var institutionID interface{}
+	if req.InstitutionID > 0 {
+		institutionID = req.InstitutionID
+	}
+
+	query, args, err = util.Sq.
+		Insert("grading_policy_assignments").
+		SetMap(map[string]interface{}{
+			"grading_policy_id": req.GradingPolicyID,
+			"institution_id":    institutionID,
+			column:              id,
+		}).
+		Suffix("RETURNING id, inserted_at").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building insert grading policy assignment sql: %w", err)
+	}
+
+	a := Assignment{
+		GradingPolicyID:  req.GradingPolicyID,
+		RootAccountID:    req.RootAccountID,
+		EnrollmentTermID: req.EnrollmentTermID,
+		CourseID:         req.CourseID,
+		InstitutionID:    req.InstitutionID,
+	}
+	err = db.QueryRow(query, args...).Scan(&a.ID, &a.InsertedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error executing insert grading policy assignment sql: %w", err)
+	}
+
+	return &a, nil
+}
+
+// Unassign deletes a grading policy assignment.
+func Unassign(db services.DB, assignmentID uint64) error {
+	query, args, err := util.Sq.
+		Delete("grading_policy_assignments").
+		Where(sq.Eq{"id": assignmentID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error building unassign grading policy sql: %w", err)
+	}
+
+	_, err = db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error executing unassign grading policy sql: %w", err)
+	}
+
+	return nil
+}
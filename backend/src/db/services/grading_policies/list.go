@@ -0,0 +1,460 @@
+package grading_policies
+
+import (
+	"database/sql"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"math"
+	"time"
+)
+
+const (
+	// CalculationMethodCBLLegacy averages each outcome's positive scores, dropping the lowest
+	// if the policy allows it. It's how CanvasCBL has always calculated grades.
+	CalculationMethodCBLLegacy = "cbl_legacy"
+	// CalculationMethodCanvas calculates each outcome's score with the outcome's own
+	// Canvas calculation method, like decaying_average or n_mastery.
+	CalculationMethodCanvas = "canvas"
+)
+
+// Band is a grade that a grading policy can award.
+type Band struct {
+	// Grade is the name of the grade, like A or B-
+	Grade string
+	// Rank is how "good" the grade is. Higher is better.
+	Rank int8
+	// MostAbove is the score that MostPercent of outcomes must be at or over.
+	MostAbove float64
+	// AllAbove is the score that every outcome must be at or over.
+	AllAbove float64
+	// GPAVal is the number of GPA points the grade is worth, where a B-, B or B+ are all 3.0.
+	GPAVal float64
+	// SubgradeGPAVal is the traditional number of GPA points the grade is worth, so an A- is 3.7.
+	SubgradeGPAVal float64
+	// MostAboveRating, if set, is the description of a Canvas proficiency rating whose points
+	// are used instead of MostAbove.
+	MostAboveRating string
+	// AllAboveRating, if set, is the description of a Canvas proficiency rating whose points
+	// are used instead of AllAbove.
+	AllAboveRating string
+}
+
+// Policy is a set of rules for turning outcome scores into a grade.
+type Policy struct {
+	ID   uint64
+	Name string
+	// MostPercent is the percent of outcomes (0-100) that have to be over a band's MostAbove.
+	MostPercent float64
+	// DropWorstScore is whether an outcome's lowest score can be dropped if it improves the average.
+	DropWorstScore bool
+	// DropWorstMinScores is the number of scores an outcome needs before its lowest can be dropped.
+	DropWorstMinScores uint64
+	// CalculationMethod is how outcome scores are calculated, either CalculationMethodCBLLegacy
+	// or CalculationMethodCanvas. DropWorstScore only applies to CalculationMethodCBLLegacy.
+	CalculationMethod string
+	// Bands are sorted by rank, best first.
+	Bands      []Band
+	InsertedAt time.Time
+}
+
+// Assignment attaches a grading policy to exactly one of a root account (institution),
+// an enrollment term or a course. Unset scopes are zero.
+type Assignment struct {
+	ID               uint64
+	GradingPolicyID  uint64
+	RootAccountID    uint64
+	EnrollmentTermID uint64
+	CourseID         uint64
+	// InstitutionID is the institution whose Canvas IDs the scope uses, or 0 for the default Canvas instance
+	InstitutionID uint64
+	InsertedAt    time.Time
+}
+
+// Set holds every grading policy and assignment so that policies can be picked without hitting the db.
+type Set struct {
+	Policies    map[uint64]Policy
+	Assignments []Assignment
+}
+
+// ListRequest is the request for List.
+type ListRequest struct {
+	IDs []uint64
+}
+
+// ListAssignmentsRequest is the request for ListAssignments.
+type ListAssignmentsRequest struct {
+	GradingPolicyID uint64
+}
+
+// Default is the policy used when nothing is assigned. It's the original CanvasCBL grading scale.
+var Default = Policy{
+	Name:               "CanvasCBL Default",
+	MostPercent:        75,
+	DropWorstScore:     true,
+	DropWorstMinScores: 2,
+	CalculationMethod:  CalculationMethodCBLLegacy,
+	Bands: []Band{
+		{"A", 6, 3.3, 3, 4, 4, "", ""},
+		{"A-", 5, 3.3, 2.5, 4, 3.7, "", ""},
+		{"B+", 4, 2.6, 2.2, 3, 3.3, "", ""},
+		{"B", 3, 2.6, 1.8, 3, 3, "", ""},
+		{"B-", 2, 2.6, 1.5, 3, 2.7, "", ""},
+		{"C", 1, 2.2, 1.5, 2, 2, "", ""},
+		{"I", 0, 0, 0, 0, 0, "", ""},
+	},
+}
+
+// Ranks returns a map of grade to rank.
+func (p Policy) Ranks() map[string]int8 {
+	rs := make(map[string]int8, len(p.Bands))
+	for _, b := range p.Bands {
+		rs[b.Grade] = b.Rank
+	}
+
+	return rs
+}
+
+// Band returns the band for a grade, if the policy has it.
+func (p Policy) Band(grade string) (Band, bool) {
+	for _, b := range p.Bands {
+		if b.Grade == grade {
+			return b, true
+		}
+	}
+
+	return Band{}, false
+}
+
+// GradeForRank returns the grade whose rank is closest to rank, preferring the lower grade on a tie.
+// It's useful for turning an average rank back into a grade.
+func (p Policy) GradeForRank(rank float64) string {
+	var (
+		closest  string
+		distance = math.Inf(1)
+	)
+
+	// bands are best first, so going backwards means ties go to the lower grade
+	for i := len(p.Bands) - 1; i >= 0; i-- {
+		d := math.Abs(float64(p.Bands[i].Rank) - rank)
+		if d < distance {
+			closest = p.Bands[i].Grade
+			distance = d
+		}
+	}
+
+	return closest
+}
+
+// ForCourse picks the most specific policy for a course at an institution (0 for the default Canvas instance):
+// course, then term, then root account. If no policy is assigned, it returns nil.
+func (s Set) ForCourse(institutionID uint64, courseID uint64, enrollmentTermID uint64, rootAccountID uint64) *Policy {
+	var (
+		termPolicyID    uint64
+		accountPolicyID uint64
+	)
+
+	for _, a := range s.Assignments {
+		if a.InstitutionID != institutionID {
+			continue
+		}
+
+		switch {
+		case a.CourseID > 0:
+			if a.CourseID == courseID {
+				if p, ok := s.Policies[a.GradingPolicyID]; ok {
+					return &p
+				}
+			}
+		case a.EnrollmentTermID > 0:
+			if a.EnrollmentTermID == enrollmentTermID {
+				termPolicyID = a.GradingPolicyID
+			}
+		case a.RootAccountID > 0:
+			if a.RootAccountID == rootAccountID {
+				accountPolicyID = a.GradingPolicyID
+			}
+		}
+	}
+
+	for _, id := range []uint64{termPolicyID, accountPolicyID} {
+		if p, ok := s.Policies[id]; ok && id > 0 {
+			return &p
+		}
+	}
+
+	return nil
+}
+
+// List lists grading policies along with their bands.
+func List(db services.DB, req *ListRequest) (*[]Policy, error) {
+	q := util.Sq.
+		Select(
+			"id",
+			"name",
+			"most_percent",
+			"drop_worst_score",
+			"drop_worst_min_scores",
+			"calculation_method",
+			"inserted_at",
+		).
+		From("grading_policies").
+		OrderBy("id")
+
+	if len(req.IDs) > 0 {
+		q = q.Where(sq.Eq{"id": req.IDs})
+	}
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building list grading policies sql: %w", err)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing list grading policies sql: %w", err)
+	}
+
+	defer rows.Close()
+
+	var (
+		ps  []Policy
+		ids []uint64
+	)
+	for rows.Next() {
+		var p Policy
+		err := rows.Scan(
+			&p.ID,
+			&p.Name,
+			&p.MostPercent,
+			&p.DropWorstScore,
+			&p.DropWorstMinScores,
+			&p.CalculationMethod,
+			&p.InsertedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning list grading policies sql: %w", err)
+		}
+
+		ps = append(ps, p)
+		ids = append(ids, p.ID)
+	}
+
+	if len(ps) < 1 {
+		return &ps, nil
+	}
+
+	bands, err := listBands(db, ids)
+	if err != nil {
+		return nil, fmt.Errorf("error listing grading policy bands: %w", err)
+	}
+
+	for i, p := range ps {
+		ps[i].Bands = bands[p.ID]
+	}
+
+	return &ps, nil
+}
+
+// listBands lists bands for the specified policies, best first. It returns map[policyID][]Band.
+func listBands(db services.DB, policyIDs []uint64) (map[uint64][]Band, error) {
+	query, args, err := util.Sq.
+		Select(
+			"grading_policy_id",
+			"grade",
+			"rank",
+			"most_above",
+			"all_above",
+			"gpa_value",
+			"subgrade_gpa_value",
+			"most_above_rating",
+			"all_above_rating",
+		).
+		From("grading_policy_bands").
+		Where(sq.Eq{"grading_policy_id": policyIDs}).
+		OrderBy("grading_policy_id", "rank DESC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building list grading policy bands sql: %w", err)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing list grading policy bands sql: %w", err)
+	}
+
+	defer rows.Close()
+
+	bands := make(map[uint64][]Band)
+	for rows.Next() {
+		var (
+			policyID        uint64
+			b               Band
+			mostAboveRating sql.NullString
+			allAboveRating  sql.NullString
+		)
+		err := rows.Scan(
+			&policyID,
+			&b.Grade,
+			&b.Rank,
+			&b.MostAbove,
+			&b.AllAbove,
+			&b.GPAVal,
+			&b.SubgradeGPAVal,
+			&mostAboveRating,
+			&allAboveRating,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning list grading policy bands sql: %w", err)
+		}
+
+		b.MostAboveRating = mostAboveRating.String
+		b.AllAboveRating = allAboveRating.String
+
+		bands[policyID] = append(bands[policyID], b)
+	}
+
+	return bands, nil
+}
+
+// ListAssignments lists grading policy assignments.
+func ListAssignments(db services.DB, req *ListAssignmentsRequest) (*[]Assignment, error) {
+	q := util.Sq.
+		Select(
+			"id",
+			"grading_policy_id",
+			"root_account_id",
+			"enrollment_term_id",
+			"course_id",
+			"institution_id",
+			"inserted_at",
+		).
+		From("grading_policy_assignments").
+		OrderBy("id")
+
+	if req.GradingPolicyID > 0 {
+		q = q.Where(sq.Eq{"grading_policy_id": req.GradingPolicyID})
+	}
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building list grading policy assignments sql: %w", err)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing list grading policy assignments sql: %w", err)
+	}
+
+	defer rows.Close()
+
+	var as []Assignment
+	for rows.Next() {
+		var (
+			a                                                   Assignment
+			rootAccountID, enrollmentTermID, cID, institutionID sql.NullInt64
+		)
+		err := rows.Scan(
+			&a.ID,
+			&a.GradingPolicyID,
+			&rootAccountID,
+			&enrollmentTermID,
+			&cID,
+			&institutionID,
+			&a.InsertedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning list grading policy assignments sql: %w", err)
+		}
+
+		if rootAccountID.Valid {
+			a.RootAccountID = uint64(rootAccountID.Int64)
+		}
+
+		if enrollmentTermID.Valid {
+			a.EnrollmentTermID = uint64(enrollmentTermID.Int64)
+		}
+
+		if cID.Valid {
+			a.CourseID = uint64(cID.Int64)
+		}
+
+		if institutionID.Valid {
+			a.InstitutionID = uint64(institutionID.Int64)
+		}
+
+		as = append(as, a)
+	}
+
+	return &as, nil
+}
+
+// GetSet gets every grading policy and assignment.
+func GetSet(db services.DB) (*Set, error) {
+	ps, err := List(db, &ListRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing grading policies for set: %w", err)
+	}
+
+	as, err := ListAssignments(db, &ListAssignmentsRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing grading policy assignments for set: %w", err)
+	}
+
+	s := Set{
+		Policies:    make(map[uint64]Policy, len(*ps)),
+		Assignments: *as,
+	}
+
+	for _, p := range *ps {
+		s.Policies[p.ID] = p
+	}
+
+	return &s, nil
+}
+
+// GetForCourse gets the grading policy for a stored course at an institution (0 for the default Canvas instance),
+// using the course's stored term and root account. If no policy is assigned, it returns nil.
+func GetForCourse(db services.DB, institutionID uint64, courseID uint64) (*Policy, error) {
+	query, args, err := util.Sq.
+		Select("grading_policy_assignments.grading_policy_id").
+		From("grading_policy_assignments").
+		Join("courses ON courses.course_id = ? AND courses.institution_key = ?", courseID, institutionID).
+		Where("grading_policy_assignments.institution_key = courses.institution_key").
+		Where(sq.Or{
+			sq.Expr("grading_policy_assignments.course_id = courses.course_id"),
+			sq.Expr("grading_policy_assignments.enrollment_term_id = courses.enrollment_term_id"),
+			sq.Expr("grading_policy_assignments.root_account_id = courses.root_account_id"),
+		}).
+		OrderBy(
+			"grading_policy_assignments.course_id IS NULL",
+			"grading_policy_assignments.enrollment_term_id IS NULL",
+		).
+		Limit(1).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building get grading policy for course sql: %w", err)
+	}
+
+	var policyID uint64
+	err = db.QueryRow(query, args...).Scan(&policyID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("error executing get grading policy for course sql: %w", err)
+	}
+
+	ps, err := List(db, &ListRequest{IDs: []uint64{policyID}})
+	if err != nil {
+		return nil, fmt.Errorf("error listing grading policy for course: %w", err)
+	}
+
+	if len(*ps) < 1 {
+		return nil, nil
+	}
+
+	return &(*ps)[0], nil
+}
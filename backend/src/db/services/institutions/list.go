@@ -0,0 +1,84 @@
+package institutions
+
+import (
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"time"
+)
+
+// Institution is a school or district with its own Canvas instance and developer key.
+type Institution struct {
+	ID   uint64
+	Name string
+	// CanvasDomain is the domain Canvas is running on, like canvas.example.edu
+	CanvasDomain             string
+	CanvasOAuth2ClientID     string
+	CanvasOAuth2ClientSecret string
+	// CurrentEnrollmentTermID is the Canvas enrollment term ID of the term in session. 0 if not set.
+	CurrentEnrollmentTermID uint64
+	InsertedAt              time.Time
+}
+
+type ListRequest struct {
+	IDs           []uint64
+	CanvasDomains []string
+}
+
+// List lists institutions, oldest first.
+func List(db services.DB, req *ListRequest) (*[]Institution, error) {
+	q := util.Sq.
+		Select(
+			"id",
+			"name",
+			"canvas_domain",
+			"canvas_oauth2_client_id",
+			"canvas_oauth2_client_secret",
+			"current_enrollment_term_id",
+			"inserted_at",
+		).
+		From("institutions").
+		OrderBy("id")
+
+	if len(req.IDs) > 0 {
+		q = q.Where(sq.Eq{"id": req.IDs})
+	}
+
+	if len(req.CanvasDomains) > 0 {
+		q = q.Where(sq.Eq{"canvas_domain": req.CanvasDomains})
+	}
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building list institutions sql: %w", err)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing list institutions sql: %w", err)
+	}
+
+	defer rows.Close()
+
+	var is []Institution
+	for rows.Next() {
+		var i Institution
+		err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.CanvasDomain,
+			&i.CanvasOAuth2ClientID,
+			&i.CanvasOAuth2ClientSecret,
+			&i.CurrentEnrollmentTermID,
+			&i.InsertedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning list institutions sql: %w", err)
+		}
+
+		is = append(is, i)
+	}
+
+	return &is, nil
+}
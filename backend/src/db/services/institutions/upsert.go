@@ -0,0 +1,54 @@
+package institutions
+
+import (
+	"fmt"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+)
+
+type UpsertRequest struct {
+	Name                     string
+	CanvasDomain             string
+	CanvasOAuth2ClientID     string
+	CanvasOAuth2ClientSecret string
+	CurrentEnrollmentTermID  uint64
+}
+
+// Upsert inserts an institution, or updates the institution with the same Canvas domain.
+func Upsert(db services.DB, req *UpsertRequest) (*Institution, error) {
+	query, args, err := util.Sq.
+		Insert("institutions").
+		SetMap(map[string]interface{}{
+			"name":                        req.Name,
+			"canvas_domain":               req.CanvasDomain,
+			"canvas_oauth2_client_id":     req.CanvasOAuth2ClientID,
+			"canvas_oauth2_client_secret": req.CanvasOAuth2ClientSecret,
+			"current_enrollment_term_id":  req.CurrentEnrollmentTermID,
+		}).
+		Suffix("ON CONFLICT (canvas_domain) DO UPDATE SET name = EXCLUDED.name, " +
+			"canvas_oauth2_client_id = EXCLUDED.canvas_oauth2_client_id, " +
+			"canvas_oauth2_client_secret = EXCLUDED.canvas_oauth2_client_secret, " +
+			"current_enrollment_term_id = EXCLUDED.current_enrollment_term_id " +
+			"RETURNING id, name, canvas_domain, canvas_oauth2_client_id, canvas_oauth2_client_secret, " +
+			"current_enrollment_term_id, inserted_at").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building upsert institution sql: %w", err)
+	}
+
+	var i Institution
+	err = db.QueryRow(query, args...).Scan(
+		&i.ID,
+		&i.Name,
+		&i.CanvasDomain,
+		&i.CanvasOAuth2ClientID,
+		&i.CanvasOAuth2ClientSecret,
+		&i.CurrentEnrollmentTermID,
+		&i.InsertedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error executing upsert institution sql: %w", err)
+	}
+
+	return &i, nil
+}
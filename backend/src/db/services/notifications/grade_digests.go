@@ -0,0 +1,102 @@
+package notifications
+
+import (
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"time"
+)
+
+// GradeDigest is a record of a grade digest being sent, so that the next one covers what changed since.
+type GradeDigest struct {
+	ID           uint64
+	CanvasUserID uint64
+	InsertedAt   time.Time
+}
+
+// ListGradeDigestsRequest is the request for ListGradeDigests.
+type ListGradeDigestsRequest struct {
+	CanvasUserIDs []uint64
+	// InstitutionID is the users' institution, or 0 for the default Canvas instance
+	InstitutionID uint64
+}
+
+// InsertGradeDigestRequest is the request for InsertGradeDigest.
+type InsertGradeDigestRequest struct {
+	CanvasUserID uint64
+	// InstitutionID is the user's institution, or 0 for the default Canvas instance
+	InstitutionID uint64
+}
+
+// ListGradeDigests lists the latest grade digest sent to each user.
+func ListGradeDigests(db services.DB, req *ListGradeDigestsRequest) (*[]GradeDigest, error) {
+	q := util.Sq.
+		Select(
+			"DISTINCT ON (canvas_user_id) id",
+			"canvas_user_id",
+			"inserted_at",
+		).
+		From("grade_digest_notifications").
+		Where(sq.Eq{"institution_key": req.InstitutionID}).
+		OrderBy("canvas_user_id", "inserted_at DESC")
+
+	if len(req.CanvasUserIDs) > 0 {
+		q = q.Where(sq.Eq{"canvas_user_id": req.CanvasUserIDs})
+	}
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building list grade digest notifications sql: %w", err)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing list grade digest notifications sql: %w", err)
+	}
+
+	defer rows.Close()
+
+	var ds []GradeDigest
+	for rows.Next() {
+		var d GradeDigest
+		err = rows.Scan(
+			&d.ID,
+			&d.CanvasUserID,
+			&d.InsertedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning list grade digest notifications sql: %w", err)
+		}
+
+		ds = append(ds, d)
+	}
+
+	return &ds, nil
+}
+
+// InsertGradeDigest records that a grade digest was sent.
+func InsertGradeDigest(db services.DB, req *InsertGradeDigestRequest) error {
+	var institutionID interface{}
+	if req.InstitutionID > 0 {
+		institutionID = req.InstitutionID
+	}
+
+	query, args, err := util.Sq.
+		Insert("grade_digest_notifications").
+		SetMap(map[string]interface{}{
+			"canvas_user_id": req.CanvasUserID,
+			"institution_id": institutionID,
+		}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error building insert grade digest notification sql: %w", err)
+	}
+
+	_, err = db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error executing insert grade digest notification sql: %w", err)
+	}
+
+	return nil
+}
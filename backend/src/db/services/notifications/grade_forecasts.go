@@ -0,0 +1,119 @@
+package notifications
+
+import (
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"time"
+)
+
+// GradeForecast is a record of a grade drop forecast notification being sent, so that it isn't sent again.
+type GradeForecast struct {
+	ID           uint64
+	CanvasUserID uint64
+	CourseID     uint64
+	// Grade is the grade when the notification was sent
+	Grade string
+	// ProjectedGrade is the forecasted grade when the notification was sent
+	ProjectedGrade string
+	InsertedAt     time.Time
+}
+
+// ListGradeForecastsRequest is the request for ListGradeForecasts.
+type ListGradeForecastsRequest struct {
+	CanvasUserIDs []uint64
+	// InstitutionID is the users' institution, or 0 for the default Canvas instance
+	InstitutionID uint64
+}
+
+// InsertGradeForecastRequest is the request for InsertGradeForecast.
+type InsertGradeForecastRequest struct {
+	CanvasUserID   uint64
+	CourseID       uint64
+	Grade          string
+	ProjectedGrade string
+	// InstitutionID is the user's institution, or 0 for the default Canvas instance
+	InstitutionID uint64
+}
+
+// ListGradeForecasts lists the latest grade drop forecast notification sent for each user and course.
+func ListGradeForecasts(db services.DB, req *ListGradeForecastsRequest) (*[]GradeForecast, error) {
+	q := util.Sq.
+		Select(
+			"DISTINCT ON (canvas_user_id, course_id) id",
+			"canvas_user_id",
+			"course_id",
+			"grade",
+			"projected_grade",
+			"inserted_at",
+		).
+		From("grade_forecast_notifications").
+		Where(sq.Eq{"institution_key": req.InstitutionID}).
+		OrderBy("canvas_user_id", "course_id", "inserted_at DESC")
+
+	if len(req.CanvasUserIDs) > 0 {
+		q = q.Where(sq.Eq{"canvas_user_id": req.CanvasUserIDs})
+	}
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building list grade forecast notifications sql: %w", err)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing list grade forecast notifications sql: %w", err)
+	}
+
+	defer rows.Close()
+
+	var fs []GradeForecast
+	for rows.Next() {
+		var f GradeForecast
+		err = rows.Scan(
+			&f.ID,
+			&f.CanvasUserID,
+			&f.CourseID,
+			&f.Grade,
+			&f.ProjectedGrade,
+			&f.InsertedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning list grade forecast notifications sql: %w", err)
+		}
+
+		fs = append(fs, f)
+	}
+
+	return &fs, nil
+}
+
+// InsertGradeForecast records that a grade drop forecast notification was sent.
+func InsertGradeForecast(db services.DB, req *InsertGradeForecastRequest) error {
+	var institutionID interface{}
+	if req.InstitutionID > 0 {
+		institutionID = req.InstitutionID
+	}
+
+	query, args, err := util.Sq.
+		Insert("grade_forecast_notifications").
+		SetMap(map[string]interface{}{
+			"canvas_user_id":  req.CanvasUserID,
+			"course_id":       req.CourseID,
+			"grade":           req.Grade,
+			"projected_grade": req.ProjectedGrade,
+			"institution_id":  institutionID,
+		}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error building insert grade forecast notification sql: %w", err)
+	}
+
+	_, err = db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error executing insert grade forecast notification sql: %w", err)
+	}
+
+	return nil
+}
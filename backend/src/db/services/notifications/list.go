@@ -26,6 +26,10 @@ func (m Medium) IsValid() bool {
 const (
 	// GradeChangeNotificationID is the ID for the grade_change notification type.
 	TypeGradeChange = 1
+	// TypeGradeDropForecast is the ID for the grade_drop_forecast notification type.
+	TypeGradeDropForecast = 2
+	// TypeGradeDigest is the ID for the grade_digest notification type.
+	TypeGradeDigest = 3
 
 	// zeroMedium is Medium's zero value
 	zeroMedium = Medium("")
@@ -42,9 +46,11 @@ type Setting struct {
 	ID           uint64
 	UserID       uint64
 	CanvasUserID uint64
-	Type         uint64
-	Medium       Medium
-	InsertedAt   time.Time
+	// InstitutionID is the user's institution, or 0 for the default Canvas instance
+	InstitutionID uint64
+	Type          uint64
+	Medium        Medium
+	InsertedAt    time.Time
 }
 
 // Type represents a notification type.
@@ -125,6 +131,7 @@ func ListSettings(db services.DB, req *ListSettingsRequest) (*[]Setting, error)
 			"notification_settings.id",
 			"notification_settings.user_id",
 			"users.canvas_user_id",
+			"users.institution_id",
 			"notification_settings.notification_type_id",
 			"notification_settings.medium",
 			"notification_settings.inserted_at",
@@ -166,11 +173,16 @@ func ListSettings(db services.DB, req *ListSettingsRequest) (*[]Setting, error)
 
 	var settings []Setting
 	for rows.Next() {
-		var s Setting
+		var (
+			s             Setting
+			institutionID sql.NullInt64
+		)
+
 		err = rows.Scan(
 			&s.ID,
 			&s.UserID,
 			&s.CanvasUserID,
+			&institutionID,
 			&s.Type,
 			&s.Medium,
 			&s.InsertedAt,
@@ -179,6 +191,10 @@ func ListSettings(db services.DB, req *ListSettingsRequest) (*[]Setting, error)
 			return nil, fmt.Errorf("error scanning list notification settings sql: %w", err)
 		}
 
+		if institutionID.Valid {
+			s.InstitutionID = uint64(institutionID.Int64)
+		}
+
 		settings = append(settings, s)
 	}
 
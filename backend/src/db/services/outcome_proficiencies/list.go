@@ -0,0 +1,79 @@
+package outcome_proficiencies
+
+import (
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"time"
+)
+
+// Rating is a Canvas outcome proficiency rating, cached for a root account.
+type Rating struct {
+	ID            uint64
+	RootAccountID uint64
+	Description   string
+	Points        float64
+	Mastery       bool
+	// Color is a hex color without the #, like 127A1B
+	Color      string
+	InsertedAt time.Time
+}
+
+// ListRequest is the request for List.
+type ListRequest struct {
+	RootAccountIDs []uint64
+}
+
+// List lists cached proficiency ratings, best (most points) first.
+func List(db services.DB, req *ListRequest) (*[]Rating, error) {
+	q := util.Sq.
+		Select(
+			"id",
+			"root_account_id",
+			"description",
+			"points",
+			"mastery",
+			"color",
+			"inserted_at",
+		).
+		From("outcome_proficiency_ratings").
+		OrderBy("root_account_id", "points DESC")
+
+	if len(req.RootAccountIDs) > 0 {
+		q = q.Where(sq.Eq{"root_account_id": req.RootAccountIDs})
+	}
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building list outcome proficiency ratings sql: %w", err)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing list outcome proficiency ratings sql: %w", err)
+	}
+
+	defer rows.Close()
+
+	var rs []Rating
+	for rows.Next() {
+		var r Rating
+		err := rows.Scan(
+			&r.ID,
+			&r.RootAccountID,
+			&r.Description,
+			&r.Points,
+			&r.Mastery,
+			&r.Color,
+			&r.InsertedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning list outcome proficiency ratings sql: %w", err)
+		}
+
+		rs = append(rs, r)
+	}
+
+	return &rs, nil
+}
@@ -0,0 +1,66 @@
+package outcome_proficiencies
+
+import (
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+)
+
+// ReplaceRequest is the request for Replace.
+type ReplaceRequest struct {
+	RootAccountID uint64
+	Ratings       []Rating
+}
+
+// Replace replaces every cached rating for a root account. It should be run in a transaction.
+func Replace(db services.DB, req *ReplaceRequest) error {
+	query, args, err := util.Sq.
+		Delete("outcome_proficiency_ratings").
+		Where(sq.Eq{"root_account_id": req.RootAccountID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error building delete outcome proficiency ratings sql: %w", err)
+	}
+
+	_, err = db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error executing delete outcome proficiency ratings sql: %w", err)
+	}
+
+	if len(req.Ratings) < 1 {
+		return nil
+	}
+
+	q := util.Sq.
+		Insert("outcome_proficiency_ratings").
+		Columns(
+			"root_account_id",
+			"description",
+			"points",
+			"mastery",
+			"color",
+		)
+
+	for _, r := range req.Ratings {
+		q = q.Values(
+			req.RootAccountID,
+			r.Description,
+			r.Points,
+			r.Mastery,
+			r.Color,
+		)
+	}
+
+	query, args, err = q.ToSql()
+	if err != nil {
+		return fmt.Errorf("error building insert outcome proficiency ratings sql: %w", err)
+	}
+
+	_, err = db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error executing insert outcome proficiency ratings sql: %w", err)
+	}
+
+	return nil
+}
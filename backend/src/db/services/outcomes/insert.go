@@ -7,25 +7,29 @@ import (
 )
 
 type InsertRequest struct {
-	CanvasID       uint64
-	CourseID       *uint64
-	ContextID      uint64
-	DisplayName    string
-	Title          string
-	MasteryPoints  float64
-	PointsPossible float64
+	CanvasID          uint64
+	CourseID          *uint64
+	ContextID         uint64
+	DisplayName       string
+	Title             string
+	MasteryPoints     float64
+	PointsPossible    float64
+	CalculationMethod string
+	CalculationInt    int64
 }
 
 // InsertOutcome inserts an outcome
 func InsertOutcome(db services.DB, req *InsertRequest) error {
 	vals := map[string]interface{}{
-		"canvas_id":       req.CanvasID,
-		"course_id":       req.CourseID,
-		"context_id":      req.ContextID,
-		"display_name":    req.DisplayName,
-		"title":           req.Title,
-		"mastery_points":  req.MasteryPoints,
-		"points_possible": req.PointsPossible,
+		"canvas_id":          req.CanvasID,
+		"course_id":          req.CourseID,
+		"context_id":         req.ContextID,
+		"display_name":       req.DisplayName,
+		"title":              req.Title,
+		"mastery_points":     req.MasteryPoints,
+		"points_possible":    req.PointsPossible,
+		"calculation_method": req.CalculationMethod,
+		"calculation_int":    req.CalculationInt,
 	}
 
 	query, args, err := util.Sq.
@@ -11,19 +11,23 @@ func UpsertOutcome(db services.DB, req *InsertRequest) error {
 	query, args, err := util.Sq.
 		Insert("outcomes").
 		SetMap(map[string]interface{}{
-			"canvas_id":       req.CanvasID,
-			"course_id":       req.CourseID,
-			"context_id":      req.ContextID,
-			"display_name":    req.DisplayName,
-			"title":           req.Title,
-			"mastery_points":  req.MasteryPoints,
-			"points_possible": req.PointsPossible,
+			"canvas_id":          req.CanvasID,
+			"course_id":          req.CourseID,
+			"context_id":         req.ContextID,
+			"display_name":       req.DisplayName,
+			"title":              req.Title,
+			"mastery_points":     req.MasteryPoints,
+			"points_possible":    req.PointsPossible,
+			"calculation_method": req.CalculationMethod,
+			"calculation_int":    req.CalculationInt,
 		}).
 		Suffix("ON CONFLICT (canvas_id) DO UPDATE SET " +
 			"display_name = EXCLUDED.display_name, " +
 			"title = EXCLUDED.title, " +
 			"mastery_points = EXCLUDED.mastery_points, " +
-			"points_possible = EXCLUDED.points_possible",
+			"points_possible = EXCLUDED.points_possible, " +
+			"calculation_method = EXCLUDED.calculation_method, " +
+			"calculation_int = EXCLUDED.calculation_int",
 		).
 		ToSql()
 	if err != nil {
@@ -0,0 +1,102 @@
+package scheduled_jobs
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"time"
+)
+
+// Job is a scheduled job, as last recorded by its leader.
+type Job struct {
+	Name     string
+	Schedule string
+	// Leader is the server running the job, like "hostname:pid".
+	Leader      string
+	LeaderSince time.Time
+	// The rest are zero if the job hasn't gotten that far yet.
+	NextRunAt       time.Time
+	LastStartedAt   time.Time
+	LastFinishedAt  time.Time
+	LastSucceededAt time.Time
+	// LastError is the last run's error, or empty if it succeeded.
+	LastError string
+	UpdatedAt time.Time
+}
+
+// Running is whether the job's last run hasn't finished.
+func (j Job) Running() bool {
+	return !j.LastStartedAt.IsZero() && j.LastStartedAt.After(j.LastFinishedAt)
+}
+
+// ListRequest is the request for List. It's empty, but it can be added to in the future.
+type ListRequest struct{}
+
+// List lists scheduled jobs by name.
+func List(db services.DB, _ *ListRequest) (*[]Job, error) {
+	query, args, err := util.Sq.
+		Select(
+			"name",
+			"schedule",
+			"leader",
+			"leader_since",
+			"next_run_at",
+			"last_started_at",
+			"last_finished_at",
+			"last_succeeded_at",
+			"last_error",
+			"updated_at",
+		).
+		From("scheduled_jobs").
+		OrderBy("name").
+		Limit(services.DefaultSelectLimit).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building list scheduled jobs sql: %w", err)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing list scheduled jobs sql: %w", err)
+	}
+
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		var (
+			j               Job
+			nextRunAt       sql.NullTime
+			lastStartedAt   sql.NullTime
+			lastFinishedAt  sql.NullTime
+			lastSucceededAt sql.NullTime
+			lastError       sql.NullString
+		)
+
+		err := rows.Scan(
+			&j.Name,
+			&j.Schedule,
+			&j.Leader,
+			&j.LeaderSince,
+			&nextRunAt,
+			&lastStartedAt,
+			&lastFinishedAt,
+			&lastSucceededAt,
+			&lastError,
+			&j.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning list scheduled jobs sql: %w", err)
+		}
+
+		j.NextRunAt = nextRunAt.Time
+		j.LastStartedAt = lastStartedAt.Time
+		j.LastFinishedAt = lastFinishedAt.Time
+		j.LastSucceededAt = lastSucceededAt.Time
+		j.LastError = lastError.String
+		jobs = append(jobs, j)
+	}
+
+	return &jobs, nil
+}
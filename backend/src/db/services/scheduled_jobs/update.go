@@ -0,0 +1,99 @@
+package scheduled_jobs
+
+import (
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"time"
+)
+
+type UpsertRequest struct {
+	Name      string
+	Schedule  string
+	Leader    string
+	NextRunAt time.Time
+}
+
+// Upsert records a job's leader and its next run. LeaderSince only changes when the leader does.
+func Upsert(db services.DB, req *UpsertRequest) error {
+	query, args, err := util.Sq.
+		Insert("scheduled_jobs").
+		SetMap(map[string]interface{}{
+			"name":        req.Name,
+			"schedule":    req.Schedule,
+			"leader":      req.Leader,
+			"next_run_at": req.NextRunAt,
+		}).
+		Suffix("ON CONFLICT (name) DO UPDATE SET " +
+			"schedule = EXCLUDED.schedule, " +
+			"leader_since = CASE WHEN scheduled_jobs.leader = EXCLUDED.leader " +
+			"THEN scheduled_jobs.leader_since ELSE now() END, " +
+			"leader = EXCLUDED.leader, " +
+			"next_run_at = EXCLUDED.next_run_at, " +
+			"updated_at = now()").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error building upsert scheduled job sql: %w", err)
+	}
+
+	_, err = db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error executing upsert scheduled job sql: %w", err)
+	}
+
+	return nil
+}
+
+// Start records that a job's run started now.
+func Start(db services.DB, name string) error {
+	query, args, err := util.Sq.
+		Update("scheduled_jobs").
+		Set("last_started_at", sq.Expr("now()")).
+		Set("updated_at", sq.Expr("now()")).
+		Where(sq.Eq{"name": name}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error building start scheduled job sql: %w", err)
+	}
+
+	_, err = db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error executing start scheduled job sql: %w", err)
+	}
+
+	return nil
+}
+
+type FinishRequest struct {
+	Name string
+	// Error is why the run failed. If it's empty, the run succeeded.
+	Error string
+}
+
+// Finish records that a job's run finished now.
+func Finish(db services.DB, req *FinishRequest) error {
+	q := util.Sq.
+		Update("scheduled_jobs").
+		Set("last_finished_at", sq.Expr("now()")).
+		Set("updated_at", sq.Expr("now()")).
+		Where(sq.Eq{"name": req.Name})
+
+	if len(req.Error) > 0 {
+		q = q.Set("last_error", req.Error)
+	} else {
+		q = q.Set("last_error", nil).Set("last_succeeded_at", sq.Expr("now()"))
+	}
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return fmt.Errorf("error building finish scheduled job sql: %w", err)
+	}
+
+	_, err = db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error executing finish scheduled job sql: %w", err)
+	}
+
+	return nil
+}
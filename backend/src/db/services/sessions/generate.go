@@ -9,31 +9,34 @@ import (
 type GenerateRequest struct {
 	CanvasUserID  uint64
 	GoogleUsersID uint64
+	// InstitutionID is the institution the session was started at, if any
+	InstitutionID uint64
 }
 
 // Generate generates a session for the given userID using the given database
 func Generate(db services.DB, req *GenerateRequest) (*string, error) {
-	q := util.Sq.
-		Insert("sessions").
-		Suffix("RETURNING session_string")
+	values := map[string]interface{}{}
+	if req.CanvasUserID > 0 {
+		values["canvas_user_id"] = req.CanvasUserID
+	}
+
+	if req.GoogleUsersID > 0 {
+		values["google_users_id"] = req.GoogleUsersID
+	}
 
-	if req.CanvasUserID > 0 && req.GoogleUsersID > 0 {
-		q = q.SetMap(map[string]interface{}{
-			"canvas_user_id":  req.CanvasUserID,
-			"google_users_id": req.GoogleUsersID,
-		})
-	} else if req.GoogleUsersID > 0 {
-		q = q.SetMap(map[string]interface{}{
-			"google_users_id": req.GoogleUsersID,
-		})
-	} else if req.CanvasUserID > 0 {
-		q = q.SetMap(map[string]interface{}{
-			"canvas_user_id": req.CanvasUserID,
-		})
-	} else {
+	if len(values) < 1 {
 		return nil, nil
 	}
 
+	if req.InstitutionID > 0 {
+		values["institution_id"] = req.InstitutionID
+	}
+
+	q := util.Sq.
+		Insert("sessions").
+		SetMap(values).
+		Suffix("RETURNING session_string")
+
 	query, args, err := q.ToSql()
 
 	if err != nil {
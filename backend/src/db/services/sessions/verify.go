@@ -11,8 +11,10 @@ import (
 type VerifiedSession struct {
 	SessionString string `json:"-"`
 	// users.id
-	UserID               uint64 `json:"user_id"`
-	CanvasUserID         uint64 `json:"canvas_user_id"`
+	UserID       uint64 `json:"user_id"`
+	CanvasUserID uint64 `json:"canvas_user_id"`
+	// InstitutionID is the user's institution, or 0 for the default Canvas instance
+	InstitutionID        uint64 `json:"institution_id"`
 	UserStatus           int    `json:"status"`
 	Email                string `json:"email"`
 	HasValidSubscription bool   `json:"has_valid_subscription"`
@@ -28,6 +30,7 @@ func Verify(db services.DB, sessionString string) (*VerifiedSession, error) {
 		Select(
 			"users.id AS user_id",
 			"users.canvas_user_id AS canvas_user_id",
+			"users.institution_id AS institution_id",
 			"users.status AS user_status",
 			//"google_users.id AS google_users_id",
 			"users.email AS email",
@@ -38,7 +41,8 @@ func Verify(db services.DB, sessionString string) (*VerifiedSession, error) {
 		From("users").
 		//RightJoin("google_users ON LOWER(users.email) = LOWER(google_users.email)").
 		//Join("sessions ON (users.canvas_user_id = sessions.canvas_user_id OR google_users.id = sessions.google_users_id)").
-		Join("sessions ON users.canvas_user_id = sessions.canvas_user_id").
+		Join("sessions ON users.canvas_user_id = sessions.canvas_user_id AND " +
+			"users.institution_key = sessions.institution_key").
 		Where(sq.Eq{"sessions.session_string": sessionString}).
 		Limit(1).
 		ToSql()
@@ -49,15 +53,16 @@ func Verify(db services.DB, sessionString string) (*VerifiedSession, error) {
 	row := db.QueryRow(query, args...)
 
 	var (
-		vs                   VerifiedSession
-		userID, canvasUserID sql.NullInt64
-		email                sql.NullString
+		vs                                  VerifiedSession
+		userID, canvasUserID, institutionID sql.NullInt64
+		email                               sql.NullString
 		//googleUsersID             sql.NullString
 	)
 
 	err = row.Scan(
 		&userID,
 		&canvasUserID,
+		&institutionID,
 		&vs.UserStatus,
 		//&googleUsersID,
 		&email,
@@ -79,6 +84,10 @@ func Verify(db services.DB, sessionString string) (*VerifiedSession, error) {
 		vs.CanvasUserID = uint64(canvasUserID.Int64)
 	}
 
+	if institutionID.Valid {
+		vs.InstitutionID = uint64(institutionID.Int64)
+	}
+
 	//if googleUsersID.Valid {
 	//	vs.GoogleUsersID = googleUsersID.String
 	//}
@@ -27,6 +27,8 @@ type SubmissionSummary struct {
 type CourseUserSummaryRequest struct {
 	CourseID uint64
 	UserIDs  []uint64
+	// InstitutionID is the course's institution, or 0 for the default Canvas instance
+	InstitutionID uint64
 
 	/*
 		SeparateLate allows you to separate late submissions.
@@ -67,6 +69,7 @@ func GetCourseUserSummary(db services.DB, req *CourseUserSummaryRequest) (*Cours
 	q := util.Sq.
 		Select(cols...).
 		From("submissions").
+		Where(sq.Eq{"institution_key": req.InstitutionID}).
 		GroupBy(groupBys...).
 		OrderBy("user_canvas_id")
 
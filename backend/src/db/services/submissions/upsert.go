@@ -85,6 +85,8 @@ type UpsertRequest struct {
 	SecondsLate      uint64
 	ExtraAttempts    uint64
 	PostedAt         time.Time
+	// InstitutionID is the course's institution, or 0 for the default Canvas instance
+	InstitutionID uint64
 }
 
 // AttachmentUpsertRequest represents all the data required to upsert an attachment.
@@ -101,7 +103,7 @@ type AttachmentUpsertRequest struct {
 
 // UpsertChunkSize represents the number of size of each upsert chunk.
 // If your number of upserts is less than UpsertChunkSize, chunking is not necessary.
-var UpsertChunkSize = services.CalculateChunkSize(20)
+var UpsertChunkSize = services.CalculateChunkSize(21)
 
 // Upsert upserts Submissions.
 func Upsert(db services.DB, req *[]UpsertRequest) error {
@@ -128,8 +130,10 @@ func Upsert(db services.DB, req *[]UpsertRequest) error {
 			"seconds_late",
 			"extra_attempts",
 			"posted_at",
+			"institution_id",
 		).
-		Suffix("ON CONFLICT (canvas_id) DO UPDATE SET " +
+		// submission IDs are only unique within an institution (see migrations/009_institutions.sql)
+		Suffix("ON CONFLICT (institution_key, canvas_id) DO UPDATE SET " +
 			"attempt = EXCLUDED.attempt, " +
 			"score = EXCLUDED.score, " +
 			"workflow_state = EXCLUDED.workflow_state, " +
@@ -159,7 +163,8 @@ func Upsert(db services.DB, req *[]UpsertRequest) error {
 			pointsDeducted,
 			secondsLate,
 			extraAttempts,
-			postedAt interface{}
+			postedAt,
+			institutionID interface{}
 
 		if r.Attempt != 0 {
 			attempt = r.Attempt
@@ -209,6 +214,10 @@ func Upsert(db services.DB, req *[]UpsertRequest) error {
 			postedAt = r.PostedAt
 		}
 
+		if r.InstitutionID > 0 {
+			institutionID = r.InstitutionID
+		}
+
 		q = q.Values(
 			r.CanvasID,
 			r.CourseID,
@@ -230,6 +239,7 @@ func Upsert(db services.DB, req *[]UpsertRequest) error {
 			secondsLate,
 			extraAttempts,
 			postedAt,
+			institutionID,
 		)
 	}
 
@@ -0,0 +1,106 @@
+package terms
+
+import (
+	"database/sql"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"time"
+)
+
+// Term is a school term, like a semester, tied to a Canvas enrollment term.
+type Term struct {
+	ID                     uint64
+	Name                   string
+	CanvasEnrollmentTermID uint64
+	StartAt                time.Time
+	EndAt                  time.Time
+	// InstitutionID is the term's institution, or 0 for the default Canvas instance
+	InstitutionID uint64
+	InsertedAt    time.Time
+}
+
+type ListRequest struct {
+	IDs                     []uint64
+	CanvasEnrollmentTermIDs []uint64
+	// InstitutionID, if not nil, only lists terms from that institution (0 for the default Canvas instance).
+	// Use it with CanvasEnrollmentTermIDs and At, as terms are per institution.
+	InstitutionID *uint64
+	// At only lists terms that were in session at the specified time.
+	At *time.Time
+}
+
+// List lists terms, oldest first.
+func List(db services.DB, req *ListRequest) (*[]Term, error) {
+	q := util.Sq.
+		Select(
+			"id",
+			"name",
+			"canvas_enrollment_term_id",
+			"start_at",
+			"end_at",
+			"institution_id",
+			"inserted_at",
+		).
+		From("terms").
+		OrderBy("start_at")
+
+	if len(req.IDs) > 0 {
+		q = q.Where(sq.Eq{"id": req.IDs})
+	}
+
+	if len(req.CanvasEnrollmentTermIDs) > 0 {
+		q = q.Where(sq.Eq{"canvas_enrollment_term_id": req.CanvasEnrollmentTermIDs})
+	}
+
+	if req.InstitutionID != nil {
+		q = q.Where(sq.Eq{"institution_key": *req.InstitutionID})
+	}
+
+	if req.At != nil {
+		q = q.
+			Where(sq.LtOrEq{"start_at": req.At}).
+			Where(sq.Gt{"end_at": req.At})
+	}
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building list terms sql: %w", err)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing list terms sql: %w", err)
+	}
+
+	defer rows.Close()
+
+	var ts []Term
+	for rows.Next() {
+		var (
+			t             Term
+			institutionID sql.NullInt64
+		)
+		err := rows.Scan(
+			&t.ID,
+			&t.Name,
+			&t.CanvasEnrollmentTermID,
+			&t.StartAt,
+			&t.EndAt,
+			&institutionID,
+			&t.InsertedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning list terms sql: %w", err)
+		}
+
+		if institutionID.Valid {
+			t.InstitutionID = uint64(institutionID.Int64)
+		}
+
+		ts = append(ts, t)
+	}
+
+	return &ts, nil
+}
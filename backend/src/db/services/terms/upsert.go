@@ -0,0 +1,57 @@
+package terms
+
+import (
+	"fmt"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"time"
+)
+
+type UpsertRequest struct {
+	Name                   string
+	CanvasEnrollmentTermID uint64
+	StartAt                time.Time
+	EndAt                  time.Time
+	// InstitutionID is the term's institution, or 0 for the default Canvas instance
+	InstitutionID uint64
+}
+
+// Upsert inserts a term, or updates the term with the same Canvas enrollment term ID at the same institution.
+func Upsert(db services.DB, req *UpsertRequest) (*Term, error) {
+	var institutionID interface{}
+	if req.InstitutionID > 0 {
+		institutionID = req.InstitutionID
+	}
+
+	query, args, err := util.Sq.
+		Insert("terms").
+		SetMap(map[string]interface{}{
+			"name":                      req.Name,
+			"canvas_enrollment_term_id": req.CanvasEnrollmentTermID,
+			"start_at":                  req.StartAt,
+			"end_at":                    req.EndAt,
+			"institution_id":            institutionID,
+		}).
+		Suffix("ON CONFLICT (institution_key, canvas_enrollment_term_id) DO UPDATE SET name = EXCLUDED.name, " +
+			"start_at = EXCLUDED.start_at, end_at = EXCLUDED.end_at " +
+			"RETURNING id, name, canvas_enrollment_term_id, start_at, end_at, inserted_at").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error building upsert term sql: %w", err)
+	}
+
+	t := Term{InstitutionID: req.InstitutionID}
+	err = db.QueryRow(query, args...).Scan(
+		&t.ID,
+		&t.Name,
+		&t.CanvasEnrollmentTermID,
+		&t.StartAt,
+		&t.EndAt,
+		&t.InsertedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error executing upsert term sql: %w", err)
+	}
+
+	return &t, nil
+}
@@ -14,8 +14,11 @@ type ListRequest struct {
 	Email        string
 	LTIUserID    string
 	CanvasUserID uint64
-	Limit        uint64
-	Offset       uint64
+	// InstitutionID, if not nil, only lists users from that institution (0 for the default Canvas instance).
+	// Use it with CanvasUserID, as Canvas user IDs are only unique within an institution.
+	InstitutionID *uint64
+	Limit         uint64
+	Offset        uint64
 }
 
 type ListObserveesRequest struct {
@@ -23,6 +26,8 @@ type ListObserveesRequest struct {
 	ObserverCanvasUserID uint64
 	ObserveeCanvasUserID uint64
 	ObserveeName         string
+	// InstitutionID is the observer's institution, or 0 for the default Canvas instance
+	InstitutionID uint64
 
 	ActiveOnly bool
 	Limit      uint64
@@ -73,6 +78,10 @@ func List(db services.DB, req *ListRequest) (*[]User, error) {
 		q = q.Where(sq.Eq{"canvas_user_id": req.CanvasUserID})
 	}
 
+	if req.InstitutionID != nil {
+		q = q.Where(sq.Eq{"institution_key": *req.InstitutionID})
+	}
+
 	if req.Limit > 0 {
 		q = q.Limit(req.Limit)
 	}
@@ -175,7 +184,8 @@ func ListObservees(db services.DB, req *ListObserveesRequest) (*[]Observee, erro
 			"deleted_at",
 			"inserted_at",
 		).
-		From("observees")
+		From("observees").
+		Where(sq.Eq{"institution_key": req.InstitutionID})
 
 	if req.ID != 0 {
 		q = q.Where(sq.Eq{"id": req.ID})
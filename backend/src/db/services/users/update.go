@@ -15,10 +15,11 @@ type UpdateUserRequest struct {
 	HasValidSubscription *bool
 }
 
-func SoftDeleteUserObservees(db services.DB, observeeUserIDs []uint64) error {
+func SoftDeleteUserObservees(db services.DB, institutionID uint64, observeeUserIDs []uint64) error {
 	query, args, err := util.Sq.
 		Update("observees").
 		Where(sq.Eq{"observee_canvas_user_id": observeeUserIDs}).
+		Where(sq.Eq{"institution_key": institutionID}).
 		Set("deleted_at", sq.Expr("NOW()")).
 		ToSql()
 	if err != nil {
@@ -33,10 +34,11 @@ func SoftDeleteUserObservees(db services.DB, observeeUserIDs []uint64) error {
 	return nil
 }
 
-func UnSoftDeleteUserObservees(db services.DB, observeeUserIDs []uint64) error {
+func UnSoftDeleteUserObservees(db services.DB, institutionID uint64, observeeUserIDs []uint64) error {
 	query, args, err := util.Sq.
 		Update("observees").
 		Where(sq.Eq{"observee_canvas_user_id": observeeUserIDs}).
+		Where(sq.Eq{"institution_key": institutionID}).
 		Set("deleted_at", nil).
 		ToSql()
 	if err != nil {
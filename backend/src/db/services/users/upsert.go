@@ -13,6 +13,8 @@ type UpsertRequest struct {
 	Email        string
 	LTIUserID    string
 	CanvasUserID int64
+	// InstitutionID is the user's institution, or 0 for the default Canvas instance
+	InstitutionID uint64
 }
 
 // UpsertResponse contains some user data sometimes needed after an upsert.
@@ -24,6 +26,8 @@ type UpsertResponse struct {
 type UpsertObserveesRequest struct {
 	Observees            []Observee
 	ObserverCanvasUserID uint64
+	// InstitutionID is the observer's institution, or 0 for the default Canvas instance
+	InstitutionID uint64
 }
 
 // UpsertProfile wraps UpsertMultipleProfiles for one user only
@@ -42,7 +46,9 @@ func UpsertProfile(db services.DB, ur *UpsertRequest, returnInsertedAt bool) (*U
 
 // UpsertMultipleProfiles upserts multiple user profiles
 func UpsertMultipleProfiles(db services.DB, ur *[]UpsertRequest, returnInsertedAt bool) (*[]UpsertResponse, error) {
-	suffix := "ON CONFLICT (canvas_user_id) DO UPDATE SET name = EXCLUDED.name, email = EXCLUDED.email"
+	// canvas user IDs are only unique within an institution (see migrations/009_institutions.sql)
+	suffix := "ON CONFLICT (institution_key, canvas_user_id) DO UPDATE SET " +
+		"name = EXCLUDED.name, email = EXCLUDED.email"
 	if returnInsertedAt {
 		suffix += " RETURNING id, inserted_at"
 	}
@@ -54,6 +60,7 @@ func UpsertMultipleProfiles(db services.DB, ur *[]UpsertRequest, returnInsertedA
 			"email",
 			"lti_user_id",
 			"canvas_user_id",
+			"institution_id",
 		).
 		Suffix(suffix)
 
@@ -63,7 +70,12 @@ func UpsertMultipleProfiles(db services.DB, ur *[]UpsertRequest, returnInsertedA
 			LTIUserID = r.LTIUserID
 		}
 
-		q = q.Values(r.Name, r.Email, LTIUserID, r.CanvasUserID)
+		var institutionID interface{}
+		if r.InstitutionID > 0 {
+			institutionID = r.InstitutionID
+		}
+
+		q = q.Values(r.Name, r.Email, LTIUserID, r.CanvasUserID, institutionID)
 	}
 
 	query, args, err := q.ToSql()
@@ -108,13 +120,18 @@ func UpsertUserObservees(db services.DB, req *UpsertObserveesRequest) error {
 
 	q := util.Sq.
 		Insert("observees").
-		Columns("observer_canvas_user_id", "observee_canvas_user_id", "observee_name").
-		Suffix("ON CONFLICT (observer_canvas_user_id, observee_canvas_user_id) DO UPDATE SET " +
+		Columns("observer_canvas_user_id", "observee_canvas_user_id", "observee_name", "institution_id").
+		Suffix("ON CONFLICT (institution_key, observer_canvas_user_id, observee_canvas_user_id) DO UPDATE SET " +
 			"observee_canvas_user_id = EXCLUDED.observee_canvas_user_id, " +
 			"observee_name = EXCLUDED.observee_name")
 
+	var institutionID interface{}
+	if req.InstitutionID > 0 {
+		institutionID = req.InstitutionID
+	}
+
 	for _, o := range req.Observees {
-		q = q.Values(req.ObserverCanvasUserID, o.CanvasUserID, o.Name)
+		q = q.Values(req.ObserverCanvasUserID, o.CanvasUserID, o.Name, institutionID)
 	}
 
 	query, args, err := q.ToSql()
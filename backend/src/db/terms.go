@@ -0,0 +1,134 @@
+package db
+
+import (
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/course_weights"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/courses"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/final_grades"
+	gradessvc "github.com/iamtheyammer/canvascbl/backend/src/db/services/grades"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/grading_policies"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/terms"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"github.com/pkg/errors"
+)
+
+func ListTerms(req *terms.ListRequest) (*[]terms.Term, error) {
+	ts, err := terms.List(util.DB, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing terms")
+	}
+
+	return ts, nil
+}
+
+func UpsertTerm(req *terms.UpsertRequest) (*terms.Term, error) {
+	t, err := terms.Upsert(util.DB, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error upserting term")
+	}
+
+	return t, nil
+}
+
+/*
+FreezeTermFinalGrades saves the most recent grade for every user in every course in the term as their final grade.
+Only courses and grades from the term's institution are used.
+GPA values come from each course's grading policy and the course weight is saved too.
+
+Final grades are saved in one transaction. Freezing a term more than once replaces them. It returns the number of final grades saved.
+*/
+func FreezeTermFinalGrades(t terms.Term) (int, error) {
+	cs, err := courses.List(util.DB, &courses.ListRequest{
+		EnrollmentTermIDs: []uint64{t.CanvasEnrollmentTermID},
+		InstitutionID:     t.InstitutionID,
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "error listing courses in term")
+	}
+
+	if len(*cs) < 1 {
+		return 0, nil
+	}
+
+	coursesByID := make(map[uint64]courses.Course, len(*cs))
+	var courseIDs []uint64
+	for _, c := range *cs {
+		coursesByID[c.CourseID] = c
+		courseIDs = append(courseIDs, c.CourseID)
+	}
+
+	gs, err := gradessvc.List(util.DB, &gradessvc.ListRequest{
+		CourseIDs:     &courseIDs,
+		InstitutionID: &t.InstitutionID,
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "error listing grades in term")
+	}
+
+	policies, err := grading_policies.GetSet(util.DB)
+	if err != nil {
+		return 0, errors.Wrap(err, "error getting grading policies")
+	}
+
+	cws, err := course_weights.List(util.DB, &course_weights.ListRequest{})
+	if err != nil {
+		return 0, errors.Wrap(err, "error listing course weights")
+	}
+	weights := course_weights.NewSet(*cws)
+
+	var req []final_grades.UpsertRequest
+	for _, g := range *gs {
+		c := coursesByID[g.CourseID]
+
+		policy := policies.ForCourse(t.InstitutionID, c.CourseID, c.EnrollmentTermID, c.RootAccountID)
+		if policy == nil {
+			policy = &grading_policies.Default
+		}
+
+		fg := final_grades.UpsertRequest{
+			TermID:        t.ID,
+			CanvasUserID:  g.UserCanvasID,
+			CourseID:      g.CourseID,
+			Grade:         g.Grade,
+			Weight:        weights.WeightForCourse(t.InstitutionID, c.CourseID, c.CourseCode),
+			InstitutionID: t.InstitutionID,
+		}
+
+		if b, ok := policy.Band(g.Grade); ok {
+			fg.GPAValue = b.GPAVal
+			fg.SubgradeGPAValue = b.SubgradeGPAVal
+		}
+
+		req = append(req, fg)
+	}
+
+	// every chunk is saved or none are, so a failed freeze doesn't leave a term half frozen
+	trx, err := util.DB.Begin()
+	if err != nil {
+		return 0, errors.Wrap(err, "error beginning freeze term final grades transaction")
+	}
+
+	for i := 0; i < len(req); i += final_grades.UpsertChunkSize {
+		end := i + final_grades.UpsertChunkSize
+		if end > len(req) {
+			end = len(req)
+		}
+
+		chunk := req[i:end]
+		err := final_grades.UpsertMultiple(trx, &chunk)
+		if err != nil {
+			rollbackErr := trx.Rollback()
+			if rollbackErr != nil {
+				return 0, errors.Wrap(rollbackErr, "error rolling back freeze term final grades transaction")
+			}
+
+			return 0, errors.Wrap(err, "error upserting final grades")
+		}
+	}
+
+	err = trx.Commit()
+	if err != nil {
+		return 0, errors.Wrap(err, "error committing freeze term final grades transaction")
+	}
+
+	return len(req), nil
+}
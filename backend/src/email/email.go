@@ -1,6 +1,9 @@
 package email
 
-import "github.com/sendgrid/sendgrid-go/helpers/mail"
+import (
+	"github.com/iamtheyammer/canvascbl/backend/src/env"
+	"github.com/sendgrid/sendgrid-go/helpers/mail"
+)
 
 type template struct {
 	ID      string
@@ -31,6 +34,16 @@ var (
 		From:    mail.NewEmail("CanvasCBL Grades", "grades@canvascbl.com"),
 		ReplyTo: defaultReplyTo,
 	}
+	gradeDropForecast = template{
+		ID:      env.SendGridGradeDropForecastTemplateID,
+		From:    mail.NewEmail("CanvasCBL Grades", "grades@canvascbl.com"),
+		ReplyTo: defaultReplyTo,
+	}
+	gradeDigest = template{
+		ID:      env.SendGridGradeDigestTemplateID,
+		From:    mail.NewEmail("CanvasCBL Grades", "grades@canvascbl.com"),
+		ReplyTo: defaultReplyTo,
+	}
 	parentGradeChange = template{
 		ID:      "d-a3966f137ea54953918c342a2238b804",
 		From:    mail.NewEmail("CanvasCBL Grades", "grades@canvascbl.com"),
@@ -0,0 +1,51 @@
+package email
+
+import (
+	"strings"
+)
+
+// GradeDigestEmailData represents the data needed to send a grade digest email.
+type GradeDigestEmailData struct {
+	To     string
+	Name   string
+	Grades []GradeDigestGrade
+}
+
+// GradeDigestGrade is one course's grade in a grade digest.
+type GradeDigestGrade struct {
+	// StudentName is only set when the grade is an observee's.
+	StudentName string
+	ClassName   string
+	Grade       string
+	// PreviousGrade is the grade at the last digest. It's empty if there wasn't one.
+	PreviousGrade string
+}
+
+// SendGradeDigestEmail sends a summary of grades and how they changed since the last digest.
+// It does nothing if there's no grade digest template.
+func SendGradeDigestEmail(req *GradeDigestEmailData) {
+	if len(gradeDigest.ID) < 1 {
+		return
+	}
+
+	var grades []map[string]interface{}
+	for _, g := range req.Grades {
+		grade := map[string]interface{}{
+			"class_name":     g.ClassName,
+			"grade":          g.Grade,
+			"previous_grade": g.PreviousGrade,
+			"changed":        len(g.PreviousGrade) > 0 && g.PreviousGrade != g.Grade,
+		}
+
+		if len(g.StudentName) > 0 {
+			grade["student_first_name"] = strings.Split(g.StudentName, " ")[0]
+		}
+
+		grades = append(grades, grade)
+	}
+
+	send(gradeDigest, map[string]interface{}{
+		"first_name": strings.Split(req.Name, " ")[0],
+		"grades":     grades,
+	}, req.To, req.Name)
+}
@@ -0,0 +1,42 @@
+package email
+
+import (
+	"strings"
+)
+
+// GradeDropForecastEmailData represents the data needed to send a grade drop forecast email.
+type GradeDropForecastEmailData struct {
+	To   string
+	Name string
+	// StudentName is only set when the email is going to a parent.
+	StudentName    string
+	ClassName      string
+	CurrentGrade   string
+	ProjectedGrade string
+	// ProjectedAt is when the grade is projected for, like the end of the term.
+	ProjectedAt string
+}
+
+// SendGradeDropForecastEmail sends an email warning that a grade will probably drop.
+// It does nothing if there's no grade drop forecast template.
+func SendGradeDropForecastEmail(req *GradeDropForecastEmailData) {
+	if len(gradeDropForecast.ID) < 1 {
+		return
+	}
+
+	firstName := strings.Split(req.Name, " ")[0]
+
+	data := map[string]interface{}{
+		"first_name":      firstName,
+		"class_name":      req.ClassName,
+		"current_grade":   req.CurrentGrade,
+		"projected_grade": req.ProjectedGrade,
+		"projected_at":    req.ProjectedAt,
+	}
+
+	if len(req.StudentName) > 0 {
+		data["student_first_name"] = strings.Split(req.StudentName, " ")[0]
+	}
+
+	send(gradeDropForecast, data, req.To, req.Name)
+}
@@ -3,6 +3,7 @@ package env
 import (
 	"fmt"
 	"strconv"
+	"time"
 )
 
 var (
@@ -13,6 +14,38 @@ var (
 	CanvasOAuth2SuccessURI   = getEnvOrPanic("CANVAS_OAUTH2_SUCCESS_URI")
 
 	CanvasCurrentEnrollmentTermID = getCanvasCurrentEnrollmentTermID()
+
+	// CanvasRequestTimeout is how long a single Canvas request can take, including reading its body.
+	CanvasRequestTimeout = getCanvasRequestDuration("CANVAS_REQUEST_TIMEOUT", "30s")
+	// CanvasRequestMaxRetries is how many times a failed Canvas GET request is tried again.
+	CanvasRequestMaxRetries = getCanvasRequestInt("CANVAS_REQUEST_MAX_RETRIES", "3")
+	// CanvasRequestRetryBaseDelay is about how long to wait before the first retry. It doubles with each retry.
+	CanvasRequestRetryBaseDelay = getCanvasRequestDuration("CANVAS_REQUEST_RETRY_BASE_DELAY", "500ms")
+	// CanvasFetchAllUserTimeout is how long fetch_all spends fetching a single user's grades before giving up on them.
+	CanvasFetchAllUserTimeout = getCanvasRequestDuration("CANVAS_FETCH_ALL_USER_TIMEOUT", "5m")
+	// CanvasFetchAllWorkers is how many fetch_all jobs this server runs at once. With 0, it doesn't run any.
+	CanvasFetchAllWorkers = getCanvasRequestInt("CANVAS_FETCH_ALL_WORKERS", "4")
+	// CanvasFetchAllMaxAttempts is how many times a student or observer's fetch_all job is tried if it fails transiently.
+	CanvasFetchAllMaxAttempts = getCanvasRequestInt("CANVAS_FETCH_ALL_MAX_ATTEMPTS", "2")
+	// CanvasIncrementalFetch is whether fetch_all skips courses where nothing was graded since their grades were stored.
+	CanvasIncrementalFetch = getEnv("CANVAS_INCREMENTAL_FETCH", "true") == "true"
+	// CanvasIncrementalFetchMaxAge is how old a stored grade can be before its course is fetched again anyway.
+	CanvasIncrementalFetchMaxAge = getCanvasRequestDuration("CANVAS_INCREMENTAL_FETCH_MAX_AGE", "24h")
+
+	// CanvasResponseCache is where Canvas responses are cached: "memory", "postgres" or "none".
+	CanvasResponseCache = getEnv("CANVAS_RESPONSE_CACHE", "none")
+	// CanvasResponseCacheSize is how many responses the memory cache holds.
+	CanvasResponseCacheSize = getCanvasRequestInt("CANVAS_RESPONSE_CACHE_SIZE", "5000")
+
+	// CanvasGraphQL is whether assignments and submissions are fetched with Canvas's GraphQL API when a token can use it.
+	// Every developer key must allow POST /api/graphql, as it's added to the requested scopes.
+	CanvasGraphQL = getEnv("CANVAS_GRAPHQL", "false") == "true"
+
+	// CanvasFakeFixtures, if present, is the path to fixtures for a fake Canvas (see package canvasfake),
+	// which is used instead of CanvasDomain. It's only used in development, and can't be set in production.
+	CanvasFakeFixtures = getCanvasFakeFixtures()
+	// CanvasFakeAddr is the address the fake Canvas listens on.
+	CanvasFakeAddr = getEnv("CANVAS_FAKE_ADDR", "localhost:8001")
 )
 
 func getCanvasCurrentEnrollmentTermID() int {
@@ -25,3 +58,30 @@ func getCanvasCurrentEnrollmentTermID() int {
 
 	return etIDInt
 }
+
+func getCanvasFakeFixtures() string {
+	f := getEnv("CANVAS_FAKE_FIXTURES", "")
+	if len(f) > 0 && Env == EnvironmentProduction {
+		panic("CANVAS_FAKE_FIXTURES can't be set in production")
+	}
+
+	return f
+}
+
+func getCanvasRequestDuration(key string, fallback string) time.Duration {
+	d, err := time.ParseDuration(getEnv(key, fallback))
+	if err != nil {
+		panic(fmt.Errorf("error parsing %s as a duration: %w", key, err))
+	}
+
+	return d
+}
+
+func getCanvasRequestInt(key string, fallback string) int {
+	i, err := strconv.Atoi(getEnv(key, fallback))
+	if err != nil {
+		panic(fmt.Errorf("error converting %s to an int: %w", key, err))
+	}
+
+	return i
+}
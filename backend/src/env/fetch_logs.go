@@ -0,0 +1,21 @@
+package env
+
+var (
+	// FetchLogSink is where fetch_all logs (like run summaries) are written: "s3", "file", "stdout" or "none".
+	// It defaults to s3, except in development, where it defaults to stdout.
+	FetchLogSink = getEnv("FETCH_LOG_SINK", getDefaultFetchLogSink())
+	// FetchLogDir is the directory the file sink writes logs to. It's created if it doesn't exist.
+	FetchLogDir = getEnv("FETCH_LOG_DIR", "fetch_logs")
+	// FetchLogS3Bucket is the bucket the s3 sink uploads logs to.
+	FetchLogS3Bucket = getEnv("FETCH_LOG_S3_BUCKET", "canvascbl-fetch-all-grades-logs")
+	// FetchLogS3Region is the region FetchLogS3Bucket is in.
+	FetchLogS3Region = getEnv("FETCH_LOG_S3_REGION", "us-east-2")
+)
+
+func getDefaultFetchLogSink() string {
+	if Env == EnvironmentDevelopment {
+		return "stdout"
+	}
+
+	return "s3"
+}
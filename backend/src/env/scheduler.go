@@ -0,0 +1,13 @@
+package env
+
+var (
+	// ScheduleFetchAll is the cron schedule (in UTC) that fetch_all runs on. If it's empty, it's only run through
+	// GET /api/v1/grades/fetch_all, like by lambda_grades_fetcher.
+	ScheduleFetchAll = getEnv("SCHEDULE_FETCH_ALL", "")
+	// ScheduleReconcileFetchRuns is the cron schedule (in UTC) that unfinished fetch_all runs are reconciled on.
+	// If it's empty, they aren't.
+	ScheduleReconcileFetchRuns = getEnv("SCHEDULE_RECONCILE_FETCH_RUNS", "*/15 * * * *")
+	// ScheduleGradeDigests is the cron schedule (in UTC) that grade digest emails are sent on. If it's empty,
+	// they aren't.
+	ScheduleGradeDigests = getEnv("SCHEDULE_GRADE_DIGESTS", "0 14 * * 0")
+)
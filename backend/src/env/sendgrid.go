@@ -2,4 +2,10 @@ package env
 
 var (
 	SendGridAPIKey = getEnvOrPanic("SENDGRID_API_KEY")
+	// SendGridGradeDropForecastTemplateID is the template for grade drop forecast emails.
+	// If it's empty, they aren't sent.
+	SendGridGradeDropForecastTemplateID = getEnv("SENDGRID_GRADE_DROP_FORECAST_TEMPLATE_ID", "")
+	// SendGridGradeDigestTemplateID is the template for weekly grade digest emails.
+	// If it's empty, they aren't sent.
+	SendGridGradeDigestTemplateID = getEnv("SENDGRID_GRADE_DIGEST_TEMPLATE_ID", "")
 )
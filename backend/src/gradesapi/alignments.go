@@ -1,12 +1,12 @@
 package gradesapi
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/iamtheyammer/canvascbl/backend/src/oauth2"
 	"github.com/iamtheyammer/canvascbl/backend/src/util"
 	"github.com/julienschmidt/httprouter"
-	"io"
 	"net/http"
 )
 
@@ -34,14 +34,14 @@ func AlignmentsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Par
 	errCtx.AddCustomField("course_id", cID)
 	errCtx.AddCustomField("student_id", sID)
 
-	var alignments *http.Response
+	var alignments []json.RawMessage
 	_, err := handleRequestWithTokenRefresh(func(reqD *requestDetails) error {
-		resp, alErr := proxyCanvasOutcomeAlignments(*reqD, cID, sID)
+		als, alErr := getCanvasOutcomeAlignments(*reqD, cID, sID)
 		if alErr != nil {
 			return fmt.Errorf("error getting outcome alignments for course %s: %w", cID, alErr)
 		}
 
-		alignments = resp
+		alignments = als
 		return nil
 	}, rdP, *userID)
 	if errors.Is(err, canvasErrorInvalidAccessTokenError) || errors.Is(err, canvasErrorInsufficientScopesOnAccessTokenError) {
@@ -50,6 +50,9 @@ func AlignmentsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Par
 			Action: gradesErrorActionRedirectToOAuth,
 		}, http.StatusForbidden)
 		return
+	} else if errors.Is(err, canvasErrorRateLimitExceededError) {
+		handleError(w, gradesErrorCanvasRateLimitedResponse, http.StatusTooManyRequests)
+		return
 	} else if errors.Is(err, canvasErrorUnknownError) {
 		handleError(w, gradesErrorUnknownCanvasErrorResponse, util.CanvasProxyErrorCode)
 		return
@@ -58,14 +61,17 @@ func AlignmentsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Par
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	_, err = io.Copy(w, alignments.Body)
+	if alignments == nil {
+		// an empty list, not null, like Canvas
+		alignments = []json.RawMessage{}
+	}
+
+	jAlignments, err := json.Marshal(alignments)
 	if err != nil {
-		handleISE(w, errCtx.Apply(fmt.Errorf("error copying body for outcome alignments: %w", err)))
+		handleISE(w, errCtx.Apply(fmt.Errorf("error marshaling outcome alignments for course %s: %w", cID, err)))
 		return
 	}
 
-	defer alignments.Body.Close()
-
+	util.SendJSONResponse(w, jAlignments)
 	return
 }
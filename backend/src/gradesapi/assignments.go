@@ -53,6 +53,9 @@ func AssignmentsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 			Action: gradesErrorActionRedirectToOAuth,
 		}, http.StatusForbidden)
 		return
+	} else if errors.Is(err, canvasErrorRateLimitExceededError) {
+		handleError(w, gradesErrorCanvasRateLimitedResponse, http.StatusTooManyRequests)
+		return
 	} else if errors.Is(err, canvasErrorUnknownError) {
 		handleError(w, gradesErrorUnknownCanvasErrorResponse, util.CanvasProxyErrorCode)
 		return
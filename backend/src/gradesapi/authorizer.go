@@ -124,5 +124,8 @@ func authorizer(
 
 	errCtx.UserID = userID
 
+	// Canvas requests stop if the client goes away
+	rd.Ctx = r.Context()
+
 	return &userID, &rd, session, &errCtx
 }
@@ -3,14 +3,47 @@ package gradesapi
 import (
 	"math"
 	"sort"
+	"time"
 )
 
+type outcomeCalculationMethod string
+
+const (
+	// outcomeCalculationCBLLegacy averages positive scores, dropping the lowest if the policy allows it.
+	outcomeCalculationCBLLegacy = outcomeCalculationMethod("cbl_legacy")
+	// outcomeCalculationDecayingAverage weights the latest score Int% and the average of the rest 100-Int%.
+	outcomeCalculationDecayingAverage = outcomeCalculationMethod("decaying_average")
+	// outcomeCalculationNMastery averages the scores at or over mastery, if there are at least Int of them.
+	outcomeCalculationNMastery = outcomeCalculationMethod("n_mastery")
+	// outcomeCalculationLatest uses the latest score.
+	outcomeCalculationLatest = outcomeCalculationMethod("latest")
+	// outcomeCalculationHighest uses the highest score.
+	outcomeCalculationHighest = outcomeCalculationMethod("highest")
+	// outcomeCalculationAverage averages every score.
+	outcomeCalculationAverage = outcomeCalculationMethod("average")
+
+	// outcomeCalculationDefaultDecayingAverageInt is Canvas's default weight for decaying_average.
+	outcomeCalculationDefaultDecayingAverageInt = 65
+)
+
+// outcomeCalculation is how an outcome's score is calculated, from the outcome in Canvas.
+type outcomeCalculation struct {
+	Method outcomeCalculationMethod
+	// Int is Canvas's calculation_int: the latest score's weight for decaying_average, or n for n_mastery.
+	Int int64
+	// MasteryPoints is the score needed for mastery, used by n_mastery.
+	MasteryPoints float64
+}
+
+// outcomeCalculations is map[outcomeID<uint64>]outcomeCalculation
+type outcomeCalculations map[uint64]outcomeCalculation
+
 type grade struct {
 	// the grade, like A or B
 	Grade string `json:"grade"`
 	// how "good" the grade is, higher is better
 	Rank int8 `json:"rank"`
-	// 75% = most; aka max
+	// MostPercent% (usually 75%) = most; aka max
 	MostAbove float64 `json:"most_above"`
 	// aka min
 	AllAbove float64 `json:"all_above"`
@@ -27,6 +60,8 @@ type computedAverage struct {
 	DidDropWorstScore bool `json:"did_drop_worst_score"`
 	// The computed final average
 	Average float64 `json:"average"`
+	// The description of the best proficiency rating the average is at or over, like "Meets Mastery"
+	ProficiencyRating string `json:"proficiency_rating,omitempty"`
 }
 
 type computedGrade struct {
@@ -34,17 +69,17 @@ type computedGrade struct {
 	Grade grade `json:"grade"`
 	// Relationship between outcome ID and whether the last score was dropped
 	Averages map[uint64]computedAverage `json:"averages"`
-}
-
-// gradeMap is a slice of possible grades
-var gradeMap = []grade{
-	{"A", 6, 3.3, 3, 4, 4},
-	{"A-", 5, 3.3, 2.5, 4, 3.7},
-	{"B+", 4, 2.6, 2.2, 3, 3.3},
-	{"B", 3, 2.6, 1.8, 3, 3},
-	{"B-", 2, 2.6, 1.5, 3, 2.7},
-	{"C", 1, 2.2, 1.5, 2, 2},
-	{"I", 0, 0, 0, 0, 0},
+	// The ID of the grading policy used to calculate the grade. Omitted for the default policy.
+	GradingPolicyID uint64 `json:"grading_policy_id,omitempty"`
+	// Cutoff is the course's current or upcoming drop cutoff, after which lowest scores aren't dropped.
+	// Omitted if the course doesn't have one.
+	Cutoff *gradeCutoff `json:"cutoff,omitempty"`
+	// Explanation explains how the grade was calculated. Only included with include[]=grade_explanations.
+	Explanation *gradeExplanation `json:"explanation,omitempty"`
+	// Forecast projects the grade to the end of the term. Only included with include[]=grade_forecasts.
+	Forecast *gradeForecast `json:"forecast,omitempty"`
+	// reused is whether the grade is a stored grade from an incremental fetch, which isn't stored again.
+	reused bool
 }
 
 var naGrade = grade{"N/A", -1, 0, 0, 0, 0}
@@ -53,17 +88,28 @@ var naGrade = grade{"N/A", -1, 0, 0, 0, 0}
 calculateGradeFromOutcomeResults calculates a grade object from a map of scores.
 The map should look like this: map[outcomeID<uint64>][]scores<float64>.
 
+calcs holds each outcome's Canvas calculation method, which is used if the policy
+uses Canvas calculation methods. Outcomes not in calcs use the CBL legacy method.
+
+policy holds the grades and rules (percent of outcomes counted, dropping the lowest score) to use.
+
 isAfterCutoff represents whether the lowest score should be dropped to improve a grade.
 
 The returned map displays the relationship between the outcome ID and whether the last score was dropped.
 
 Note that this is a very expensive function: ~O(n^5+n), so consider use inside of a goroutine.
 */
-func calculateGradeFromOutcomeResults(results map[uint64][]canvasOutcomeResult, isAfterCutoff bool) *computedGrade {
+func calculateGradeFromOutcomeResults(
+	results map[uint64][]canvasOutcomeResult,
+	calcs outcomeCalculations,
+	policy gradingPolicy,
+	isAfterCutoff bool,
+) *computedGrade {
 	if len(results) < 1 {
 		return &computedGrade{
-			Grade:    naGrade,
-			Averages: nil,
+			Grade:           naGrade,
+			Averages:        nil,
+			GradingPolicyID: policy.ID,
 		}
 	}
 
@@ -73,82 +119,259 @@ func calculateGradeFromOutcomeResults(results map[uint64][]canvasOutcomeResult,
 	var avgs []float64
 
 	for oID, rs := range results {
-		if len(rs) < 1 {
+		avg, ok := calculateOutcomeAverage(rs, policy.outcomeCalculation(calcs, oID), policy, isAfterCutoff)
+		if !ok {
 			continue
 		}
 
-		if len(rs) == 1 && rs[0].Score > 0 {
-			// why do the work if there's only one score?
-			averages[oID] = computedAverage{
-				DidDropWorstScore: false,
-				Average:           rs[0].Score,
-			}
+		averages[oID] = avg
+		avgs = append(avgs, avg.Average)
+	}
 
-			avgs = append(avgs, rs[0].Score)
-			continue
+	if len(avgs) < 1 {
+		return &computedGrade{
+			Grade:           naGrade,
+			Averages:        nil,
+			GradingPolicyID: policy.ID,
 		}
+	}
 
-		var scores []float64
-		for _, s := range rs {
-			if s.Score > 0 {
-				scores = append(scores, s.Score)
-			}
+	return &computedGrade{
+		Grade:           calculateGradeFromAverages(avgs, policy),
+		Averages:        averages,
+		GradingPolicyID: policy.ID,
+	}
+}
+
+/*
+calculateOutcomeAverage calculates the score for a single outcome's results with calc.
+
+If the outcome doesn't have a score yet, ok will be false.
+*/
+func calculateOutcomeAverage(
+	rs []canvasOutcomeResult,
+	calc outcomeCalculation,
+	policy gradingPolicy,
+	isAfterCutoff bool,
+) (avg computedAverage, ok bool) {
+	switch calc.Method {
+	case outcomeCalculationDecayingAverage,
+		outcomeCalculationNMastery,
+		outcomeCalculationLatest,
+		outcomeCalculationHighest,
+		outcomeCalculationAverage:
+		return calculateCanvasOutcomeAverage(rs, calc)
+	default:
+		return calculateCBLLegacyOutcomeAverage(rs, policy, isAfterCutoff)
+	}
+}
+
+/*
+calculateCBLLegacyOutcomeAverage calculates the average for a single outcome's results
+the way CanvasCBL always has.
+
+Only positive scores are counted. If the policy allows it and it isn't after the cutoff,
+the lowest score is dropped when that improves the average.
+
+If there are no positive scores, ok will be false.
+*/
+func calculateCBLLegacyOutcomeAverage(
+	rs []canvasOutcomeResult,
+	policy gradingPolicy,
+	isAfterCutoff bool,
+) (avg computedAverage, ok bool) {
+	if len(rs) < 1 {
+		return computedAverage{}, false
+	}
+
+	if len(rs) == 1 && rs[0].Score > 0 {
+		// why do the work if there's only one score?
+		return computedAverage{
+			DidDropWorstScore: false,
+			Average:           rs[0].Score,
+		}, true
+	}
+
+	var scores []float64
+	for _, s := range rs {
+		if s.Score > 0 {
+			scores = append(scores, s.Score)
 		}
+	}
 
-		if len(scores) < 1 {
-			continue
+	if len(scores) < 1 {
+		return computedAverage{}, false
+	}
+
+	sortedScores := sort.Float64Slice(scores)
+	sort.Sort(sort.Reverse(sortedScores))
+
+	var total float64
+	for _, s := range sortedScores {
+		total += s
+	}
+
+	numScores := float64(sortedScores.Len())
+
+	// average of all sortedScores
+	allScoreAvg := total / numScores
+	// average of all sortedScores except for lowest (last, as it's sorted)
+	// zero by default because this is only calculated if there are enough scores
+	noLastAvg := float64(0)
+	if numScores > 1 && sortedScores.Len() >= policy.DropWorstMinScores {
+		// total - lastScore / numberOfScores - 1 (so that the average is right)
+		noLastAvg = (total - sortedScores[int(numScores-1)]) / (numScores - 1)
+	}
+
+	// if the "dropped" average helped the score AND it isn't after the cutoff, use it.
+	if policy.DropWorstScore && (noLastAvg > allScoreAvg) && !isAfterCutoff {
+		return computedAverage{
+			DidDropWorstScore: true,
+			Average:           noLastAvg,
+		}, true
+	}
+
+	return computedAverage{
+		DidDropWorstScore: false,
+		Average:           allScoreAvg,
+	}, true
+}
+
+/*
+calculateCanvasOutcomeAverage calculates the score for a single outcome's results the way Canvas does,
+with calc.Method. Every score counts, including zeroes.
+
+Results are ordered by when they were submitted or assessed. Results without a time, like
+simulated ones, are treated as the latest.
+
+If there are no results, or there aren't enough scores at mastery for n_mastery, ok will be false.
+*/
+func calculateCanvasOutcomeAverage(rs []canvasOutcomeResult, calc outcomeCalculation) (avg computedAverage, ok bool) {
+	if len(rs) < 1 {
+		return computedAverage{}, false
+	}
+
+	sorted := outcomeResultsByAssessedAt(rs)
+	scores := make([]float64, len(sorted))
+	for i, r := range sorted {
+		scores[i] = r.Score
+	}
+
+	switch calc.Method {
+	case outcomeCalculationLatest:
+		return computedAverage{Average: scores[len(scores)-1]}, true
+	case outcomeCalculationHighest:
+		highest := scores[0]
+		for _, s := range scores {
+			highest = math.Max(highest, s)
 		}
 
-		sortedScores := sort.Float64Slice(scores)
-		sort.Sort(sort.Reverse(sortedScores))
+		return computedAverage{Average: highest}, true
+	case outcomeCalculationAverage:
+		return computedAverage{Average: averageOf(scores)}, true
+	case outcomeCalculationDecayingAverage:
+		if len(scores) == 1 {
+			return computedAverage{Average: scores[0]}, true
+		}
 
-		var total float64
-		for _, s := range sortedScores {
-			total += s
+		weight := float64(calc.Int)
+		if weight <= 0 || weight >= 100 {
+			weight = outcomeCalculationDefaultDecayingAverageInt
 		}
 
-		numScores := float64(sortedScores.Len())
+		latest := scores[len(scores)-1]
+		rest := averageOf(scores[:len(scores)-1])
+
+		return computedAverage{Average: latest*weight/100 + rest*(100-weight)/100}, true
+	case outcomeCalculationNMastery:
+		var mastered []float64
+		for _, s := range scores {
+			if s >= calc.MasteryPoints {
+				mastered = append(mastered, s)
+			}
+		}
 
-		didDrop := false
+		n := int(calc.Int)
+		if n < 1 {
+			n = 1
+		}
 
-		// average of all sortedScores
-		allScoreAvg := total / numScores
-		// average of all sortedScores except for lowest (last, as it's sorted)
-		// zero by default because this is only calculated if there is more than 1 score
-		noLastAvg := float64(0)
-		if numScores > 1 {
-			// total - lastScore / numberOfScores - 1 (so that the average is right)
-			noLastAvg = (total - sortedScores[int(numScores-1)]) / (numScores - 1)
+		if len(mastered) < n {
+			return computedAverage{}, false
 		}
 
-		// default the average to using all sortedScores
-		avg := allScoreAvg
+		return computedAverage{Average: averageOf(mastered)}, true
+	}
+
+	return computedAverage{}, false
+}
+
+// outcomeResultsByAssessedAt returns a copy of rs, oldest first.
+// Results without a valid time go last, in their original order.
+func outcomeResultsByAssessedAt(rs []canvasOutcomeResult) []canvasOutcomeResult {
+	type timedResult struct {
+		at     time.Time
+		hasAt  bool
+		result canvasOutcomeResult
+	}
 
-		// if the "dropped" average helped the score AND it isn't after the cutoff, use it.
-		if (noLastAvg > allScoreAvg) && !isAfterCutoff {
-			avg = noLastAvg
-			didDrop = true
+	trs := make([]timedResult, len(rs))
+	for i, r := range rs {
+		trs[i].result = r
+		if at, err := time.Parse(time.RFC3339, r.SubmittedOrAssessedAt); err == nil {
+			trs[i].at = at
+			trs[i].hasAt = true
 		}
+	}
 
-		averages[oID] = computedAverage{
-			DidDropWorstScore: didDrop,
-			Average:           avg,
+	sort.SliceStable(trs, func(i, j int) bool {
+		if trs[i].hasAt != trs[j].hasAt {
+			return trs[i].hasAt
 		}
-		avgs = append(avgs, avg)
+
+		return trs[i].at.Before(trs[j].at)
+	})
+
+	sorted := make([]canvasOutcomeResult, len(trs))
+	for i, tr := range trs {
+		sorted[i] = tr.result
 	}
 
-	if len(avgs) < 1 {
-		return &computedGrade{
-			Grade:    naGrade,
-			Averages: nil,
+	return sorted
+}
+
+// averageOf returns the mean of fs. fs must not be empty.
+func averageOf(fs []float64) float64 {
+	var total float64
+	for _, f := range fs {
+		total += f
+	}
+
+	return total / float64(len(fs))
+}
+
+// outcomeCalculationsFromOutcomes gets the calculation method of each outcome.
+func outcomeCalculationsFromOutcomes(os []canvasOutcome) outcomeCalculations {
+	calcs := make(outcomeCalculations, len(os))
+	for _, o := range os {
+		calcs[o.ID] = outcomeCalculation{
+			Method:        outcomeCalculationMethod(o.CalculationMethod),
+			Int:           o.CalculationInt,
+			MasteryPoints: o.MasteryPoints,
 		}
 	}
 
-	// what is 75% of len(s)
-	outcomesOverMinNeeded := int(math.Floor(float64(75*len(avgs)) / float64(100)))
+	return calcs
+}
+
+// calculateGradeFromAverages picks the best grade in the policy that a set of outcome averages qualifies for.
+func calculateGradeFromAverages(avgs []float64, policy gradingPolicy) grade {
+	// what is policy.MostPercent% (usually 75%) of len(s)
+	outcomesOverMinNeeded := policy.numMostOutcomes(len(avgs))
 
-	// float64 outcome results
-	sortedOutcomes := sort.Float64Slice(avgs)
+	// float64 outcome results, copied so we don't reorder the caller's slice
+	sortedOutcomes := make(sort.Float64Slice, len(avgs))
+	copy(sortedOutcomes, avgs)
 	// sorts in place
 	sort.Sort(sort.Reverse(sortedOutcomes))
 	// sortedOutcomes are now sorted
@@ -156,21 +379,15 @@ func calculateGradeFromOutcomeResults(results map[uint64][]canvasOutcomeResult,
 	// first 75% of outcomes
 	countedOutcomes := sortedOutcomes[:outcomesOverMinNeeded]
 
-	// if there is only one graded outcome in a class, that outcome is counted
-	// this also fixes an array[-1] bug
-	if len(countedOutcomes) < 1 {
-		countedOutcomes = sortedOutcomes
-	}
-
 	lowestCountedOutcome := countedOutcomes[len(countedOutcomes)-1]
 
 	// overall
 	lowestOutcome := sortedOutcomes[len(sortedOutcomes)-1]
 
-	// default to an I
-	finalGrade := gradeMap[6]
+	// default to the lowest grade (an I by default)
+	finalGrade := policy.lowestGrade()
 
-	for _, v := range gradeMap {
+	for _, v := range policy.Grades {
 		// lowest outcome is over minimum (AllAbove)
 		if v.AllAbove > lowestOutcome {
 			continue
@@ -187,18 +404,18 @@ func calculateGradeFromOutcomeResults(results map[uint64][]canvasOutcomeResult,
 		}
 	}
 
-	return &computedGrade{
-		Grade:    finalGrade,
-		Averages: averages,
-	}
+	return finalGrade
 }
 
 /*
 
 calculateGPAFromDetailedGrades returns a gpa object from
 a detailedGrades object.
+
+weights is map[courseID<uint64>]weight<float64>. A course's weight is added to its
+grade's GPA values for the weighted GPA, unless the grade isn't worth any GPA points.
 */
-func calculateGPAFromDetailedGrades(g detailedGrades) gpa {
+func calculateGPAFromDetailedGrades(g detailedGrades, weights map[uint64]float64) gpa {
 	finalGPA := gpa{}
 	for uID, cs := range g {
 		var (
@@ -207,20 +424,35 @@ func calculateGPAFromDetailedGrades(g detailedGrades) gpa {
 			subSum float64
 			// default sum
 			defSum float64
+			// weighted subgrade sum
+			weightedSubSum float64
+			// weighted default sum
+			weightedDefSum float64
 			// courses with a valid grade
 			validClasses float64
 		)
 
-		for _, c := range cs {
+		for cID, c := range cs {
 			if c.Grade != naGrade {
 				validClasses += 1
 			}
 			subSum += c.Grade.SubgradeGPAVal
 			defSum += c.Grade.GPAVal
+
+			weightedSubSum += c.Grade.SubgradeGPAVal
+			weightedDefSum += c.Grade.GPAVal
+			if c.Grade.GPAVal > 0 {
+				weightedSubSum += weights[cID]
+				weightedDefSum += weights[cID]
+			}
 		}
 
-		cGPA.Unweighted.Subgrades = subSum / validClasses
-		cGPA.Unweighted.Default = defSum / validClasses
+		if validClasses > 0 {
+			cGPA.Unweighted.Subgrades = subSum / validClasses
+			cGPA.Unweighted.Default = defSum / validClasses
+			cGPA.Weighted.Subgrades = weightedSubSum / validClasses
+			cGPA.Weighted.Default = weightedDefSum / validClasses
+		}
 
 		finalGPA[uID] = cGPA
 	}
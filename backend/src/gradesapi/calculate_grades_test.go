@@ -25,7 +25,7 @@ func Test_calculateGradeFromOutcomeResults(t *testing.T) {
 				2: {canvasOutcomeResult{Score: 4}},
 				3: {canvasOutcomeResult{Score: 3}}},
 			}, want: &computedGrade{
-				Grade: grade{"A", 6, 3.3, 3},
+				Grade: grade{"A", 6, 3.3, 3, 4, 4},
 				Averages: map[uint64]computedAverage{
 					1: {
 						DidDropWorstScore: false,
@@ -44,9 +44,40 @@ func Test_calculateGradeFromOutcomeResults(t *testing.T) {
 		}}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := calculateGradeFromOutcomeResults(tt.args.results, tt.args.isAfterCutoff); !reflect.DeepEqual(got, tt.want) {
+			if got := calculateGradeFromOutcomeResults(tt.args.results, nil, defaultGradingPolicy, tt.args.isAfterCutoff); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("calculateGradeFromOutcomeResults() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func Test_calculateCanvasOutcomeAverage(t *testing.T) {
+	// out of order on purpose: 4, then 3, then 2
+	results := []canvasOutcomeResult{
+		{Score: 2, SubmittedOrAssessedAt: "2020-01-03T00:00:00Z"},
+		{Score: 4, SubmittedOrAssessedAt: "2020-01-01T00:00:00Z"},
+		{Score: 3, SubmittedOrAssessedAt: "2020-01-02T00:00:00Z"},
+	}
+
+	tests := []struct {
+		name   string
+		calc   outcomeCalculation
+		want   float64
+		wantOk bool
+	}{
+		{"latest", outcomeCalculation{Method: outcomeCalculationLatest}, 2, true},
+		{"highest", outcomeCalculation{Method: outcomeCalculationHighest}, 4, true},
+		{"average", outcomeCalculation{Method: outcomeCalculationAverage}, 3, true},
+		{"decaying_average_50", outcomeCalculation{Method: outcomeCalculationDecayingAverage, Int: 50}, 2.75, true},
+		{"n_mastery_2", outcomeCalculation{Method: outcomeCalculationNMastery, Int: 2, MasteryPoints: 3}, 3.5, true},
+		{"n_mastery_3", outcomeCalculation{Method: outcomeCalculationNMastery, Int: 3, MasteryPoints: 3}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := calculateCanvasOutcomeAverage(results, tt.calc)
+			if ok != tt.wantOk || got.Average != tt.want {
+				t.Errorf("calculateCanvasOutcomeAverage() = %v, %v, want %v, %v", got.Average, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
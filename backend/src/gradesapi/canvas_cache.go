@@ -0,0 +1,340 @@
+package gradesapi
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/canvas_responses"
+	"github.com/iamtheyammer/canvascbl/backend/src/env"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Canvas sends an ETag (and sometimes a Last-Modified) with most responses. Cached responses are
+revalidated with If-None-Match/If-Modified-Since, and Canvas responds with 304 Not Modified if they
+haven't changed, which is faster and cheaper on the rate limit than getting them again.
+
+Responses from endpoints in canvasResponseCacheTTLs are used without asking Canvas at all for a while.
+*/
+const (
+	// canvasResponseCacheMaxBodySize is the biggest response body that's cached.
+	canvasResponseCacheMaxBodySize = 1 << 20
+	// canvasResponseCacheMaxAge is how long the postgres cache keeps responses that aren't used.
+	canvasResponseCacheMaxAge = time.Hour * 24
+	// canvasResponseCachePruneInterval is how often the postgres cache deletes old responses.
+	canvasResponseCachePruneInterval = time.Hour
+)
+
+// canvasResponseCacheTTLs are how long responses from endpoints whose data rarely changes can be used
+// without revalidating them. Patterns are matched against the path (without the leading slash) and query.
+//
+// Endpoints with grades, like courses (which include total scores), submissions and outcome results,
+// are always revalidated.
+var canvasResponseCacheTTLs = []struct {
+	pattern *regexp.Regexp
+	ttl     time.Duration
+}{
+	{regexp.MustCompile(`^api/v1/courses/\d+/assignments\?`), time.Minute * 10},
+	{regexp.MustCompile(`^api/v1/courses/\d+/outcome_alignments\?`), time.Minute * 10},
+	{regexp.MustCompile(`^api/v1/outcomes/\d+$`), time.Hour},
+	{regexp.MustCompile(`^api/v1/accounts/\d+/outcome_proficiency$`), time.Hour},
+	{regexp.MustCompile(`^api/v1/users/\d+/profile$`), time.Hour},
+	{regexp.MustCompile(`^api/v1/users/\d+/observees$`), time.Minute * 10},
+}
+
+// cachedCanvasResponse is a successful response from Canvas, with what's needed to revalidate and replay it.
+type cachedCanvasResponse struct {
+	ETag         string
+	LastModified string
+	// Link is kept for pagination
+	Link        string
+	ContentType string
+	Body        []byte
+	// StoredAt is when the response was last fetched or revalidated
+	StoredAt time.Time
+}
+
+// canvasResponseCache stores responses by a key from canvasResponseCacheKey.
+// Implementations must be safe for concurrent use.
+type canvasResponseCache interface {
+	// Get returns nil if there's no response with the key.
+	Get(key string) (*cachedCanvasResponse, error)
+	Set(key string, r cachedCanvasResponse) error
+}
+
+// canvasResponses is the cache used for Canvas requests, or nil if responses aren't cached.
+var canvasResponses = newCanvasResponseCache(env.CanvasResponseCache)
+
+func newCanvasResponseCache(kind string) canvasResponseCache {
+	switch kind {
+	case "memory":
+		return newMemoryCanvasResponseCache(env.CanvasResponseCacheSize)
+	case "postgres":
+		return &postgresCanvasResponseCache{}
+	case "none", "":
+		return nil
+	default:
+		panic(fmt.Errorf("unknown CANVAS_RESPONSE_CACHE %q (must be memory, postgres or none)", kind))
+	}
+}
+
+// canvasResponseCacheKey is the key for a response. It's by token ID, not token, so a refreshed token keeps its
+// responses and tokens aren't stored.
+func canvasResponseCacheKey(tokenID uint64, u *url.URL) string {
+	return fmt.Sprintf("%d %s", tokenID, u.String())
+}
+
+// canvasResponseCacheTTL is how long a response from u can be used without revalidating it.
+func canvasResponseCacheTTL(u *url.URL) time.Duration {
+	p := strings.TrimPrefix(u.Path, "/")
+	if len(u.RawQuery) > 0 {
+		p += "?" + u.RawQuery
+	}
+
+	for _, t := range canvasResponseCacheTTLs {
+		if t.pattern.MatchString(p) {
+			return t.ttl
+		}
+	}
+
+	return 0
+}
+
+/*
+doCachedCanvasRequest makes the GET request req through cache. If there's a fresh response in the cache,
+Canvas isn't asked at all. Otherwise, a cached response is revalidated, and a new one is stored.
+
+Cache errors are reported, but don't fail the request.
+*/
+func doCachedCanvasRequest(cache canvasResponseCache, req *http.Request, rd requestDetails) (*http.Response, error) {
+	key := canvasResponseCacheKey(rd.TokenID, req.URL)
+	ttl := canvasResponseCacheTTL(req.URL)
+
+	cached, err := cache.Get(key)
+	if err != nil {
+		util.HandleError(fmt.Errorf("error getting cached canvas response: %w", err))
+		cached = nil
+	}
+
+	if cached != nil {
+		if ttl > 0 && time.Since(cached.StoredAt) < ttl {
+			return cached.toResponse(req), nil
+		}
+
+		if len(cached.ETag) > 0 {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if len(cached.LastModified) > 0 {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	resp, err := doCanvasRequestWithRetries(req, rd)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+
+		revalidated := *cached
+		revalidated.StoredAt = time.Now()
+		if err := cache.Set(key, revalidated); err != nil {
+			util.HandleError(fmt.Errorf("error storing revalidated canvas response: %w", err))
+		}
+
+		return revalidated.toResponse(req), nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+
+	etag := resp.Header.Get("ETag")
+	lastModified := resp.Header.Get("Last-Modified")
+	if len(etag) < 1 && len(lastModified) < 1 && ttl < 1 {
+		return resp, nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, &canvasRequestError{
+			StatusCode: resp.StatusCode,
+			Transient:  isTransientNetworkError(err),
+			Err:        fmt.Errorf("error reading canvas response body: %w", err),
+		}
+	}
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	if len(body) > canvasResponseCacheMaxBodySize {
+		return resp, nil
+	}
+
+	err = cache.Set(key, cachedCanvasResponse{
+		ETag:         etag,
+		LastModified: lastModified,
+		Link:         resp.Header.Get("Link"),
+		ContentType:  resp.Header.Get("Content-Type"),
+		Body:         body,
+		StoredAt:     time.Now(),
+	})
+	if err != nil {
+		util.HandleError(fmt.Errorf("error storing canvas response: %w", err))
+	}
+
+	return resp, nil
+}
+
+// toResponse makes a 200 OK response for req from r.
+func (r cachedCanvasResponse) toResponse(req *http.Request) *http.Response {
+	h := http.Header{}
+	if len(r.ContentType) > 0 {
+		h.Set("Content-Type", r.ContentType)
+	}
+	if len(r.Link) > 0 {
+		h.Set("Link", r.Link)
+	}
+	if len(r.ETag) > 0 {
+		h.Set("ETag", r.ETag)
+	}
+	if len(r.LastModified) > 0 {
+		h.Set("Last-Modified", r.LastModified)
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          ioutil.NopCloser(bytes.NewReader(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}
+}
+
+// memoryCanvasResponseCache is an LRU cache in memory, for when there's only one server.
+type memoryCanvasResponseCache struct {
+	mutex sync.Mutex
+	size  int
+	// elements' values are *memoryCanvasResponseCacheEntry, most recently used first
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryCanvasResponseCacheEntry struct {
+	key      string
+	response cachedCanvasResponse
+}
+
+func newMemoryCanvasResponseCache(size int) *memoryCanvasResponseCache {
+	return &memoryCanvasResponseCache{
+		size:    size,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+func (c *memoryCanvasResponseCache) Get(key string) (*cachedCanvasResponse, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, nil
+	}
+
+	c.order.MoveToFront(el)
+	r := el.Value.(*memoryCanvasResponseCacheEntry).response
+	return &r, nil
+}
+
+func (c *memoryCanvasResponseCache) Set(key string, r cachedCanvasResponse) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*memoryCanvasResponseCacheEntry).response = r
+		c.order.MoveToFront(el)
+		return nil
+	}
+
+	c.entries[key] = c.order.PushFront(&memoryCanvasResponseCacheEntry{key: key, response: r})
+
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*memoryCanvasResponseCacheEntry).key)
+	}
+
+	return nil
+}
+
+// postgresCanvasResponseCache stores responses in the canvas_responses table, so they're shared between servers.
+type postgresCanvasResponseCache struct {
+	mutex    sync.Mutex
+	prunedAt time.Time
+}
+
+func (c *postgresCanvasResponseCache) Get(key string) (*cachedCanvasResponse, error) {
+	r, err := canvas_responses.Get(db, key)
+	if err != nil {
+		return nil, fmt.Errorf("error getting canvas response from db: %w", err)
+	}
+
+	if r == nil {
+		return nil, nil
+	}
+
+	return &cachedCanvasResponse{
+		ETag:         r.ETag,
+		LastModified: r.LastModified,
+		Link:         r.Link,
+		ContentType:  r.ContentType,
+		Body:         r.Body,
+		StoredAt:     r.StoredAt,
+	}, nil
+}
+
+func (c *postgresCanvasResponseCache) Set(key string, r cachedCanvasResponse) error {
+	err := canvas_responses.Upsert(db, &canvas_responses.CanvasResponse{
+		Key:          key,
+		ETag:         r.ETag,
+		LastModified: r.LastModified,
+		Link:         r.Link,
+		ContentType:  r.ContentType,
+		Body:         r.Body,
+		StoredAt:     r.StoredAt,
+	})
+	if err != nil {
+		return fmt.Errorf("error upserting canvas response: %w", err)
+	}
+
+	c.mutex.Lock()
+	shouldPrune := time.Since(c.prunedAt) > canvasResponseCachePruneInterval
+	if shouldPrune {
+		c.prunedAt = time.Now()
+	}
+	c.mutex.Unlock()
+
+	if shouldPrune {
+		err = canvas_responses.DeleteStoredBefore(db, time.Now().Add(-canvasResponseCacheMaxAge))
+		if err != nil {
+			return fmt.Errorf("error deleting old canvas responses: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,394 @@
+package gradesapi
+
+import (
+	"context"
+	"errors"
+	"github.com/iamtheyammer/canvascbl/backend/src/env"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/*
+Canvas rate limits each access token with a leaky bucket. Every request costs some of the
+bucket (sent back as X-Request-Cost), and what's left is sent back as X-Rate-Limit-Remaining.
+Once it's empty, Canvas responds with 403 Forbidden (Rate Limit Exceeded).
+
+https://canvas.instructure.com/doc/api/file.throttling.html
+*/
+const (
+	// canvasRateLimitBucketSize is the most a token's bucket can hold.
+	canvasRateLimitBucketSize = 700
+	// canvasRateLimitRefillPerSecond is about how much Canvas refills a token's bucket every second.
+	canvasRateLimitRefillPerSecond = 10
+	// canvasRateLimitLowWater is the estimated remaining budget under which requests are slowed down.
+	canvasRateLimitLowWater = 150
+	// canvasRateLimitMaxWait is the longest a request will be slowed down for.
+	canvasRateLimitMaxWait = time.Second * 15
+	// canvasRateLimitMaxConcurrent is the most requests a token can have in flight at once.
+	// Any more wait in line.
+	canvasRateLimitMaxConcurrent = 8
+	// canvasRateLimitBudgetTTL is how long a token's budget is kept after its last request.
+	canvasRateLimitBudgetTTL = time.Minute * 10
+
+	// canvasRetryMaxDelay is the longest to wait before retrying a request. If Canvas asks for
+	// longer with Retry-After, the request isn't retried.
+	canvasRetryMaxDelay = time.Second * 30
+)
+
+/*
+canvasRequestError is an error from a Canvas request, along with whether making the
+request again later might work.
+
+Its message is the wrapped error's, so it can be wrapped with %w like any other error.
+*/
+type canvasRequestError struct {
+	// StatusCode is Canvas's status code, or 0 if there wasn't a response
+	StatusCode int
+	// Attempts is how many times the request was made. It's 0 if unknown.
+	Attempts int
+	// Transient is whether the error might go away if the request is made again later,
+	// like a timeout, a 5xx or a rate limit.
+	Transient bool
+	// RetryAfter is how long Canvas asked to wait before trying again, or 0 if it didn't.
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *canvasRequestError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *canvasRequestError) Unwrap() error {
+	return e.Err
+}
+
+// canvasRateLimitBudget tracks a single token's rate limit.
+type canvasRateLimitBudget struct {
+	// slots has a value for every request in flight, so it queues requests past canvasRateLimitMaxConcurrent
+	slots chan struct{}
+
+	mutex sync.Mutex
+	// remaining is the last X-Rate-Limit-Remaining from Canvas. It's only valid if hasRemaining.
+	remaining    float64
+	hasRemaining bool
+	updatedAt    time.Time
+	// averageCost is a running average of X-Request-Cost
+	averageCost float64
+	inFlight    int
+	lastUsed    time.Time
+}
+
+var canvasRateLimits = struct {
+	sync.Mutex
+	// map[token]*canvasRateLimitBudget
+	Budgets  map[string]*canvasRateLimitBudget
+	PrunedAt time.Time
+}{
+	Budgets: map[string]*canvasRateLimitBudget{},
+}
+
+/*
+doCanvasRequest makes req with the shared httpClient, waiting first if rd's token is running
+low on its rate limit. Requests without a token aren't limited.
+
+GET requests with a stored token go through canvasResponses, if there is one (see doCachedCanvasRequest).
+
+GET requests that fail in a way that might be temporary are retried up to
+env.CanvasRequestMaxRetries times, with exponential backoff and jitter, respecting Retry-After.
+The last response is returned as-is, even if it isn't a 200. If there's no response,
+the error is a *canvasRequestError.
+
+Nothing is retried or waited for once req's context is done.
+
+It's the only way requests should be made to Canvas.
+*/
+func doCanvasRequest(req *http.Request, rd requestDetails) (*http.Response, error) {
+	if canvasResponses != nil && req.Method == http.MethodGet && rd.TokenID > 0 {
+		return doCachedCanvasRequest(canvasResponses, req, rd)
+	}
+
+	return doCanvasRequestWithRetries(req, rd)
+}
+
+// doCanvasRequestWithRetries makes req, retrying it if it's a GET request that failed temporarily.
+func doCanvasRequestWithRetries(req *http.Request, rd requestDetails) (*http.Response, error) {
+	maxRetries := 0
+	if req.Method == http.MethodGet {
+		maxRetries = env.CanvasRequestMaxRetries
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := doCanvasRequestOnce(req, rd)
+
+		var (
+			retry bool
+			delay time.Duration
+		)
+		if err != nil {
+			retry = isTransientNetworkError(err)
+			delay = canvasRetryBackoff(attempt)
+		} else if isRetryableCanvasResponse(resp) {
+			retry = true
+			delay = canvasRetryAfter(resp)
+			if delay < 1 {
+				delay = canvasRetryBackoff(attempt)
+			}
+		}
+
+		ctxErr := req.Context().Err()
+		if ctxErr != nil {
+			// the error was probably from the context, which won't go away by retrying
+			retry = false
+		}
+
+		if !retry || ctxErr != nil || attempt >= maxRetries || delay > canvasRetryMaxDelay {
+			if err != nil {
+				return nil, &canvasRequestError{
+					Attempts:  attempt + 1,
+					Transient: retry,
+					Err:       err,
+				}
+			}
+
+			return resp, nil
+		}
+
+		if resp != nil {
+			// drain the body so the connection can be reused
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-req.Context().Done():
+			t.Stop()
+		}
+	}
+}
+
+// doCanvasRequestOnce makes req once, within rd's rate limit.
+func doCanvasRequestOnce(req *http.Request, rd requestDetails) (*http.Response, error) {
+	if len(rd.Token) < 1 {
+		return httpClient.Do(req)
+	}
+
+	b := canvasRateLimitBudgetForToken(rd.Token)
+	err := b.acquire(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpClient.Do(req)
+	b.release(resp)
+
+	return resp, err
+}
+
+// canvasRateLimitBudgetForToken gets the budget for a token, creating it if it doesn't exist.
+func canvasRateLimitBudgetForToken(token string) *canvasRateLimitBudget {
+	canvasRateLimits.Lock()
+	defer canvasRateLimits.Unlock()
+
+	now := time.Now()
+	if now.Sub(canvasRateLimits.PrunedAt) > canvasRateLimitBudgetTTL {
+		for t, b := range canvasRateLimits.Budgets {
+			b.mutex.Lock()
+			if b.inFlight < 1 && now.Sub(b.lastUsed) > canvasRateLimitBudgetTTL {
+				delete(canvasRateLimits.Budgets, t)
+			}
+			b.mutex.Unlock()
+		}
+
+		canvasRateLimits.PrunedAt = now
+	}
+
+	b, ok := canvasRateLimits.Budgets[token]
+	if !ok {
+		b = &canvasRateLimitBudget{
+			slots:       make(chan struct{}, canvasRateLimitMaxConcurrent),
+			averageCost: 1,
+		}
+		canvasRateLimits.Budgets[token] = b
+	}
+
+	b.mutex.Lock()
+	b.lastUsed = now
+	b.mutex.Unlock()
+
+	return b
+}
+
+// acquire waits for a slot, then waits until the token has enough budget left.
+// If ctx is done first, it returns ctx's error without a slot.
+func (b *canvasRateLimitBudget) acquire(ctx context.Context) error {
+	select {
+	case b.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	b.mutex.Lock()
+	wait := b.wait(time.Now())
+	b.inFlight++
+	b.mutex.Unlock()
+
+	if wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			b.release(nil)
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// release records the rate limit headers from resp, which may be nil, and frees a slot.
+func (b *canvasRateLimitBudget) release(resp *http.Response) {
+	b.mutex.Lock()
+	b.inFlight--
+
+	if resp != nil {
+		if remaining, err := strconv.ParseFloat(resp.Header.Get("X-Rate-Limit-Remaining"), 64); err == nil {
+			b.remaining = remaining
+			b.hasRemaining = true
+			b.updatedAt = time.Now()
+		}
+
+		if cost, err := strconv.ParseFloat(resp.Header.Get("X-Request-Cost"), 64); err == nil {
+			b.averageCost = (b.averageCost*4 + cost) / 5
+		}
+	}
+	b.mutex.Unlock()
+
+	<-b.slots
+}
+
+/*
+wait returns how long a request should wait to keep the token over canvasRateLimitLowWater.
+b.mutex must be held.
+
+The remaining budget is estimated from the last one Canvas sent, plus what's been refilled since,
+minus what requests in flight will probably cost.
+*/
+func (b *canvasRateLimitBudget) wait(now time.Time) time.Duration {
+	if !b.hasRemaining {
+		return 0
+	}
+
+	refilled := now.Sub(b.updatedAt).Seconds() * canvasRateLimitRefillPerSecond
+	estimated := math.Min(canvasRateLimitBucketSize, b.remaining+refilled) - float64(b.inFlight)*b.averageCost
+	if estimated >= canvasRateLimitLowWater {
+		return 0
+	}
+
+	wait := time.Duration((canvasRateLimitLowWater - estimated) / canvasRateLimitRefillPerSecond * float64(time.Second))
+	if wait > canvasRateLimitMaxWait {
+		return canvasRateLimitMaxWait
+	}
+
+	return wait
+}
+
+// isCanvasRateLimitResponse is whether resp is Canvas saying that the token is out of budget.
+func isCanvasRateLimitResponse(resp *http.Response) bool {
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		return false
+	}
+
+	remaining, err := strconv.ParseFloat(resp.Header.Get("X-Rate-Limit-Remaining"), 64)
+	return err == nil && remaining <= 0
+}
+
+// isRetryableCanvasResponse is whether resp is an error from Canvas that might go away if the request is made again.
+func isRetryableCanvasResponse(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+
+	return isCanvasRateLimitResponse(resp)
+}
+
+// isTransientNetworkError is whether err, from httpClient.Do, is a timeout or dropped connection.
+func isTransientNetworkError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+// canvasRetryBackoff is how long to wait before retrying after attempt (starting at 0) failed:
+// env.CanvasRequestRetryBaseDelay doubled for every attempt, with half of it random.
+func canvasRetryBackoff(attempt int) time.Duration {
+	d := env.CanvasRequestRetryBaseDelay * time.Duration(1<<uint(attempt))
+	if d > canvasRetryMaxDelay || d <= 0 {
+		d = canvasRetryMaxDelay
+	}
+
+	half := int64(d / 2)
+	if half < 1 {
+		return d
+	}
+
+	return time.Duration(half + rand.Int63n(half))
+}
+
+// canvasRetryAfter parses resp's Retry-After header, which can be seconds or a date. It returns 0 if there isn't one.
+func canvasRetryAfter(resp *http.Response) time.Duration {
+	ra := resp.Header.Get("Retry-After")
+	if len(ra) < 1 {
+		return 0
+	}
+
+	if secs, err := strconv.Atoi(ra); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+
+	if at, err := http.ParseTime(ra); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
+
+// canvasResponseError wraps err, the error from a non-200 response from Canvas, with retry metadata from resp.
+func canvasResponseError(resp *http.Response, err error) error {
+	return &canvasRequestError{
+		StatusCode: resp.StatusCode,
+		Transient:  isRetryableCanvasResponse(resp),
+		RetryAfter: canvasRetryAfter(resp),
+		Err:        err,
+	}
+}
+
+// isTransientCanvasError is whether err came from a Canvas request that might work if it's made again later.
+func isTransientCanvasError(err error) bool {
+	var reqErr *canvasRequestError
+	return errors.As(err, &reqErr) && reqErr.Transient
+}
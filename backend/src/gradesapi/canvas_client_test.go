@@ -0,0 +1,428 @@
+package gradesapi
+
+import (
+	"context"
+	"errors"
+	"github.com/iamtheyammer/canvascbl/backend/src/env"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// responseWithHeaders makes a response with a status code and headers, for tests.
+func responseWithHeaders(statusCode int, headers map[string]string) *http.Response {
+	resp := &http.Response{StatusCode: statusCode, Header: http.Header{}}
+	for k, v := range headers {
+		resp.Header.Set(k, v)
+	}
+
+	return resp
+}
+
+func Test_canvasRetryBackoff(t *testing.T) {
+	baseDelay := env.CanvasRequestRetryBaseDelay
+	env.CanvasRequestRetryBaseDelay = 100 * time.Millisecond
+	defer func() { env.CanvasRequestRetryBaseDelay = baseDelay }()
+
+	tests := []struct {
+		name    string
+		attempt int
+		// the backoff is random in [min, max)
+		min, max time.Duration
+	}{
+		{name: "first", attempt: 0, min: 50 * time.Millisecond, max: 100 * time.Millisecond},
+		{name: "doubles", attempt: 1, min: 100 * time.Millisecond, max: 200 * time.Millisecond},
+		{name: "doubles_again", attempt: 3, min: 400 * time.Millisecond, max: 800 * time.Millisecond},
+		{name: "capped", attempt: 10, min: canvasRetryMaxDelay / 2, max: canvasRetryMaxDelay},
+		{name: "overflow_is_capped", attempt: 100, min: canvasRetryMaxDelay / 2, max: canvasRetryMaxDelay},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// it's random, so try it enough times to see most of the range
+			for i := 0; i < 100; i++ {
+				if got := canvasRetryBackoff(tt.attempt); got < tt.min || got >= tt.max {
+					t.Fatalf("canvasRetryBackoff() = %v, want in [%v, %v)", got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func Test_canvasRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter string
+		// the wait is in [min, max], since dates are compared to now
+		min, max time.Duration
+	}{
+		{name: "none", retryAfter: "", min: 0, max: 0},
+		{name: "seconds", retryAfter: "5", min: 5 * time.Second, max: 5 * time.Second},
+		{name: "zero_seconds", retryAfter: "0", min: 0, max: 0},
+		{name: "negative_seconds", retryAfter: "-5", min: 0, max: 0},
+		{name: "invalid", retryAfter: "soon", min: 0, max: 0},
+		{
+			name:       "date",
+			retryAfter: time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat),
+			// dates are only to the second
+			min: 8 * time.Second,
+			max: 10 * time.Second,
+		},
+		{
+			name:       "past_date",
+			retryAfter: time.Now().Add(-10 * time.Second).UTC().Format(http.TimeFormat),
+			min:        0,
+			max:        0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := responseWithHeaders(http.StatusTooManyRequests, map[string]string{"Retry-After": tt.retryAfter})
+			if got := canvasRetryAfter(resp); got < tt.min || got > tt.max {
+				t.Errorf("canvasRetryAfter() = %v, want in [%v, %v]", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func Test_isCanvasRateLimitResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		resp *http.Response
+		want bool
+	}{
+		{name: "nil", resp: nil, want: false},
+		{name: "ok", resp: responseWithHeaders(http.StatusOK, nil), want: false},
+		{
+			name: "rate_limited",
+			resp: responseWithHeaders(http.StatusForbidden, map[string]string{"X-Rate-Limit-Remaining": "0"}),
+			want: true,
+		},
+		{
+			name: "rate_limited_negative",
+			resp: responseWithHeaders(http.StatusForbidden, map[string]string{"X-Rate-Limit-Remaining": "-1.5"}),
+			want: true,
+		},
+		{
+			name: "forbidden_with_budget",
+			resp: responseWithHeaders(http.StatusForbidden, map[string]string{"X-Rate-Limit-Remaining": "600.25"}),
+			want: false,
+		},
+		{name: "forbidden", resp: responseWithHeaders(http.StatusForbidden, nil), want: false},
+		{
+			name: "ok_without_budget",
+			resp: responseWithHeaders(http.StatusOK, map[string]string{"X-Rate-Limit-Remaining": "0"}),
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isCanvasRateLimitResponse(tt.resp); got != tt.want {
+				t.Errorf("isCanvasRateLimitResponse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_canvasRateLimitBudget_wait(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		budget *canvasRateLimitBudget
+		want   time.Duration
+	}{
+		{
+			name:   "unknown",
+			budget: &canvasRateLimitBudget{averageCost: 1},
+			want:   0,
+		},
+		{
+			name:   "plenty",
+			budget: &canvasRateLimitBudget{remaining: 700, hasRemaining: true, updatedAt: now, averageCost: 1},
+			want:   0,
+		},
+		{
+			name:   "low",
+			budget: &canvasRateLimitBudget{remaining: 100, hasRemaining: true, updatedAt: now, averageCost: 1},
+			want:   5 * time.Second,
+		},
+		{
+			name: "partly_refilled",
+			budget: &canvasRateLimitBudget{
+				remaining:    100,
+				hasRemaining: true,
+				updatedAt:    now.Add(-2 * time.Second),
+				averageCost:  1,
+			},
+			want: 3 * time.Second,
+		},
+		{
+			name: "refilled",
+			budget: &canvasRateLimitBudget{
+				remaining:    100,
+				hasRemaining: true,
+				updatedAt:    now.Add(-5 * time.Second),
+				averageCost:  1,
+			},
+			want: 0,
+		},
+		{
+			name: "in_flight",
+			budget: &canvasRateLimitBudget{
+				remaining:    200,
+				hasRemaining: true,
+				updatedAt:    now,
+				averageCost:  10,
+				inFlight:     10,
+			},
+			want: 5 * time.Second,
+		},
+		{
+			name:   "exhausted",
+			budget: &canvasRateLimitBudget{remaining: 0, hasRemaining: true, updatedAt: now, averageCost: 1},
+			want:   canvasRateLimitMaxWait,
+		},
+		{
+			// the bucket can't hold more than canvasRateLimitBucketSize, however long it's been
+			name: "refill_is_capped",
+			budget: &canvasRateLimitBudget{
+				remaining:    0,
+				hasRemaining: true,
+				updatedAt:    now.Add(-time.Hour),
+				averageCost:  100,
+				inFlight:     6,
+			},
+			want: 5 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.budget.wait(now); got != tt.want {
+				t.Errorf("wait() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_canvasRateLimitBudget_acquireRelease(t *testing.T) {
+	newBudget := func() *canvasRateLimitBudget {
+		return &canvasRateLimitBudget{
+			slots:       make(chan struct{}, 2),
+			averageCost: 1,
+		}
+	}
+
+	t.Run("slots_exhausted", func(t *testing.T) {
+		b := newBudget()
+		for i := 0; i < 2; i++ {
+			if err := b.acquire(context.Background()); err != nil {
+				t.Fatalf("acquire() error = %v", err)
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := b.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("acquire() with no slots error = %v, want %v", err, context.DeadlineExceeded)
+		}
+
+		b.release(nil)
+		if err := b.acquire(context.Background()); err != nil {
+			t.Fatalf("acquire() after release error = %v", err)
+		}
+	})
+
+	t.Run("release_records_headers", func(t *testing.T) {
+		b := newBudget()
+		if err := b.acquire(context.Background()); err != nil {
+			t.Fatalf("acquire() error = %v", err)
+		}
+
+		b.release(responseWithHeaders(http.StatusOK, map[string]string{
+			"X-Rate-Limit-Remaining": "500.5",
+			"X-Request-Cost":         "6",
+		}))
+
+		if !b.hasRemaining || b.remaining != 500.5 {
+			t.Errorf("release() remaining = %v (has %v), want 500.5", b.remaining, b.hasRemaining)
+		}
+		if b.averageCost != 2 {
+			t.Errorf("release() averageCost = %v, want 2", b.averageCost)
+		}
+		if b.inFlight != 0 || len(b.slots) != 0 {
+			t.Errorf("release() left %d in flight and %d slots taken, want 0", b.inFlight, len(b.slots))
+		}
+	})
+
+	t.Run("budget_exhausted", func(t *testing.T) {
+		b := newBudget()
+		b.remaining = 0
+		b.hasRemaining = true
+		b.updatedAt = time.Now()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := b.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("acquire() with no budget error = %v, want %v", err, context.DeadlineExceeded)
+		}
+
+		// giving up gives the slot back
+		if b.inFlight != 0 || len(b.slots) != 0 {
+			t.Errorf("acquire() left %d in flight and %d slots taken, want 0", b.inFlight, len(b.slots))
+		}
+	})
+
+	t.Run("budget_recovered", func(t *testing.T) {
+		b := newBudget()
+		b.remaining = 0
+		b.hasRemaining = true
+		// long enough ago for the bucket to have refilled past canvasRateLimitLowWater
+		b.updatedAt = time.Now().Add(-(canvasRateLimitLowWater/canvasRateLimitRefillPerSecond + 1) * time.Second)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := b.acquire(ctx); err != nil {
+			t.Fatalf("acquire() after recovering error = %v", err)
+		}
+		b.release(nil)
+	})
+}
+
+func Test_doCanvasRequestWithRetries(t *testing.T) {
+	maxRetries, baseDelay := env.CanvasRequestMaxRetries, env.CanvasRequestRetryBaseDelay
+	env.CanvasRequestMaxRetries = 2
+	env.CanvasRequestRetryBaseDelay = time.Millisecond
+	defer func() {
+		env.CanvasRequestMaxRetries, env.CanvasRequestRetryBaseDelay = maxRetries, baseDelay
+	}()
+
+	type response struct {
+		statusCode int
+		headers    map[string]string
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		// responses are sent in order, and the last one is repeated
+		responses  []response
+		wantStatus int
+		wantCalls  int
+	}{
+		{
+			name:       "ok",
+			method:     http.MethodGet,
+			responses:  []response{{statusCode: http.StatusOK}},
+			wantStatus: http.StatusOK,
+			wantCalls:  1,
+		},
+		{
+			name:       "too_many_requests_then_ok",
+			method:     http.MethodGet,
+			responses:  []response{{statusCode: http.StatusTooManyRequests}, {statusCode: http.StatusOK}},
+			wantStatus: http.StatusOK,
+			wantCalls:  2,
+		},
+		{
+			name:   "rate_limited_then_ok",
+			method: http.MethodGet,
+			responses: []response{
+				{statusCode: http.StatusForbidden, headers: map[string]string{"X-Rate-Limit-Remaining": "0"}},
+				{statusCode: http.StatusOK},
+			},
+			wantStatus: http.StatusOK,
+			wantCalls:  2,
+		},
+		{
+			name:       "unavailable",
+			method:     http.MethodGet,
+			responses:  []response{{statusCode: http.StatusServiceUnavailable}},
+			wantStatus: http.StatusServiceUnavailable,
+			wantCalls:  3,
+		},
+		{
+			name:       "not_found",
+			method:     http.MethodGet,
+			responses:  []response{{statusCode: http.StatusNotFound}, {statusCode: http.StatusOK}},
+			wantStatus: http.StatusNotFound,
+			wantCalls:  1,
+		},
+		{
+			name:       "unauthorized",
+			method:     http.MethodGet,
+			responses:  []response{{statusCode: http.StatusUnauthorized}, {statusCode: http.StatusOK}},
+			wantStatus: http.StatusUnauthorized,
+			wantCalls:  1,
+		},
+		{
+			name:       "forbidden",
+			method:     http.MethodGet,
+			responses:  []response{{statusCode: http.StatusForbidden}, {statusCode: http.StatusOK}},
+			wantStatus: http.StatusForbidden,
+			wantCalls:  1,
+		},
+		{
+			name:       "post_isnt_retried",
+			method:     http.MethodPost,
+			responses:  []response{{statusCode: http.StatusServiceUnavailable}, {statusCode: http.StatusOK}},
+			wantStatus: http.StatusServiceUnavailable,
+			wantCalls:  1,
+		},
+		{
+			name:   "retry_after_too_long",
+			method: http.MethodGet,
+			responses: []response{
+				{statusCode: http.StatusTooManyRequests, headers: map[string]string{"Retry-After": "3600"}},
+				{statusCode: http.StatusOK},
+			},
+			wantStatus: http.StatusTooManyRequests,
+			wantCalls:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mutex sync.Mutex
+				calls int
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mutex.Lock()
+				resp := tt.responses[len(tt.responses)-1]
+				if calls < len(tt.responses) {
+					resp = tt.responses[calls]
+				}
+				calls++
+				mutex.Unlock()
+
+				for k, v := range resp.headers {
+					w.Header().Set(k, v)
+				}
+				w.WriteHeader(resp.statusCode)
+			}))
+			defer srv.Close()
+
+			req, err := http.NewRequest(tt.method, srv.URL, nil)
+			if err != nil {
+				t.Fatalf("error making request: %v", err)
+			}
+
+			resp, err := doCanvasRequestWithRetries(req, requestDetails{})
+			if err != nil {
+				t.Fatalf("doCanvasRequestWithRetries() error = %v", err)
+			}
+			_ = resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("doCanvasRequestWithRetries() status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if calls != tt.wantCalls {
+				t.Errorf("doCanvasRequestWithRetries() made %d calls, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,635 @@
+package gradesapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/iamtheyammer/canvascbl/backend/src/env"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// canvasGraphQLUnsupportedFor is how long a token that can't use GraphQL sticks to REST before trying again.
+	canvasGraphQLUnsupportedFor = time.Hour
+	// canvasGraphQLAssignmentsPerPage is how many assignments are in each page of the course work query.
+	canvasGraphQLAssignmentsPerPage = 50
+	// canvasGraphQLSubmissionsPerAssignment is the most submissions fetched for each assignment. If an assignment
+	// has more, the course is fetched over REST instead.
+	canvasGraphQLSubmissionsPerAssignment = 100
+)
+
+var (
+	// canvasErrorGraphQL is when Canvas responds to a GraphQL query with errors.
+	canvasErrorGraphQL = errors.New("canvas returned errors from a graphql query")
+	// canvasErrorGraphQLIncomplete is when a GraphQL query can't get everything REST would.
+	canvasErrorGraphQLIncomplete = errors.New("the graphql query didn't get all of the data")
+)
+
+/*
+canvasCourseWorkQuery gets a course's assignments, with their rubrics and (if $submissions is true)
+every submission the user can see, with rubric assessments.
+
+Field names and enums match REST, except that IDs are strings.
+*/
+var canvasCourseWorkQuery = `query CourseWork($courseID: ID!, $after: String, $submissions: Boolean!) {
+  course(id: $courseID) {
+    assignmentsConnection(first: ` + strconv.Itoa(canvasGraphQLAssignmentsPerPage) + `, after: $after) {
+      pageInfo { hasNextPage endCursor }
+      nodes {
+        _id
+        name
+        pointsPossible
+        dueAt
+        lockAt
+        unlockAt
+        createdAt
+        updatedAt
+        gradingType
+        position
+        published
+        state
+        htmlUrl
+        submissionTypes
+        omitFromFinalGrade
+        muted
+        assignmentGroup { _id }
+        rubric {
+          _id
+          title
+          pointsPossible
+          freeFormCriterionComments
+          criteria {
+            _id
+            points
+            criterionUseRange
+            ignoreForScoring
+            outcome { _id }
+            ratings { _id points }
+          }
+        }
+        submissionsConnection(first: ` + strconv.Itoa(canvasGraphQLSubmissionsPerAssignment) + `, filter: {states: [unsubmitted, submitted, pending_review, graded]}) @include(if: $submissions) {
+          pageInfo { hasNextPage }
+          nodes {
+            _id
+            userId
+            attempt
+            score
+            grade
+            enteredScore
+            enteredGrade
+            excused
+            late
+            missing
+            extraAttempts
+            deductedPoints
+            latePolicyStatus
+            gradeMatchesCurrentSubmission
+            gradingPeriodId
+            gradedAt
+            postedAt
+            submittedAt
+            submissionType
+            state
+            url
+            attachments { _id displayName contentType mimeClass size url createdAt updatedAt }
+            rubricAssessmentsConnection {
+              nodes {
+                assessmentRatings { _id points comments criterion { _id } }
+              }
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+
+type canvasGraphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+type canvasGraphQLError struct {
+	Message string `json:"message"`
+}
+
+type canvasGraphQLPageInfo struct {
+	HasNextPage bool   `json:"hasNextPage"`
+	EndCursor   string `json:"endCursor"`
+}
+
+// POST /api/graphql with canvasCourseWorkQuery
+type canvasGraphQLCourseWorkResponse struct {
+	Data struct {
+		// Course is nil if the user can't see the course
+		Course *struct {
+			AssignmentsConnection struct {
+				PageInfo canvasGraphQLPageInfo     `json:"pageInfo"`
+				Nodes    []canvasGraphQLAssignment `json:"nodes"`
+			} `json:"assignmentsConnection"`
+		} `json:"course"`
+	} `json:"data"`
+	Errors []canvasGraphQLError `json:"errors"`
+}
+
+type canvasGraphQLAssignment struct {
+	ID                 string   `json:"_id"`
+	Name               string   `json:"name"`
+	PointsPossible     float64  `json:"pointsPossible"`
+	DueAt              string   `json:"dueAt"`
+	LockAt             string   `json:"lockAt"`
+	UnlockAt           string   `json:"unlockAt"`
+	CreatedAt          string   `json:"createdAt"`
+	UpdatedAt          string   `json:"updatedAt"`
+	GradingType        string   `json:"gradingType"`
+	Position           int64    `json:"position"`
+	Published          bool     `json:"published"`
+	State              string   `json:"state"`
+	HTMLURL            string   `json:"htmlUrl"`
+	SubmissionTypes    []string `json:"submissionTypes"`
+	OmitFromFinalGrade bool     `json:"omitFromFinalGrade"`
+	Muted              bool     `json:"muted"`
+	AssignmentGroup    *struct {
+		ID string `json:"_id"`
+	} `json:"assignmentGroup"`
+	Rubric *struct {
+		ID                        string  `json:"_id"`
+		Title                     string  `json:"title"`
+		PointsPossible            float64 `json:"pointsPossible"`
+		FreeFormCriterionComments bool    `json:"freeFormCriterionComments"`
+		Criteria                  []struct {
+			ID                string  `json:"_id"`
+			Points            float64 `json:"points"`
+			CriterionUseRange bool    `json:"criterionUseRange"`
+			IgnoreForScoring  bool    `json:"ignoreForScoring"`
+			Outcome           *struct {
+				ID string `json:"_id"`
+			} `json:"outcome"`
+			Ratings []struct {
+				ID     string  `json:"_id"`
+				Points float64 `json:"points"`
+			} `json:"ratings"`
+		} `json:"criteria"`
+	} `json:"rubric"`
+	SubmissionsConnection struct {
+		PageInfo canvasGraphQLPageInfo     `json:"pageInfo"`
+		Nodes    []canvasGraphQLSubmission `json:"nodes"`
+	} `json:"submissionsConnection"`
+}
+
+type canvasGraphQLSubmission struct {
+	ID                            string    `json:"_id"`
+	UserID                        string    `json:"userId"`
+	Attempt                       uint64    `json:"attempt"`
+	Score                         float64   `json:"score"`
+	Grade                         string    `json:"grade"`
+	EnteredScore                  float64   `json:"enteredScore"`
+	EnteredGrade                  string    `json:"enteredGrade"`
+	Excused                       bool      `json:"excused"`
+	Late                          bool      `json:"late"`
+	Missing                       bool      `json:"missing"`
+	ExtraAttempts                 uint64    `json:"extraAttempts"`
+	DeductedPoints                float64   `json:"deductedPoints"`
+	LatePolicyStatus              string    `json:"latePolicyStatus"`
+	GradeMatchesCurrentSubmission bool      `json:"gradeMatchesCurrentSubmission"`
+	GradingPeriodID               string    `json:"gradingPeriodId"`
+	GradedAt                      time.Time `json:"gradedAt"`
+	PostedAt                      time.Time `json:"postedAt"`
+	SubmittedAt                   time.Time `json:"submittedAt"`
+	SubmissionType                string    `json:"submissionType"`
+	State                         string    `json:"state"`
+	URL                           string    `json:"url"`
+	Attachments                   []struct {
+		ID          string    `json:"_id"`
+		DisplayName string    `json:"displayName"`
+		ContentType string    `json:"contentType"`
+		MimeClass   string    `json:"mimeClass"`
+		Size        uint64    `json:"size"`
+		URL         string    `json:"url"`
+		CreatedAt   time.Time `json:"createdAt"`
+		UpdatedAt   time.Time `json:"updatedAt"`
+	} `json:"attachments"`
+	RubricAssessmentsConnection *struct {
+		Nodes []struct {
+			AssessmentRatings []struct {
+				ID        string  `json:"_id"`
+				Points    float64 `json:"points"`
+				Comments  string  `json:"comments"`
+				Criterion *struct {
+					ID string `json:"_id"`
+				} `json:"criterion"`
+			} `json:"assessmentRatings"`
+		} `json:"nodes"`
+	} `json:"rubricAssessmentsConnection"`
+}
+
+// canvasGraphQLUnsupported holds tokens that can't use GraphQL, so they go straight to REST.
+var canvasGraphQLUnsupported = struct {
+	sync.Mutex
+	// map[tokenID]until
+	Tokens map[uint64]time.Time
+}{
+	Tokens: map[uint64]time.Time{},
+}
+
+// canUseCanvasGraphQL is whether GraphQL should be tried with rd's token.
+func canUseCanvasGraphQL(rd requestDetails) bool {
+	if !env.CanvasGraphQL || len(rd.Token) < 1 {
+		return false
+	}
+
+	canvasGraphQLUnsupported.Lock()
+	defer canvasGraphQLUnsupported.Unlock()
+
+	until, ok := canvasGraphQLUnsupported.Tokens[rd.TokenID]
+	if !ok {
+		return true
+	}
+
+	if time.Now().After(until) {
+		delete(canvasGraphQLUnsupported.Tokens, rd.TokenID)
+		return true
+	}
+
+	return false
+}
+
+// markCanvasGraphQLUnsupported sends rd's token to REST for canvasGraphQLUnsupportedFor.
+func markCanvasGraphQLUnsupported(rd requestDetails) {
+	canvasGraphQLUnsupported.Lock()
+	canvasGraphQLUnsupported.Tokens[rd.TokenID] = time.Now().Add(canvasGraphQLUnsupportedFor)
+	canvasGraphQLUnsupported.Unlock()
+}
+
+// isCanvasGraphQLUnsupportedError is whether err means the token (or the Canvas instance) can't use GraphQL at all.
+func isCanvasGraphQLUnsupportedError(err error) bool {
+	var reqErr *canvasRequestError
+	return errors.Is(err, canvasErrorInsufficientScopesOnAccessTokenError) ||
+		errors.Is(err, canvasErrorGraphQL) ||
+		(errors.As(err, &reqErr) && reqErr.StatusCode == http.StatusNotFound)
+}
+
+// canFallBackFromCanvasGraphQL is whether REST might work after GraphQL failed with err.
+// Errors that REST would get too, like an invalid token, are returned instead.
+func canFallBackFromCanvasGraphQL(err error) bool {
+	return !errors.Is(err, canvasErrorInvalidAccessTokenError) &&
+		!errors.Is(err, canvasErrorRateLimitExceededError) &&
+		!errors.Is(err, context.Canceled) &&
+		!errors.Is(err, context.DeadlineExceeded)
+}
+
+type getCanvasCourseWorkRequest struct {
+	courseID string
+	// studentIDs is for REST. GraphQL gets every submission the user can see, which is the same as "all"
+	// for observers and teachers and just the user for students.
+	studentIDs  []string
+	assignments bool
+	submissions bool
+}
+
+// canvasCourseWork is a course's assignments and submissions.
+type canvasCourseWork struct {
+	Assignments canvasAssignmentsResponse
+	Submissions canvasSubmissionsResponse
+}
+
+/*
+getCanvasCourseWork gets a course's assignments and/or submissions (with rubric assessments).
+
+If env.CanvasGraphQL is set and the token can use it, they're fetched with a single paginated GraphQL query.
+Otherwise, or if GraphQL fails in a way REST might not, they're fetched over REST.
+Tokens that can't use GraphQL aren't tried again for canvasGraphQLUnsupportedFor.
+*/
+func getCanvasCourseWork(rd requestDetails, req *getCanvasCourseWorkRequest) (*canvasCourseWork, error) {
+	if canUseCanvasGraphQL(rd) {
+		work, err := getCanvasCourseWorkGraphQL(rd, req)
+		if err == nil {
+			return work, nil
+		}
+
+		if !canFallBackFromCanvasGraphQL(err) {
+			return nil, err
+		}
+
+		if isCanvasGraphQLUnsupportedError(err) {
+			markCanvasGraphQLUnsupported(rd)
+		}
+	}
+
+	return getCanvasCourseWorkREST(rd, req)
+}
+
+// getCanvasCourseWorkREST gets course work with a request each for assignments and submissions, at the same time.
+func getCanvasCourseWorkREST(rd requestDetails, req *getCanvasCourseWorkRequest) (*canvasCourseWork, error) {
+	var (
+		work = canvasCourseWork{}
+		wg   = sync.WaitGroup{}
+		aErr error
+		sErr error
+	)
+
+	if req.assignments {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			as, err := getCanvasCourseAssignments(rd, req.courseID, []string{})
+			if err != nil {
+				aErr = err
+				return
+			}
+
+			work.Assignments = *as
+		}()
+	}
+
+	if req.submissions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ss, err := getCanvasCourseSubmissions(rd, &getCanvasCourseSubmissionsRequest{
+				courseID:   req.courseID,
+				studentIDs: req.studentIDs,
+			})
+			if err != nil {
+				sErr = err
+				return
+			}
+
+			work.Submissions = *ss
+		}()
+	}
+
+	wg.Wait()
+
+	if aErr != nil {
+		return nil, aErr
+	}
+
+	if sErr != nil {
+		return nil, sErr
+	}
+
+	return &work, nil
+}
+
+// getCanvasCourseWorkGraphQL gets course work with canvasCourseWorkQuery.
+func getCanvasCourseWorkGraphQL(rd requestDetails, req *getCanvasCourseWorkRequest) (*canvasCourseWork, error) {
+	var (
+		work  = canvasCourseWork{}
+		after interface{}
+	)
+
+	for {
+		body, err := json.Marshal(canvasGraphQLRequest{
+			Query: canvasCourseWorkQuery,
+			Variables: map[string]interface{}{
+				"courseID":    req.courseID,
+				"after":       after,
+				"submissions": req.submissions,
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling canvas graphql course work request: %w", err)
+		}
+
+		var resp canvasGraphQLCourseWorkResponse
+		_, err = makeCanvasPostRequest("api/graphql", bytes.NewReader(body), rd, &resp)
+		if err != nil {
+			return nil, fmt.Errorf("error getting canvas course work for course %s over graphql: %w", req.courseID, err)
+		}
+
+		if len(resp.Errors) > 0 {
+			return nil, fmt.Errorf(
+				"error getting canvas course work for course %s over graphql (%s): %w",
+				req.courseID,
+				resp.Errors[0].Message,
+				canvasErrorGraphQL,
+			)
+		}
+
+		if resp.Data.Course == nil {
+			return nil, fmt.Errorf("error getting canvas course work for course %s over graphql: course is null: %w",
+				req.courseID, canvasErrorGraphQLIncomplete)
+		}
+
+		conn := resp.Data.Course.AssignmentsConnection
+		for _, a := range conn.Nodes {
+			if req.submissions && a.SubmissionsConnection.PageInfo.HasNextPage {
+				return nil, fmt.Errorf(
+					"error getting canvas course work for course %s over graphql: assignment %s has over %d submissions: %w",
+					req.courseID,
+					a.ID,
+					canvasGraphQLSubmissionsPerAssignment,
+					canvasErrorGraphQLIncomplete,
+				)
+			}
+
+			ca, err := a.toCanvasAssignment(req.courseID)
+			if err != nil {
+				return nil, err
+			}
+
+			if req.assignments {
+				work.Assignments = append(work.Assignments, *ca)
+			}
+
+			for _, s := range a.SubmissionsConnection.Nodes {
+				cs, err := s.toCanvasSubmission(ca.ID)
+				if err != nil {
+					return nil, err
+				}
+
+				work.Submissions = append(work.Submissions, *cs)
+			}
+		}
+
+		if !conn.PageInfo.HasNextPage {
+			return &work, nil
+		}
+
+		after = conn.PageInfo.EndCursor
+	}
+}
+
+// parseCanvasGraphQLID parses a legacy ID (_id) from GraphQL. An empty ID is 0.
+func parseCanvasGraphQLID(id string) (uint64, error) {
+	if len(id) < 1 {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing canvas graphql id %s: %w", id, err)
+	}
+
+	return n, nil
+}
+
+// toCanvasAssignment maps a GraphQL assignment into the REST type.
+func (a canvasGraphQLAssignment) toCanvasAssignment(courseID string) (*canvasAssignment, error) {
+	id, err := parseCanvasGraphQLID(a.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	cID, err := strconv.ParseInt(courseID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing course id %s: %w", courseID, err)
+	}
+
+	ca := canvasAssignment{
+		ID:                 id,
+		CourseID:           cID,
+		Name:               a.Name,
+		PointsPossible:     a.PointsPossible,
+		DueAt:              a.DueAt,
+		LockAt:             a.LockAt,
+		UnlockAt:           a.UnlockAt,
+		CreatedAt:          a.CreatedAt,
+		UpdatedAt:          a.UpdatedAt,
+		GradingType:        a.GradingType,
+		Position:           a.Position,
+		Published:          a.Published,
+		WorkflowState:      a.State,
+		HTMLURL:            a.HTMLURL,
+		SubmissionTypes:    a.SubmissionTypes,
+		OmitFromFinalGrade: a.OmitFromFinalGrade,
+		Muted:              a.Muted,
+	}
+
+	if a.AssignmentGroup != nil {
+		gID, err := parseCanvasGraphQLID(a.AssignmentGroup.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		ca.AssignmentGroupID = int64(gID)
+	}
+
+	if a.Rubric != nil {
+		rID, err := parseCanvasGraphQLID(a.Rubric.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		ca.RubricSettings.ID = int64(rID)
+		ca.RubricSettings.Title = a.Rubric.Title
+		ca.RubricSettings.PointsPossible = a.Rubric.PointsPossible
+		ca.RubricSettings.FreeFormCriterionComments = a.Rubric.FreeFormCriterionComments
+		ca.FreeFormCriterionComments = a.Rubric.FreeFormCriterionComments
+
+		for _, c := range a.Rubric.Criteria {
+			criterion := canvasRubricCriterion{
+				ID:                c.ID,
+				Points:            c.Points,
+				CriterionUseRange: c.CriterionUseRange,
+				IgnoreForScoring:  c.IgnoreForScoring,
+			}
+
+			if c.Outcome != nil {
+				oID, err := parseCanvasGraphQLID(c.Outcome.ID)
+				if err != nil {
+					return nil, err
+				}
+
+				criterion.OutcomeID = int64(oID)
+			}
+
+			for _, r := range c.Ratings {
+				criterion.Ratings = append(criterion.Ratings, canvasRubricRating{ID: r.ID, Points: r.Points})
+			}
+
+			ca.Rubric = append(ca.Rubric, criterion)
+		}
+	}
+
+	return &ca, nil
+}
+
+// toCanvasSubmission maps a GraphQL submission into the REST type.
+func (s canvasGraphQLSubmission) toCanvasSubmission(assignmentID uint64) (*canvasSubmission, error) {
+	id, err := parseCanvasGraphQLID(s.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, err := parseCanvasGraphQLID(s.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	gradingPeriodID, err := parseCanvasGraphQLID(s.GradingPeriodID)
+	if err != nil {
+		return nil, err
+	}
+
+	cs := canvasSubmission{
+		ID:                            id,
+		AssignmentID:                  assignmentID,
+		UserID:                        userID,
+		Attempt:                       s.Attempt,
+		Score:                         s.Score,
+		Grade:                         s.Grade,
+		EnteredScore:                  s.EnteredScore,
+		EnteredGrade:                  s.EnteredGrade,
+		Excused:                       s.Excused,
+		Late:                          s.Late,
+		Missing:                       s.Missing,
+		ExtraAttempts:                 s.ExtraAttempts,
+		PointsDeducted:                s.DeductedPoints,
+		LatePolicyStatus:              s.LatePolicyStatus,
+		GradeMatchesCurrentSubmission: s.GradeMatchesCurrentSubmission,
+		GradingPeriodID:               gradingPeriodID,
+		GradedAt:                      s.GradedAt,
+		PostedAt:                      s.PostedAt,
+		SubmittedAt:                   s.SubmittedAt,
+		SubmissionType:                s.SubmissionType,
+		WorkflowState:                 s.State,
+		URL:                           s.URL,
+	}
+
+	for _, a := range s.Attachments {
+		aID, err := parseCanvasGraphQLID(a.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		cs.Attachments = append(cs.Attachments, canvasSubmissionAttachment{
+			ID:          aID,
+			DisplayName: a.DisplayName,
+			ContentType: a.ContentType,
+			MimeClass:   a.MimeClass,
+			Size:        a.Size,
+			URL:         a.URL,
+			CreatedAt:   a.CreatedAt,
+			UpdatedAt:   a.UpdatedAt,
+		})
+	}
+
+	if s.RubricAssessmentsConnection != nil && len(s.RubricAssessmentsConnection.Nodes) > 0 {
+		// like REST, only the latest assessment is included
+		ratings := s.RubricAssessmentsConnection.Nodes[len(s.RubricAssessmentsConnection.Nodes)-1].AssessmentRatings
+
+		cs.RubricAssessment = make(map[string]canvasRubricAssessmentRating, len(ratings))
+		for _, r := range ratings {
+			if r.Criterion == nil {
+				continue
+			}
+
+			cs.RubricAssessment[r.Criterion.ID] = canvasRubricAssessmentRating{
+				RatingID: r.ID,
+				Points:   r.Points,
+				Comments: r.Comments,
+			}
+		}
+	}
+
+	return &cs, nil
+}
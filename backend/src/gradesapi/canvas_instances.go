@@ -0,0 +1,136 @@
+package gradesapi
+
+import (
+	"errors"
+	"fmt"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/institutions"
+	"github.com/iamtheyammer/canvascbl/backend/src/env"
+	"strings"
+	"sync"
+	"time"
+)
+
+// canvasInstancesValidFor is how long institutions are memoized for.
+const canvasInstancesValidFor = time.Minute * 5
+
+var canvasErrorUnknownInstitution = errors.New("the institution doesn't exist")
+
+// canvasInstance is a Canvas that requests can be made to, with the developer key CanvasCBL uses there.
+type canvasInstance struct {
+	// InstitutionID is 0 for the default instance
+	InstitutionID uint64
+	// Scheme is https, except for a fake Canvas
+	Scheme             string
+	Domain             string
+	OAuth2ClientID     string
+	OAuth2ClientSecret string
+	// CurrentEnrollmentTermID is 0 if the institution doesn't set one
+	CurrentEnrollmentTermID int
+}
+
+/*
+defaultCanvasInstance is the Canvas set with environment variables. It's used for institution ID 0,
+which is every user, token and course from before institutions existed.
+
+If env.CanvasFakeFixtures is set in development, it's the fake Canvas at env.CanvasFakeAddr.
+*/
+var defaultCanvasInstance = func() canvasInstance {
+	i := canvasInstance{
+		Scheme:                  "https",
+		Domain:                  env.CanvasDomain,
+		OAuth2ClientID:          env.CanvasOAuth2ClientID,
+		OAuth2ClientSecret:      env.CanvasOAuth2ClientSecret,
+		CurrentEnrollmentTermID: env.CanvasCurrentEnrollmentTermID,
+	}
+
+	if env.Env == env.EnvironmentDevelopment && len(env.CanvasFakeFixtures) > 0 {
+		i.Scheme = "http"
+		i.Domain = env.CanvasFakeAddr
+	}
+
+	return i
+}()
+
+var memoizedCanvasInstances = struct {
+	sync.Mutex
+	// map[institutionID]canvasInstance
+	Instances  map[uint64]canvasInstance
+	ValidUntil time.Time
+}{}
+
+// getCanvasInstances gets every institution's Canvas instance, not including the default one.
+func getCanvasInstances() (map[uint64]canvasInstance, error) {
+	memoizedCanvasInstances.Lock()
+	defer memoizedCanvasInstances.Unlock()
+
+	if memoizedCanvasInstances.ValidUntil.After(time.Now()) {
+		return memoizedCanvasInstances.Instances, nil
+	}
+
+	is, err := institutions.List(db, &institutions.ListRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing institutions: %w", err)
+	}
+
+	instances := make(map[uint64]canvasInstance, len(*is))
+	for _, i := range *is {
+		instances[i.ID] = canvasInstance{
+			InstitutionID:           i.ID,
+			Scheme:                  "https",
+			Domain:                  i.CanvasDomain,
+			OAuth2ClientID:          i.CanvasOAuth2ClientID,
+			OAuth2ClientSecret:      i.CanvasOAuth2ClientSecret,
+			CurrentEnrollmentTermID: int(i.CurrentEnrollmentTermID),
+		}
+	}
+
+	memoizedCanvasInstances.Instances = instances
+	memoizedCanvasInstances.ValidUntil = time.Now().Add(canvasInstancesValidFor)
+
+	return instances, nil
+}
+
+// canvasInstanceForInstitution gets the Canvas instance for an institution. ID 0 is the default instance.
+func canvasInstanceForInstitution(institutionID uint64) (canvasInstance, error) {
+	if institutionID < 1 {
+		return defaultCanvasInstance, nil
+	}
+
+	instances, err := getCanvasInstances()
+	if err != nil {
+		return canvasInstance{}, err
+	}
+
+	i, ok := instances[institutionID]
+	if !ok {
+		return canvasInstance{}, fmt.Errorf("error finding institution id %d: %w", institutionID, canvasErrorUnknownInstitution)
+	}
+
+	return i, nil
+}
+
+// canvasInstanceForDomain gets the Canvas instance running on domain. The default instance's domain works too.
+func canvasInstanceForDomain(domain string) (canvasInstance, error) {
+	domain = strings.ToLower(domain)
+	if domain == strings.ToLower(defaultCanvasInstance.Domain) {
+		return defaultCanvasInstance, nil
+	}
+
+	instances, err := getCanvasInstances()
+	if err != nil {
+		return canvasInstance{}, err
+	}
+
+	for _, i := range instances {
+		if strings.ToLower(i.Domain) == domain {
+			return i, nil
+		}
+	}
+
+	return canvasInstance{}, fmt.Errorf("error finding institution with domain %s: %w", domain, canvasErrorUnknownInstitution)
+}
+
+// baseURL is the URL requests to the instance start with, including the trailing slash.
+func (i canvasInstance) baseURL() string {
+	return i.Scheme + "://" + i.Domain + "/"
+}
@@ -1,6 +1,7 @@
 package gradesapi
 
 import (
+	"errors"
 	"fmt"
 	"github.com/iamtheyammer/canvascbl/backend/src/db/services/sessions"
 	"github.com/iamtheyammer/canvascbl/backend/src/db/services/users"
@@ -11,6 +12,7 @@ import (
 	uuid "github.com/satori/go.uuid"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -29,6 +31,8 @@ type canvasState struct {
 	Intent string
 	State  string
 	Dest   string
+	// Institution is the ID of the institution the user is logging in at. 0 is the default instance.
+	Institution uint64
 }
 
 func (st canvasState) String() string {
@@ -38,6 +42,9 @@ func (st canvasState) String() string {
 	if len(st.Dest) > 0 {
 		s += "dest=" + st.Dest + ";"
 	}
+	if st.Institution > 0 {
+		s += "institution=" + strconv.FormatUint(st.Institution, 10) + ";"
+	}
 	return s
 }
 
@@ -53,6 +60,11 @@ func unmarshalCanvasState(st string) canvasState {
 			s.Intent = kv[1]
 		case "dest":
 			s.Dest = kv[1]
+		case "institution":
+			id, err := strconv.ParseUint(kv[1], 10, 64)
+			if err == nil {
+				s.Institution = id
+			}
 		}
 	}
 
@@ -70,11 +82,11 @@ func destIsValid(dest string) bool {
 	return true
 }
 
-func getCanvasOAuth2AuthURI(intent string, dest string) string {
+func getCanvasOAuth2AuthURI(inst canvasInstance, intent string, dest string) string {
 	redirectURL := url.URL{
-		Host:   env.CanvasDomain,
+		Host:   inst.Domain,
 		Path:   "/login/oauth2/auth",
-		Scheme: "https",
+		Scheme: inst.Scheme,
 	}
 
 	purpose := "CanvasCBL"
@@ -86,8 +98,9 @@ func getCanvasOAuth2AuthURI(intent string, dest string) string {
 	}
 
 	state := canvasState{
-		Intent: intent,
-		State:  uuid.NewV4().String(),
+		Intent:      intent,
+		State:       uuid.NewV4().String(),
+		Institution: inst.InstitutionID,
 	}
 
 	if len(dest) > 0 && destIsValid(dest) {
@@ -95,7 +108,7 @@ func getCanvasOAuth2AuthURI(intent string, dest string) string {
 	}
 
 	q := redirectURL.Query()
-	q.Set("client_id", env.CanvasOAuth2ClientID)
+	q.Set("client_id", inst.OAuth2ClientID)
 	q.Set("response_type", "code")
 	q.Set("purpose", purpose)
 	q.Set("redirect_uri", env.BaseURL+"/api/canvas/oauth2/response")
@@ -119,19 +132,51 @@ func getCanvasOAuth2SuccessURI(name string, intent string, dest string) string {
 }
 
 // CanvasOAuth2RequestHandler handles forwarding the user to the proper URI for OAuth2 with Canvas.
+// The Canvas instance can be picked with the institution (id) or canvas_domain query params.
 func CanvasOAuth2RequestHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	intent := r.URL.Query().Get("intent")
 	dest := r.URL.Query().Get("dest")
+
+	inst, err := canvasInstanceFromQuery(r.URL.Query())
+	if err != nil {
+		if errors.Is(err, canvasErrorUnknownInstitution) {
+			util.SendBadRequest(w, "unknown institution or canvas_domain as query param")
+			return
+		}
+
+		handleISE(w, fmt.Errorf("error getting canvas instance in canvas oauth2 request handler: %w", err))
+		return
+	}
+
 	switch intent {
 	case "auth":
-		util.SendRedirect(w, getCanvasOAuth2AuthURI(intent, dest))
+		util.SendRedirect(w, getCanvasOAuth2AuthURI(inst, intent, dest))
 	case "reauth":
-		util.SendRedirect(w, getCanvasOAuth2AuthURI(intent, dest))
+		util.SendRedirect(w, getCanvasOAuth2AuthURI(inst, intent, dest))
 	default:
-		util.SendRedirect(w, getCanvasOAuth2AuthURI("auth", dest))
+		util.SendRedirect(w, getCanvasOAuth2AuthURI(inst, "auth", dest))
 	}
 }
 
+// canvasInstanceFromQuery gets the Canvas instance from the institution or canvas_domain query params,
+// or the default instance if neither is there.
+func canvasInstanceFromQuery(q url.Values) (canvasInstance, error) {
+	if id := q.Get("institution"); len(id) > 0 {
+		institutionID, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			return canvasInstance{}, fmt.Errorf("error parsing institution %s: %w", id, canvasErrorUnknownInstitution)
+		}
+
+		return canvasInstanceForInstitution(institutionID)
+	}
+
+	if domain := q.Get("canvas_domain"); len(domain) > 0 {
+		return canvasInstanceForDomain(domain)
+	}
+
+	return defaultCanvasInstance, nil
+}
+
 // CanvasOAuth2ResponseHandler handles the token grant from Canvas.
 func CanvasOAuth2ResponseHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	code := r.URL.Query().Get("code")
@@ -155,14 +200,23 @@ func CanvasOAuth2ResponseHandler(w http.ResponseWriter, r *http.Request, _ httpr
 		return
 	}
 
-	grantResp, err := getTokenFromAuthorizationCode(code)
+	inst, err := canvasInstanceForInstitution(state.Institution)
+	if err != nil {
+		handleISE(w, fmt.Errorf("error getting canvas instance in canvas oauth2 response handler: %w", err))
+		return
+	}
+
+	grantResp, err := getTokenFromAuthorizationCode(inst, code)
 	if err != nil {
 		handleISE(w, fmt.Errorf("error getting token from authorization code: %w", err))
 		return
 	}
 
 	if state.Intent == "reauth" {
-		profiles, err := users.List(db, &users.ListRequest{CanvasUserID: grantResp.User.ID})
+		profiles, err := users.List(db, &users.ListRequest{
+			CanvasUserID:  grantResp.User.ID,
+			InstitutionID: &inst.InstitutionID,
+		})
 		if err != nil {
 			handleISE(w, fmt.Errorf("error listing users in canvas oauth2 response handler (reauth): %w", err))
 			return
@@ -170,13 +224,14 @@ func CanvasOAuth2ResponseHandler(w http.ResponseWriter, r *http.Request, _ httpr
 
 		if len(*profiles) < 1 {
 			// this user is trying to reauth without authing first?
-			util.SendRedirect(w, getCanvasOAuth2AuthURI("auth", ""))
+			util.SendRedirect(w, getCanvasOAuth2AuthURI(inst, "auth", ""))
 			return
 		}
 
 		// this user just wants a new session
 		ss, err := sessions.Generate(db, &sessions.GenerateRequest{
-			CanvasUserID: grantResp.User.ID,
+			CanvasUserID:  grantResp.User.ID,
+			InstitutionID: inst.InstitutionID,
 		})
 		if err != nil {
 			handleISE(w, fmt.Errorf("error generating session in canvas oauth2 response handler (reauth): %w", err))
@@ -189,8 +244,9 @@ func CanvasOAuth2ResponseHandler(w http.ResponseWriter, r *http.Request, _ httpr
 	}
 
 	rd := requestDetails{
-		Token:        grantResp.AccessToken,
-		RefreshToken: grantResp.RefreshToken,
+		Token:         grantResp.AccessToken,
+		RefreshToken:  grantResp.RefreshToken,
+		InstitutionID: inst.InstitutionID,
 	}
 
 	profile, err := getCanvasProfile(rd, "self")
@@ -201,10 +257,11 @@ func CanvasOAuth2ResponseHandler(w http.ResponseWriter, r *http.Request, _ httpr
 
 	// doing this synchronously so that we can generate a session
 	profileResp, err := users.UpsertProfile(db, &users.UpsertRequest{
-		Name:         profile.Name,
-		Email:        profile.PrimaryEmail,
-		LTIUserID:    profile.LtiUserID,
-		CanvasUserID: int64(profile.ID),
+		Name:          profile.Name,
+		Email:         profile.PrimaryEmail,
+		LTIUserID:     profile.LtiUserID,
+		CanvasUserID:  int64(profile.ID),
+		InstitutionID: inst.InstitutionID,
 	}, true)
 	if err != nil {
 		handleISE(w, fmt.Errorf("error upserting profile in canvas oauth2 response handler: %w", err))
@@ -216,9 +273,12 @@ func CanvasOAuth2ResponseHandler(w http.ResponseWriter, r *http.Request, _ httpr
 	}
 
 	// this one can be done async
-	go saveCanvasOAuth2GrantToDB(grantResp)
+	go saveCanvasOAuth2GrantToDB(grantResp, inst.InstitutionID)
 
-	ss, err := sessions.Generate(db, &sessions.GenerateRequest{CanvasUserID: profile.ID})
+	ss, err := sessions.Generate(db, &sessions.GenerateRequest{
+		CanvasUserID:  profile.ID,
+		InstitutionID: inst.InstitutionID,
+	})
 	if err != nil {
 		handleISE(w, fmt.Errorf("error generating session in canvas oauth2 response handler: %w", err))
 		return
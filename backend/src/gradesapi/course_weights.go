@@ -0,0 +1,56 @@
+package gradesapi
+
+import (
+	"fmt"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/course_weights"
+	"sync"
+	"time"
+)
+
+// courseWeightsValidFor is how long course weights are memoized for.
+const courseWeightsValidFor = time.Minute * 5
+
+var memoizedCourseWeights = struct {
+	sync.Mutex
+	Set        *course_weights.Set
+	ValidUntil time.Time
+}{}
+
+// getCourseWeightSet gets all course weights, memoized for courseWeightsValidFor.
+func getCourseWeightSet() (*course_weights.Set, error) {
+	memoizedCourseWeights.Lock()
+	defer memoizedCourseWeights.Unlock()
+
+	if memoizedCourseWeights.Set != nil && memoizedCourseWeights.ValidUntil.After(time.Now()) {
+		return memoizedCourseWeights.Set, nil
+	}
+
+	cws, err := course_weights.List(db, &course_weights.ListRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing course weights: %w", err)
+	}
+
+	s := course_weights.NewSet(*cws)
+
+	memoizedCourseWeights.Set = &s
+	memoizedCourseWeights.ValidUntil = time.Now().Add(courseWeightsValidFor)
+
+	return &s, nil
+}
+
+// getCourseWeights returns map[courseID<uint64>]weight<float64> for courses at an institution that have a weight.
+func getCourseWeights(institutionID uint64, cs []canvasCourse) (map[uint64]float64, error) {
+	s, err := getCourseWeightSet()
+	if err != nil {
+		return nil, err
+	}
+
+	weights := make(map[uint64]float64)
+	for _, c := range cs {
+		if w := s.WeightForCourse(institutionID, c.ID, c.CourseCode); w != 0 {
+			weights[c.ID] = w
+		}
+	}
+
+	return weights, nil
+}
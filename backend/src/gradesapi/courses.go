@@ -72,6 +72,9 @@ func ListCoursesHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Par
 			Action: gradesErrorActionRedirectToOAuth,
 		}, http.StatusForbidden)
 		return
+	} else if errors.Is(err, canvasErrorRateLimitExceededError) {
+		handleError(w, gradesErrorCanvasRateLimitedResponse, http.StatusTooManyRequests)
+		return
 	} else if errors.Is(err, canvasErrorUnknownError) {
 		handleError(w, gradesErrorUnknownCanvasErrorResponse, util.CanvasProxyErrorCode)
 		return
@@ -80,7 +83,7 @@ func ListCoursesHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Par
 		return
 	}
 
-	go saveCoursesToDB((*[]canvasCourse)(cs))
+	go saveCoursesToDB((*[]canvasCourse)(cs), rdP.InstitutionID)
 
 	j, err := json.Marshal(&listCoursesResponse{
 		Courses: cs,
@@ -171,6 +174,9 @@ func CourseEnrollmentsHandler(w http.ResponseWriter, r *http.Request, ps httprou
 			Action: gradesErrorActionRedirectToOAuth,
 		}, http.StatusForbidden)
 		return
+	} else if errors.Is(err, canvasErrorRateLimitExceededError) {
+		handleError(w, gradesErrorCanvasRateLimitedResponse, http.StatusTooManyRequests)
+		return
 	} else if errors.Is(err, canvasErrorUnknownError) {
 		handleError(w, gradesErrorUnknownCanvasErrorResponse, util.CanvasProxyErrorCode)
 		return
@@ -179,7 +185,7 @@ func CourseEnrollmentsHandler(w http.ResponseWriter, r *http.Request, ps httprou
 		return
 	}
 
-	go saveEnrollmentsToDB(es)
+	go saveEnrollmentsToDB(es, rdP.InstitutionID)
 
 	sendJSON(w, &listEnrollmentsResponse{Enrollments: es})
 	return
@@ -290,7 +296,7 @@ func CourseSubmissionSummaryHandler(w http.ResponseWriter, r *http.Request, ps h
 			includes: []string{"observed_users"},
 		})
 		if outErr != nil {
-			return fmt.Errorf("error getting assignments for course %s: %w", cID, outErr)
+			return fmt.Errorf("error getting assignments for course %d: %w", cID, outErr)
 		}
 
 		ces = *tResp
@@ -302,6 +308,9 @@ func CourseSubmissionSummaryHandler(w http.ResponseWriter, r *http.Request, ps h
 			Action: gradesErrorActionRedirectToOAuth,
 		}, http.StatusForbidden)
 		return
+	} else if errors.Is(err, canvasErrorRateLimitExceededError) {
+		handleError(w, gradesErrorCanvasRateLimitedResponse, http.StatusTooManyRequests)
+		return
 	} else if errors.Is(err, canvasErrorUnknownError) {
 		handleError(w, gradesErrorUnknownCanvasErrorResponse, util.CanvasProxyErrorCode)
 		return
@@ -380,9 +389,10 @@ func CourseSubmissionSummaryHandler(w http.ResponseWriter, r *http.Request, ps h
 
 	if useCache {
 		sums, err := submissions.GetCourseUserSummary(db, &submissions.CourseUserSummaryRequest{
-			CourseID:     uint64(cID),
-			UserIDs:      requestedUserIDs,
-			SeparateLate: lateCount,
+			CourseID:      uint64(cID),
+			UserIDs:       requestedUserIDs,
+			InstitutionID: rd.InstitutionID,
+			SeparateLate:  lateCount,
 		})
 		if err != nil {
 			handleISE(w, errCtx.Apply(fmt.Errorf("error getting course user submission summary from db for"+
@@ -454,6 +464,9 @@ func CourseSubmissionSummaryHandler(w http.ResponseWriter, r *http.Request, ps h
 				Action: gradesErrorActionRedirectToOAuth,
 			}, http.StatusForbidden)
 			return
+		} else if errors.Is(err, canvasErrorRateLimitExceededError) {
+			handleError(w, gradesErrorCanvasRateLimitedResponse, http.StatusTooManyRequests)
+			return
 		} else if errors.Is(err, canvasErrorUnknownError) {
 			handleError(w, gradesErrorUnknownCanvasErrorResponse, util.CanvasProxyErrorCode)
 			return
@@ -462,7 +475,7 @@ func CourseSubmissionSummaryHandler(w http.ResponseWriter, r *http.Request, ps h
 			return
 		}
 
-		go saveSubmissionsToDB(*canvasSubs, uint64(cID))
+		go saveSubmissionsToDB(*canvasSubs, uint64(cID), rd.InstitutionID)
 
 		for _, sub := range *canvasSubs {
 			s := resp.SubmissionSummary[sub.UserID]
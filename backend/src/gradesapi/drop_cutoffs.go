@@ -0,0 +1,70 @@
+package gradesapi
+
+import (
+	"fmt"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/drop_cutoffs"
+	"sync"
+	"time"
+)
+
+// gradeCutoff is the drop cutoff for a course.
+type gradeCutoff struct {
+	// InEffect is whether lowest scores can't be dropped right now
+	InEffect bool `json:"in_effect"`
+	// StartsAt is when lowest scores stop being dropped
+	StartsAt time.Time `json:"starts_at"`
+	// EndsAt is when lowest scores can be dropped again. Omitted if never.
+	EndsAt *time.Time `json:"ends_at,omitempty"`
+}
+
+// dropCutoffsValidFor is how long drop cutoffs are memoized for.
+const dropCutoffsValidFor = time.Minute * 5
+
+var memoizedDropCutoffs = struct {
+	sync.Mutex
+	Set        *drop_cutoffs.Set
+	ValidUntil time.Time
+}{}
+
+// getDropCutoffSet gets all drop cutoffs, memoized for dropCutoffsValidFor.
+func getDropCutoffSet() (*drop_cutoffs.Set, error) {
+	memoizedDropCutoffs.Lock()
+	defer memoizedDropCutoffs.Unlock()
+
+	if memoizedDropCutoffs.Set != nil && memoizedDropCutoffs.ValidUntil.After(time.Now()) {
+		return memoizedDropCutoffs.Set, nil
+	}
+
+	cs, err := drop_cutoffs.List(db, &drop_cutoffs.ListRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing drop cutoffs: %w", err)
+	}
+
+	s := drop_cutoffs.Set{Cutoffs: *cs}
+
+	memoizedDropCutoffs.Set = &s
+	memoizedDropCutoffs.ValidUntil = time.Now().Add(dropCutoffsValidFor)
+
+	return &s, nil
+}
+
+// dropCutoffForCourse returns whether a course at an institution is after its drop cutoff right now,
+// and the cutoff to show with its grades (nil if it doesn't have a current or upcoming one).
+func dropCutoffForCourse(s *drop_cutoffs.Set, institutionID uint64, c canvasCourse) (bool, *gradeCutoff) {
+	if s == nil {
+		return false, nil
+	}
+
+	now := time.Now()
+	dc := s.ForCourse(institutionID, c.ID, uint64(c.EnrollmentTermID), now)
+	if dc == nil {
+		return false, nil
+	}
+
+	inEffect := dc.InEffect(now)
+	return inEffect, &gradeCutoff{
+		InEffect: inEffect,
+		StartsAt: dc.StartsAt,
+		EndsAt:   dc.EndsAt,
+	}
+}
@@ -0,0 +1,263 @@
+package gradesapi
+
+import (
+	"math"
+	"sort"
+)
+
+type gradeExplanationDropReason string
+
+const (
+	// gradeExplanationDropNotPositive means the score was zero or less, so CBL legacy ignores it.
+	gradeExplanationDropNotPositive = gradeExplanationDropReason("not_positive")
+	// gradeExplanationDropLowest means the lowest score was dropped because that improved the average.
+	gradeExplanationDropLowest = gradeExplanationDropReason("lowest_dropped")
+	// gradeExplanationDropNotLatest means only the latest score counts.
+	gradeExplanationDropNotLatest = gradeExplanationDropReason("not_latest")
+	// gradeExplanationDropNotHighest means only the highest score counts.
+	gradeExplanationDropNotHighest = gradeExplanationDropReason("not_highest")
+	// gradeExplanationDropBelowMastery means only scores at or over mastery count.
+	gradeExplanationDropBelowMastery = gradeExplanationDropReason("below_mastery")
+)
+
+// gradeExplanation explains how a computedGrade was calculated.
+type gradeExplanation struct {
+	// MostPercent is the percent of outcomes that have to be over a grade's MostAbove
+	MostPercent float64 `json:"most_percent"`
+	// CountedOutcomeIDs are the best MostPercent% of outcomes, best first.
+	// They're the ones that have to be over MostAbove.
+	CountedOutcomeIDs []uint64 `json:"counted_outcome_ids"`
+	// LowestCountedOutcome is the worst outcome in CountedOutcomeIDs, compared to MostAbove
+	LowestCountedOutcome *gradeExplanationOutcomeAverage `json:"lowest_counted_outcome"`
+	// LowestOutcome is the worst outcome overall, compared to AllAbove
+	LowestOutcome *gradeExplanationOutcomeAverage `json:"lowest_outcome"`
+	// Bands are the grades better than the one earned, and which thresholds were missed
+	Bands []gradeExplanationBand `json:"bands"`
+	// Outcomes is map[outcomeID<uint64>]gradeExplanationOutcome, including outcomes without a score
+	Outcomes map[uint64]gradeExplanationOutcome `json:"outcomes"`
+}
+
+type gradeExplanationOutcomeAverage struct {
+	OutcomeID uint64  `json:"outcome_id"`
+	Average   float64 `json:"average"`
+}
+
+type gradeExplanationBand struct {
+	Grade     string  `json:"grade"`
+	Rank      int8    `json:"rank"`
+	MostAbove float64 `json:"most_above"`
+	AllAbove  float64 `json:"all_above"`
+	// FailedMostAbove is whether the lowest counted outcome is under MostAbove
+	FailedMostAbove bool `json:"failed_most_above"`
+	// FailedAllAbove is whether the lowest outcome is under AllAbove
+	FailedAllAbove bool `json:"failed_all_above"`
+}
+
+type gradeExplanationOutcome struct {
+	CalculationMethod outcomeCalculationMethod `json:"calculation_method"`
+	// Scored is whether the outcome has a score. Outcomes without one don't affect the grade.
+	Scored  bool    `json:"scored"`
+	Average float64 `json:"average"`
+	// Counted is whether the outcome is in CountedOutcomeIDs
+	Counted       bool                           `json:"counted"`
+	DroppedScores []gradeExplanationDroppedScore `json:"dropped_scores"`
+	// NextBand is how far the outcome is from the next grade up. It's nil if there isn't one.
+	NextBand *gradeExplanationNextBand `json:"next_band,omitempty"`
+}
+
+type gradeExplanationDroppedScore struct {
+	OutcomeResultID uint64                     `json:"outcome_result_id"`
+	Score           float64                    `json:"score"`
+	Reason          gradeExplanationDropReason `json:"reason"`
+}
+
+type gradeExplanationNextBand struct {
+	Grade string `json:"grade"`
+	// Threshold is MostAbove if the outcome is counted (and MostAbove is higher), otherwise AllAbove
+	Threshold float64         `json:"threshold"`
+	Reason    gradePlanReason `json:"reason"`
+	// Distance is how much the outcome's average has to go up to reach Threshold. Zero if it's already there.
+	Distance float64 `json:"distance"`
+}
+
+/*
+explainGrade explains grd, which must have been calculated by calculateGradeFromOutcomeResults
+with the same arguments.
+*/
+func explainGrade(
+	results map[uint64][]canvasOutcomeResult,
+	calcs outcomeCalculations,
+	policy gradingPolicy,
+	isAfterCutoff bool,
+	grd computedGrade,
+) *gradeExplanation {
+	e := gradeExplanation{
+		MostPercent:       policy.MostPercent,
+		CountedOutcomeIDs: []uint64{},
+		Bands:             []gradeExplanationBand{},
+		Outcomes:          make(map[uint64]gradeExplanationOutcome, len(results)),
+	}
+
+	// outcome IDs sorted by average, best first
+	var outcomeIDs []uint64
+	for oID := range grd.Averages {
+		outcomeIDs = append(outcomeIDs, oID)
+	}
+
+	sort.Slice(outcomeIDs, func(i, j int) bool {
+		ai, aj := grd.Averages[outcomeIDs[i]].Average, grd.Averages[outcomeIDs[j]].Average
+		if ai == aj {
+			return outcomeIDs[i] < outcomeIDs[j]
+		}
+		return ai > aj
+	})
+
+	counted := make(map[uint64]struct{})
+	if len(outcomeIDs) > 0 {
+		e.CountedOutcomeIDs = outcomeIDs[:policy.numMostOutcomes(len(outcomeIDs))]
+		for _, oID := range e.CountedOutcomeIDs {
+			counted[oID] = struct{}{}
+		}
+
+		lowestCountedID := e.CountedOutcomeIDs[len(e.CountedOutcomeIDs)-1]
+		e.LowestCountedOutcome = &gradeExplanationOutcomeAverage{
+			OutcomeID: lowestCountedID,
+			Average:   grd.Averages[lowestCountedID].Average,
+		}
+
+		lowestID := outcomeIDs[len(outcomeIDs)-1]
+		e.LowestOutcome = &gradeExplanationOutcomeAverage{
+			OutcomeID: lowestID,
+			Average:   grd.Averages[lowestID].Average,
+		}
+
+		for _, g := range policy.Grades {
+			if g.Rank <= grd.Grade.Rank {
+				continue
+			}
+
+			e.Bands = append(e.Bands, gradeExplanationBand{
+				Grade:           g.Grade,
+				Rank:            g.Rank,
+				MostAbove:       g.MostAbove,
+				AllAbove:        g.AllAbove,
+				FailedMostAbove: e.LowestCountedOutcome.Average < g.MostAbove,
+				FailedAllAbove:  e.LowestOutcome.Average < g.AllAbove,
+			})
+		}
+	}
+
+	// the next grade up is the lowest ranked grade better than the one earned
+	var next *grade
+	for _, g := range policy.Grades {
+		if g.Rank > grd.Grade.Rank && (next == nil || g.Rank < next.Rank) {
+			ng := g
+			next = &ng
+		}
+	}
+
+	for oID, rs := range results {
+		calc := policy.outcomeCalculation(calcs, oID)
+		avg, scored := grd.Averages[oID]
+
+		o := gradeExplanationOutcome{
+			CalculationMethod: calc.Method,
+			Scored:            scored,
+			Average:           avg.Average,
+			DroppedScores:     droppedOutcomeScores(rs, calc, avg, isAfterCutoff),
+		}
+
+		if _, ok := counted[oID]; ok {
+			o.Counted = true
+		}
+
+		if scored && next != nil {
+			nb := gradeExplanationNextBand{
+				Grade:     next.Grade,
+				Threshold: next.AllAbove,
+				Reason:    gradePlanReasonAllAbove,
+			}
+
+			if o.Counted && next.MostAbove > next.AllAbove {
+				nb.Threshold = next.MostAbove
+				nb.Reason = gradePlanReasonMostAbove
+			}
+
+			// rounded to the hundredth to hide float error
+			nb.Distance = math.Max(0, math.Round((nb.Threshold-o.Average)*100)/100)
+			o.NextBand = &nb
+		}
+
+		e.Outcomes[oID] = o
+	}
+
+	return &e
+}
+
+// droppedOutcomeScores lists the results that didn't count toward avg, and why.
+func droppedOutcomeScores(
+	rs []canvasOutcomeResult,
+	calc outcomeCalculation,
+	avg computedAverage,
+	isAfterCutoff bool,
+) []gradeExplanationDroppedScore {
+	dropped := []gradeExplanationDroppedScore{}
+	if len(rs) < 1 {
+		return dropped
+	}
+
+	drop := func(r canvasOutcomeResult, reason gradeExplanationDropReason) {
+		dropped = append(dropped, gradeExplanationDroppedScore{
+			OutcomeResultID: r.ID,
+			Score:           r.Score,
+			Reason:          reason,
+		})
+	}
+
+	switch calc.Method {
+	case outcomeCalculationLatest:
+		sorted := outcomeResultsByAssessedAt(rs)
+		for _, r := range sorted[:len(sorted)-1] {
+			drop(r, gradeExplanationDropNotLatest)
+		}
+	case outcomeCalculationHighest:
+		highest := 0
+		for i, r := range rs {
+			if r.Score > rs[highest].Score {
+				highest = i
+			}
+		}
+
+		for i, r := range rs {
+			if i != highest {
+				drop(r, gradeExplanationDropNotHighest)
+			}
+		}
+	case outcomeCalculationNMastery:
+		for _, r := range rs {
+			if r.Score < calc.MasteryPoints {
+				drop(r, gradeExplanationDropBelowMastery)
+			}
+		}
+	case outcomeCalculationDecayingAverage, outcomeCalculationAverage:
+		// every score counts
+	default:
+		lowest := -1
+		for i, r := range rs {
+			if r.Score <= 0 {
+				drop(r, gradeExplanationDropNotPositive)
+				continue
+			}
+
+			if lowest < 0 || r.Score < rs[lowest].Score {
+				lowest = i
+			}
+		}
+
+		if avg.DidDropWorstScore && !isAfterCutoff && lowest >= 0 {
+			drop(rs[lowest], gradeExplanationDropLowest)
+		}
+	}
+
+	return dropped
+}
@@ -0,0 +1,913 @@
+package gradesapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/canvas_tokens"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/enrollments"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/fetch_jobs"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/fetch_runs"
+	gradessvc "github.com/iamtheyammer/canvascbl/backend/src/db/services/grades"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/notifications"
+	"github.com/iamtheyammer/canvascbl/backend/src/email"
+	"github.com/iamtheyammer/canvascbl/backend/src/env"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+fetch_all is a job queue in the fetch_jobs table, so that it survives restarts and can be spread across servers.
+
+GradesForAllHandler queues a run: a job for every user with a token and a summary job. Workers claim jobs
+in priority order, so teachers (who fetch every student in their courses) run first, then students and observers
+(without the courses teachers fetched), then the summary, which writes the run's results to the fetch log sink
+(see fetch_log_sink.go).
+*/
+
+const (
+	// fetchAllPollInterval is how long an idle worker waits before looking for a job again.
+	fetchAllPollInterval = 5 * time.Second
+	// fetchAllLeaseMargin is added to CanvasFetchAllUserTimeout so that a slow save doesn't lose a job's lease.
+	fetchAllLeaseMargin = 5 * time.Minute
+	// fetchAllRetryBaseDelay is how long to wait before the first retry of a job. It doubles with each retry.
+	fetchAllRetryBaseDelay = time.Minute
+	// fetchAllSummaryMaxAttempts is how many times the summary job is tried.
+	fetchAllSummaryMaxAttempts = 3
+	// fetchAllRunStateTTL is how long run states are kept in memory.
+	fetchAllRunStateTTL = 24 * time.Hour
+)
+
+const (
+	fetchAllPriorityTeacher = iota
+	fetchAllPriorityRest
+	fetchAllPrioritySummary
+)
+
+// fetchAllUser is a user in a run. Canvas user IDs are only unique within an institution, so it's both.
+type fetchAllUser struct {
+	// InstitutionID is 0 for the default Canvas instance
+	InstitutionID uint64
+	CanvasUserID  uint64
+}
+
+// MarshalText lets fetchAllUser key maps in JSON, as institutionID:canvasUserID.
+func (u fetchAllUser) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d:%d", u.InstitutionID, u.CanvasUserID)), nil
+}
+
+// fetchAllUserOf is job's user.
+func fetchAllUserOf(job fetch_jobs.Job) fetchAllUser {
+	return fetchAllUser{InstitutionID: job.InstitutionID, CanvasUserID: job.CanvasUserID}
+}
+
+// fetchAllTeacherResult is stored as a teacher job's result.
+type fetchAllTeacherResult struct {
+	// Courses are the courses the teacher fetched, map[courseID]courseName.
+	Courses map[uint64]string `json:"courses"`
+}
+
+// fetchAllJobError is a failed job in a run's summary.
+type fetchAllJobError struct {
+	CanvasUserID  uint64          `json:"canvas_user_id"`
+	InstitutionID uint64          `json:"institution_id,omitempty"`
+	Kind          fetch_jobs.Kind `json:"kind"`
+	Error         string          `json:"error"`
+	Transient     bool            `json:"transient,omitempty"`
+	Attempts      int             `json:"attempts"`
+}
+
+// fetchAllRunSummary is what's logged once a run is done.
+type fetchAllRunSummary struct {
+	RunID string `json:"run_id"`
+	// Errors is map[jobID]fetchAllJobError, as a user who's both a teacher and a student has two jobs.
+	Errors map[uint64]fetchAllJobError `json:"errors"`
+	// TeacherStatuses and RestStatuses are whether each user's job succeeded, keyed by institutionID:canvasUserID.
+	TeacherStatuses map[fetchAllUser]bool `json:"teacher_statuses"`
+	RestStatuses    map[fetchAllUser]bool `json:"rest_statuses"`
+	NumTeachers     int                   `json:"num_teachers"`
+	NumBoth         int                   `json:"num_both"`
+	NumStudents     int                   `json:"num_students"`
+	NumObservers    int                   `json:"num_observers"`
+	NumSucceeded    int                   `json:"num_succeeded"`
+	NumErrors       int                   `json:"num_errors"`
+	NumRetried      int                   `json:"num_retried"`
+}
+
+// fetchAllRunState is what every student and observer job in a run needs. It's loaded once per run and server.
+type fetchAllRunState struct {
+	// StartedAt is when the run was queued. Grades from before it are compared against for grade change emails.
+	StartedAt time.Time
+	// ExcludeCourses are courses that a teacher job already fetched, as map[institutionID]map[courseID]struct{}.
+	ExcludeCourses map[uint64]map[uint64]struct{}
+	// ExcludedCourseNames is map[institutionID]map[courseID]courseName for ExcludeCourses.
+	ExcludedCourseNames map[uint64]map[uint64]string
+	// NotificationUsers want grade change emails.
+	NotificationUsers map[fetchAllUser]struct{}
+	// ForecastUsers want grade drop forecast emails. It's empty if those can't be sent.
+	ForecastUsers map[fetchAllUser]struct{}
+
+	loadedAt time.Time
+}
+
+var (
+	ErrFetchAllRunNotFound      = errors.New("fetch run not found")
+	ErrFetchAllRunNotFinished   = errors.New("fetch run isn't finished")
+	ErrFetchAllRunNoFailedUsers = errors.New("no users failed in the fetch run")
+)
+
+var fetchAllRunStates = struct {
+	sync.Mutex
+	// States is map[runID]*fetchAllRunState
+	States map[string]*fetchAllRunState
+}{States: map[string]*fetchAllRunState{}}
+
+// GradesForAllHandler queues a fetch_all run for every user with a token, and responds with its ID.
+func GradesForAllHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	key := r.Header.Get("X-CanvasCBL-Script-Key")
+	if len(key) < 1 {
+		util.SendBadRequest(w, "missing X-CanvasCBL-Script-Key as header")
+		return
+	} else if key != env.ScriptKey {
+		util.SendUnauthorized(w, "invalid X-CanvasCBL-Script-Key as header")
+		return
+	}
+
+	runID, err := EnqueueFetchAllRun()
+	if err != nil {
+		handleISE(w, fmt.Errorf("error queueing fetch_all run: %w", err))
+		return
+	}
+
+	sendJSON(w, struct {
+		RunID string `json:"run_id"`
+	}{RunID: runID})
+}
+
+// EnqueueFetchAllRun queues a job for every user with a token, and a summary job, and returns the run's ID.
+func EnqueueFetchAllRun() (string, error) {
+	teacherEnrollments, err := enrollments.List(db, &enrollments.ListRequest{Type: enrollments.TypeTeacher})
+	if err != nil {
+		return "", fmt.Errorf("error listing teacher enrollments: %w", err)
+	}
+
+	teachers := map[fetchAllUser]struct{}{}
+	for _, e := range *teacherEnrollments {
+		teachers[fetchAllUser{InstitutionID: e.InstitutionID, CanvasUserID: e.UserCanvasID}] = struct{}{}
+	}
+
+	studentEnrollments, err := enrollments.List(db, &enrollments.ListRequest{Type: enrollments.TypeStudent})
+	if err != nil {
+		return "", fmt.Errorf("error listing student enrollments: %w", err)
+	}
+
+	students := map[fetchAllUser]struct{}{}
+	for _, e := range *studentEnrollments {
+		students[fetchAllUser{InstitutionID: e.InstitutionID, CanvasUserID: e.UserCanvasID}] = struct{}{}
+	}
+
+	// one token per user per institution, as the same canvas user ID can be someone else at another one
+	tokens, err := canvas_tokens.List(db, &canvas_tokens.ListRequest{
+		OrderBys: []string{
+			"canvas_tokens.institution_key",
+			"canvas_tokens.canvas_user_id",
+			"canvas_tokens.inserted_at DESC",
+		},
+		DistinctOn: "canvas_tokens.institution_key, canvas_tokens.canvas_user_id",
+	})
+	if err != nil {
+		return "", fmt.Errorf("error listing all unique canvas tokens: %w", err)
+	}
+
+	var jobs []fetch_jobs.EnqueueRequest
+	for _, t := range *tokens {
+		u := fetchAllUser{InstitutionID: t.InstitutionID, CanvasUserID: t.CanvasUserID}
+		_, userIsTeacher := teachers[u]
+		_, userIsStudent := students[u]
+
+		if userIsTeacher {
+			jobs = append(jobs, fetchAllUserJob(fetch_jobs.KindTeacher, u))
+		}
+
+		if userIsStudent {
+			// this covers a VERY RARE circumstance that a user is both a student and a teacher in different courses.
+			jobs = append(jobs, fetchAllUserJob(fetch_jobs.KindStudent, u))
+		} else if !userIsTeacher {
+			// user is an observer because they are not a student and not a teacher
+			jobs = append(jobs, fetchAllUserJob(fetch_jobs.KindObserver, u))
+		}
+	}
+
+	return startFetchAllRun("", jobs)
+}
+
+/*
+RerunFetchAllRun queues a new run for the users whose fetches failed in a finished run, and returns its ID.
+There's no run if every user succeeded.
+*/
+func RerunFetchAllRun(runID string) (string, error) {
+	runs, err := fetch_runs.List(db, &fetch_runs.ListRequest{ID: runID})
+	if err != nil {
+		return "", fmt.Errorf("error listing fetch runs: %w", err)
+	}
+
+	if len(*runs) < 1 {
+		return "", ErrFetchAllRunNotFound
+	}
+
+	if !(*runs)[0].Finished() {
+		return "", ErrFetchAllRunNotFinished
+	}
+
+	failed, err := fetch_runs.ListUsers(db, &fetch_runs.ListUsersRequest{
+		RunID:    runID,
+		Statuses: []fetch_jobs.Status{fetch_jobs.StatusFailed},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error listing failed fetch run users: %w", err)
+	}
+
+	if len(*failed) < 1 {
+		return "", ErrFetchAllRunNoFailedUsers
+	}
+
+	var jobs []fetch_jobs.EnqueueRequest
+	for _, u := range *failed {
+		jobs = append(jobs, fetchAllUserJob(u.Kind, fetchAllUser{
+			InstitutionID: u.InstitutionID,
+			CanvasUserID:  u.CanvasUserID,
+		}))
+	}
+
+	return startFetchAllRun(runID, jobs)
+}
+
+// fetchAllUserJob is the job for a user in a run.
+func fetchAllUserJob(kind fetch_jobs.Kind, u fetchAllUser) fetch_jobs.EnqueueRequest {
+	if kind == fetch_jobs.KindTeacher {
+		// teachers aren't retried: if one fails, their students fetch the teacher's courses themselves.
+		return fetch_jobs.EnqueueRequest{
+			Kind:          kind,
+			CanvasUserID:  u.CanvasUserID,
+			InstitutionID: u.InstitutionID,
+			Priority:      fetchAllPriorityTeacher,
+			MaxAttempts:   1,
+		}
+	}
+
+	return fetch_jobs.EnqueueRequest{
+		Kind:          kind,
+		CanvasUserID:  u.CanvasUserID,
+		InstitutionID: u.InstitutionID,
+		Priority:      fetchAllPriorityRest,
+		MaxAttempts:   env.CanvasFetchAllMaxAttempts,
+	}
+}
+
+// startFetchAllRun records a new run with userJobs and queues them, along with a summary job.
+func startFetchAllRun(rerunOf string, userJobs []fetch_jobs.EnqueueRequest) (string, error) {
+	runID := uuid.NewV4().String()
+
+	var (
+		jobs  []fetch_jobs.EnqueueRequest
+		users []fetch_runs.InsertUserRequest
+	)
+
+	for _, j := range userJobs {
+		j.RunID = runID
+		jobs = append(jobs, j)
+		users = append(users, fetch_runs.InsertUserRequest{
+			RunID:         runID,
+			CanvasUserID:  j.CanvasUserID,
+			InstitutionID: j.InstitutionID,
+			Kind:          j.Kind,
+		})
+	}
+
+	jobs = append(jobs, fetch_jobs.EnqueueRequest{
+		RunID:       runID,
+		Kind:        fetch_jobs.KindSummary,
+		Priority:    fetchAllPrioritySummary,
+		MaxAttempts: fetchAllSummaryMaxAttempts,
+	})
+
+	trx, err := db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("error beginning enqueue fetch_all run transaction: %w", err)
+	}
+
+	rb := func(at string) {
+		err := trx.Rollback()
+		if err != nil {
+			util.HandleError(fmt.Errorf("error rolling back enqueue fetch_all run transaction at %s: %w", at, err))
+		}
+	}
+
+	err = fetch_runs.Insert(trx, &fetch_runs.InsertRequest{ID: runID, RerunOf: rerunOf})
+	if err != nil {
+		rb("run")
+		return "", fmt.Errorf("error inserting fetch run: %w", err)
+	}
+
+	err = fetch_runs.InsertUsers(trx, &users)
+	if err != nil {
+		rb("users")
+		return "", fmt.Errorf("error inserting fetch run users: %w", err)
+	}
+
+	err = fetch_jobs.Enqueue(trx, &jobs)
+	if err != nil {
+		rb("jobs")
+		return "", fmt.Errorf("error enqueueing fetch_all jobs: %w", err)
+	}
+
+	err = trx.Commit()
+	if err != nil {
+		return "", fmt.Errorf("error committing enqueue fetch_all run transaction: %w", err)
+	}
+
+	return runID, nil
+}
+
+// StartFetchAllWorkers starts env.CanvasFetchAllWorkers workers, which run fetch_all jobs until the process exits.
+func StartFetchAllWorkers() {
+	for i := 0; i < env.CanvasFetchAllWorkers; i++ {
+		go fetchAllWorker()
+	}
+}
+
+func fetchAllWorker() {
+	for {
+		failExpiredFetchAllJobs()
+
+		job, err := fetch_jobs.Claim(db, env.CanvasFetchAllUserTimeout+fetchAllLeaseMargin)
+		if err != nil {
+			util.HandleError(fmt.Errorf("error claiming a fetch_all job: %w", err))
+			time.Sleep(fetchAllPollInterval)
+			continue
+		}
+
+		if job == nil {
+			time.Sleep(fetchAllPollInterval)
+			continue
+		}
+
+		updateFetchAllRunUser(*job, &fetch_runs.UpdateUserRequest{Status: fetch_jobs.StatusRunning})
+
+		result, gep := runFetchAllJob(*job)
+		finishFetchAllJob(*job, result, gep)
+	}
+}
+
+// failExpiredFetchAllJobs fails jobs whose workers died on their last attempt, and records them in their runs.
+func failExpiredFetchAllJobs() {
+	jobs, err := fetch_jobs.FailExpired(db)
+	if err != nil {
+		util.HandleError(fmt.Errorf("error failing expired fetch_all jobs: %w", err))
+		return
+	}
+
+	for _, j := range *jobs {
+		updateFetchAllRunUser(j, &fetch_runs.UpdateUserRequest{
+			Status:        fetch_jobs.StatusFailed,
+			Error:         j.Error,
+			ErrorCategory: fetch_runs.ErrorCategoryInternal,
+		})
+	}
+}
+
+// runFetchAllJob runs a job, returning its result (JSON, or nil).
+func runFetchAllJob(job fetch_jobs.Job) (result []byte, gep *GradesErrorResponse) {
+	// a panic here would take the whole server down, not just a request
+	defer func() {
+		if r := recover(); r != nil {
+			result = nil
+			gep = &GradesErrorResponse{InternalError: fmt.Errorf("panic in fetch_all %s job: %v", job.Kind, r)}
+		}
+	}()
+
+	switch job.Kind {
+	case fetch_jobs.KindTeacher:
+		return runFetchAllTeacherJob(job)
+	case fetch_jobs.KindStudent, fetch_jobs.KindObserver:
+		return runFetchAllRestJob(job)
+	case fetch_jobs.KindSummary:
+		return runFetchAllSummaryJob(job)
+	default:
+		return nil, &GradesErrorResponse{InternalError: fmt.Errorf("unknown fetch_all job kind %s", job.Kind)}
+	}
+}
+
+// finishFetchAllJob records a job's outcome, queueing it to run again if it failed transiently and has attempts left.
+func finishFetchAllJob(job fetch_jobs.Job, result []byte, gep *GradesErrorResponse) {
+	if gep == nil {
+		err := fetch_jobs.Succeed(db, &fetch_jobs.SucceedRequest{
+			ID:      job.ID,
+			Attempt: job.Attempts,
+			Result:  result,
+		})
+		if errors.Is(err, fetch_jobs.ErrLostLease) {
+			// the worker that claimed it records it
+			return
+		} else if err != nil {
+			util.HandleError(fmt.Errorf("error marking fetch_all job %d as succeeded: %w", job.ID, err))
+		}
+
+		updateFetchAllRunUser(job, &fetch_runs.UpdateUserRequest{Status: fetch_jobs.StatusSucceeded})
+		return
+	}
+
+	gep = gep.withTransient()
+	// summaries only fail on our side (like writing logs), so they're always worth another try
+	if job.Kind == fetch_jobs.KindSummary {
+		gep.Transient = true
+	}
+
+	msg := gep.Error
+	if gep.InternalError != nil {
+		msg = gep.InternalError.Error()
+		util.HandleError(fmt.Errorf(
+			"error in fetch_all %s job for user %d: %w",
+			job.Kind,
+			job.CanvasUserID,
+			gep.InternalError,
+		))
+	}
+
+	req := fetch_jobs.FailRequest{
+		ID:        job.ID,
+		Attempt:   job.Attempts,
+		Error:     msg,
+		Transient: gep.Transient,
+	}
+
+	if gep.Transient && job.Attempts < job.MaxAttempts {
+		retryAt := time.Now().Add(fetchAllRetryBaseDelay << uint(job.Attempts-1))
+		req.RetryAt = &retryAt
+	}
+
+	err := fetch_jobs.Fail(db, &req)
+	if errors.Is(err, fetch_jobs.ErrLostLease) {
+		return
+	} else if err != nil {
+		util.HandleError(fmt.Errorf("error marking fetch_all job %d as failed: %w", job.ID, err))
+	}
+
+	status := fetch_jobs.StatusFailed
+	if req.RetryAt != nil {
+		status = fetch_jobs.StatusQueued
+	}
+
+	updateFetchAllRunUser(job, &fetch_runs.UpdateUserRequest{
+		Status:        status,
+		Error:         msg,
+		ErrorCategory: fetchAllErrorCategory(gep),
+	})
+}
+
+// updateFetchAllRunUser records where job's user is in its run. Summary jobs don't have a user.
+func updateFetchAllRunUser(job fetch_jobs.Job, req *fetch_runs.UpdateUserRequest) {
+	if job.Kind == fetch_jobs.KindSummary {
+		return
+	}
+
+	req.RunID = job.RunID
+	req.CanvasUserID = job.CanvasUserID
+	req.InstitutionID = job.InstitutionID
+	req.Kind = job.Kind
+	req.Attempts = job.Attempts
+
+	err := fetch_runs.UpdateUser(db, req)
+	if err != nil {
+		util.HandleError(fmt.Errorf("error updating fetch run user for fetch_all job %d: %w", job.ID, err))
+	}
+}
+
+// fetchAllErrorCategory categorizes a failed job's error.
+func fetchAllErrorCategory(gep *GradesErrorResponse) fetch_runs.ErrorCategory {
+	switch {
+	case gep.Action == gradesErrorActionRedirectToOAuth || gep.Error == gradesErrorRevokedToken:
+		return fetch_runs.ErrorCategoryToken
+	case gep.StatusCode == http.StatusTooManyRequests:
+		return fetch_runs.ErrorCategoryRateLimited
+	case gep.InternalError != nil && errors.Is(gep.InternalError, context.DeadlineExceeded):
+		return fetch_runs.ErrorCategoryTimeout
+	case gep.InternalError == nil || gep.Transient:
+		return fetch_runs.ErrorCategoryCanvas
+	default:
+		return fetch_runs.ErrorCategoryInternal
+	}
+}
+
+func runFetchAllTeacherJob(job fetch_jobs.Job) ([]byte, *GradesErrorResponse) {
+	ctx, cancel := context.WithTimeout(context.Background(), env.CanvasFetchAllUserTimeout)
+	defer cancel()
+
+	resp, dbReq, gep := AllGradesForTeacher(&UserGradesRequest{
+		CanvasUserID:     job.CanvasUserID,
+		InstitutionID:    job.InstitutionID,
+		ManualFetch:      false,
+		ReturnDBRequests: true,
+		Incremental:      true,
+		Ctx:              ctx,
+	})
+	if gep != nil {
+		return nil, gep
+	}
+
+	err := saveBatchGradesDBRequests([]UserGradesDBRequests{*dbReq})
+	if err != nil {
+		return nil, &GradesErrorResponse{InternalError: fmt.Errorf("error saving teacher grades: %w", err)}
+	}
+
+	res := fetchAllTeacherResult{Courses: map[uint64]string{}}
+	for _, c := range *resp.Courses {
+		res.Courses[c.ID] = c.Name
+	}
+
+	jRes, err := json.Marshal(&res)
+	if err != nil {
+		return nil, &GradesErrorResponse{InternalError: fmt.Errorf("error marshaling teacher job result: %w", err)}
+	}
+
+	return jRes, nil
+}
+
+func runFetchAllRestJob(job fetch_jobs.Job) ([]byte, *GradesErrorResponse) {
+	state, err := getFetchAllRunState(job)
+	if err != nil {
+		return nil, &GradesErrorResponse{InternalError: fmt.Errorf("error getting fetch_all run state: %w", err)}
+	}
+
+	user := fetchAllUserOf(job)
+	_, wantsForecasts := state.ForecastUsers[user]
+
+	ctx, cancel := context.WithTimeout(context.Background(), env.CanvasFetchAllUserTimeout)
+	defer cancel()
+
+	resp, dbReq, gep := GradesForUser(&UserGradesRequest{
+		CanvasUserID:     job.CanvasUserID,
+		InstitutionID:    job.InstitutionID,
+		ExcludeCourseIDs: state.ExcludeCourses[job.InstitutionID],
+		DetailedGrades:   true,
+		GradeForecasts:   wantsForecasts,
+		ManualFetch:      false,
+		ReturnDBRequests: true,
+		FetchAssignments: true,
+		Incremental:      true,
+		Ctx:              ctx,
+	})
+	if gep != nil {
+		return nil, gep
+	}
+
+	err = saveBatchGradesDBRequests([]UserGradesDBRequests{*dbReq})
+	if err != nil {
+		return nil, &GradesErrorResponse{InternalError: fmt.Errorf("error saving grades: %w", err)}
+	}
+
+	// grades are saved, so nothing below fails the job: a retry would fetch and send everything again
+
+	if wantsForecasts {
+		err := sendGradeDropForecastNotifications(resp, job.InstitutionID)
+		if err != nil {
+			util.HandleError(fmt.Errorf("error sending grade drop forecast notifications in fetch_all for user %d: %w", job.CanvasUserID, err))
+		}
+	}
+
+	if _, ok := state.NotificationUsers[user]; ok {
+		err := sendFetchAllGradeChangeEmails(state, job.InstitutionID, resp)
+		if err != nil {
+			util.HandleError(fmt.Errorf("error sending grade change emails in fetch_all for user %d: %w", job.CanvasUserID, err))
+		}
+	}
+
+	return nil, nil
+}
+
+// getFetchAllRunState gets the state for job's run, loading it if this server hasn't yet.
+// Every teacher job in the run must be done.
+func getFetchAllRunState(job fetch_jobs.Job) (*fetchAllRunState, error) {
+	fetchAllRunStates.Lock()
+	defer fetchAllRunStates.Unlock()
+
+	for runID, s := range fetchAllRunStates.States {
+		if time.Since(s.loadedAt) > fetchAllRunStateTTL {
+			delete(fetchAllRunStates.States, runID)
+		}
+	}
+
+	if s, ok := fetchAllRunStates.States[job.RunID]; ok {
+		return s, nil
+	}
+
+	s := fetchAllRunState{
+		StartedAt:           job.InsertedAt,
+		ExcludeCourses:      map[uint64]map[uint64]struct{}{},
+		ExcludedCourseNames: map[uint64]map[uint64]string{},
+		NotificationUsers:   map[fetchAllUser]struct{}{},
+		ForecastUsers:       map[fetchAllUser]struct{}{},
+		loadedAt:            time.Now(),
+	}
+
+	teacherJobs, err := fetch_jobs.List(db, &fetch_jobs.ListRequest{
+		RunID:    job.RunID,
+		Kinds:    []fetch_jobs.Kind{fetch_jobs.KindTeacher},
+		Statuses: []fetch_jobs.Status{fetch_jobs.StatusSucceeded},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing succeeded teacher jobs: %w", err)
+	}
+
+	for _, tj := range *teacherJobs {
+		var res fetchAllTeacherResult
+		err := json.Unmarshal(tj.Result, &res)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshaling teacher job %d result: %w", tj.ID, err)
+		}
+
+		if s.ExcludeCourses[tj.InstitutionID] == nil {
+			s.ExcludeCourses[tj.InstitutionID] = map[uint64]struct{}{}
+			s.ExcludedCourseNames[tj.InstitutionID] = map[uint64]string{}
+		}
+
+		for cID, name := range res.Courses {
+			s.ExcludeCourses[tj.InstitutionID][cID] = struct{}{}
+			s.ExcludedCourseNames[tj.InstitutionID][cID] = name
+		}
+	}
+
+	notificationReqs, err := notifications.ListSettings(db, &notifications.ListSettingsRequest{
+		Type:   notifications.TypeGradeChange,
+		Medium: notifications.MediumEmail,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing grade change notification requests: %w", err)
+	}
+
+	for _, r := range *notificationReqs {
+		s.NotificationUsers[fetchAllUser{InstitutionID: r.InstitutionID, CanvasUserID: r.CanvasUserID}] = struct{}{}
+	}
+
+	// forecasts are only calculated if the email can be sent.
+	if len(env.SendGridGradeDropForecastTemplateID) > 0 {
+		forecastReqs, err := notifications.ListSettings(db, &notifications.ListSettingsRequest{
+			Type:   notifications.TypeGradeDropForecast,
+			Medium: notifications.MediumEmail,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error listing grade drop forecast notification requests: %w", err)
+		}
+
+		for _, r := range *forecastReqs {
+			s.ForecastUsers[fetchAllUser{InstitutionID: r.InstitutionID, CanvasUserID: r.CanvasUserID}] = struct{}{}
+		}
+	}
+
+	fetchAllRunStates.States[job.RunID] = &s
+	return &s, nil
+}
+
+// sendFetchAllGradeChangeEmails emails the user in resp, from institutionID, about every grade that changed since
+// the run started, including grades a teacher job fetched.
+func sendFetchAllGradeChangeEmails(state *fetchAllRunState, institutionID uint64, resp *UserGradesResponse) error {
+	pr := *resp.UserProfile
+	userIsObserver := resp.Observees != nil && len(*resp.Observees) > 0
+
+	courseNames := make(map[uint64]string)
+	for _, c := range *resp.Courses {
+		courseNames[c.ID] = c.Name
+	}
+
+	// previous grades for the user and their observees, map[userID]map[courseID]gradessvc.Grade
+	userIDs := []uint64{pr.ID}
+	for uID := range resp.DetailedGrades {
+		if uID != pr.ID {
+			userIDs = append(userIDs, uID)
+		}
+	}
+
+	prevGrades, err := gradessvc.List(db, &gradessvc.ListRequest{
+		UserCanvasIDs: &userIDs,
+		InstitutionID: &institutionID,
+		Before:        &state.StartedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("error listing previous grades: %w", err)
+	}
+
+	prev := make(map[uint64]map[uint64]gradessvc.Grade)
+	for _, pg := range *prevGrades {
+		if prev[pg.UserCanvasID] == nil {
+			prev[pg.UserCanvasID] = map[uint64]gradessvc.Grade{}
+		}
+
+		prev[pg.UserCanvasID][pg.CourseID] = pg
+	}
+
+	send := func(studentID uint64, className string, previousGrade string, currentGrade string) {
+		if userIsObserver {
+			var studentName string
+			for _, o := range *resp.Observees {
+				if o.ID == studentID {
+					studentName = o.Name
+					break
+				}
+			}
+
+			go email.SendParentGradeChangeEmail(&email.ParentGradeChangeEmailData{
+				To:            pr.PrimaryEmail,
+				Name:          pr.Name,
+				StudentName:   studentName,
+				ClassName:     className,
+				PreviousGrade: previousGrade,
+				CurrentGrade:  currentGrade,
+			})
+		} else {
+			go email.SendGradeChangeEmail(&email.GradeChangeEmailData{
+				To:            pr.PrimaryEmail,
+				Name:          pr.Name,
+				ClassName:     className,
+				PreviousGrade: previousGrade,
+				CurrentGrade:  currentGrade,
+			})
+		}
+	}
+
+	excludeCourses := state.ExcludeCourses[institutionID]
+
+	// once for grades a teacher fetched
+	if len(excludeCourses) > 0 {
+		excludedCourseIDs := make([]uint64, 0, len(excludeCourses))
+		for cID := range excludeCourses {
+			excludedCourseIDs = append(excludedCourseIDs, cID)
+		}
+
+		teacherGrades, err := gradessvc.List(db, &gradessvc.ListRequest{
+			UserCanvasIDs: &[]uint64{pr.ID},
+			CourseIDs:     &excludedCourseIDs,
+			InstitutionID: &institutionID,
+			After:         &state.StartedAt,
+		})
+		if err != nil {
+			return fmt.Errorf("error listing grades fetched by teachers: %w", err)
+		}
+
+		for _, g := range *teacherGrades {
+			p, ok := prev[pr.ID][g.CourseID]
+			// if not the student will get infinite emails for grade -> NA
+			if !ok || p.Grade == g.Grade || g.Grade == naGrade.Grade {
+				continue
+			}
+
+			courseName := courseNames[g.CourseID]
+			if len(courseName) < 1 {
+				courseName = state.ExcludedCourseNames[institutionID][g.CourseID]
+			}
+
+			send(pr.ID, courseName, p.Grade, g.Grade)
+		}
+	}
+
+	// and once more for grades the user fetched
+	for uID, cs := range resp.DetailedGrades {
+		for cID, c := range cs {
+			// skip courses we handled above
+			if _, ok := excludeCourses[cID]; ok {
+				continue
+			}
+
+			p, ok := prev[uID][cID]
+			if !ok || p.Grade == c.Grade.Grade || c.Grade == naGrade {
+				continue
+			}
+
+			send(uID, courseNames[cID], p.Grade, c.Grade.Grade)
+		}
+	}
+
+	return nil
+}
+
+func runFetchAllSummaryJob(job fetch_jobs.Job) ([]byte, *GradesErrorResponse) {
+	summary, err := finishFetchAllRun(db, job.RunID)
+	if err != nil {
+		return nil, &GradesErrorResponse{InternalError: err}
+	}
+
+	err = writeFetchAllLog(summary)
+	if err != nil {
+		return nil, &GradesErrorResponse{InternalError: fmt.Errorf("error writing fetch_all summary log: %w", err)}
+	}
+
+	jSummary, err := json.Marshal(summary)
+	if err != nil {
+		return nil, &GradesErrorResponse{InternalError: fmt.Errorf("error marshaling fetch_all summary: %w", err)}
+	}
+
+	return jSummary, nil
+}
+
+// finishFetchAllRun summarizes a run from its jobs and records it as finished.
+func finishFetchAllRun(db services.DB, runID string) (*fetchAllRunSummary, error) {
+	jobs, err := fetch_jobs.List(db, &fetch_jobs.ListRequest{RunID: runID})
+	if err != nil {
+		return nil, fmt.Errorf("error listing fetch_all run jobs: %w", err)
+	}
+
+	summary := fetchAllRunSummary{
+		RunID:           runID,
+		Errors:          map[uint64]fetchAllJobError{},
+		TeacherStatuses: map[fetchAllUser]bool{},
+		RestStatuses:    map[fetchAllUser]bool{},
+	}
+
+	var students []fetchAllUser
+	for _, j := range *jobs {
+		succeeded := j.Status == fetch_jobs.StatusSucceeded
+		u := fetchAllUserOf(j)
+
+		switch j.Kind {
+		case fetch_jobs.KindTeacher:
+			summary.TeacherStatuses[u] = succeeded
+		case fetch_jobs.KindStudent:
+			students = append(students, u)
+			summary.RestStatuses[u] = succeeded
+		case fetch_jobs.KindObserver:
+			summary.NumObservers++
+			summary.RestStatuses[u] = succeeded
+		default:
+			continue
+		}
+
+		if j.Attempts > 1 {
+			summary.NumRetried++
+		}
+
+		if succeeded {
+			summary.NumSucceeded++
+		} else {
+			summary.Errors[j.ID] = fetchAllJobError{
+				CanvasUserID:  j.CanvasUserID,
+				InstitutionID: j.InstitutionID,
+				Kind:          j.Kind,
+				Error:         j.Error,
+				Transient:     j.ErrorTransient,
+				Attempts:      j.Attempts,
+			}
+		}
+	}
+
+	for _, s := range students {
+		if _, ok := summary.TeacherStatuses[s]; ok {
+			summary.NumBoth++
+		}
+	}
+
+	summary.NumTeachers = len(summary.TeacherStatuses) - summary.NumBoth
+	summary.NumStudents = len(students) - summary.NumBoth
+	summary.NumErrors = len(summary.Errors)
+
+	err = fetch_runs.Finish(db, &fetch_runs.FinishRequest{
+		ID:           runID,
+		NumTeachers:  summary.NumTeachers,
+		NumBoth:      summary.NumBoth,
+		NumStudents:  summary.NumStudents,
+		NumObservers: summary.NumObservers,
+		NumSucceeded: summary.NumSucceeded,
+		NumErrors:    summary.NumErrors,
+		NumRetried:   summary.NumRetried,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error finishing fetch run: %w", err)
+	}
+
+	return &summary, nil
+}
+
+// writeFetchAllLog writes input as JSON to the fetch log sink, if there is one.
+func writeFetchAllLog(input interface{}) error {
+	if fetchLogs == nil {
+		return nil
+	}
+
+	name := time.Now().Format(time.RFC3339) + "-" + string(env.Env) + ".json"
+
+	jRet, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("error marshaling fetch log to json: %w", err)
+	}
+
+	err = fetchLogs.Write(name, jRet)
+	if err != nil {
+		return fmt.Errorf("error writing fetch log: %w", err)
+	}
+
+	return nil
+}
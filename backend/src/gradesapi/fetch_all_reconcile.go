@@ -0,0 +1,119 @@
+package gradesapi
+
+import (
+	"context"
+	"fmt"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/fetch_jobs"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/fetch_runs"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+)
+
+/*
+ReconcileFetchAllRuns fixes unfinished fetch_all runs whose records don't match their jobs.
+
+A server can stop between finishing a job and recording it in fetch_run_users, which leaves the user queued or
+running forever. Those users are updated from their jobs. And if a run's summary job failed for good, nothing
+would ever finish the run, so runs with every job done are finished here.
+*/
+func ReconcileFetchAllRuns(ctx context.Context) error {
+	cdb := services.WithContext(ctx, db)
+
+	runs, err := fetch_runs.List(cdb, &fetch_runs.ListRequest{Unfinished: true})
+	if err != nil {
+		return fmt.Errorf("error listing unfinished fetch runs: %w", err)
+	}
+
+	for _, r := range *runs {
+		err := reconcileFetchAllRun(cdb, r.ID)
+		if err != nil {
+			return fmt.Errorf("error reconciling fetch run %s: %w", r.ID, err)
+		}
+	}
+
+	return nil
+}
+
+func reconcileFetchAllRun(db services.DB, runID string) error {
+	jobs, err := fetch_jobs.List(db, &fetch_jobs.ListRequest{RunID: runID})
+	if err != nil {
+		return fmt.Errorf("error listing fetch_all run jobs: %w", err)
+	}
+
+	users, err := fetch_runs.ListUsers(db, &fetch_runs.ListUsersRequest{RunID: runID})
+	if err != nil {
+		return fmt.Errorf("error listing fetch run users: %w", err)
+	}
+
+	// map[kind]map[fetchAllUser]fetch_runs.User
+	runUsers := map[fetch_jobs.Kind]map[fetchAllUser]fetch_runs.User{}
+	for _, u := range *users {
+		if runUsers[u.Kind] == nil {
+			runUsers[u.Kind] = map[fetchAllUser]fetch_runs.User{}
+		}
+
+		runUsers[u.Kind][fetchAllUser{InstitutionID: u.InstitutionID, CanvasUserID: u.CanvasUserID}] = u
+	}
+
+	var (
+		done          = true
+		summaryFailed bool
+	)
+
+	for _, j := range *jobs {
+		if j.Status == fetch_jobs.StatusQueued || j.Status == fetch_jobs.StatusRunning {
+			done = false
+			continue
+		}
+
+		if j.Kind == fetch_jobs.KindSummary {
+			summaryFailed = j.Status == fetch_jobs.StatusFailed
+			continue
+		}
+
+		u, ok := runUsers[j.Kind][fetchAllUserOf(j)]
+		if !ok || u.Status == j.Status {
+			continue
+		}
+
+		req := fetch_runs.UpdateUserRequest{
+			RunID:         runID,
+			CanvasUserID:  j.CanvasUserID,
+			InstitutionID: j.InstitutionID,
+			Kind:          j.Kind,
+			Status:        j.Status,
+			Attempts:      j.Attempts,
+		}
+
+		if j.Status == fetch_jobs.StatusFailed {
+			req.Error = j.Error
+			// the job's error wasn't categorized if the server stopped before it could be
+			req.ErrorCategory = u.ErrorCategory
+			if len(req.ErrorCategory) < 1 {
+				req.ErrorCategory = fetch_runs.ErrorCategoryInternal
+			}
+		}
+
+		err := fetch_runs.UpdateUser(db, &req)
+		if err != nil {
+			return fmt.Errorf("error updating fetch run user %d: %w", j.CanvasUserID, err)
+		}
+	}
+
+	if !done || !summaryFailed {
+		return nil
+	}
+
+	summary, err := finishFetchAllRun(db, runID)
+	if err != nil {
+		return err
+	}
+
+	// the log is what the summary job failed at most of the time, so it's not worth failing over again
+	err = writeFetchAllLog(summary)
+	if err != nil {
+		util.HandleError(fmt.Errorf("error writing reconciled fetch_all summary log for run %s: %w", runID, err))
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package gradesapi
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	awssession "github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/iamtheyammer/canvascbl/backend/src/env"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// fetchLogSink stores fetch_all logs, like run summaries.
+// Implementations must be safe for concurrent use.
+type fetchLogSink interface {
+	// Write stores a JSON log with a name like "2020-01-02T15:04:05Z-production.json".
+	Write(name string, data []byte) error
+}
+
+// fetchLogs is where fetch_all logs are written, or nil if they aren't.
+var fetchLogs = newFetchLogSink(env.FetchLogSink)
+
+func newFetchLogSink(kind string) fetchLogSink {
+	switch kind {
+	case "s3":
+		return &s3FetchLogSink{bucket: env.FetchLogS3Bucket, region: env.FetchLogS3Region}
+	case "file":
+		return &fileFetchLogSink{dir: env.FetchLogDir}
+	case "stdout":
+		return stdoutFetchLogSink{}
+	case "none", "":
+		return nil
+	default:
+		panic(fmt.Errorf("unknown FETCH_LOG_SINK %q (must be s3, file, stdout or none)", kind))
+	}
+}
+
+// s3FetchLogSink uploads logs to an S3 bucket. Its AWS session isn't created until the first upload,
+// so servers that never write a log don't need AWS credentials.
+type s3FetchLogSink struct {
+	bucket string
+	region string
+
+	once     sync.Once
+	uploader *s3manager.Uploader
+	initErr  error
+}
+
+func (s *s3FetchLogSink) Write(name string, data []byte) error {
+	s.once.Do(func() {
+		sess, err := awssession.NewSession(&aws.Config{Region: aws.String(s.region)})
+		if err != nil {
+			s.initErr = fmt.Errorf("error creating aws session: %w", err)
+			return
+		}
+
+		s.uploader = s3manager.NewUploader(sess)
+	})
+	if s.initErr != nil {
+		return s.initErr
+	}
+
+	_, err := s.uploader.Upload(&s3manager.UploadInput{
+		Bucket:      aws.String(s.bucket),
+		ContentType: aws.String("application/json"),
+		Key:         aws.String(name),
+		Body:        bytes.NewReader(data),
+	})
+	if err != nil {
+		return fmt.Errorf("error uploading %s to s3 bucket %s: %w", name, s.bucket, err)
+	}
+
+	return nil
+}
+
+// fileFetchLogSink writes logs to files in a directory.
+type fileFetchLogSink struct {
+	dir string
+}
+
+func (s *fileFetchLogSink) Write(name string, data []byte) error {
+	err := os.MkdirAll(s.dir, 0755)
+	if err != nil {
+		return fmt.Errorf("error creating fetch log directory %s: %w", s.dir, err)
+	}
+
+	// name is only ever made by us, but Base keeps it in dir anyway
+	path := filepath.Join(s.dir, filepath.Base(name))
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		return fmt.Errorf("error writing fetch log %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// stdoutFetchLogSink prints logs, one per line, after their name.
+type stdoutFetchLogSink struct{}
+
+func (stdoutFetchLogSink) Write(name string, data []byte) error {
+	_, err := fmt.Fprintf(os.Stdout, "fetch log %s: %s\n", name, data)
+	if err != nil {
+		return fmt.Errorf("error printing fetch log %s: %w", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,458 @@
+package gradesapi
+
+import (
+	"fmt"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/drop_cutoffs"
+	gradessvc "github.com/iamtheyammer/canvascbl/backend/src/db/services/grades"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/notifications"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/terms"
+	"github.com/iamtheyammer/canvascbl/backend/src/email"
+	"math"
+	"time"
+)
+
+type gradeForecastTrend string
+
+const (
+	gradeForecastTrendUp     = gradeForecastTrend("up")
+	gradeForecastTrendDown   = gradeForecastTrend("down")
+	gradeForecastTrendSteady = gradeForecastTrend("steady")
+
+	// gradeForecastTrendWindow is how far back grade history is checked for a course's recent trend.
+	gradeForecastTrendWindow = time.Hour * 24 * 14
+)
+
+/*
+gradeForecast projects a course's grade to the end of its term, assuming each outcome's
+scores keep following their trend.
+*/
+type gradeForecast struct {
+	// ProjectedAt is the end of the course's term
+	ProjectedAt time.Time `json:"projected_at"`
+	// Projected is the expected grade at ProjectedAt
+	Projected grade `json:"projected"`
+	// Low and High are the projected grade if every outcome ends up one standard deviation
+	// under or over its trend.
+	Low  grade `json:"low"`
+	High grade `json:"high"`
+	// RecentTrend is the direction of the course's latest grade change in the last two weeks
+	RecentTrend gradeForecastTrend `json:"recent_trend"`
+	// LikelyToDrop is whether the grade is likely to be at least one band lower at ProjectedAt
+	LikelyToDrop bool `json:"likely_to_drop"`
+}
+
+// gradeForecastInputs holds what's needed to forecast grades that isn't in the outcome results.
+type gradeForecastInputs struct {
+	// CourseEnds is map[courseID<uint64>]endOfTerm<time.Time>
+	CourseEnds map[uint64]time.Time
+	// RecentChanges is map[canvasUserID<uint64>][]gradeChange, oldest first
+	RecentChanges map[uint64][]gradeChange
+	Cutoffs       *drop_cutoffs.Set
+	// InstitutionID is the institution of the courses and users, or 0 for the default Canvas instance
+	InstitutionID uint64
+	Now           time.Time
+}
+
+/*
+getGradeForecastInputs gets term ends for cs and recent grade changes for userIDs at an institution.
+
+Term ends come from stored terms, falling back to the course's end date in Canvas.
+Courses without either can't be forecasted, so they're left out.
+*/
+func getGradeForecastInputs(
+	institutionID uint64,
+	cs []canvasCourse,
+	userIDs []uint64,
+	cutoffs *drop_cutoffs.Set,
+) (*gradeForecastInputs, error) {
+	now := time.Now()
+	in := gradeForecastInputs{
+		CourseEnds:    make(map[uint64]time.Time, len(cs)),
+		RecentChanges: make(map[uint64][]gradeChange, len(userIDs)),
+		Cutoffs:       cutoffs,
+		InstitutionID: institutionID,
+		Now:           now,
+	}
+
+	var termIDs []uint64
+	for _, c := range cs {
+		if c.EnrollmentTermID > 0 {
+			termIDs = append(termIDs, uint64(c.EnrollmentTermID))
+		}
+	}
+
+	// map[canvasEnrollmentTermID]endAt
+	termEnds := make(map[uint64]time.Time)
+	if len(termIDs) > 0 {
+		ts, err := terms.List(db, &terms.ListRequest{
+			CanvasEnrollmentTermIDs: termIDs,
+			InstitutionID:           &institutionID,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error listing terms for grade forecasts: %w", err)
+		}
+
+		for _, t := range *ts {
+			if end, ok := termEnds[t.CanvasEnrollmentTermID]; !ok || t.EndAt.After(end) {
+				termEnds[t.CanvasEnrollmentTermID] = t.EndAt
+			}
+		}
+	}
+
+	for _, c := range cs {
+		if end, ok := termEnds[uint64(c.EnrollmentTermID)]; ok {
+			in.CourseEnds[c.ID] = end
+			continue
+		}
+
+		if end, err := time.Parse(time.RFC3339, c.EndAt); err == nil {
+			in.CourseEnds[c.ID] = end
+		}
+	}
+
+	since := now.Add(-gradeForecastTrendWindow)
+	for _, uID := range userIDs {
+		previous, err := gradessvc.List(db, &gradessvc.ListRequest{
+			UserCanvasIDs: &[]uint64{uID},
+			Before:        &since,
+			InstitutionID: &institutionID,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error listing grades before recent grade history for grade forecasts: %w", err)
+		}
+
+		gs, err := gradessvc.ListHistory(db, &gradessvc.ListHistoryRequest{
+			UserCanvasID:  uID,
+			InstitutionID: &institutionID,
+			After:         &since,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error listing recent grade history for grade forecasts: %w", err)
+		}
+
+		in.RecentChanges[uID] = gradeChangesFromGrades(*previous, *gs)
+	}
+
+	return &in, nil
+}
+
+/*
+forecastGrade forecasts current, a grade calculated from results with calcs and policy, for a
+user in c. It returns nil if the course's term end isn't known or has passed, or if current is N/A.
+
+Each outcome's scores are fit to a line over time. Future scores are placed along that line
+until the end of the term, at the rate the outcome has been assessed so far, then the grade is
+calculated again with them.
+*/
+func forecastGrade(
+	in *gradeForecastInputs,
+	c canvasCourse,
+	canvasUserID uint64,
+	results map[uint64][]canvasOutcomeResult,
+	calcs outcomeCalculations,
+	policy gradingPolicy,
+	current computedGrade,
+) *gradeForecast {
+	end, ok := in.CourseEnds[c.ID]
+	if !ok || !end.After(in.Now) || current.Grade.Rank == naGrade.Rank {
+		return nil
+	}
+
+	isAfterCutoff := false
+	if in.Cutoffs != nil {
+		if dc := in.Cutoffs.ForCourse(in.InstitutionID, c.ID, uint64(c.EnrollmentTermID), end); dc != nil {
+			isAfterCutoff = dc.InEffect(end)
+		}
+	}
+
+	projected := make(map[uint64][]canvasOutcomeResult, len(results))
+	low := make(map[uint64][]canvasOutcomeResult, len(results))
+	high := make(map[uint64][]canvasOutcomeResult, len(results))
+	for oID, rs := range results {
+		p, l, h := projectOutcomeResults(rs, in.Now, end)
+		projected[oID] = p
+		low[oID] = l
+		high[oID] = h
+	}
+
+	f := gradeForecast{
+		ProjectedAt: end,
+		Projected:   calculateGradeFromOutcomeResults(projected, calcs, policy, isAfterCutoff).Grade,
+		Low:         calculateGradeFromOutcomeResults(low, calcs, policy, isAfterCutoff).Grade,
+		High:        calculateGradeFromOutcomeResults(high, calcs, policy, isAfterCutoff).Grade,
+		RecentTrend: recentGradeTrend(in.RecentChanges[canvasUserID], c.ID, policy),
+	}
+
+	f.LikelyToDrop = f.Projected.Rank < current.Grade.Rank ||
+		(f.Low.Rank < current.Grade.Rank && f.RecentTrend == gradeForecastTrendDown)
+
+	return &f
+}
+
+/*
+projectOutcomeResults adds projected future results to rs, returning results projected along the
+trend, one standard deviation under it and one over it.
+
+Scores are kept between zero and the most points possible.
+*/
+func projectOutcomeResults(rs []canvasOutcomeResult, now time.Time, end time.Time) (
+	projected []canvasOutcomeResult,
+	low []canvasOutcomeResult,
+	high []canvasOutcomeResult,
+) {
+	projected = append([]canvasOutcomeResult{}, rs...)
+	low = append([]canvasOutcomeResult{}, rs...)
+	high = append([]canvasOutcomeResult{}, rs...)
+
+	if len(rs) < 1 {
+		return
+	}
+
+	var (
+		possible float64
+		// days since the first dated result
+		xs []float64
+		ys []float64
+		// first is the time of the first dated result
+		first time.Time
+	)
+	for _, r := range outcomeResultsByAssessedAt(rs) {
+		possible = math.Max(possible, math.Max(r.Possible, r.Score))
+
+		at, err := time.Parse(time.RFC3339, r.SubmittedOrAssessedAt)
+		if err != nil {
+			continue
+		}
+
+		if len(xs) < 1 {
+			first = at
+		}
+
+		xs = append(xs, at.Sub(first).Hours()/24)
+		ys = append(ys, r.Score)
+	}
+
+	// without a trend, scores are expected to stay around their average
+	var scores []float64
+	for _, r := range rs {
+		scores = append(scores, r.Score)
+	}
+	intercept, slope, deviation := averageOf(scores), 0.0, standardDeviationOf(scores)
+	numFuture := 1
+
+	if len(xs) > 1 && xs[len(xs)-1] > 0 {
+		intercept, slope, deviation = linearRegression(xs, ys)
+
+		// future results come at the same rate as past ones
+		perDay := float64(len(xs)-1) / xs[len(xs)-1]
+		numFuture = int(math.Round(perDay * end.Sub(now).Hours() / 24))
+		if numFuture < 1 {
+			numFuture = 1
+		} else if numFuture > len(rs) {
+			// don't let a few outcomes assessed in a burst overwhelm the rest
+			numFuture = len(rs)
+		}
+	} else {
+		first = now
+	}
+
+	clamp := func(score float64) float64 {
+		return math.Min(possible, math.Max(0, score))
+	}
+
+	remaining := end.Sub(now)
+	for i := 1; i <= numFuture; i++ {
+		at := now.Add(remaining * time.Duration(i) / time.Duration(numFuture))
+		score := intercept + slope*at.Sub(first).Hours()/24
+
+		r := canvasOutcomeResult{
+			Possible:              possible,
+			SubmittedOrAssessedAt: at.Format(time.RFC3339),
+		}
+
+		r.Score = clamp(score)
+		projected = append(projected, r)
+
+		r.Score = clamp(score - deviation)
+		low = append(low, r)
+
+		r.Score = clamp(score + deviation)
+		high = append(high, r)
+	}
+
+	return
+}
+
+/*
+linearRegression fits ys to a line over xs with least squares, returning the line and the
+standard deviation of the points from it. xs must have at least two different values.
+*/
+func linearRegression(xs []float64, ys []float64) (intercept float64, slope float64, deviation float64) {
+	meanX, meanY := averageOf(xs), averageOf(ys)
+
+	var sxx, sxy float64
+	for i := range xs {
+		sxx += (xs[i] - meanX) * (xs[i] - meanX)
+		sxy += (xs[i] - meanX) * (ys[i] - meanY)
+	}
+
+	slope = sxy / sxx
+	intercept = meanY - slope*meanX
+
+	var residuals []float64
+	for i := range xs {
+		residuals = append(residuals, ys[i]-(intercept+slope*xs[i]))
+	}
+
+	// two points always fit a line, so there's no deviation to measure
+	if len(xs) > 2 {
+		var sse float64
+		for _, r := range residuals {
+			sse += r * r
+		}
+
+		deviation = math.Sqrt(sse / float64(len(xs)-2))
+	}
+
+	return
+}
+
+// standardDeviationOf returns the population standard deviation of fs. fs must not be empty.
+func standardDeviationOf(fs []float64) float64 {
+	mean := averageOf(fs)
+
+	var total float64
+	for _, f := range fs {
+		total += (f - mean) * (f - mean)
+	}
+
+	return math.Sqrt(total / float64(len(fs)))
+}
+
+// recentGradeTrend is the direction of the latest change in changes for a course.
+func recentGradeTrend(changes []gradeChange, courseID uint64, policy gradingPolicy) gradeForecastTrend {
+	rank := func(g string) (int8, bool) {
+		for _, pg := range policy.Grades {
+			if pg.Grade == g {
+				return pg.Rank, true
+			}
+		}
+
+		return 0, false
+	}
+
+	for i := len(changes) - 1; i >= 0; i-- {
+		ch := changes[i]
+		if ch.CourseID != courseID {
+			continue
+		}
+
+		from, fromOK := rank(ch.From)
+		to, toOK := rank(ch.To)
+		if !fromOK || !toOK {
+			return gradeForecastTrendSteady
+		}
+
+		switch {
+		case to > from:
+			return gradeForecastTrendUp
+		case to < from:
+			return gradeForecastTrendDown
+		}
+
+		return gradeForecastTrendSteady
+	}
+
+	return gradeForecastTrendSteady
+}
+
+/*
+sendGradeDropForecastNotifications emails the user resp is for, at an institution, about courses where
+a grade is likely to drop. resp must have detailed grades with forecasts.
+
+A notification isn't sent again for a course until its grade or projected grade changes.
+*/
+func sendGradeDropForecastNotifications(resp *UserGradesResponse, institutionID uint64) error {
+	var userIDs []uint64
+	for uID := range resp.DetailedGrades {
+		userIDs = append(userIDs, uID)
+	}
+
+	if len(userIDs) < 1 || resp.UserProfile == nil {
+		return nil
+	}
+
+	sent, err := notifications.ListGradeForecasts(db, &notifications.ListGradeForecastsRequest{
+		CanvasUserIDs: userIDs,
+		InstitutionID: institutionID,
+	})
+	if err != nil {
+		return fmt.Errorf("error listing sent grade forecast notifications: %w", err)
+	}
+
+	// map[canvasUserID<uint64>]map[courseID<uint64>]notifications.GradeForecast
+	lastSent := make(map[uint64]map[uint64]notifications.GradeForecast)
+	for _, s := range *sent {
+		if lastSent[s.CanvasUserID] == nil {
+			lastSent[s.CanvasUserID] = make(map[uint64]notifications.GradeForecast)
+		}
+
+		lastSent[s.CanvasUserID][s.CourseID] = s
+	}
+
+	courseNames := make(map[uint64]string)
+	if resp.Courses != nil {
+		for _, c := range *resp.Courses {
+			courseNames[c.ID] = c.Name
+		}
+	}
+
+	pr := *resp.UserProfile
+	userIsObserver := resp.Observees != nil && len(*resp.Observees) > 0
+
+	for uID, cs := range resp.DetailedGrades {
+		for cID, g := range cs {
+			f := g.Forecast
+			if f == nil || !f.LikelyToDrop {
+				continue
+			}
+
+			if s, ok := lastSent[uID][cID]; ok && s.Grade == g.Grade.Grade && s.ProjectedGrade == f.Projected.Grade {
+				continue
+			}
+
+			data := email.GradeDropForecastEmailData{
+				To:             pr.PrimaryEmail,
+				Name:           pr.Name,
+				ClassName:      courseNames[cID],
+				CurrentGrade:   g.Grade.Grade,
+				ProjectedGrade: f.Projected.Grade,
+				ProjectedAt:    f.ProjectedAt.Format("January 2"),
+			}
+
+			if userIsObserver {
+				for _, o := range *resp.Observees {
+					if o.ID == uID {
+						data.StudentName = o.Name
+						break
+					}
+				}
+			}
+
+			err := notifications.InsertGradeForecast(db, &notifications.InsertGradeForecastRequest{
+				CanvasUserID:   uID,
+				CourseID:       cID,
+				Grade:          g.Grade.Grade,
+				ProjectedGrade: f.Projected.Grade,
+				InstitutionID:  institutionID,
+			})
+			if err != nil {
+				return fmt.Errorf("error inserting grade forecast notification: %w", err)
+			}
+
+			go email.SendGradeDropForecastEmail(&data)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,214 @@
+package gradesapi
+
+import (
+	"context"
+	"fmt"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	coursessvc "github.com/iamtheyammer/canvascbl/backend/src/db/services/courses"
+	gradessvc "github.com/iamtheyammer/canvascbl/backend/src/db/services/grades"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/notifications"
+	userssvc "github.com/iamtheyammer/canvascbl/backend/src/db/services/users"
+	"github.com/iamtheyammer/canvascbl/backend/src/email"
+	"github.com/iamtheyammer/canvascbl/backend/src/env"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"sort"
+	"time"
+)
+
+const (
+	// gradeDigestPeriod is how far back a user's first digest looks for grade changes.
+	gradeDigestPeriod = 7 * 24 * time.Hour
+	// gradeDigestMinInterval is how long after a digest another one can be sent, so a re-run doesn't send it twice.
+	gradeDigestMinInterval = 24 * time.Hour
+)
+
+/*
+SendGradeDigests emails every user who wants grade digests their current grades (and their observees'), along with
+what each grade was at their last digest.
+
+Grades come from the database, so they're as fresh as the last fetch_all. One user's failure doesn't stop the others.
+*/
+func SendGradeDigests(ctx context.Context) error {
+	if len(env.SendGridGradeDigestTemplateID) < 1 {
+		return nil
+	}
+
+	cdb := services.WithContext(ctx, db)
+
+	settings, err := notifications.ListSettings(cdb, &notifications.ListSettingsRequest{
+		Type:   notifications.TypeGradeDigest,
+		Medium: notifications.MediumEmail,
+	})
+	if err != nil {
+		return fmt.Errorf("error listing grade digest notification settings: %w", err)
+	}
+
+	numErrors := 0
+	for _, s := range *settings {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		err := sendGradeDigest(cdb, s)
+		if err != nil {
+			util.HandleError(fmt.Errorf("error sending grade digest to user %d: %w", s.UserID, err))
+			numErrors++
+		}
+	}
+
+	if numErrors > 0 {
+		return fmt.Errorf("%d of %d grade digests couldn't be sent", numErrors, len(*settings))
+	}
+
+	return nil
+}
+
+// sendGradeDigest sends a grade digest to the user with s.
+func sendGradeDigest(db services.DB, s notifications.Setting) error {
+	now := time.Now()
+	since := now.Add(-gradeDigestPeriod)
+
+	lastSent, err := notifications.ListGradeDigests(db, &notifications.ListGradeDigestsRequest{
+		CanvasUserIDs: []uint64{s.CanvasUserID},
+		InstitutionID: s.InstitutionID,
+	})
+	if err != nil {
+		return fmt.Errorf("error listing sent grade digests: %w", err)
+	}
+
+	if len(*lastSent) > 0 {
+		last := (*lastSent)[0].InsertedAt
+		if now.Sub(last) < gradeDigestMinInterval {
+			return nil
+		}
+
+		since = last
+	}
+
+	us, err := userssvc.List(db, &userssvc.ListRequest{ID: s.UserID})
+	if err != nil {
+		return fmt.Errorf("error listing user: %w", err)
+	}
+
+	if len(*us) < 1 {
+		return nil
+	}
+
+	user := (*us)[0]
+
+	obs, err := userssvc.ListObservees(db, &userssvc.ListObserveesRequest{
+		ObserverCanvasUserID: s.CanvasUserID,
+		InstitutionID:        s.InstitutionID,
+		ActiveOnly:           true,
+	})
+	if err != nil {
+		return fmt.Errorf("error listing observees: %w", err)
+	}
+
+	userIDs := []uint64{s.CanvasUserID}
+	studentNames := map[uint64]string{}
+	for _, o := range *obs {
+		userIDs = append(userIDs, o.CanvasUserID)
+		studentNames[o.CanvasUserID] = o.Name
+	}
+
+	current, err := gradessvc.List(db, &gradessvc.ListRequest{
+		UserCanvasIDs: &userIDs,
+		InstitutionID: &s.InstitutionID,
+	})
+	if err != nil {
+		return fmt.Errorf("error listing current grades: %w", err)
+	}
+
+	if len(*current) < 1 {
+		return nil
+	}
+
+	previous, err := gradessvc.List(db, &gradessvc.ListRequest{
+		UserCanvasIDs: &userIDs,
+		InstitutionID: &s.InstitutionID,
+		Before:        &since,
+	})
+	if err != nil {
+		return fmt.Errorf("error listing previous grades: %w", err)
+	}
+
+	// map[userID]map[courseID]grade
+	prev := map[uint64]map[uint64]string{}
+	for _, g := range *previous {
+		if prev[g.UserCanvasID] == nil {
+			prev[g.UserCanvasID] = map[uint64]string{}
+		}
+
+		prev[g.UserCanvasID][g.CourseID] = g.Grade
+	}
+
+	var courseIDs []uint64
+	for _, g := range *current {
+		courseIDs = append(courseIDs, g.CourseID)
+	}
+
+	cs, err := coursessvc.List(db, &coursessvc.ListRequest{
+		CourseIDs:     courseIDs,
+		InstitutionID: s.InstitutionID,
+	})
+	if err != nil {
+		return fmt.Errorf("error listing courses: %w", err)
+	}
+
+	termID, err := currentEnrollmentTermID(s.InstitutionID)
+	if err != nil {
+		return fmt.Errorf("error getting current enrollment term id: %w", err)
+	}
+
+	// only courses in the current term or later, like the ones fetch_all fetches
+	courseNames := map[uint64]string{}
+	for _, c := range *cs {
+		if int(c.EnrollmentTermID) >= termID {
+			courseNames[c.CourseID] = c.Name
+		}
+	}
+
+	var grades []email.GradeDigestGrade
+	for _, g := range *current {
+		name, ok := courseNames[g.CourseID]
+		if !ok || g.Grade == naGrade.Grade {
+			continue
+		}
+
+		grades = append(grades, email.GradeDigestGrade{
+			StudentName:   studentNames[g.UserCanvasID],
+			ClassName:     name,
+			Grade:         g.Grade,
+			PreviousGrade: prev[g.UserCanvasID][g.CourseID],
+		})
+	}
+
+	if len(grades) < 1 {
+		return nil
+	}
+
+	sort.Slice(grades, func(i, j int) bool {
+		if grades[i].StudentName != grades[j].StudentName {
+			return grades[i].StudentName < grades[j].StudentName
+		}
+
+		return grades[i].ClassName < grades[j].ClassName
+	})
+
+	err = notifications.InsertGradeDigest(db, &notifications.InsertGradeDigestRequest{
+		CanvasUserID:  s.CanvasUserID,
+		InstitutionID: s.InstitutionID,
+	})
+	if err != nil {
+		return fmt.Errorf("error inserting grade digest notification: %w", err)
+	}
+
+	go email.SendGradeDigestEmail(&email.GradeDigestEmailData{
+		To:     user.Email,
+		Name:   user.Name,
+		Grades: grades,
+	})
+
+	return nil
+}
@@ -0,0 +1,277 @@
+package gradesapi
+
+import (
+	"fmt"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/gpas"
+	gradessvc "github.com/iamtheyammer/canvascbl/backend/src/db/services/grades"
+	"github.com/iamtheyammer/canvascbl/backend/src/oauth2"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	gradesErrorNotObservingUserGradeHistory = "you can only see grade history for yourself and users you observe"
+
+	// gradeHistoryDefaultRange is how far back history goes when start isn't specified.
+	gradeHistoryDefaultRange = time.Hour * 24 * 90
+)
+
+// gradeChange is a course's grade changing. Repeated fetches of the same grade aren't changes.
+type gradeChange struct {
+	CourseID uint64 `json:"course_id"`
+	// From is the grade before the change. Empty if it's the first grade stored for the course.
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	ChangedAt time.Time `json:"changed_at"`
+	// ManualFetch is whether the change was found by the user fetching grades, rather than a background fetch
+	ManualFetch bool `json:"manual_fetch"`
+}
+
+// gpaHistoryPoint is a GPA at a point in time. Points are only included when the GPA changes.
+type gpaHistoryPoint struct {
+	GPA         gpaValues `json:"gpa"`
+	RecordedAt  time.Time `json:"recorded_at"`
+	ManualFetch bool      `json:"manual_fetch"`
+}
+
+type gradeHistoryResponse struct {
+	UserID uint64    `json:"user_id"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	// Changes are sorted oldest first
+	Changes []gradeChange `json:"changes"`
+	GPA     struct {
+		Unweighted []gpaHistoryPoint `json:"unweighted"`
+		Weighted   []gpaHistoryPoint `json:"weighted"`
+	} `json:"gpa"`
+}
+
+// GradeHistoryHandler handles /api/v1/grades/history
+func GradeHistoryHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	q := r.URL.Query()
+
+	var requestedUserID uint64
+	if uID := q.Get("user_id"); len(uID) > 0 {
+		id, err := strconv.Atoi(uID)
+		if err != nil || id < 1 {
+			util.SendBadRequest(w, "invalid user_id as query param")
+			return
+		}
+
+		requestedUserID = uint64(id)
+	}
+
+	var courseIDs []uint64
+	for _, cID := range q["course_id[]"] {
+		id, err := strconv.Atoi(cID)
+		if err != nil || id < 1 {
+			util.SendBadRequest(w, "invalid course_id[] as query param")
+			return
+		}
+
+		courseIDs = append(courseIDs, uint64(id))
+	}
+
+	end := time.Now()
+	if e := q.Get("end"); len(e) > 0 {
+		t, err := time.Parse(time.RFC3339, e)
+		if err != nil {
+			util.SendBadRequest(w, "invalid end as query param (must be RFC 3339)")
+			return
+		}
+
+		end = t
+	}
+
+	start := end.Add(-gradeHistoryDefaultRange)
+	if s := q.Get("start"); len(s) > 0 {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			util.SendBadRequest(w, "invalid start as query param (must be RFC 3339)")
+			return
+		}
+
+		start = t
+	}
+
+	if !start.Before(end) {
+		util.SendBadRequest(w, "start must be before end")
+		return
+	}
+
+	userID, rdP, sess, errCtx := authorizer(w, r, []oauth2.Scope{oauth2.ScopeGradeHistory}, &oauth2.AuthorizerAPICall{
+		Method:    "GET",
+		RoutePath: "grades/history",
+		Query:     &r.URL.RawQuery,
+	})
+	if (userID == nil || rdP == nil || errCtx == nil) && sess == nil {
+		return
+	}
+
+	errCtx.AddCustomField("user_id", requestedUserID)
+
+	selfCanvasUserID, err := canvasUserIDForGradeRequest(*userID, 0)
+	if err != nil {
+		handleISE(w, errCtx.Apply(fmt.Errorf("error getting canvas user id for grade history: %w", err)))
+		return
+	}
+
+	canvasUserID := selfCanvasUserID
+	if requestedUserID > 0 && requestedUserID != selfCanvasUserID {
+		isObserving, err := isObservingUser(rdP.InstitutionID, selfCanvasUserID, requestedUserID)
+		if err != nil {
+			handleISE(w, errCtx.Apply(fmt.Errorf("error checking observees for grade history: %w", err)))
+			return
+		}
+
+		if !isObserving {
+			handleError(w, GradesErrorResponse{
+				Error: gradesErrorNotObservingUserGradeHistory,
+			}, http.StatusForbidden)
+			return
+		}
+
+		canvasUserID = requestedUserID
+	}
+
+	h, err := getGradeHistory(rdP.InstitutionID, canvasUserID, courseIDs, start, end)
+	if err != nil {
+		handleISE(w, errCtx.Apply(fmt.Errorf("error getting grade history: %w", err)))
+		return
+	}
+
+	sendJSON(w, h)
+	return
+}
+
+/*
+getGradeHistory gets a user's grade changes and GPAs from start (inclusive) to end (exclusive). Grades are from the
+user's institution (0 for the default Canvas instance).
+
+If courseIDs is empty, every course is included.
+*/
+func getGradeHistory(
+	institutionID uint64,
+	canvasUserID uint64,
+	courseIDs []uint64,
+	start, end time.Time,
+) (*gradeHistoryResponse, error) {
+	// the latest grade before start is where each course's history starts from
+	listReq := gradessvc.ListRequest{
+		UserCanvasIDs: &[]uint64{canvasUserID},
+		Before:        &start,
+		InstitutionID: &institutionID,
+	}
+	if len(courseIDs) > 0 {
+		listReq.CourseIDs = &courseIDs
+	}
+
+	previous, err := gradessvc.List(db, &listReq)
+	if err != nil {
+		return nil, fmt.Errorf("error listing grades before history start: %w", err)
+	}
+
+	gs, err := gradessvc.ListHistory(db, &gradessvc.ListHistoryRequest{
+		UserCanvasID:  canvasUserID,
+		InstitutionID: &institutionID,
+		CourseIDs:     courseIDs,
+		After:         &start,
+		Before:        &end,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing grade history: %w", err)
+	}
+
+	gpaHistory, err := gpas.List(db, &gpas.ListRequest{
+		CanvasUserIDs: []uint64{canvasUserID},
+		InstitutionID: institutionID,
+		After:         &start,
+		Before:        &end,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing gpa history: %w", err)
+	}
+
+	resp := gradeHistoryResponse{
+		UserID:  canvasUserID,
+		Start:   start,
+		End:     end,
+		Changes: gradeChangesFromGrades(*previous, *gs),
+	}
+
+	resp.GPA.Unweighted, resp.GPA.Weighted = gpaHistoryFromGPAs(*gpaHistory)
+
+	return &resp, nil
+}
+
+/*
+gradeChangesFromGrades collapses stored grades into changes, oldest first.
+
+previous should have the latest grade before gs for each course. gs must be sorted by course, then oldest first,
+like gradessvc.ListHistory returns.
+*/
+func gradeChangesFromGrades(previous []gradessvc.Grade, gs []gradessvc.Grade) []gradeChange {
+	// map[courseID]grade
+	last := make(map[uint64]string, len(previous))
+	for _, g := range previous {
+		last[g.CourseID] = g.Grade
+	}
+
+	changes := []gradeChange{}
+	for _, g := range gs {
+		from, ok := last[g.CourseID]
+		if ok && from == g.Grade {
+			continue
+		}
+
+		changes = append(changes, gradeChange{
+			CourseID:    g.CourseID,
+			From:        from,
+			To:          g.Grade,
+			ChangedAt:   g.InsertedAt,
+			ManualFetch: g.ManualFetch,
+		})
+		last[g.CourseID] = g.Grade
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].ChangedAt.Before(changes[j].ChangedAt)
+	})
+
+	return changes
+}
+
+// gpaHistoryFromGPAs splits stored GPAs into unweighted and weighted series, leaving out repeats.
+// gs must be sorted oldest first.
+func gpaHistoryFromGPAs(gs []gpas.GPA) (unweighted []gpaHistoryPoint, weighted []gpaHistoryPoint) {
+	unweighted = []gpaHistoryPoint{}
+	weighted = []gpaHistoryPoint{}
+
+	for _, g := range gs {
+		series := &unweighted
+		if g.Weighted {
+			series = &weighted
+		}
+
+		v := gpaValues{
+			Default:   g.GPA,
+			Subgrades: g.GPAWithSubgrades,
+		}
+
+		if n := len(*series); n > 0 && (*series)[n-1].GPA == v {
+			continue
+		}
+
+		*series = append(*series, gpaHistoryPoint{
+			GPA:         v,
+			RecordedAt:  g.InsertedAt,
+			ManualFetch: g.ManualFetch,
+		})
+	}
+
+	return
+}
@@ -0,0 +1,149 @@
+package gradesapi
+
+import (
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/gpas"
+	gradessvc "github.com/iamtheyammer/canvascbl/backend/src/db/services/grades"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// historyTime is a time a few hours into 2020, for tests.
+func historyTime(hours int) time.Time {
+	return time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(hours) * time.Hour)
+}
+
+func Test_gradeChangesFromGrades(t *testing.T) {
+	gradeOf := func(courseID uint64, g string, hours int) gradessvc.Grade {
+		return gradessvc.Grade{CourseID: courseID, Grade: g, InsertedAt: historyTime(hours)}
+	}
+
+	tests := []struct {
+		name     string
+		previous []gradessvc.Grade
+		gs       []gradessvc.Grade
+		want     []gradeChange
+	}{
+		{
+			name: "no_grades",
+			want: []gradeChange{},
+		},
+		{
+			name: "first_grade_without_previous",
+			gs:   []gradessvc.Grade{gradeOf(1, "A", 1)},
+			want: []gradeChange{{CourseID: 1, From: "", To: "A", ChangedAt: historyTime(1)}},
+		},
+		{
+			name:     "first_grade_same_as_previous",
+			previous: []gradessvc.Grade{gradeOf(1, "A", 0)},
+			gs:       []gradessvc.Grade{gradeOf(1, "A", 1)},
+			want:     []gradeChange{},
+		},
+		{
+			name:     "first_grade_different_from_previous",
+			previous: []gradessvc.Grade{gradeOf(1, "B", 0)},
+			gs:       []gradessvc.Grade{gradeOf(1, "A", 1)},
+			want:     []gradeChange{{CourseID: 1, From: "B", To: "A", ChangedAt: historyTime(1)}},
+		},
+		{
+			name: "repeated_grades",
+			gs: []gradessvc.Grade{
+				gradeOf(1, "A", 1),
+				gradeOf(1, "A", 2),
+				gradeOf(1, "B", 3),
+				gradeOf(1, "B", 4),
+				gradeOf(1, "A", 5),
+			},
+			want: []gradeChange{
+				{CourseID: 1, From: "", To: "A", ChangedAt: historyTime(1)},
+				{CourseID: 1, From: "A", To: "B", ChangedAt: historyTime(3)},
+				{CourseID: 1, From: "B", To: "A", ChangedAt: historyTime(5)},
+			},
+		},
+		{
+			name:     "several_courses_sorted_by_time",
+			previous: []gradessvc.Grade{gradeOf(2, "C", 0)},
+			gs: []gradessvc.Grade{
+				gradeOf(1, "A", 2),
+				gradeOf(1, "B", 4),
+				gradeOf(2, "C", 1),
+				gradeOf(2, "B", 3),
+			},
+			want: []gradeChange{
+				{CourseID: 1, From: "", To: "A", ChangedAt: historyTime(2)},
+				{CourseID: 2, From: "C", To: "B", ChangedAt: historyTime(3)},
+				{CourseID: 1, From: "A", To: "B", ChangedAt: historyTime(4)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := gradeChangesFromGrades(tt.previous, tt.gs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("gradeChangesFromGrades() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_gpaHistoryFromGPAs(t *testing.T) {
+	gpaOf := func(weighted bool, gpa, subgrades float64, hours int) gpas.GPA {
+		return gpas.GPA{Weighted: weighted, GPA: gpa, GPAWithSubgrades: subgrades, InsertedAt: historyTime(hours)}
+	}
+	pointOf := func(gpa, subgrades float64, hours int) gpaHistoryPoint {
+		return gpaHistoryPoint{GPA: gpaValues{Default: gpa, Subgrades: subgrades}, RecordedAt: historyTime(hours)}
+	}
+
+	tests := []struct {
+		name           string
+		gs             []gpas.GPA
+		wantUnweighted []gpaHistoryPoint
+		wantWeighted   []gpaHistoryPoint
+	}{
+		{
+			name:           "no_gpas",
+			wantUnweighted: []gpaHistoryPoint{},
+			wantWeighted:   []gpaHistoryPoint{},
+		},
+		{
+			name:           "first_gpa",
+			gs:             []gpas.GPA{gpaOf(false, 3.5, 3.6, 1)},
+			wantUnweighted: []gpaHistoryPoint{pointOf(3.5, 3.6, 1)},
+			wantWeighted:   []gpaHistoryPoint{},
+		},
+		{
+			name: "repeated_gpas",
+			gs: []gpas.GPA{
+				gpaOf(false, 3.5, 3.6, 1),
+				gpaOf(false, 3.5, 3.6, 2),
+				gpaOf(false, 3.5, 3.7, 3),
+				gpaOf(false, 3.5, 3.7, 4),
+				gpaOf(false, 3.5, 3.6, 5),
+			},
+			wantUnweighted: []gpaHistoryPoint{pointOf(3.5, 3.6, 1), pointOf(3.5, 3.7, 3), pointOf(3.5, 3.6, 5)},
+			wantWeighted:   []gpaHistoryPoint{},
+		},
+		{
+			name: "weighted_and_unweighted_repeat_separately",
+			gs: []gpas.GPA{
+				gpaOf(false, 3.5, 3.6, 1),
+				gpaOf(true, 3.5, 3.6, 1),
+				gpaOf(false, 3.5, 3.6, 2),
+				gpaOf(true, 4, 4.1, 2),
+				gpaOf(true, 4, 4.1, 3),
+			},
+			wantUnweighted: []gpaHistoryPoint{pointOf(3.5, 3.6, 1)},
+			wantWeighted:   []gpaHistoryPoint{pointOf(3.5, 3.6, 1), pointOf(4, 4.1, 2)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotUnweighted, gotWeighted := gpaHistoryFromGPAs(tt.gs)
+			if !reflect.DeepEqual(gotUnweighted, tt.wantUnweighted) {
+				t.Errorf("gpaHistoryFromGPAs() unweighted = %v, want %v", gotUnweighted, tt.wantUnweighted)
+			}
+			if !reflect.DeepEqual(gotWeighted, tt.wantWeighted) {
+				t.Errorf("gpaHistoryFromGPAs() weighted = %v, want %v", gotWeighted, tt.wantWeighted)
+			}
+		})
+	}
+}
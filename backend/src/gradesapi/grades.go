@@ -1,31 +1,24 @@
 package gradesapi
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
-	awssession "github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
-	"github.com/iamtheyammer/canvascbl/backend/src/db/services/canvas_tokens"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
 	coursessvc "github.com/iamtheyammer/canvascbl/backend/src/db/services/courses"
 	"github.com/iamtheyammer/canvascbl/backend/src/db/services/enrollments"
 	"github.com/iamtheyammer/canvascbl/backend/src/db/services/gpas"
 	gradessvc "github.com/iamtheyammer/canvascbl/backend/src/db/services/grades"
-	"github.com/iamtheyammer/canvascbl/backend/src/db/services/notifications"
 	"github.com/iamtheyammer/canvascbl/backend/src/db/services/sessions"
 	"github.com/iamtheyammer/canvascbl/backend/src/db/services/submissions"
 	"github.com/iamtheyammer/canvascbl/backend/src/db/services/users"
-	"github.com/iamtheyammer/canvascbl/backend/src/email"
-	"github.com/iamtheyammer/canvascbl/backend/src/env"
 	"github.com/iamtheyammer/canvascbl/backend/src/oauth2"
 	"github.com/iamtheyammer/canvascbl/backend/src/util"
 	"github.com/julienschmidt/httprouter"
 	"net/http"
 	"strconv"
 	"sync"
-	"time"
 )
 
 var (
@@ -34,16 +27,10 @@ var (
 		Error:      gradesErrorUnknownCanvasError,
 		StatusCode: util.CanvasProxyErrorCode,
 	}
-	s3Uploader = func() *s3manager.Uploader {
-		s, err := awssession.NewSession(&aws.Config{Region: aws.String("us-east-2")})
-		if err != nil {
-			panic(fmt.Errorf("error creating aws session: %w", err))
-		}
-
-		ul := s3manager.NewUploader(s)
-
-		return ul
-	}()
+	gradesErrorCanvasRateLimitedResponse = GradesErrorResponse{
+		Error:      gradesErrorCanvasRateLimited,
+		StatusCode: http.StatusTooManyRequests,
+	}
 )
 
 type gradesErrorAction string
@@ -58,12 +45,17 @@ type detailedGrades map[uint64]map[uint64]computedGrade
 // map[courseID]map[userID]map[outcomeID][]canvasOutcomeResult
 type processedOutcomeResults map[uint64]map[uint64]map[uint64][]canvasOutcomeResult
 
+// gpaValues is a default and subgrade gpa
+type gpaValues struct {
+	Subgrades float64 `json:"subgrades"`
+	Default   float64 `json:"default"`
+}
+
 // calculatedGPA represents a single user's gpa
 type calculatedGPA struct {
-	Unweighted struct {
-		Subgrades float64 `json:"subgrades"`
-		Default   float64 `json:"default"`
-	} `json:"unweighted"`
+	Unweighted gpaValues `json:"unweighted"`
+	// Weighted adds course weights (honors, AP) to each passing grade
+	Weighted gpaValues `json:"weighted"`
 }
 
 // gpa represents more than one user's GPA
@@ -74,6 +66,7 @@ const (
 	gradesErrorRevokedToken          = "the token/refresh token has been revoked or no longer works"
 	gradesErrorRefreshedTokenError   = "after refreshing the token, it is invalid"
 	gradesErrorUnknownCanvasError    = "there was an unknown error from canvas"
+	gradesErrorCanvasRateLimited     = "canvas is rate limiting requests for this user, try again in a minute"
 	gradesErrorInvalidInclude        = "invalid include"
 	gradesErrorUnauthorizedScope     = "your oauth2 grant doesn't have one or more requested scopes"
 	gradesErrorInvalidAccessToken    = "invalid access token"
@@ -89,6 +82,10 @@ const (
 	gradesIncludeSimpleGrades   = gradesInclude("simple_grades")
 	gradesIncludeDetailedGrades = gradesInclude("detailed_grades")
 	gradesIncludeGPA            = gradesInclude("gpa")
+	// gradesIncludeGradeExplanations adds explanations to detailed grades, so it implies detailed_grades.
+	gradesIncludeGradeExplanations = gradesInclude("grade_explanations")
+	// gradesIncludeGradeForecasts adds end of term forecasts to detailed grades, so it implies detailed_grades.
+	gradesIncludeGradeForecasts = gradesInclude("grade_forecasts")
 )
 
 type gradesHandlerRequest struct {
@@ -99,20 +96,37 @@ type gradesHandlerRequest struct {
 	OutcomeResults bool
 	DetailedGrades bool
 	GPA            bool
+	// GradeExplanations implies DetailedGrades
+	GradeExplanations bool
+	// GradeForecasts implies DetailedGrades
+	GradeForecasts bool
 }
 
 // UserGradesRequest represents a request for GradesForUser.
 type UserGradesRequest struct {
 	UserID       uint64
 	CanvasUserID uint64
+	// InstitutionID is CanvasUserID's institution (0 for the default Canvas instance), as Canvas user IDs are only
+	// unique within one. It's only used to find a token for CanvasUserID.
+	InstitutionID uint64
 	// If specified, don't fetch grades for specified courses. Not respected in AllGradesForTeacher.
 	ExcludeCourseIDs map[uint64]struct{}
 	// Not respected in AllGradesForTeacher.
-	DetailedGrades   bool
+	DetailedGrades bool
+	// Adds explanations to detailed grades. Not respected in AllGradesForTeacher.
+	GradeExplanations bool
+	// Adds end of term forecasts to detailed grades. Not respected in AllGradesForTeacher.
+	GradeForecasts   bool
 	ManualFetch      bool
 	ReturnDBRequests bool
 	Rd               *requestDetails
 	FetchAssignments bool
+	// Ctx cancels every Canvas request and database read for the user. If nil, nothing is canceled.
+	// Saving to the database isn't canceled, as the grades were already fetched.
+	Ctx context.Context
+	// Incremental skips courses where nothing was graded since their grades were stored, reusing those grades
+	// (see getUnchangedCourses). Reused grades don't have averages, explanations or forecasts.
+	Incremental bool
 }
 
 // UserGradesResponse is all possible info from a GradesForUser call.
@@ -153,10 +167,20 @@ type UserGradesDBRequests struct {
 // InternalError will be populated when there is a server error.
 // It is JSON-serializable.
 type GradesErrorResponse struct {
-	Error         string            `json:"error"`
-	Action        gradesErrorAction `json:"action,omitempty"`
-	StatusCode    int               `json:"status_code,omitempty"`
-	InternalError error             `json:"-"`
+	Error      string            `json:"error"`
+	Action     gradesErrorAction `json:"action,omitempty"`
+	StatusCode int               `json:"status_code,omitempty"`
+	// Transient is whether the error might go away if grades are fetched again later.
+	// It's only set in fetch_all.
+	Transient     bool  `json:"transient,omitempty"`
+	InternalError error `json:"-"`
+}
+
+// withTransient returns a copy of r with Transient set, so that shared responses aren't changed.
+func (r GradesErrorResponse) withTransient() *GradesErrorResponse {
+	r.Transient = r.StatusCode == http.StatusTooManyRequests ||
+		(r.InternalError != nil && isTransientCanvasError(r.InternalError))
+	return &r
 }
 
 func (r gradesHandlerRequest) toScopes() []oauth2.Scope {
@@ -215,6 +239,12 @@ func GradesHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params)
 			req.DetailedGrades = true
 		case gradesIncludeGPA:
 			req.GPA = true
+		case gradesIncludeGradeExplanations:
+			req.DetailedGrades = true
+			req.GradeExplanations = true
+		case gradesIncludeGradeForecasts:
+			req.DetailedGrades = true
+			req.GradeForecasts = true
 		default:
 			handleError(w, GradesErrorResponse{
 				Error: gradesErrorInvalidInclude,
@@ -244,11 +274,20 @@ func GradesHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params)
 	}
 
 	g, _, gep := GradesForUser(&UserGradesRequest{
-		UserID:         *userID,
-		DetailedGrades: req.DetailedGrades,
-		ManualFetch:    manualFetch,
+		UserID:            *userID,
+		DetailedGrades:    req.DetailedGrades,
+		GradeExplanations: req.GradeExplanations,
+		GradeForecasts:    req.GradeForecasts,
+		ManualFetch:       manualFetch,
+		// stop fetching if the client goes away
+		Ctx: r.Context(),
 	})
 	if gep != nil {
+		if r.Context().Err() != nil {
+			// the client is gone, so there's no one to respond to
+			return
+		}
+
 		if gep.InternalError != nil {
 			handleISE(w, errCtx.Apply(gep.InternalError))
 			return
@@ -299,445 +338,6 @@ func GradesHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params)
 	return
 }
 
-// GradesForAllHandler gets grades for all users with a specified key.
-func GradesForAllHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	key := r.Header.Get("X-CanvasCBL-Script-Key")
-	if len(key) < 1 {
-		util.SendBadRequest(w, "missing X-CanvasCBL-Script-Key as header")
-		return
-	} else if key != env.ScriptKey {
-		util.SendUnauthorized(w, "invalid X-CanvasCBL-Script-Key as header")
-		return
-	}
-
-	returnData := r.URL.Query().Get("return_data") == "true"
-	if !returnData {
-		util.SendNoContent(w)
-		// NOT returning-- we want to finish our work
-	}
-
-	uploadToS3 := func(isError bool, input interface{}) {
-		key := time.Now().Format(time.RFC3339) + "-" + string(env.Env) + ".json"
-		if isError {
-			key = "error-" + key
-		}
-
-		i := &s3manager.UploadInput{
-			Bucket:      aws.String("canvascbl-fetch-all-grades-logs"),
-			ContentType: aws.String("application/json"),
-			Key:         aws.String(key),
-		}
-
-		jRet, err := json.Marshal(input)
-		if err != nil {
-			util.HandleError(fmt.Errorf("error marshaling upload to s3 input to json: %w", err))
-		}
-
-		i.Body = bytes.NewReader(jRet)
-
-		_, err = s3Uploader.Upload(i)
-		if err != nil {
-			util.HandleError(fmt.Errorf("error uploading to s3: %w", err))
-		}
-	}
-
-	// 1. Figure out all courses to pull (from enrollments.List)
-
-	// first, just teachers
-	teacherEnrollments, err := enrollments.List(db, &enrollments.ListRequest{Type: enrollments.TypeTeacher})
-	if err != nil {
-		e := fmt.Errorf("error listing teacher enrollments in fetch_all: %w", err)
-		util.HandleError(e)
-		uploadToS3(true, e)
-		return
-	}
-
-	// teachersByTeacher (map[teacherID<uint64>][]courseID<uint64>) tells us teachers and the classes they teach.
-	teachersByTeacher := map[uint64][]uint64{}
-	// teachersByCourse (map[courseID<uint64>]teacherID<uint64> quickly lets us figure out if a course has a teacher (that's signed up!) or not.
-	teachersByCourse := map[uint64]uint64{}
-	/* students (map[userID<uint64>][]courseID<uint64>) tells us which courses students are in that a teacher is
-	available for.
-	*/
-	studentsExcludeCourses := map[uint64][]uint64{}
-	// studentsAll is the opposite of studentsExcludeCourses.
-	studentsAll := map[uint64][]uint64{}
-
-	for _, e := range *teacherEnrollments {
-		teachersByTeacher[e.UserCanvasID] = append(teachersByTeacher[e.UserCanvasID], e.CourseID)
-		teachersByCourse[e.CourseID] = e.UserCanvasID
-	}
-
-	// now, list student enrollments.
-	studentEnrollments, err := enrollments.List(db, &enrollments.ListRequest{Type: enrollments.TypeStudent})
-	if err != nil {
-		e := fmt.Errorf("error listing student enrollments in fetch_all: %w", err)
-		util.HandleError(e)
-		uploadToS3(true, e)
-		return
-	}
-
-	for _, e := range *studentEnrollments {
-		studentsAll[e.UserCanvasID] = append(studentsAll[e.UserCanvasID], e.CourseID)
-
-		// if a teacher exists
-		if _, ok := teachersByCourse[e.CourseID]; ok {
-			studentsExcludeCourses[e.UserCanvasID] = append(studentsExcludeCourses[e.UserCanvasID], e.CourseID)
-		}
-	}
-
-	// 2. Get all grade change email recipients and their previous grades.
-
-	// students that want notifications (map[studentCanvasUserID<uint64>]struct{}{})
-	studentsEnabledNotifications := make(map[uint64]struct{})
-	var studentsEnabledNotificationsSlice []uint64
-
-	notificationReqs, err := notifications.ListSettings(db, &notifications.ListSettingsRequest{
-		Type:   notifications.TypeGradeChange,
-		Medium: notifications.MediumEmail,
-	})
-	if err != nil {
-		e := fmt.Errorf("error listing notification requests in fetch_all: %w", err)
-		util.HandleError(e)
-		uploadToS3(true, e)
-		return
-	}
-
-	for _, r := range *notificationReqs {
-		studentsEnabledNotifications[r.CanvasUserID] = struct{}{}
-		studentsEnabledNotificationsSlice = append(studentsEnabledNotificationsSlice, r.CanvasUserID)
-	}
-
-	// for those, we'll get their previous grades
-	prevGrades, err := gradessvc.List(db, &gradessvc.ListRequest{
-		UserCanvasIDs: &studentsEnabledNotificationsSlice,
-	})
-	if err != nil {
-		e := fmt.Errorf("error listing previous grades in fetch_all: %w", err)
-		util.HandleError(e)
-		uploadToS3(true, e)
-		return
-	}
-
-	// holds all previous grades for students who have notifications enabled
-	// map[studentID<uint64>]map[courseID<uint64>]gradessvc.Grade
-	studentPrevGrades := make(map[uint64]map[uint64]gradessvc.Grade, len(studentsEnabledNotifications))
-	// holds all new grades for students who have notifications enabled
-	studentNewGrades := make(map[uint64]map[uint64]computedGrade, len(studentsEnabledNotifications))
-	for _, pg := range *prevGrades {
-		if studentPrevGrades[pg.UserCanvasID] == nil {
-			studentPrevGrades[pg.UserCanvasID] = map[uint64]gradessvc.Grade{pg.CourseID: pg}
-		} else {
-			studentPrevGrades[pg.UserCanvasID][pg.CourseID] = pg
-		}
-	}
-
-	// 3. Grab tokens for everyone.
-
-	tokens, err := canvas_tokens.List(util.DB, &canvas_tokens.ListRequest{
-		OrderBys:   []string{"canvas_tokens.canvas_user_id", "canvas_tokens.inserted_at DESC"},
-		DistinctOn: "canvas_tokens.canvas_user_id",
-	})
-	if err != nil {
-		wErr := fmt.Errorf("error listing all unique canvas tokens for grades for all: %w", err)
-		if returnData {
-			handleISE(w, wErr)
-		} else {
-			uploadToS3(true, &wErr)
-		}
-
-		return
-	}
-
-	var (
-		teacherTokens []canvas_tokens.CanvasToken
-		// bothTokens covers a VERY RARE circumstance that a user is both a student and a teacher in different courses.
-		bothTokens     []canvas_tokens.CanvasToken
-		studentTokens  []canvas_tokens.CanvasToken
-		observerTokens []canvas_tokens.CanvasToken
-	)
-
-	for _, t := range *tokens {
-		// if the user is a teacher
-		_, userIsTeacher := teachersByTeacher[t.CanvasUserID]
-		_, userIsStudent := studentsAll[t.CanvasUserID]
-
-		if userIsTeacher && userIsStudent {
-			bothTokens = append(bothTokens, t)
-			continue
-		} else if userIsTeacher && !userIsStudent {
-			teacherTokens = append(teacherTokens, t)
-			continue
-		} else if !userIsTeacher && userIsStudent {
-			studentTokens = append(studentTokens, t)
-		} else if !userIsTeacher && !userIsStudent {
-			// user is an observer because they are not a student and not a teacher
-			observerTokens = append(observerTokens, t)
-		}
-	}
-
-	// 4. If so, fetch teacher first, then students (without teacher courses). If not, fetch everything.
-
-	// error mapped by canvas user id
-	errs := make(map[uint64]*GradesErrorResponse)
-	// whether we had a success for the specified canvas user id
-	// if 123 worked, statuses[123] = true.
-	teacherStatuses := make(map[uint64]bool)
-	// excludeCourses contains courses that a teacher has already fetched the grade for.
-	excludeCourses := map[uint64]struct{}{}
-	// map[courseID]courseName
-	excludedCourseNames := map[uint64]string{}
-
-	var dbReqs []UserGradesDBRequests
-
-	// concatenate teachers and both
-	for _, tt := range append(teacherTokens, bothTokens...) {
-		rd := rdFromToken(tt)
-
-		resp, dbReq, err := AllGradesForTeacher(&UserGradesRequest{
-			CanvasUserID:     tt.CanvasUserID,
-			ManualFetch:      false,
-			ReturnDBRequests: true,
-			Rd:               &rd,
-		})
-		if err != nil {
-			if err.InternalError != nil {
-				util.HandleError(fmt.Errorf("error in fetch_all when getching grades for teacher %d: %w", tt.CanvasUserID, err.InternalError))
-			}
-
-			errs[tt.CanvasUserID] = err
-		} else {
-			dbReqs = append(dbReqs, *dbReq)
-		}
-
-		teacherStatuses[tt.CanvasUserID] = err == nil
-
-		if err != nil {
-			continue
-		}
-
-		for _, c := range *resp.Courses {
-			excludeCourses[c.ID] = struct{}{}
-			excludedCourseNames[c.ID] = c.Name
-		}
-
-		// store grades for students with notifications enabled
-		for uID, dg := range resp.DetailedGrades {
-			if _, ok := studentsEnabledNotifications[uID]; ok {
-				if studentNewGrades[uID] == nil {
-					studentNewGrades[uID] = map[uint64]computedGrade{}
-				}
-
-				for cID, g := range dg {
-					studentNewGrades[uID][cID] = g
-				}
-			}
-		}
-	}
-
-	// to DB we go
-	if len(dbReqs) > 0 {
-		handleBatchGradesDBRequests(dbReqs)
-	}
-
-	// students, both, observers
-
-	dbReqs = []UserGradesDBRequests{}
-	restStatuses := make(map[uint64]bool)
-
-	handleRestRequests := func(t canvas_tokens.CanvasToken) {
-		rd := rdFromToken(t)
-
-		resp, dbReq, err := GradesForUser(&UserGradesRequest{
-			CanvasUserID:     t.CanvasUserID,
-			ExcludeCourseIDs: excludeCourses,
-			DetailedGrades:   true,
-			ManualFetch:      false,
-			ReturnDBRequests: true,
-			Rd:               &rd,
-			FetchAssignments: true,
-		})
-		if err != nil {
-			if err.InternalError != nil {
-				util.HandleError(fmt.Errorf("error in fetch_all when getching grades for user %d: %w", t.CanvasUserID, err.InternalError))
-			}
-
-			errs[t.CanvasUserID] = err
-		} else {
-			dbReqs = append(dbReqs, *dbReq)
-		}
-
-		restStatuses[t.CanvasUserID] = err == nil
-
-		if err != nil {
-			return
-		}
-
-		userIsObserver := resp.Observees != nil && len(*resp.Observees) > 0
-
-		if _, ok := studentsEnabledNotifications[t.CanvasUserID]; ok {
-			// the user would like a notification
-
-			courseNames := make(map[uint64]string)
-			for _, c := range *resp.Courses {
-				courseNames[c.ID] = c.Name
-			}
-
-			pr := *resp.UserProfile
-
-			// once for grades a teacher fetched
-			for cID, g := range studentNewGrades[pr.ID] {
-				// if a previous grade exists for user
-				if uPrev, ok := studentPrevGrades[pr.ID]; ok {
-					// prev grade for course?
-					if prev, ok := uPrev[cID]; ok {
-						// are they different?
-						if g.Grade.Grade != prev.Grade {
-							// if not the student will get infinite emails for grade -> NA
-							if g.Grade == naGrade {
-								continue
-							}
-
-							courseName := courseNames[cID]
-							if len(courseName) < 1 {
-								courseName = excludedCourseNames[cID]
-							}
-
-							if userIsObserver {
-								var studentName string
-								for _, o := range *resp.Observees {
-									if o.ID == pr.ID {
-										studentName = o.Name
-										break
-									}
-								}
-
-								go email.SendParentGradeChangeEmail(&email.ParentGradeChangeEmailData{
-									To:            pr.PrimaryEmail,
-									Name:          pr.Name,
-									StudentName:   studentName,
-									ClassName:     courseName,
-									PreviousGrade: prev.Grade,
-									CurrentGrade:  g.Grade.Grade,
-								})
-							} else {
-								go email.SendGradeChangeEmail(&email.GradeChangeEmailData{
-									To:            pr.PrimaryEmail,
-									Name:          pr.Name,
-									ClassName:     courseName,
-									PreviousGrade: prev.Grade,
-									CurrentGrade:  g.Grade.Grade,
-								})
-							}
-						}
-					}
-				}
-			}
-
-			// and once more for grades the user fetched
-			for uID, cs := range resp.DetailedGrades {
-				// range thru courses
-				for cID, c := range cs {
-					// skip courses we handled above
-					if _, ok := excludeCourses[cID]; ok {
-						continue
-					}
-
-					// if a previous grade exists for user
-					if uPrev, ok := studentPrevGrades[uID]; ok {
-						// prev grade for course?
-						if prev, ok := uPrev[cID]; ok {
-							// are they different?
-							if c.Grade.Grade != prev.Grade {
-								// if not the student will get infinite emails for grade -> NA
-								if c.Grade == naGrade {
-									continue
-								}
-
-								if userIsObserver {
-									var studentName string
-									for _, o := range *resp.Observees {
-										if o.ID == uID {
-											studentName = o.Name
-											break
-										}
-									}
-
-									go email.SendParentGradeChangeEmail(&email.ParentGradeChangeEmailData{
-										To:            pr.PrimaryEmail,
-										Name:          pr.Name,
-										StudentName:   studentName,
-										ClassName:     courseNames[cID],
-										PreviousGrade: prev.Grade,
-										CurrentGrade:  c.Grade.Grade,
-									})
-								} else {
-									go email.SendGradeChangeEmail(&email.GradeChangeEmailData{
-										To:            pr.PrimaryEmail,
-										Name:          pr.Name,
-										ClassName:     courseNames[cID],
-										PreviousGrade: prev.Grade,
-										CurrentGrade:  c.Grade.Grade,
-									})
-								}
-							}
-						}
-					}
-				}
-			}
-		}
-	}
-
-	// splitting these due to "ON CONFLICT can't update same row twice" in enrollments
-	for _, st := range append(studentTokens, bothTokens...) {
-		handleRestRequests(st)
-	}
-
-	for _, ot := range observerTokens {
-		handleRestRequests(ot)
-	}
-
-	// to DB we go
-	if len(dbReqs) > 0 {
-		handleBatchGradesDBRequests(dbReqs)
-	}
-
-	resp := struct {
-		Errors          map[uint64]*GradesErrorResponse `json:"errors"`
-		TeacherStatuses map[uint64]bool                 `json:"teacher_statuses"`
-		RestStatuses    map[uint64]bool                 `json:"rest_statuses"`
-		NumTeachers     int                             `json:"num_teachers"`
-		NumBoth         int                             `json:"num_both"`
-		NumStudents     int                             `json:"num_students"`
-		NumObservers    int                             `json:"num_observers"`
-		NumErrors       int                             `json:"num_errors"`
-	}{
-		Errors:          errs,
-		TeacherStatuses: teacherStatuses,
-		RestStatuses:    restStatuses,
-		NumTeachers:     len(teacherTokens),
-		NumBoth:         len(bothTokens),
-		NumStudents:     len(studentTokens),
-		NumObservers:    len(observerTokens),
-		NumErrors:       len(errs),
-	}
-
-	if returnData {
-		jRet, err := json.Marshal(&resp)
-		if err != nil {
-			handleISE(w, fmt.Errorf("error marshaling errors and statuses from fetch all grades: %w", err))
-			return
-		}
-
-		util.SendJSONResponse(w, jRet)
-		return
-	} else {
-		uploadToS3(false, resp)
-	}
-
-	return
-}
-
 func GradesForUser(req *UserGradesRequest) (*UserGradesResponse, *UserGradesDBRequests, *GradesErrorResponse) {
 	var (
 		rd       requestDetails
@@ -750,7 +350,7 @@ func GradesForUser(req *UserGradesRequest) (*UserGradesResponse, *UserGradesDBRe
 		if req.UserID > 0 {
 			rd, err = rdFromUserID(req.UserID)
 		} else {
-			rd, err = rdFromCanvasUserID(req.CanvasUserID)
+			rd, err = rdFromCanvasUserID(req.InstitutionID, req.CanvasUserID)
 		}
 	} else {
 		rd = *req.Rd
@@ -760,6 +360,10 @@ func GradesForUser(req *UserGradesRequest) (*UserGradesResponse, *UserGradesDBRe
 		return nil, nil, &GradesErrorResponse{InternalError: fmt.Errorf("error getting rd from user id: %w", err)}
 	}
 
+	if req.Ctx != nil {
+		rd.Ctx = req.Ctx
+	}
+
 	if rd.TokenID < 1 {
 		return nil, nil, &GradesErrorResponse{
 			Error:      gradesErrorNoTokens,
@@ -768,6 +372,8 @@ func GradesForUser(req *UserGradesRequest) (*UserGradesResponse, *UserGradesDBRe
 		}
 	}
 
+	rd.refreshIfExpiresSoon()
+
 	profile, err := getCanvasProfile(rd, "self")
 	if err != nil {
 		if errors.Is(err, canvasErrorInvalidAccessTokenError) {
@@ -796,7 +402,9 @@ func GradesForUser(req *UserGradesRequest) (*UserGradesResponse, *UserGradesDBRe
 						Action:     gradesErrorActionRedirectToOAuth,
 						StatusCode: http.StatusForbidden,
 					}
-				} else if errors.Is(err, canvasErrorUnknownError) {
+				} else if errors.Is(newProfileErr, canvasErrorRateLimitExceededError) {
+					return nil, nil, &gradesErrorCanvasRateLimitedResponse
+				} else if errors.Is(newProfileErr, canvasErrorUnknownError) {
 					return nil, nil, &gradesErrorUnknownCanvasErrorResponse
 				}
 
@@ -810,6 +418,8 @@ func GradesForUser(req *UserGradesRequest) (*UserGradesResponse, *UserGradesDBRe
 				Action:     gradesErrorActionRedirectToOAuth,
 				StatusCode: http.StatusForbidden,
 			}
+		} else if errors.Is(err, canvasErrorRateLimitExceededError) {
+			return nil, nil, &gradesErrorCanvasRateLimitedResponse
 		} else if errors.Is(err, canvasErrorUnknownError) {
 			return nil, nil, &gradesErrorUnknownCanvasErrorResponse
 		} else {
@@ -826,10 +436,10 @@ func GradesForUser(req *UserGradesRequest) (*UserGradesResponse, *UserGradesDBRe
 		go func() {
 			defer dbReqsWg.Done()
 
-			dbReqs.Profile = prepareProfileForDB((*canvasUserProfile)(profile))
+			dbReqs.Profile = prepareProfileForDB((*canvasUserProfile)(profile), rd.InstitutionID)
 		}()
 	} else {
-		go saveProfileToDB((*canvasUserProfile)(profile))
+		go saveProfileToDB((*canvasUserProfile)(profile), rd.InstitutionID)
 	}
 
 	mutex := sync.Mutex{}
@@ -854,9 +464,16 @@ func GradesForUser(req *UserGradesRequest) (*UserGradesResponse, *UserGradesDBRe
 			return
 		}
 
+		termID, termErr := currentEnrollmentTermID(rd.InstitutionID)
+		if termErr != nil {
+			err = termErr
+			mutex.Unlock()
+			return
+		}
+
 		var cs []canvasCourse
 		for _, c := range *coursesResp {
-			if int(c.EnrollmentTermID) >= env.CanvasCurrentEnrollmentTermID {
+			if int(c.EnrollmentTermID) >= termID {
 				cs = append(cs, c)
 			}
 		}
@@ -873,7 +490,7 @@ func GradesForUser(req *UserGradesRequest) (*UserGradesResponse, *UserGradesDBRe
 		go func() {
 			defer wg.Done()
 
-			hiddenIDs, hiddenErr := coursessvc.GetUserHiddenCourses(db, req.UserID)
+			hiddenIDs, hiddenErr := coursessvc.GetUserHiddenCourses(services.WithContext(rd.ctx(), db), req.UserID)
 			if hiddenErr != nil {
 				mutex.Lock()
 				err = hiddenErr
@@ -913,6 +530,8 @@ func GradesForUser(req *UserGradesRequest) (*UserGradesResponse, *UserGradesDBRe
 				Action:     gradesErrorActionRetryOnce,
 				StatusCode: http.StatusForbidden,
 			}
+		} else if errors.Is(err, canvasErrorRateLimitExceededError) {
+			return nil, nil, &gradesErrorCanvasRateLimitedResponse
 		} else if errors.Is(err, canvasErrorUnknownError) {
 			return nil, nil, &gradesErrorUnknownCanvasErrorResponse
 		}
@@ -925,16 +544,16 @@ func GradesForUser(req *UserGradesRequest) (*UserGradesResponse, *UserGradesDBRe
 		go func() {
 			defer dbReqsWg.Done()
 
-			cReq, eReq := prepareCoursesForDB(allCourses)
+			cReq, eReq := prepareCoursesForDB(allCourses, rd.InstitutionID)
 			dbReqs.Courses = cReq
 			dbReqs.Enrollments = *eReq
 		}()
 	} else {
-		go saveCoursesToDB(allCourses)
+		go saveCoursesToDB(allCourses, rd.InstitutionID)
 	}
 
 	// observees are special and don't get added to dbReqs
-	go saveObserveesToDB((*[]canvasObservee)(observees), profile.ID)
+	go saveObserveesToDB((*[]canvasObservee)(observees), profile.ID, rd.InstitutionID)
 
 	// we now have both allCourses and observees.
 	gradedUsers, validCourses := getGradedUsersAndValidCourses(allCourses)
@@ -950,10 +569,36 @@ func GradesForUser(req *UserGradesRequest) (*UserGradesResponse, *UserGradesDBRe
 		courses = *validCourses
 	}
 
+	// observees need to specify "all" to get submissions for observees
+	// students get a 401 when specifying all
+	var submissionsFor []string
+
+	if len(*observees) > 0 {
+		submissionsFor = append(submissionsFor, "all")
+	}
+
+	// map[courseID]map[userID]gradessvc.Grade
+	unchangedCourses := map[uint64]map[uint64]gradessvc.Grade{}
+	if req.Incremental {
+		unchanged, uErr := getUnchangedCourses(rd, &getUnchangedCoursesRequest{
+			courses:    courses,
+			users:      gradedUsers,
+			studentIDs: submissionsFor,
+		})
+		if uErr != nil {
+			// everything will be fetched, which will find out whether the error was with the token
+			util.HandleError(fmt.Errorf("error getting unchanged courses for user %d: %w", profile.ID, uErr))
+		} else {
+			unchangedCourses = unchanged
+		}
+	}
+
 	// outcome_alignments / outcome_rollups / assignments [Grades/GradeBreakdown]
 
 	// map[courseID]map[userID]map[outcomeID][]canvasOutcomeResult
 	results := processedOutcomeResults{}
+	// map[courseID]outcomeCalculations
+	calculations := make(map[uint64]outcomeCalculations)
 	assignments := make(map[uint64]canvasAssignmentsResponse)
 	submits := make(map[uint64]canvasSubmissionsResponse, len(*allCourses))
 	for i, c := range courses {
@@ -970,6 +615,10 @@ func GradesForUser(req *UserGradesRequest) (*UserGradesResponse, *UserGradesDBRe
 			courses[i].CanvasCBLHidden = true
 		}
 
+		if _, ok := unchangedCourses[c.ID]; ok {
+			continue
+		}
+
 		// results
 		wg.Add(1)
 		go func(courseIDS string, courseID uint64) {
@@ -997,63 +646,38 @@ func GradesForUser(req *UserGradesRequest) (*UserGradesResponse, *UserGradesDBRe
 
 			mutex.Lock()
 			results[courseID] = *processedResults
+			calculations[courseID] = outcomeCalculationsFromOutcomes(rs.Linked.Outcomes)
 			mutex.Unlock()
 			return
 		}(cID, c.ID)
 
-		// fetch assignments
-		if req.FetchAssignments {
+		// fetch assignments and submissions
+		fetchSubmissions := rd.hasScopeVersion(2)
+		if req.FetchAssignments || fetchSubmissions {
 			wg.Add(1)
 			go func(courseID uint64) {
 				defer wg.Done()
 
-				as, aErr := getCanvasCourseAssignments(
-					rd,
-					fmt.Sprintf("%d", courseID),
-					[]string{},
-				)
-				if aErr != nil {
+				work, wErr := getCanvasCourseWork(rd, &getCanvasCourseWorkRequest{
+					courseID:    fmt.Sprintf("%d", courseID),
+					studentIDs:  submissionsFor,
+					assignments: req.FetchAssignments,
+					submissions: fetchSubmissions,
+				})
+				if wErr != nil {
 					mutex.Lock()
-					err = aErr
+					err = wErr
 					mutex.Unlock()
 					return
 				}
 
 				mutex.Lock()
-				assignments[courseID] = *as
-				mutex.Unlock()
-			}(c.ID)
-		}
-
-		// fetch submissions
-		if rd.hasScopeVersion(2) {
-			wg.Add(1)
-			go func(courseID uint64) {
-				defer wg.Done()
-
-				// observees need to specify "all" to get submissions for observees
-				// students get a 401 when specifying all
-				var submissionsFor []string
-
-				if len(*observees) > 0 {
-					submissionsFor = append(submissionsFor, "all")
-				} else {
-
+				if req.FetchAssignments {
+					assignments[courseID] = work.Assignments
 				}
-
-				ss, sErr := getCanvasCourseSubmissions(rd, &getCanvasCourseSubmissionsRequest{
-					courseID:   fmt.Sprintf("%d", courseID),
-					studentIDs: submissionsFor,
-				})
-				if sErr != nil {
-					mutex.Lock()
-					err = sErr
-					mutex.Unlock()
-					return
+				if fetchSubmissions {
+					submits[courseID] = work.Submissions
 				}
-
-				mutex.Lock()
-				submits[courseID] = *ss
 				mutex.Unlock()
 			}(c.ID)
 		}
@@ -1070,6 +694,8 @@ func GradesForUser(req *UserGradesRequest) (*UserGradesResponse, *UserGradesDBRe
 				StatusCode:    http.StatusForbidden,
 				InternalError: nil,
 			}
+		} else if errors.Is(err, canvasErrorRateLimitExceededError) {
+			return nil, nil, &gradesErrorCanvasRateLimitedResponse
 		} else if errors.Is(err, canvasErrorUnknownError) {
 			return nil, nil, &gradesErrorUnknownCanvasErrorResponse
 		} else if errors.Is(err, canvasErrorInsufficientScopesOnAccessTokenError) {
@@ -1091,7 +717,7 @@ func GradesForUser(req *UserGradesRequest) (*UserGradesResponse, *UserGradesDBRe
 		go func() {
 			defer dbReqsWg.Done()
 
-			resReq, prepErr := prepareOutcomeResultsForDB(results)
+			resReq, prepErr := prepareOutcomeResultsForDB(results, rd.InstitutionID)
 			if prepErr != nil {
 				util.HandleError(fmt.Errorf("error preparing outcome results for db: %w", err))
 				return
@@ -1123,7 +749,7 @@ func GradesForUser(req *UserGradesRequest) (*UserGradesResponse, *UserGradesDBRe
 				defer dbReqsWg.Done()
 
 				for cID, ss := range submits {
-					ss, as := prepareSubmissionsForDB(ss, cID)
+					ss, as := prepareSubmissionsForDB(ss, cID, rd.InstitutionID)
 					dbReqs.Submissions = append(dbReqs.Submissions, *ss...)
 					dbReqs.SubmissionAttachments = append(dbReqs.SubmissionAttachments, *as...)
 				}
@@ -1131,19 +757,51 @@ func GradesForUser(req *UserGradesRequest) (*UserGradesResponse, *UserGradesDBRe
 			}()
 		}
 	} else {
-		go saveOutcomeResultsToDB(results)
+		go saveOutcomeResultsToDB(results, rd.InstitutionID)
 		for cID, as := range assignments {
 			go func(courseID uint64, ass canvasAssignmentsResponse, subs canvasSubmissionsResponse) {
 				saveAssignmentsToDB(ass, fmt.Sprintf("%d", courseID))
 
 				// should be fine, but just in case
 				if subs != nil {
-					saveSubmissionsToDB(subs, courseID)
+					saveSubmissionsToDB(subs, courseID, rd.InstitutionID)
 				}
 			}(cID, as, submits[cID])
 		}
 	}
 
+	policies, err := getGradingPolicySet()
+	if err != nil {
+		return nil, nil, &GradesErrorResponse{InternalError: fmt.Errorf("error getting grading policies: %w", err)}
+	}
+
+	cutoffs, err := getDropCutoffSet()
+	if err != nil {
+		return nil, nil, &GradesErrorResponse{InternalError: fmt.Errorf("error getting drop cutoffs: %w", err)}
+	}
+
+	// map[rootAccountID]ratings
+	ratings := getProficiencyRatingsForCourses(rd, *allCourses)
+
+	var forecastInputs *gradeForecastInputs
+	if req.GradeForecasts {
+		var userIDs []uint64
+		seenUserIDs := make(map[uint64]struct{})
+		for _, uIDs := range gradedUsers {
+			for _, uID := range uIDs {
+				if _, ok := seenUserIDs[uID]; !ok {
+					seenUserIDs[uID] = struct{}{}
+					userIDs = append(userIDs, uID)
+				}
+			}
+		}
+
+		forecastInputs, err = getGradeForecastInputs(rd.InstitutionID, *allCourses, userIDs, cutoffs)
+		if err != nil {
+			return nil, nil, &GradesErrorResponse{InternalError: fmt.Errorf("error getting grade forecast inputs: %w", err)}
+		}
+	}
+
 	// now, we will calculate grades
 	// map[userID<uint64>]map[courseID<uint64>]grade<computedGrade>
 	grades := detailedGrades{}
@@ -1159,17 +817,34 @@ func GradesForUser(req *UserGradesRequest) (*UserGradesResponse, *UserGradesDBRe
 			}
 		}
 
+		courseRatings := ratings[uint64(c.RootAccountID)]
+		policy := gradingPolicyForCourse(policies, rd.InstitutionID, c, courseRatings)
+		isAfterCutoff, cutoff := dropCutoffForCourse(cutoffs, rd.InstitutionID, c)
+
 		for _, uID := range uIDs {
 			wg.Add(1)
 			go func(courseID uint64, userID uint64) {
 				defer wg.Done()
 
-				mutex.Lock()
-				rs := results[courseID][userID]
-				mutex.Unlock()
+				var grd computedGrade
+				if stored, ok := unchangedCourses[courseID][userID]; ok {
+					grd = reusedComputedGrade(stored, policy, cutoff)
+				} else {
+					mutex.Lock()
+					rs := results[courseID][userID]
+					calcs := calculations[courseID]
+					mutex.Unlock()
 
-				// we're saying it's not after the cutoff for now.
-				grd := *calculateGradeFromOutcomeResults(rs, false)
+					grd = *calculateGradeFromOutcomeResults(rs, calcs, policy, isAfterCutoff)
+					grd.Cutoff = cutoff
+					labelAveragesWithProficiencyRatings(&grd, courseRatings)
+					if req.GradeExplanations {
+						grd.Explanation = explainGrade(rs, calcs, policy, isAfterCutoff, grd)
+					}
+					if forecastInputs != nil {
+						grd.Forecast = forecastGrade(forecastInputs, c, userID, rs, calcs, policy, grd)
+					}
+				}
 
 				// we'll now save the grade
 				mutex.Lock()
@@ -1198,31 +873,38 @@ func GradesForUser(req *UserGradesRequest) (*UserGradesResponse, *UserGradesDBRe
 		go func() {
 			defer dbReqsWg.Done()
 
-			gReqs, rollupReqs := prepareGradesForDB(grades, req.ManualFetch)
+			gReqs, rollupReqs := prepareGradesForDB(grades, req.ManualFetch, rd.InstitutionID)
 
 			dbReqs.Grades = gReqs
 			dbReqs.RollupScores = rollupReqs
 		}()
 	} else {
-		go saveGradesToDB(grades, req.ManualFetch)
+		go saveGradesToDB(grades, req.ManualFetch, rd.InstitutionID)
 	}
 
 	wg.Wait()
 
-	cGPA := calculateGPAFromDetailedGrades(grades)
+	weights, err := getCourseWeights(rd.InstitutionID, *allCourses)
+	if err != nil {
+		// an unweighted GPA is better than no grades at all
+		util.HandleError(fmt.Errorf("error getting course weights: %w", err))
+		weights = nil
+	}
+
+	cGPA := calculateGPAFromDetailedGrades(grades, weights)
 
 	if req.ReturnDBRequests {
 		dbReqsWg.Add(1)
 		go func() {
 			defer dbReqsWg.Done()
 
-			dbReqs.GPA = prepareGPAForDB(cGPA, req.ManualFetch)
+			dbReqs.GPA = prepareGPAForDB(cGPA, req.ManualFetch, rd.InstitutionID)
 		}()
 
 		// wait for them all to finish
 		dbReqsWg.Wait()
 	} else {
-		go saveGPAToDB(cGPA, req.ManualFetch)
+		go saveGPAToDB(cGPA, req.ManualFetch, rd.InstitutionID)
 	}
 
 	if req.ReturnDBRequests {
@@ -1254,7 +936,7 @@ func AllGradesForTeacher(req *UserGradesRequest) (*UserGradesResponse, *UserGrad
 		if req.UserID > 0 {
 			rd, err = rdFromUserID(req.UserID)
 		} else {
-			rd, err = rdFromCanvasUserID(req.CanvasUserID)
+			rd, err = rdFromCanvasUserID(req.InstitutionID, req.CanvasUserID)
 		}
 	} else {
 		rd = *req.Rd
@@ -1264,6 +946,10 @@ func AllGradesForTeacher(req *UserGradesRequest) (*UserGradesResponse, *UserGrad
 		return nil, nil, &GradesErrorResponse{InternalError: fmt.Errorf("error getting rd from user id: %w", err)}
 	}
 
+	if req.Ctx != nil {
+		rd.Ctx = req.Ctx
+	}
+
 	if rd.TokenID < 1 {
 		return nil, nil, &GradesErrorResponse{
 			Error:      gradesErrorNoTokens,
@@ -1272,6 +958,8 @@ func AllGradesForTeacher(req *UserGradesRequest) (*UserGradesResponse, *UserGrad
 		}
 	}
 
+	rd.refreshIfExpiresSoon()
+
 	profile, err := getCanvasProfile(rd, "self")
 	if err != nil {
 		if errors.Is(err, canvasErrorInvalidAccessTokenError) {
@@ -1300,7 +988,9 @@ func AllGradesForTeacher(req *UserGradesRequest) (*UserGradesResponse, *UserGrad
 						Action:     gradesErrorActionRedirectToOAuth,
 						StatusCode: http.StatusForbidden,
 					}
-				} else if errors.Is(err, canvasErrorUnknownError) {
+				} else if errors.Is(newProfileErr, canvasErrorRateLimitExceededError) {
+					return nil, nil, &gradesErrorCanvasRateLimitedResponse
+				} else if errors.Is(newProfileErr, canvasErrorUnknownError) {
 					return nil, nil, &gradesErrorUnknownCanvasErrorResponse
 				}
 
@@ -1314,6 +1004,8 @@ func AllGradesForTeacher(req *UserGradesRequest) (*UserGradesResponse, *UserGrad
 				Action:     gradesErrorActionRedirectToOAuth,
 				StatusCode: http.StatusForbidden,
 			}
+		} else if errors.Is(err, canvasErrorRateLimitExceededError) {
+			return nil, nil, &gradesErrorCanvasRateLimitedResponse
 		} else if errors.Is(err, canvasErrorUnknownError) {
 			return nil, nil, &gradesErrorUnknownCanvasErrorResponse
 		} else {
@@ -1330,10 +1022,10 @@ func AllGradesForTeacher(req *UserGradesRequest) (*UserGradesResponse, *UserGrad
 		go func() {
 			defer dbReqsWg.Done()
 
-			dbReqs.Profile = prepareProfileForDB((*canvasUserProfile)(profile))
+			dbReqs.Profile = prepareProfileForDB((*canvasUserProfile)(profile), rd.InstitutionID)
 		}()
 	} else {
-		go saveProfileToDB((*canvasUserProfile)(profile))
+		go saveProfileToDB((*canvasUserProfile)(profile), rd.InstitutionID)
 	}
 
 	mutex := sync.Mutex{}
@@ -1356,9 +1048,16 @@ func AllGradesForTeacher(req *UserGradesRequest) (*UserGradesResponse, *UserGrad
 			return
 		}
 
+		termID, termErr := currentEnrollmentTermID(rd.InstitutionID)
+		if termErr != nil {
+			err = termErr
+			mutex.Unlock()
+			return
+		}
+
 		var cs []canvasCourse
 		for _, c := range *coursesResp {
-			if int(c.EnrollmentTermID) >= env.CanvasCurrentEnrollmentTermID {
+			if int(c.EnrollmentTermID) >= termID {
 				for _, e := range c.Enrollments {
 					// make sure that the user is a teacher in this course
 					if e.Type == enrollments.TypeTeacher {
@@ -1384,6 +1083,8 @@ func AllGradesForTeacher(req *UserGradesRequest) (*UserGradesResponse, *UserGrad
 				Action:     gradesErrorActionRetryOnce,
 				StatusCode: http.StatusForbidden,
 			}
+		} else if errors.Is(err, canvasErrorRateLimitExceededError) {
+			return nil, nil, &gradesErrorCanvasRateLimitedResponse
 		} else if errors.Is(err, canvasErrorUnknownError) {
 			return nil, nil, &gradesErrorUnknownCanvasErrorResponse
 		}
@@ -1396,56 +1097,25 @@ func AllGradesForTeacher(req *UserGradesRequest) (*UserGradesResponse, *UserGrad
 		go func() {
 			defer dbReqsWg.Done()
 
-			cReq, eReq := prepareCoursesForDB(allCourses)
+			cReq, eReq := prepareCoursesForDB(allCourses, rd.InstitutionID)
 			dbReqs.Courses = cReq
 			dbReqs.Enrollments = *eReq
 		}()
 	} else {
-		go saveCoursesToDB(allCourses)
+		go saveCoursesToDB(allCourses, rd.InstitutionID)
 	}
 
 	// map[courseID]map[userID]map[outcomeID][]canvasOutcomeResult
 	results := processedOutcomeResults{}
+	// map[courseID]outcomeCalculations
+	calculations := make(map[uint64]outcomeCalculations)
 	assignments := make(map[uint64]canvasAssignmentsResponse)
 	enrolls := make(map[uint64][]canvasFullEnrollment, len(*allCourses))
 	submits := make(map[uint64]canvasSubmissionsResponse, len(*allCourses))
 	var allEnrolls []canvasFullEnrollment
 
-	// get outcome results
+	// enrollments come first, so that courses that haven't changed for any of their students can be skipped
 	for _, c := range *allCourses {
-		// results
-		wg.Add(1)
-		go func(courseID uint64) {
-			defer wg.Done()
-
-			rs, rErr := getCanvasOutcomeResults(
-				rd,
-				fmt.Sprintf("%d", courseID),
-				// we want for all!
-				[]string{},
-			)
-			if rErr != nil {
-				mutex.Lock()
-				err = rErr
-				mutex.Unlock()
-				return
-			}
-
-			processedResults, processErr := processOutcomeResults(&rs.OutcomeResults)
-			if processErr != nil {
-				mutex.Lock()
-				err = processErr
-				mutex.Unlock()
-				return
-			}
-
-			mutex.Lock()
-			results[courseID] = *processedResults
-			mutex.Unlock()
-			return
-		}(c.ID)
-
-		// fetch enrollments
 		wg.Add(1)
 		go func(courseID uint64) {
 			defer wg.Done()
@@ -1471,47 +1141,94 @@ func AllGradesForTeacher(req *UserGradesRequest) (*UserGradesResponse, *UserGrad
 			allEnrolls = append(allEnrolls, *es...)
 			mutex.Unlock()
 		}(c.ID)
+	}
+
+	wg.Wait()
+
+	// map[courseID]map[userID]gradessvc.Grade
+	unchangedCourses := map[uint64]map[uint64]gradessvc.Grade{}
+	if req.Incremental && err == nil {
+		// map[courseID][]userID
+		students := make(map[uint64][]uint64, len(enrolls))
+		for cID, es := range enrolls {
+			for _, e := range es {
+				students[cID] = append(students[cID], e.UserID)
+			}
+		}
+
+		unchanged, uErr := getUnchangedCourses(rd, &getUnchangedCoursesRequest{
+			courses:    *allCourses,
+			users:      students,
+			studentIDs: []string{"all"},
+		})
+		if uErr != nil {
+			// everything will be fetched, which will find out whether the error was with the token
+			util.HandleError(fmt.Errorf("error getting unchanged courses for teacher %d: %w", profile.ID, uErr))
+		} else {
+			unchangedCourses = unchanged
+		}
+	}
+
+	// get outcome results
+	for _, c := range *allCourses {
+		if _, ok := unchangedCourses[c.ID]; ok {
+			continue
+		}
 
-		// fetch assignments
+		// results
 		wg.Add(1)
 		go func(courseID uint64) {
 			defer wg.Done()
 
-			as, aErr := getCanvasCourseAssignments(
+			rs, rErr := getCanvasOutcomeResults(
 				rd,
 				fmt.Sprintf("%d", courseID),
+				// we want for all!
 				[]string{},
 			)
-			if aErr != nil {
+			if rErr != nil {
+				mutex.Lock()
+				err = rErr
+				mutex.Unlock()
+				return
+			}
+
+			processedResults, processErr := processOutcomeResults(&rs.OutcomeResults)
+			if processErr != nil {
 				mutex.Lock()
-				err = aErr
+				err = processErr
 				mutex.Unlock()
 				return
 			}
 
 			mutex.Lock()
-			assignments[courseID] = *as
+			results[courseID] = *processedResults
+			calculations[courseID] = outcomeCalculationsFromOutcomes(rs.Linked.Outcomes)
 			mutex.Unlock()
+			return
 		}(c.ID)
 
-		// fetch submissions
+		// fetch assignments and submissions
 		wg.Add(1)
 		go func(courseID uint64) {
 			defer wg.Done()
 
-			ss, sErr := getCanvasCourseSubmissions(rd, &getCanvasCourseSubmissionsRequest{
-				courseID:   fmt.Sprintf("%d", courseID),
-				studentIDs: []string{"all"},
+			work, wErr := getCanvasCourseWork(rd, &getCanvasCourseWorkRequest{
+				courseID:    fmt.Sprintf("%d", courseID),
+				studentIDs:  []string{"all"},
+				assignments: true,
+				submissions: true,
 			})
-			if sErr != nil {
+			if wErr != nil {
 				mutex.Lock()
-				err = sErr
+				err = wErr
 				mutex.Unlock()
 				return
 			}
 
 			mutex.Lock()
-			submits[courseID] = *ss
+			assignments[courseID] = work.Assignments
+			submits[courseID] = work.Submissions
 			mutex.Unlock()
 		}(c.ID)
 	}
@@ -1527,6 +1244,8 @@ func AllGradesForTeacher(req *UserGradesRequest) (*UserGradesResponse, *UserGrad
 				StatusCode:    http.StatusForbidden,
 				InternalError: nil,
 			}
+		} else if errors.Is(err, canvasErrorRateLimitExceededError) {
+			return nil, nil, &gradesErrorCanvasRateLimitedResponse
 		} else if errors.Is(err, canvasErrorUnknownError) {
 			return nil, nil, &gradesErrorUnknownCanvasErrorResponse
 		}
@@ -1540,7 +1259,7 @@ func AllGradesForTeacher(req *UserGradesRequest) (*UserGradesResponse, *UserGrad
 		go func() {
 			defer dbReqsWg.Done()
 
-			resReq, prepErr := prepareOutcomeResultsForDB(results)
+			resReq, prepErr := prepareOutcomeResultsForDB(results, rd.InstitutionID)
 			if prepErr != nil {
 				util.HandleError(fmt.Errorf("error preparing outcome results for db: %w", err))
 				return
@@ -1553,7 +1272,7 @@ func AllGradesForTeacher(req *UserGradesRequest) (*UserGradesResponse, *UserGrad
 		go func() {
 			defer dbReqsWg.Done()
 
-			dbReqs.Enrollments = append(dbReqs.Enrollments, *prepareEnrollmentsForDB(allEnrolls)...)
+			dbReqs.Enrollments = append(dbReqs.Enrollments, *prepareEnrollmentsForDB(allEnrolls, rd.InstitutionID)...)
 		}()
 
 		dbReqsWg.Add(1)
@@ -1576,22 +1295,22 @@ func AllGradesForTeacher(req *UserGradesRequest) (*UserGradesResponse, *UserGrad
 			defer dbReqsWg.Done()
 
 			for cID, ss := range submits {
-				ss, as := prepareSubmissionsForDB(ss, cID)
+				ss, as := prepareSubmissionsForDB(ss, cID, rd.InstitutionID)
 				dbReqs.Submissions = append(dbReqs.Submissions, *ss...)
 				dbReqs.SubmissionAttachments = append(dbReqs.SubmissionAttachments, *as...)
 			}
 
 		}()
 	} else {
-		go saveOutcomeResultsToDB(results)
-		go saveEnrollmentsToDB(allEnrolls)
+		go saveOutcomeResultsToDB(results, rd.InstitutionID)
+		go saveEnrollmentsToDB(allEnrolls, rd.InstitutionID)
 		for cID, as := range assignments {
 			go func(courseID uint64, ass canvasAssignmentsResponse, subs canvasSubmissionsResponse) {
 				saveAssignmentsToDB(ass, fmt.Sprintf("%d", courseID))
 
 				// should be fine, but just in case
 				if subs != nil {
-					saveSubmissionsToDB(subs, courseID)
+					saveSubmissionsToDB(subs, courseID, rd.InstitutionID)
 				}
 			}(cID, as, submits[cID])
 		}
@@ -1599,6 +1318,31 @@ func AllGradesForTeacher(req *UserGradesRequest) (*UserGradesResponse, *UserGrad
 
 	// calculate grades
 
+	policies, err := getGradingPolicySet()
+	if err != nil {
+		return nil, nil, &GradesErrorResponse{InternalError: fmt.Errorf("error getting grading policies: %w", err)}
+	}
+
+	cutoffs, err := getDropCutoffSet()
+	if err != nil {
+		return nil, nil, &GradesErrorResponse{InternalError: fmt.Errorf("error getting drop cutoffs: %w", err)}
+	}
+
+	// map[rootAccountID]ratings
+	ratings := getProficiencyRatingsForCourses(rd, *allCourses)
+
+	// map[courseID]gradingPolicy
+	coursePolicies := make(map[uint64]gradingPolicy, len(*allCourses))
+	// map[courseID]ratings
+	courseRatings := make(map[uint64][]proficiencyRating, len(*allCourses))
+	// map[courseID]cutoff
+	courseCutoffs := make(map[uint64]*gradeCutoff, len(*allCourses))
+	for _, c := range *allCourses {
+		courseRatings[c.ID] = ratings[uint64(c.RootAccountID)]
+		coursePolicies[c.ID] = gradingPolicyForCourse(policies, rd.InstitutionID, c, courseRatings[c.ID])
+		_, courseCutoffs[c.ID] = dropCutoffForCourse(cutoffs, rd.InstitutionID, c)
+	}
+
 	grades := detailedGrades{}
 
 	for cID, us := range results {
@@ -1607,8 +1351,16 @@ func AllGradesForTeacher(req *UserGradesRequest) (*UserGradesResponse, *UserGrad
 			go func(courseID uint64, userID uint64, scores map[uint64][]canvasOutcomeResult) {
 				defer wg.Done()
 
-				// we're saying it's not after the cutoff for now.
-				grd := *calculateGradeFromOutcomeResults(scores, false)
+				mutex.Lock()
+				calcs := calculations[courseID]
+				mutex.Unlock()
+
+				cutoff := courseCutoffs[courseID]
+				isAfterCutoff := cutoff != nil && cutoff.InEffect
+
+				grd := *calculateGradeFromOutcomeResults(scores, calcs, coursePolicies[courseID], isAfterCutoff)
+				grd.Cutoff = cutoff
+				labelAveragesWithProficiencyRatings(&grd, courseRatings[courseID])
 
 				// we'll now save the grade
 				mutex.Lock()
@@ -1627,34 +1379,51 @@ func AllGradesForTeacher(req *UserGradesRequest) (*UserGradesResponse, *UserGrad
 	// wait for CBL
 	wg.Wait()
 
+	for cID, us := range unchangedCourses {
+		for uID, stored := range us {
+			if grades[uID] == nil {
+				grades[uID] = make(map[uint64]computedGrade)
+			}
+
+			grades[uID][cID] = reusedComputedGrade(stored, coursePolicies[cID], courseCutoffs[cID])
+		}
+	}
+
 	if req.ReturnDBRequests {
 		dbReqsWg.Add(1)
 		go func() {
 			defer dbReqsWg.Done()
 
-			gReqs, rollupReqs := prepareGradesForDB(grades, req.ManualFetch)
+			gReqs, rollupReqs := prepareGradesForDB(grades, req.ManualFetch, rd.InstitutionID)
 
 			dbReqs.Grades = gReqs
 			dbReqs.RollupScores = rollupReqs
 		}()
 	} else {
-		go saveGradesToDB(grades, req.ManualFetch)
+		go saveGradesToDB(grades, req.ManualFetch, rd.InstitutionID)
+	}
+
+	weights, err := getCourseWeights(rd.InstitutionID, *allCourses)
+	if err != nil {
+		// an unweighted GPA is better than no grades at all
+		util.HandleError(fmt.Errorf("error getting course weights: %w", err))
+		weights = nil
 	}
 
-	cGPA := calculateGPAFromDetailedGrades(grades)
+	cGPA := calculateGPAFromDetailedGrades(grades, weights)
 
 	if req.ReturnDBRequests {
 		dbReqsWg.Add(1)
 		go func() {
 			defer dbReqsWg.Done()
 
-			dbReqs.GPA = prepareGPAForDB(cGPA, req.ManualFetch)
+			dbReqs.GPA = prepareGPAForDB(cGPA, req.ManualFetch, rd.InstitutionID)
 		}()
 
 		// wait for them all to finish
 		dbReqsWg.Wait()
 	} else {
-		go saveGPAToDB(cGPA, req.ManualFetch)
+		go saveGPAToDB(cGPA, req.ManualFetch, rd.InstitutionID)
 	}
 
 	if req.ReturnDBRequests {
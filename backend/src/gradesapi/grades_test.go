@@ -0,0 +1,153 @@
+package gradesapi
+
+import (
+	"github.com/iamtheyammer/canvascbl/backend/src/canvasfake"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/course_weights"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/drop_cutoffs"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/grading_policies"
+	"github.com/iamtheyammer/canvascbl/backend/src/env"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+useCanvasFake points the default Canvas instance at a fake Canvas serving the canvasfake test fixtures until the
+returned func is called. Responses aren't cached, and failed requests are retried without waiting.
+
+The terms, grading policies, drop cutoffs and course weights usually come from the database, so they're set to
+none.
+*/
+func useCanvasFake(t *testing.T) (*canvasfake.Server, func()) {
+	f, err := canvasfake.LoadFixtures("../canvasfake/testdata/fixtures.json")
+	if err != nil {
+		t.Fatalf("error loading canvas fake fixtures: %v", err)
+	}
+
+	s := canvasfake.NewServer(*f)
+	ts := httptest.NewServer(s)
+
+	instance, responses, baseDelay := defaultCanvasInstance, canvasResponses, env.CanvasRequestRetryBaseDelay
+	defaultCanvasInstance.Scheme = "http"
+	defaultCanvasInstance.Domain = strings.TrimPrefix(ts.URL, "http://")
+	canvasResponses = nil
+	env.CanvasRequestRetryBaseDelay = time.Millisecond
+
+	validUntil := time.Now().Add(time.Hour)
+	memoizedCurrentTerms.Lock()
+	memoizedCurrentTerms.Terms[0] = memoizedCurrentTerm{ValidUntil: validUntil}
+	memoizedCurrentTerms.Unlock()
+
+	memoizedGradingPolicySet.Lock()
+	memoizedGradingPolicySet.Set = &grading_policies.Set{}
+	memoizedGradingPolicySet.ValidUntil = validUntil
+	memoizedGradingPolicySet.Unlock()
+
+	memoizedDropCutoffs.Lock()
+	memoizedDropCutoffs.Set = &drop_cutoffs.Set{}
+	memoizedDropCutoffs.ValidUntil = validUntil
+	memoizedDropCutoffs.Unlock()
+
+	cws := course_weights.NewSet(nil)
+	memoizedCourseWeights.Lock()
+	memoizedCourseWeights.Set = &cws
+	memoizedCourseWeights.ValidUntil = validUntil
+	memoizedCourseWeights.Unlock()
+
+	return s, func() {
+		ts.Close()
+		defaultCanvasInstance, canvasResponses, env.CanvasRequestRetryBaseDelay = instance, responses, baseDelay
+
+		// the next call gets them from the database again
+		memoizedCurrentTerms.Lock()
+		delete(memoizedCurrentTerms.Terms, 0)
+		memoizedCurrentTerms.Unlock()
+		memoizedGradingPolicySet.Lock()
+		memoizedGradingPolicySet.ValidUntil = time.Time{}
+		memoizedGradingPolicySet.Unlock()
+		memoizedDropCutoffs.Lock()
+		memoizedDropCutoffs.ValidUntil = time.Time{}
+		memoizedDropCutoffs.Unlock()
+		memoizedCourseWeights.Lock()
+		memoizedCourseWeights.ValidUntil = time.Time{}
+		memoizedCourseWeights.Unlock()
+	}
+}
+
+func TestGradesForUser(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup changes the fake before grades are fetched
+		setup func(s *canvasfake.Server)
+		rd    requestDetails
+		// wantGrades is map[courseName]grade for user 101
+		wantGrades map[string]string
+		wantErr    *GradesErrorResponse
+	}{
+		{
+			name: "student",
+			rd:   requestDetails{TokenID: 1, Token: "student-token", RefreshToken: "student-refresh", ScopeVersion: 2},
+			wantGrades: map[string]string{
+				"Biology":       "A",
+				"World History": "N/A",
+				"Geometry":      "N/A",
+			},
+		},
+		{
+			name: "canvas_outage",
+			setup: func(s *canvasfake.Server) {
+				s.FailRequests(2, http.StatusServiceUnavailable)
+			},
+			rd: requestDetails{TokenID: 1, Token: "student-token", RefreshToken: "student-refresh", ScopeVersion: 2},
+			wantGrades: map[string]string{
+				"Biology":       "A",
+				"World History": "N/A",
+				"Geometry":      "N/A",
+			},
+		},
+		{
+			name: "revoked_token",
+			setup: func(s *canvasfake.Server) {
+				s.RevokeToken("student-token")
+				s.RevokeRefreshToken("student-refresh")
+			},
+			rd: requestDetails{TokenID: 1, Token: "student-token", RefreshToken: "student-refresh", ScopeVersion: 2},
+			wantErr: &GradesErrorResponse{
+				Error:      gradesErrorRevokedToken,
+				Action:     gradesErrorActionRedirectToOAuth,
+				StatusCode: http.StatusForbidden,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, done := useCanvasFake(t)
+			defer done()
+
+			if tt.setup != nil {
+				tt.setup(s)
+			}
+
+			rd := tt.rd
+			resp, _, gep := GradesForUser(&UserGradesRequest{Rd: &rd, ReturnDBRequests: true})
+			if tt.wantErr != nil {
+				if gep == nil || gep.Error != tt.wantErr.Error || gep.Action != tt.wantErr.Action ||
+					gep.StatusCode != tt.wantErr.StatusCode {
+					t.Fatalf("GradesForUser() error = %+v, want %+v", gep, tt.wantErr)
+				}
+				return
+			}
+			if gep != nil {
+				t.Fatalf("GradesForUser() error = %+v (internal error %v)", gep, gep.InternalError)
+			}
+
+			for name, want := range tt.wantGrades {
+				if got := resp.SimpleGrades[name][101]; got != want {
+					t.Errorf("GradesForUser() grade in %s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
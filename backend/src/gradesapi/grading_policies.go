@@ -0,0 +1,203 @@
+package gradesapi
+
+import (
+	"fmt"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/grading_policies"
+	"math"
+	"sync"
+	"time"
+)
+
+// gradingPolicy is a grading_policies.Policy ready for calculateGradeFromOutcomeResults.
+type gradingPolicy struct {
+	// ID is zero for the default policy
+	ID                 uint64
+	MostPercent        float64
+	DropWorstScore     bool
+	DropWorstMinScores int
+	// CalculationMethod is grading_policies.CalculationMethodCBLLegacy or grading_policies.CalculationMethodCanvas
+	CalculationMethod string
+	// Grades are sorted by rank, best first
+	Grades []grade
+	// RatingThresholds is map[grade]thresholds for grades with thresholds set by proficiency rating
+	RatingThresholds map[string]gradeRatingThresholds
+}
+
+// gradeRatingThresholds are the descriptions of proficiency ratings whose points are a grade's thresholds.
+type gradeRatingThresholds struct {
+	MostAbove string
+	AllAbove  string
+}
+
+// gradingPolicySetValidFor is how long grading policies are memoized for.
+const gradingPolicySetValidFor = time.Minute * 5
+
+var (
+	defaultGradingPolicy = gradingPolicyFromDB(grading_policies.Default)
+
+	memoizedGradingPolicySet = struct {
+		sync.Mutex
+		Set        *grading_policies.Set
+		ValidUntil time.Time
+	}{}
+)
+
+func gradingPolicyFromDB(p grading_policies.Policy) gradingPolicy {
+	bands := p.Bands
+	// a policy without grades can't give a grade, so we'll use the default grades
+	if len(bands) < 1 {
+		bands = grading_policies.Default.Bands
+	}
+
+	gp := gradingPolicy{
+		ID:                 p.ID,
+		MostPercent:        p.MostPercent,
+		DropWorstScore:     p.DropWorstScore,
+		DropWorstMinScores: int(p.DropWorstMinScores),
+		CalculationMethod:  p.CalculationMethod,
+		Grades:             make([]grade, len(bands)),
+		RatingThresholds:   map[string]gradeRatingThresholds{},
+	}
+
+	for i, b := range bands {
+		gp.Grades[i] = grade{
+			Grade:          b.Grade,
+			Rank:           b.Rank,
+			MostAbove:      b.MostAbove,
+			AllAbove:       b.AllAbove,
+			GPAVal:         b.GPAVal,
+			SubgradeGPAVal: b.SubgradeGPAVal,
+		}
+
+		if len(b.MostAboveRating) > 0 || len(b.AllAboveRating) > 0 {
+			gp.RatingThresholds[b.Grade] = gradeRatingThresholds{
+				MostAbove: b.MostAboveRating,
+				AllAbove:  b.AllAboveRating,
+			}
+		}
+	}
+
+	return gp
+}
+
+/*
+withProficiencyRatings returns a copy of the policy with thresholds set by proficiency rating
+replaced by the rating's points. Thresholds whose rating isn't in rs keep their number.
+*/
+func (p gradingPolicy) withProficiencyRatings(rs []proficiencyRating) gradingPolicy {
+	if len(p.RatingThresholds) < 1 || len(rs) < 1 {
+		return p
+	}
+
+	points := make(map[string]float64, len(rs))
+	for _, r := range rs {
+		points[r.Description] = r.Points
+	}
+
+	grades := make([]grade, len(p.Grades))
+	for i, g := range p.Grades {
+		t := p.RatingThresholds[g.Grade]
+		if pts, ok := points[t.MostAbove]; ok {
+			g.MostAbove = pts
+		}
+
+		if pts, ok := points[t.AllAbove]; ok {
+			g.AllAbove = pts
+		}
+
+		grades[i] = g
+	}
+
+	p.Grades = grades
+	return p
+}
+
+// lowestGrade returns the grade with the lowest rank in the policy.
+func (p gradingPolicy) lowestGrade() grade {
+	lowest := p.Grades[0]
+	for _, g := range p.Grades {
+		if g.Rank < lowest.Rank {
+			lowest = g
+		}
+	}
+
+	return lowest
+}
+
+// numMostOutcomes is how many of numOutcomes outcomes have to be over a grade's MostAbove:
+// MostPercent% of them, rounded down. If that's none, every outcome counts.
+func (p gradingPolicy) numMostOutcomes(numOutcomes int) int {
+	n := int(math.Floor(p.MostPercent * float64(numOutcomes) / float64(100)))
+
+	// if there is only one graded outcome in a class, that outcome is counted
+	// this also fixes an array[-1] bug
+	if n < 1 {
+		return numOutcomes
+	}
+
+	return n
+}
+
+// outcomeCalculation picks how an outcome's score is calculated under the policy.
+// Outcomes without a known Canvas calculation method use the CBL legacy method.
+func (p gradingPolicy) outcomeCalculation(calcs outcomeCalculations, outcomeID uint64) outcomeCalculation {
+	if p.CalculationMethod != grading_policies.CalculationMethodCanvas {
+		return outcomeCalculation{Method: outcomeCalculationCBLLegacy}
+	}
+
+	c, ok := calcs[outcomeID]
+	if !ok {
+		return outcomeCalculation{Method: outcomeCalculationCBLLegacy}
+	}
+
+	switch c.Method {
+	case outcomeCalculationDecayingAverage,
+		outcomeCalculationNMastery,
+		outcomeCalculationLatest,
+		outcomeCalculationHighest,
+		outcomeCalculationAverage:
+		return c
+	default:
+		return outcomeCalculation{Method: outcomeCalculationCBLLegacy}
+	}
+}
+
+// getGradingPolicySet gets all grading policies and assignments, memoized for gradingPolicySetValidFor.
+func getGradingPolicySet() (*grading_policies.Set, error) {
+	memoizedGradingPolicySet.Lock()
+	defer memoizedGradingPolicySet.Unlock()
+
+	if memoizedGradingPolicySet.Set != nil && memoizedGradingPolicySet.ValidUntil.After(time.Now()) {
+		return memoizedGradingPolicySet.Set, nil
+	}
+
+	s, err := grading_policies.GetSet(db)
+	if err != nil {
+		return nil, fmt.Errorf("error getting grading policy set: %w", err)
+	}
+
+	memoizedGradingPolicySet.Set = s
+	memoizedGradingPolicySet.ValidUntil = time.Now().Add(gradingPolicySetValidFor)
+
+	return s, nil
+}
+
+// gradingPolicyForCourse picks the grading policy for a course at an institution, falling back to the default policy.
+// ratings are the proficiency ratings of the course's root account, used for thresholds set by rating.
+func gradingPolicyForCourse(
+	s *grading_policies.Set,
+	institutionID uint64,
+	c canvasCourse,
+	ratings []proficiencyRating,
+) gradingPolicy {
+	if s == nil {
+		return defaultGradingPolicy
+	}
+
+	p := s.ForCourse(institutionID, c.ID, uint64(c.EnrollmentTermID), uint64(c.RootAccountID))
+	if p == nil {
+		return defaultGradingPolicy
+	}
+
+	return gradingPolicyFromDB(*p).withProficiencyRatings(ratings)
+}
@@ -0,0 +1,184 @@
+package gradesapi
+
+import (
+	"fmt"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services"
+	gradessvc "github.com/iamtheyammer/canvascbl/backend/src/db/services/grades"
+	"github.com/iamtheyammer/canvascbl/backend/src/env"
+	"net/url"
+	"sync"
+	"time"
+)
+
+/*
+Incremental fetches (used by fetch_all) ask Canvas whether anything in a course was graded since the course's grades
+were last stored before getting its outcome results, assignments and submissions. Courses where nothing was graded
+are skipped, and their last stored grades are reused. Reused grades aren't stored again.
+
+Grades are always fetched for courses where a user doesn't have a stored grade yet, or where a stored grade is
+older than env.CanvasIncrementalFetchMaxAge, which catches changes that aren't grading, like drop cutoffs passing.
+*/
+
+type getUnchangedCoursesRequest struct {
+	courses []canvasCourse
+	// users is map[courseID][]userID, the users whose grades are calculated in each course
+	users map[uint64][]uint64
+	// studentIDs are sent to Canvas as student_ids[], like when getting submissions
+	studentIDs []string
+}
+
+/*
+getUnchangedCourses gets the courses in req where nothing was graded since their grades were stored,
+along with those grades, as map[courseID]map[userID]gradessvc.Grade.
+
+Tokens that can't get submissions can't tell, so nothing is unchanged for them.
+*/
+func getUnchangedCourses(rd requestDetails, req *getUnchangedCoursesRequest) (map[uint64]map[uint64]gradessvc.Grade, error) {
+	unchanged := map[uint64]map[uint64]gradessvc.Grade{}
+	if !env.CanvasIncrementalFetch || !rd.hasScopeVersion(2) || len(req.courses) < 1 {
+		return unchanged, nil
+	}
+
+	var (
+		userIDs   []uint64
+		seenUsers = map[uint64]struct{}{}
+		courseIDs []uint64
+	)
+
+	for _, c := range req.courses {
+		courseIDs = append(courseIDs, c.ID)
+
+		for _, uID := range req.users[c.ID] {
+			if _, ok := seenUsers[uID]; !ok {
+				seenUsers[uID] = struct{}{}
+				userIDs = append(userIDs, uID)
+			}
+		}
+	}
+
+	if len(userIDs) < 1 {
+		return unchanged, nil
+	}
+
+	stored, err := gradessvc.List(services.WithContext(rd.ctx(), db), &gradessvc.ListRequest{
+		UserCanvasIDs: &userIDs,
+		CourseIDs:     &courseIDs,
+		InstitutionID: &rd.InstitutionID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing stored grades: %w", err)
+	}
+
+	// map[courseID]map[userID]gradessvc.Grade
+	storedGrades := map[uint64]map[uint64]gradessvc.Grade{}
+	for _, g := range *stored {
+		if storedGrades[g.CourseID] == nil {
+			storedGrades[g.CourseID] = map[uint64]gradessvc.Grade{}
+		}
+
+		storedGrades[g.CourseID][g.UserCanvasID] = g
+	}
+
+	var (
+		mutex    = sync.Mutex{}
+		wg       = sync.WaitGroup{}
+		checkErr error
+	)
+
+	for _, c := range req.courses {
+		cGrades := storedGrades[c.ID]
+		if len(req.users[c.ID]) < 1 {
+			continue
+		}
+
+		var oldest time.Time
+		complete := true
+		// only users in the course now, not everyone who ever had a grade in it
+		grades := make(map[uint64]gradessvc.Grade, len(req.users[c.ID]))
+		for _, uID := range req.users[c.ID] {
+			g, ok := cGrades[uID]
+			if !ok {
+				complete = false
+				break
+			}
+
+			grades[uID] = g
+			if oldest.IsZero() || g.InsertedAt.Before(oldest) {
+				oldest = g.InsertedAt
+			}
+		}
+
+		if !complete || time.Since(oldest) > env.CanvasIncrementalFetchMaxAge {
+			continue
+		}
+
+		// the fetch that stored the oldest grade started up to CanvasFetchAllUserTimeout before it was stored,
+		// and grading in that time might not have made it in.
+		since := oldest.Add(-env.CanvasFetchAllUserTimeout)
+
+		wg.Add(1)
+		go func(courseID uint64, grades map[uint64]gradessvc.Grade) {
+			defer wg.Done()
+
+			graded, gErr := hasCanvasCourseGradedSince(rd, fmt.Sprintf("%d", courseID), req.studentIDs, since)
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if gErr != nil {
+				checkErr = gErr
+				return
+			}
+
+			if !graded {
+				unchanged[courseID] = grades
+			}
+		}(c.ID, grades)
+	}
+
+	wg.Wait()
+
+	if checkErr != nil {
+		return nil, fmt.Errorf("error checking courses for changes: %w", checkErr)
+	}
+
+	return unchanged, nil
+}
+
+// hasCanvasCourseGradedSince is whether any submission in a course was graded since since.
+// It only asks for one submission, so it's much cheaper than getting all of them.
+func hasCanvasCourseGradedSince(rd requestDetails, courseID string, studentIDs []string, since time.Time) (bool, error) {
+	q := url.Values{}
+	q.Add("per_page", "1")
+	for _, id := range studentIDs {
+		q.Add("student_ids[]", id)
+	}
+
+	// time is ISO8601 (per Canvas's spec)
+	q.Add("graded_since", since.UTC().Format("2006-01-02T15:04:05Z"))
+
+	var submissions canvasSubmissionsResponse
+	_, err := makeCanvasGetRequest("api/v1/courses/"+courseID+"/students/submissions?"+q.Encode(), rd, &submissions)
+	if err != nil {
+		return false, fmt.Errorf("error getting canvas submissions graded since %s for course %s: %w", since, courseID, err)
+	}
+
+	return len(submissions) > 0, nil
+}
+
+// reusedComputedGrade makes a computedGrade from a stored grade, without averages, explanations or forecasts.
+func reusedComputedGrade(stored gradessvc.Grade, policy gradingPolicy, cutoff *gradeCutoff) computedGrade {
+	g := grade{Grade: stored.Grade}
+	for _, pg := range policy.Grades {
+		if pg.Grade == stored.Grade {
+			g = pg
+			break
+		}
+	}
+
+	return computedGrade{
+		Grade:           g,
+		GradingPolicyID: policy.ID,
+		Cutoff:          cutoff,
+		reused:          true,
+	}
+}
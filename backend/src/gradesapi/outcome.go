@@ -42,6 +42,9 @@ func OutcomeHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params
 			Action: gradesErrorActionRedirectToOAuth,
 		}, http.StatusForbidden)
 		return
+	} else if errors.Is(err, canvasErrorRateLimitExceededError) {
+		handleError(w, gradesErrorCanvasRateLimitedResponse, http.StatusTooManyRequests)
+		return
 	} else if errors.Is(err, canvasErrorUnknownError) {
 		handleError(w, gradesErrorUnknownCanvasErrorResponse, util.CanvasProxyErrorCode)
 		return
@@ -0,0 +1,261 @@
+package gradesapi
+
+import (
+	"errors"
+	"fmt"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/outcome_proficiencies"
+	"github.com/iamtheyammer/canvascbl/backend/src/oauth2"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type proficiencyRating struct {
+	Description string  `json:"description"`
+	Points      float64 `json:"points"`
+	Mastery     bool    `json:"mastery"`
+	Color       string  `json:"color"`
+}
+
+type outcomeProficiencyResponse struct {
+	RootAccountID uint64 `json:"root_account_id"`
+	// Ratings are sorted by points, best first
+	Ratings []proficiencyRating `json:"ratings"`
+}
+
+const (
+	// proficiencyRatingsStaleAfter is how long ratings cached in the db are used before they're fetched again.
+	proficiencyRatingsStaleAfter = time.Hour * 24
+	// proficiencyRatingsValidFor is how long ratings are memoized for.
+	proficiencyRatingsValidFor = time.Minute * 5
+)
+
+var memoizedProficiencyRatings = struct {
+	sync.Mutex
+	// map[rootAccountID]ratings
+	Ratings map[uint64]struct {
+		Ratings    []proficiencyRating
+		ValidUntil time.Time
+	}
+}{
+	Ratings: map[uint64]struct {
+		Ratings    []proficiencyRating
+		ValidUntil time.Time
+	}{},
+}
+
+// OutcomeProficiencyHandler handles /api/v1/courses/:courseID/outcome_proficiency
+func OutcomeProficiencyHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	courseID := ps.ByName("courseID")
+	if len(courseID) < 1 || !util.ValidateIntegerString(courseID) {
+		util.SendBadRequest(w, "missing or invalid courseID as url param")
+		return
+	}
+
+	userID, rdP, sess, errCtx := authorizer(w, r, []oauth2.Scope{oauth2.ScopeOutcomes}, &oauth2.AuthorizerAPICall{
+		Method:    "GET",
+		RoutePath: "courses/:courseID/outcome_proficiency",
+	})
+	if (userID == nil || rdP == nil || errCtx == nil) && sess == nil {
+		return
+	}
+
+	errCtx.AddCustomField("course_id", courseID)
+
+	var course *canvasCourse
+	rd, err := handleRequestWithTokenRefresh(func(reqD *requestDetails) error {
+		cs, csErr := getCanvasCourses(*reqD)
+		if csErr != nil {
+			return fmt.Errorf("error getting canvas courses: %w", csErr)
+		}
+
+		for _, c := range *cs {
+			if strconv.Itoa(int(c.ID)) == courseID {
+				cc := c
+				course = &cc
+				return nil
+			}
+		}
+
+		return errCourseNotFound
+	}, rdP, *userID)
+	if errors.Is(err, errCourseNotFound) {
+		handleError(w, GradesErrorResponse{
+			Error: gradesErrorCourseNotFound,
+		}, http.StatusNotFound)
+		return
+	} else if errors.Is(err, canvasErrorInvalidAccessTokenError) ||
+		errors.Is(err, canvasErrorInsufficientScopesOnAccessTokenError) {
+		handleError(w, GradesErrorResponse{
+			Error:  gradesErrorRevokedToken,
+			Action: gradesErrorActionRedirectToOAuth,
+		}, http.StatusForbidden)
+		return
+	} else if errors.Is(err, canvasErrorRateLimitExceededError) {
+		handleError(w, gradesErrorCanvasRateLimitedResponse, http.StatusTooManyRequests)
+		return
+	} else if errors.Is(err, canvasErrorUnknownError) {
+		handleError(w, gradesErrorUnknownCanvasErrorResponse, util.CanvasProxyErrorCode)
+		return
+	} else if err != nil {
+		handleISE(w, errCtx.Apply(fmt.Errorf("error getting course for outcome proficiency: %w", err)))
+		return
+	}
+
+	rootAccountID := uint64(course.RootAccountID)
+	ratings, err := getProficiencyRatings(rd, rootAccountID)
+	if err != nil {
+		handleISE(w, errCtx.Apply(fmt.Errorf("error getting proficiency ratings: %w", err)))
+		return
+	}
+
+	sendJSON(w, &outcomeProficiencyResponse{
+		RootAccountID: rootAccountID,
+		Ratings:       ratings,
+	})
+	return
+}
+
+/*
+getProficiencyRatings gets a root account's proficiency ratings, best first.
+
+Ratings are cached in the db and fetched from Canvas again once they're older than
+proficiencyRatingsStaleAfter. If Canvas can't be reached, stale ratings are used.
+Accounts without ratings return an empty slice.
+*/
+func getProficiencyRatings(rd requestDetails, rootAccountID uint64) ([]proficiencyRating, error) {
+	memoizedProficiencyRatings.Lock()
+	defer memoizedProficiencyRatings.Unlock()
+
+	if m, ok := memoizedProficiencyRatings.Ratings[rootAccountID]; ok && m.ValidUntil.After(time.Now()) {
+		return m.Ratings, nil
+	}
+
+	memoize := func(rs []proficiencyRating) {
+		memoizedProficiencyRatings.Ratings[rootAccountID] = struct {
+			Ratings    []proficiencyRating
+			ValidUntil time.Time
+		}{rs, time.Now().Add(proficiencyRatingsValidFor)}
+	}
+
+	cached, err := outcome_proficiencies.List(db, &outcome_proficiencies.ListRequest{
+		RootAccountIDs: []uint64{rootAccountID},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing cached proficiency ratings: %w", err)
+	}
+
+	ratings := []proficiencyRating{}
+	for _, r := range *cached {
+		ratings = append(ratings, proficiencyRating{
+			Description: r.Description,
+			Points:      r.Points,
+			Mastery:     r.Mastery,
+			Color:       r.Color,
+		})
+	}
+
+	if len(*cached) > 0 && (*cached)[0].InsertedAt.Add(proficiencyRatingsStaleAfter).After(time.Now()) {
+		memoize(ratings)
+		return ratings, nil
+	}
+
+	p, err := getCanvasOutcomeProficiency(rd, strconv.Itoa(int(rootAccountID)))
+	if err != nil {
+		if len(ratings) > 0 {
+			util.HandleError(fmt.Errorf("error refreshing proficiency ratings, using stale ones: %w", err))
+			memoize(ratings)
+			return ratings, nil
+		}
+
+		// don't ask Canvas again for a bit, as it'll probably fail the same way
+		memoize(ratings)
+		return nil, fmt.Errorf("error getting canvas outcome proficiency: %w", err)
+	}
+
+	ratings = []proficiencyRating{}
+	var dbRatings []outcome_proficiencies.Rating
+	for _, r := range p.Ratings {
+		ratings = append(ratings, proficiencyRating{
+			Description: r.Description,
+			Points:      r.Points,
+			Mastery:     r.Mastery,
+			Color:       r.Color,
+		})
+
+		dbRatings = append(dbRatings, outcome_proficiencies.Rating{
+			Description: r.Description,
+			Points:      r.Points,
+			Mastery:     r.Mastery,
+			Color:       r.Color,
+		})
+	}
+
+	sortProficiencyRatings(ratings)
+	memoize(ratings)
+
+	go saveProficiencyRatingsToDB(rootAccountID, dbRatings)
+
+	return ratings, nil
+}
+
+/*
+getProficiencyRatingsForCourses gets proficiency ratings for every root account in cs,
+as map[rootAccountID<uint64>][]proficiencyRating.
+
+Ratings only label scores and fill in thresholds, so accounts whose ratings can't be
+fetched are left out and the error is handled here.
+*/
+func getProficiencyRatingsForCourses(rd requestDetails, cs []canvasCourse) map[uint64][]proficiencyRating {
+	ratings := make(map[uint64][]proficiencyRating)
+	for _, c := range cs {
+		accountID := uint64(c.RootAccountID)
+		if _, ok := ratings[accountID]; ok || accountID < 1 {
+			continue
+		}
+
+		rs, err := getProficiencyRatings(rd, accountID)
+		if err != nil {
+			util.HandleError(fmt.Errorf("error getting proficiency ratings for root account %d: %w", accountID, err))
+			rs = []proficiencyRating{}
+		}
+
+		ratings[accountID] = rs
+	}
+
+	return ratings
+}
+
+// sortProficiencyRatings sorts ratings by points, best first.
+func sortProficiencyRatings(rs []proficiencyRating) {
+	sort.SliceStable(rs, func(i, j int) bool {
+		return rs[i].Points > rs[j].Points
+	})
+}
+
+// proficiencyRatingForScore returns the best rating that score is at or over, or nil if there isn't one.
+// rs must be sorted best first.
+func proficiencyRatingForScore(rs []proficiencyRating, score float64) *proficiencyRating {
+	for _, r := range rs {
+		if score >= r.Points {
+			rating := r
+			return &rating
+		}
+	}
+
+	return nil
+}
+
+// labelAveragesWithProficiencyRatings sets the proficiency rating of every average in g.
+func labelAveragesWithProficiencyRatings(g *computedGrade, rs []proficiencyRating) {
+	for oID, avg := range g.Averages {
+		if r := proficiencyRatingForScore(rs, avg.Average); r != nil {
+			avg.ProficiencyRating = r.Description
+			g.Averages[oID] = avg
+		}
+	}
+}
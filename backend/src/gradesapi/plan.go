@@ -0,0 +1,446 @@
+package gradesapi
+
+import (
+	"errors"
+	"fmt"
+	"github.com/iamtheyammer/canvascbl/backend/src/oauth2"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"github.com/julienschmidt/httprouter"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+type gradePlanReason string
+
+const (
+	gradesErrorUnknownTargetGrade = "unknown target grade"
+
+	// gradePlanReasonAllAbove means the outcome has to be over the target's AllAbove.
+	gradePlanReasonAllAbove = gradePlanReason("all_above")
+	// gradePlanReasonMostAbove means the outcome has to be over the target's MostAbove.
+	gradePlanReasonMostAbove = gradePlanReason("most_above")
+
+	// gradePlanMaxAssessments is the most future assessments we'll plan for on a single outcome.
+	gradePlanMaxAssessments = 10
+	// gradePlanDefaultPossible is the max score for outcomes without a possible score.
+	gradePlanDefaultPossible = 4
+)
+
+// gradePlanRequirement is a set of future scores needed on a single outcome.
+type gradePlanRequirement struct {
+	OutcomeID uint64 `json:"outcome_id"`
+	// Times is the number of future assessments needed.
+	Times int `json:"times"`
+	// MinScore is the score needed on each of those assessments.
+	MinScore float64 `json:"min_score"`
+	// Threshold is the average the outcome needs to reach.
+	Threshold        float64         `json:"threshold"`
+	Reason           gradePlanReason `json:"reason"`
+	CurrentAverage   float64         `json:"current_average"`
+	ProjectedAverage float64         `json:"projected_average"`
+}
+
+// gradePlanLimitingOutcome is the outcome keeping a user from a grade.
+type gradePlanLimitingOutcome struct {
+	OutcomeID uint64          `json:"outcome_id"`
+	Average   float64         `json:"average"`
+	Threshold float64         `json:"threshold"`
+	Reason    gradePlanReason `json:"reason"`
+}
+
+type gradePlan struct {
+	Target  grade         `json:"target"`
+	Current computedGrade `json:"current"`
+	// AlreadyAchieved is whether the current grade is at least the target.
+	AlreadyAchieved bool `json:"already_achieved"`
+	// Achievable is whether the target can be reached in gradePlanMaxAssessments per outcome.
+	Achievable      bool                      `json:"achievable"`
+	Requirements    []gradePlanRequirement    `json:"requirements"`
+	LimitingOutcome *gradePlanLimitingOutcome `json:"limiting_outcome,omitempty"`
+	// Projected is the grade if every requirement is met.
+	Projected *computedGrade `json:"projected,omitempty"`
+}
+
+// gradePlanOption is one way to get an outcome to a threshold.
+type gradePlanOption struct {
+	ok       bool
+	times    int
+	minScore float64
+	average  float64
+}
+
+// GradePlanHandler handles GET /api/v1/courses/:courseID/grades/plan
+func GradePlanHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	courseID := ps.ByName("courseID")
+	if len(courseID) < 1 || !util.ValidateIntegerString(courseID) {
+		util.SendBadRequest(w, "missing or invalid courseID as url param")
+		return
+	}
+
+	q := r.URL.Query()
+
+	target := q.Get("target")
+	if len(target) < 1 {
+		util.SendBadRequest(w, "missing target as query param")
+		return
+	}
+
+	var requestedUserID uint64
+	if uID := q.Get("user_id"); len(uID) > 0 {
+		id, err := strconv.Atoi(uID)
+		if err != nil || id < 1 {
+			util.SendBadRequest(w, "invalid user_id as query param")
+			return
+		}
+
+		requestedUserID = uint64(id)
+	}
+
+	userID, rdP, sess, errCtx := authorizer(w, r, []oauth2.Scope{oauth2.ScopeDetailedGrades}, &oauth2.AuthorizerAPICall{
+		Method:    "GET",
+		RoutePath: "courses/:courseID/grades/plan",
+		Query:     &r.URL.RawQuery,
+	})
+	if (userID == nil || rdP == nil || errCtx == nil) && sess == nil {
+		return
+	}
+
+	errCtx.AddCustomFields(map[string]interface{}{
+		"course_id": courseID,
+		"user_id":   requestedUserID,
+		"target":    target,
+	})
+
+	canvasUserID, err := canvasUserIDForGradeRequest(*userID, requestedUserID)
+	if err != nil {
+		handleISE(w, errCtx.Apply(fmt.Errorf("error getting canvas user id for grade plan: %w", err)))
+		return
+	}
+
+	course, results, outcomes, err := getCourseOutcomeResultsForUser(rdP, *userID, courseID, canvasUserID)
+	if errors.Is(err, errCourseNotFound) {
+		handleError(w, GradesErrorResponse{
+			Error: gradesErrorCourseNotFound,
+		}, http.StatusNotFound)
+		return
+	} else if errors.Is(err, errCantSeeUserGrades) {
+		handleError(w, GradesErrorResponse{
+			Error: gradesErrorCantSeeUserGrades,
+		}, http.StatusForbidden)
+		return
+	} else if errors.Is(err, canvasErrorInvalidAccessTokenError) ||
+		errors.Is(err, canvasErrorInsufficientScopesOnAccessTokenError) {
+		handleError(w, GradesErrorResponse{
+			Error:  gradesErrorRevokedToken,
+			Action: gradesErrorActionRedirectToOAuth,
+		}, http.StatusForbidden)
+		return
+	} else if errors.Is(err, canvasErrorRateLimitExceededError) {
+		handleError(w, gradesErrorCanvasRateLimitedResponse, http.StatusTooManyRequests)
+		return
+	} else if errors.Is(err, canvasErrorUnknownError) {
+		handleError(w, gradesErrorUnknownCanvasErrorResponse, util.CanvasProxyErrorCode)
+		return
+	} else if err != nil {
+		handleISE(w, errCtx.Apply(fmt.Errorf("error getting outcome results for grade plan: %w", err)))
+		return
+	}
+
+	policies, err := getGradingPolicySet()
+	if err != nil {
+		handleISE(w, errCtx.Apply(fmt.Errorf("error getting grading policies for grade plan: %w", err)))
+		return
+	}
+
+	ratings := getProficiencyRatingsForCourses(*rdP, []canvasCourse{*course})[uint64(course.RootAccountID)]
+	policy := gradingPolicyForCourse(policies, rdP.InstitutionID, *course, ratings)
+
+	cutoffs, err := getDropCutoffSet()
+	if err != nil {
+		handleISE(w, errCtx.Apply(fmt.Errorf("error getting drop cutoffs for grade plan: %w", err)))
+		return
+	}
+
+	isAfterCutoff, cutoff := dropCutoffForCourse(cutoffs, rdP.InstitutionID, *course)
+
+	var targetGrade *grade
+	for _, g := range policy.Grades {
+		if g.Grade == target {
+			tg := g
+			targetGrade = &tg
+			break
+		}
+	}
+
+	if targetGrade == nil {
+		handleError(w, GradesErrorResponse{
+			Error: gradesErrorUnknownTargetGrade,
+		}, http.StatusBadRequest)
+		return
+	}
+
+	plan := planGrade(results, outcomeCalculationsFromOutcomes(outcomes), policy, *targetGrade, isAfterCutoff)
+	plan.Current.Cutoff = cutoff
+	if plan.Projected != nil {
+		plan.Projected.Cutoff = cutoff
+	}
+
+	sendJSON(w, plan)
+	return
+}
+
+/*
+planGrade finds the fewest future outcome scores needed to get target.
+
+Every outcome has to average at least target.AllAbove, and policy.MostPercent of outcomes have to
+average at least target.MostAbove. Outcomes that are cheapest to raise are picked for MostAbove.
+Future scores are assumed to be the same on every assessment, and averages are calculated with
+calculateOutcomeAverage, so each outcome is calculated (and its lowest score dropped) just like it is for real grades.
+
+The plan is checked with calculateGradeFromOutcomeResults, so it can't drift from the real calculation.
+*/
+func planGrade(
+	results map[uint64][]canvasOutcomeResult,
+	calcs outcomeCalculations,
+	policy gradingPolicy,
+	target grade,
+	isAfterCutoff bool,
+) gradePlan {
+	current := *calculateGradeFromOutcomeResults(results, calcs, policy, isAfterCutoff)
+
+	plan := gradePlan{
+		Target:       target,
+		Current:      current,
+		Requirements: []gradePlanRequirement{},
+	}
+
+	if current.Grade.Rank >= target.Rank {
+		plan.AlreadyAchieved = true
+		plan.Achievable = true
+		return plan
+	}
+
+	// outcome IDs sorted by average, best first
+	var outcomeIDs []uint64
+	for oID := range current.Averages {
+		outcomeIDs = append(outcomeIDs, oID)
+	}
+
+	sort.Slice(outcomeIDs, func(i, j int) bool {
+		ai, aj := current.Averages[outcomeIDs[i]].Average, current.Averages[outcomeIDs[j]].Average
+		if ai == aj {
+			return outcomeIDs[i] < outcomeIDs[j]
+		}
+		return ai > aj
+	})
+
+	// there aren't any graded outcomes, so there's nothing to plan with
+	if len(outcomeIDs) < 1 {
+		return plan
+	}
+
+	plan.LimitingOutcome = findLimitingOutcome(current, outcomeIDs, policy, target)
+
+	mostNeeded := policy.numMostOutcomes(len(outcomeIDs))
+
+	allOptions := make(map[uint64]gradePlanOption, len(outcomeIDs))
+	mostOptions := make(map[uint64]gradePlanOption, len(outcomeIDs))
+	for _, oID := range outcomeIDs {
+		calc := policy.outcomeCalculation(calcs, oID)
+		allOptions[oID] = planOutcome(results[oID], calc, policy, target.AllAbove, isAfterCutoff)
+		mostOptions[oID] = planOutcome(
+			results[oID],
+			calc,
+			policy,
+			math.Max(target.AllAbove, target.MostAbove),
+			isAfterCutoff,
+		)
+
+		// every outcome must be over AllAbove
+		if !allOptions[oID].ok {
+			return plan
+		}
+	}
+
+	// pick the outcomes that cost the least extra to get over MostAbove
+	var mostCandidates []uint64
+	for _, oID := range outcomeIDs {
+		if mostOptions[oID].ok {
+			mostCandidates = append(mostCandidates, oID)
+		}
+	}
+
+	if len(mostCandidates) < mostNeeded {
+		return plan
+	}
+
+	sort.SliceStable(mostCandidates, func(i, j int) bool {
+		ci := mostOptions[mostCandidates[i]].times - allOptions[mostCandidates[i]].times
+		cj := mostOptions[mostCandidates[j]].times - allOptions[mostCandidates[j]].times
+		if ci == cj {
+			return mostOptions[mostCandidates[i]].minScore < mostOptions[mostCandidates[j]].minScore
+		}
+		return ci < cj
+	})
+
+	chosenForMost := make(map[uint64]struct{}, mostNeeded)
+	for _, oID := range mostCandidates[:mostNeeded] {
+		chosenForMost[oID] = struct{}{}
+	}
+
+	var changes []gradeSimulationChange
+	for _, oID := range outcomeIDs {
+		opt := allOptions[oID]
+		reason := gradePlanReasonAllAbove
+		threshold := target.AllAbove
+		if _, ok := chosenForMost[oID]; ok {
+			opt = mostOptions[oID]
+			reason = gradePlanReasonMostAbove
+			threshold = math.Max(target.AllAbove, target.MostAbove)
+		}
+
+		if opt.times < 1 {
+			continue
+		}
+
+		plan.Requirements = append(plan.Requirements, gradePlanRequirement{
+			OutcomeID:        oID,
+			Times:            opt.times,
+			MinScore:         opt.minScore,
+			Threshold:        threshold,
+			Reason:           reason,
+			CurrentAverage:   current.Averages[oID].Average,
+			ProjectedAverage: opt.average,
+		})
+
+		for i := 0; i < opt.times; i++ {
+			changes = append(changes, gradeSimulationChange{
+				Type:      gradeSimulationChangeAdd,
+				OutcomeID: oID,
+				Score:     opt.minScore,
+			})
+		}
+	}
+
+	// only outcomes with results are planned, so their results' Possible is enough
+	possible := make(map[uint64]float64, len(results))
+	for oID, rs := range results {
+		if len(rs) > 0 {
+			possible[oID] = rs[0].Possible
+		}
+	}
+
+	projectedResults, err := applyGradeSimulationChanges(results, possible, changes)
+	if err != nil {
+		// adds can't fail, but just in case
+		return plan
+	}
+
+	projected := calculateGradeFromOutcomeResults(projectedResults, calcs, policy, isAfterCutoff)
+	plan.Projected = projected
+	plan.Achievable = projected.Grade.Rank >= target.Rank
+
+	return plan
+}
+
+// findLimitingOutcome finds the outcome keeping the current grade from the target.
+// outcomeIDs must be sorted by average, best first.
+func findLimitingOutcome(
+	current computedGrade,
+	outcomeIDs []uint64,
+	policy gradingPolicy,
+	target grade,
+) *gradePlanLimitingOutcome {
+	lowestID := outcomeIDs[len(outcomeIDs)-1]
+	if avg := current.Averages[lowestID].Average; avg < target.AllAbove {
+		return &gradePlanLimitingOutcome{
+			OutcomeID: lowestID,
+			Average:   avg,
+			Threshold: target.AllAbove,
+			Reason:    gradePlanReasonAllAbove,
+		}
+	}
+
+	counted := policy.numMostOutcomes(len(outcomeIDs))
+
+	lowestCountedID := outcomeIDs[counted-1]
+	if avg := current.Averages[lowestCountedID].Average; avg < target.MostAbove {
+		return &gradePlanLimitingOutcome{
+			OutcomeID: lowestCountedID,
+			Average:   avg,
+			Threshold: target.MostAbove,
+			Reason:    gradePlanReasonMostAbove,
+		}
+	}
+
+	return nil
+}
+
+/*
+planOutcome finds the fewest future assessments, and then the lowest score on each of them,
+that get an outcome's average to at least threshold.
+
+Scores are rounded up to the hundredth.
+*/
+func planOutcome(
+	rs []canvasOutcomeResult,
+	calc outcomeCalculation,
+	policy gradingPolicy,
+	threshold float64,
+	isAfterCutoff bool,
+) gradePlanOption {
+	averageWith := func(times int, score float64) float64 {
+		withFuture := append([]canvasOutcomeResult{}, rs...)
+		for i := 0; i < times; i++ {
+			withFuture = append(withFuture, canvasOutcomeResult{Score: score})
+		}
+
+		avg, ok := calculateOutcomeAverage(withFuture, calc, policy, isAfterCutoff)
+		if !ok {
+			return 0
+		}
+
+		return avg.Average
+	}
+
+	if avg := averageWith(0, 0); avg >= threshold {
+		return gradePlanOption{ok: true, average: avg}
+	}
+
+	possible := float64(gradePlanDefaultPossible)
+	for _, r := range rs {
+		if r.Possible > 0 {
+			possible = r.Possible
+			break
+		}
+	}
+
+	for times := 1; times <= gradePlanMaxAssessments; times++ {
+		if averageWith(times, possible) < threshold {
+			continue
+		}
+
+		// the average only goes up with the score, so we can binary search in hundredths
+		low, high := 1, int(math.Round(possible*100))
+		for low < high {
+			mid := (low + high) / 2
+			if averageWith(times, float64(mid)/100) >= threshold {
+				high = mid
+			} else {
+				low = mid + 1
+			}
+		}
+
+		minScore := float64(high) / 100
+		return gradePlanOption{
+			ok:       true,
+			times:    times,
+			minScore: minScore,
+			average:  averageWith(times, minScore),
+		}
+	}
+
+	return gradePlanOption{}
+}
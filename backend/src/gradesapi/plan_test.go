@@ -0,0 +1,411 @@
+package gradesapi
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// scoresOf makes outcome results with scores, for tests.
+func scoresOf(scores ...float64) []canvasOutcomeResult {
+	rs := make([]canvasOutcomeResult, len(scores))
+	for i, s := range scores {
+		rs[i] = canvasOutcomeResult{Score: s}
+	}
+
+	return rs
+}
+
+// roughlyEqual compares averages, which pick up floating point error from being summed and divided.
+func roughlyEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+// gradeNamed gets a grade from the default grading policy.
+func gradeNamed(t *testing.T, name string) grade {
+	for _, g := range defaultGradingPolicy.Grades {
+		if g.Grade == name {
+			return g
+		}
+	}
+
+	t.Fatalf("no grade %s in the default grading policy", name)
+	return grade{}
+}
+
+func Test_planOutcome(t *testing.T) {
+	noDrop := defaultGradingPolicy
+	noDrop.DropWorstScore = false
+
+	tests := []struct {
+		name          string
+		rs            []canvasOutcomeResult
+		policy        gradingPolicy
+		threshold     float64
+		isAfterCutoff bool
+		want          gradePlanOption
+	}{
+		{
+			name:      "already_met",
+			rs:        scoresOf(4),
+			policy:    defaultGradingPolicy,
+			threshold: 3,
+			want:      gradePlanOption{ok: true, average: 4},
+		},
+		{
+			// the 2 is dropped once there's a higher score, so a 3 is enough
+			name:      "drop_lowest",
+			rs:        scoresOf(2),
+			policy:    defaultGradingPolicy,
+			threshold: 3,
+			want:      gradePlanOption{ok: true, times: 1, minScore: 3, average: 3},
+		},
+		{
+			name:      "without_drop_lowest",
+			rs:        scoresOf(2),
+			policy:    noDrop,
+			threshold: 3,
+			want:      gradePlanOption{ok: true, times: 1, minScore: 4, average: 3},
+		},
+		{
+			// nothing is dropped after the cutoff either
+			name:          "after_cutoff",
+			rs:            scoresOf(2),
+			policy:        defaultGradingPolicy,
+			threshold:     3,
+			isAfterCutoff: true,
+			want:          gradePlanOption{ok: true, times: 1, minScore: 4, average: 3},
+		},
+		{
+			name:      "hundredths",
+			rs:        scoresOf(3),
+			policy:    defaultGradingPolicy,
+			threshold: 3.3,
+			want:      gradePlanOption{ok: true, times: 1, minScore: 3.3, average: 3.3},
+		},
+		{
+			// one 4 with the 1 dropped is 2.5, so it takes two
+			name:      "more_than_once",
+			rs:        scoresOf(1, 1),
+			policy:    defaultGradingPolicy,
+			threshold: 3,
+			want:      gradePlanOption{ok: true, times: 2, minScore: 4, average: 3},
+		},
+		{
+			name:      "possible_from_results",
+			rs:        []canvasOutcomeResult{{Score: 2, Possible: 5}},
+			policy:    defaultGradingPolicy,
+			threshold: 4.5,
+			want:      gradePlanOption{ok: true, times: 1, minScore: 4.5, average: 4.5},
+		},
+		{
+			name:      "unreachable",
+			rs:        scoresOf(1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1),
+			policy:    defaultGradingPolicy,
+			threshold: 3,
+			want:      gradePlanOption{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := planOutcome(
+				tt.rs,
+				outcomeCalculation{Method: outcomeCalculationCBLLegacy},
+				tt.policy,
+				tt.threshold,
+				tt.isAfterCutoff,
+			)
+
+			if got.ok != tt.want.ok ||
+				got.times != tt.want.times ||
+				!roughlyEqual(got.minScore, tt.want.minScore) ||
+				!roughlyEqual(got.average, tt.want.average) {
+				t.Errorf("planOutcome() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_findLimitingOutcome(t *testing.T) {
+	tests := []struct {
+		name string
+		// averages are best first, with outcome IDs starting at 1
+		averages []float64
+		target   string
+		want     *gradePlanLimitingOutcome
+	}{
+		{
+			name:     "all_above",
+			averages: []float64{4, 4, 4, 2.9},
+			target:   "A",
+			want: &gradePlanLimitingOutcome{
+				OutcomeID: 4,
+				Average:   2.9,
+				Threshold: 3,
+				Reason:    gradePlanReasonAllAbove,
+			},
+		},
+		{
+			// 3 of 4 outcomes count, and the third is under 3.3
+			name:     "most_above",
+			averages: []float64{4, 3.5, 3.1, 3},
+			target:   "A",
+			want: &gradePlanLimitingOutcome{
+				OutcomeID: 3,
+				Average:   3.1,
+				Threshold: 3.3,
+				Reason:    gradePlanReasonMostAbove,
+			},
+		},
+		{
+			// the lowest outcome is under both, and AllAbove comes first
+			name:     "all_above_before_most_above",
+			averages: []float64{4, 3, 3, 2},
+			target:   "A",
+			want: &gradePlanLimitingOutcome{
+				OutcomeID: 4,
+				Average:   2,
+				Threshold: 3,
+				Reason:    gradePlanReasonAllAbove,
+			},
+		},
+		{
+			// with one outcome, it's the one counted
+			name:     "one_outcome",
+			averages: []float64{3.1},
+			target:   "A",
+			want: &gradePlanLimitingOutcome{
+				OutcomeID: 1,
+				Average:   3.1,
+				Threshold: 3.3,
+				Reason:    gradePlanReasonMostAbove,
+			},
+		},
+		{
+			name:     "none",
+			averages: []float64{4, 3.5, 3.3, 3},
+			target:   "A",
+			want:     nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := computedGrade{Averages: map[uint64]computedAverage{}}
+			var outcomeIDs []uint64
+			for i, avg := range tt.averages {
+				oID := uint64(i + 1)
+				current.Averages[oID] = computedAverage{Average: avg}
+				outcomeIDs = append(outcomeIDs, oID)
+			}
+
+			got := findLimitingOutcome(current, outcomeIDs, defaultGradingPolicy, gradeNamed(t, tt.target))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findLimitingOutcome() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_planGrade(t *testing.T) {
+	ones := make([]float64, 20)
+	for i := range ones {
+		ones[i] = 1
+	}
+
+	tests := []struct {
+		name    string
+		results map[uint64][]canvasOutcomeResult
+		target  string
+		// wantCurrent and wantProjected are grades. wantProjected is empty if there shouldn't be a projection.
+		wantCurrent         string
+		wantProjected       string
+		wantAlreadyAchieved bool
+		wantAchievable      bool
+		wantRequirements    []gradePlanRequirement
+		wantLimiting        *gradePlanLimitingOutcome
+	}{
+		{
+			name: "already_achieved",
+			results: map[uint64][]canvasOutcomeResult{
+				1: scoresOf(4),
+				2: scoresOf(3.5),
+			},
+			target:              "A-",
+			wantCurrent:         "A",
+			wantAlreadyAchieved: true,
+			wantAchievable:      true,
+			wantRequirements:    []gradePlanRequirement{},
+		},
+		{
+			// outcome 4 only needs AllAbove, as 1-3 already count for MostAbove
+			name: "all_above_limited",
+			results: map[uint64][]canvasOutcomeResult{
+				1: scoresOf(4),
+				2: scoresOf(4),
+				3: scoresOf(4),
+				4: scoresOf(2),
+			},
+			target:         "A",
+			wantCurrent:    "B",
+			wantProjected:  "A",
+			wantAchievable: true,
+			wantRequirements: []gradePlanRequirement{
+				{
+					OutcomeID:        4,
+					Times:            1,
+					MinScore:         3,
+					Threshold:        3,
+					Reason:           gradePlanReasonAllAbove,
+					CurrentAverage:   2,
+					ProjectedAverage: 3,
+				},
+			},
+			wantLimiting: &gradePlanLimitingOutcome{
+				OutcomeID: 4,
+				Average:   2,
+				Threshold: 3,
+				Reason:    gradePlanReasonAllAbove,
+			},
+		},
+		{
+			// 3 of 4 outcomes need 3.3: outcome 1 already has it, so 2 and 3 (the lowest IDs of the rest) are raised
+			name: "most_above_limited",
+			results: map[uint64][]canvasOutcomeResult{
+				1: scoresOf(4),
+				2: scoresOf(3),
+				3: scoresOf(3),
+				4: scoresOf(3),
+			},
+			target:         "A",
+			wantCurrent:    "B+",
+			wantProjected:  "A",
+			wantAchievable: true,
+			wantRequirements: []gradePlanRequirement{
+				{
+					OutcomeID:        2,
+					Times:            1,
+					MinScore:         3.3,
+					Threshold:        3.3,
+					Reason:           gradePlanReasonMostAbove,
+					CurrentAverage:   3,
+					ProjectedAverage: 3.3,
+				},
+				{
+					OutcomeID:        3,
+					Times:            1,
+					MinScore:         3.3,
+					Threshold:        3.3,
+					Reason:           gradePlanReasonMostAbove,
+					CurrentAverage:   3,
+					ProjectedAverage: 3.3,
+				},
+			},
+			wantLimiting: &gradePlanLimitingOutcome{
+				OutcomeID: 3,
+				Average:   3,
+				Threshold: 3.3,
+				Reason:    gradePlanReasonMostAbove,
+			},
+		},
+		{
+			// with the 1 dropped, one 3 gets outcome 2 to A-'s AllAbove. Outcome 1 is cheaper to get over MostAbove.
+			name: "drop_lowest",
+			results: map[uint64][]canvasOutcomeResult{
+				1: scoresOf(3),
+				2: scoresOf(2, 1),
+			},
+			target:         "A-",
+			wantCurrent:    "B",
+			wantProjected:  "A-",
+			wantAchievable: true,
+			wantRequirements: []gradePlanRequirement{
+				{
+					OutcomeID:        1,
+					Times:            1,
+					MinScore:         3.3,
+					Threshold:        3.3,
+					Reason:           gradePlanReasonMostAbove,
+					CurrentAverage:   3,
+					ProjectedAverage: 3.3,
+				},
+				{
+					OutcomeID:        2,
+					Times:            1,
+					MinScore:         3,
+					Threshold:        2.5,
+					Reason:           gradePlanReasonAllAbove,
+					CurrentAverage:   2,
+					ProjectedAverage: 2.5,
+				},
+			},
+			wantLimiting: &gradePlanLimitingOutcome{
+				OutcomeID: 2,
+				Average:   2,
+				Threshold: 2.5,
+				Reason:    gradePlanReasonAllAbove,
+			},
+		},
+		{
+			// 10 more 4s don't outweigh 19 1s
+			name: "unreachable",
+			results: map[uint64][]canvasOutcomeResult{
+				1: scoresOf(4),
+				2: scoresOf(ones...),
+			},
+			target:           "A",
+			wantCurrent:      "I",
+			wantRequirements: []gradePlanRequirement{},
+			wantLimiting: &gradePlanLimitingOutcome{
+				OutcomeID: 2,
+				Average:   1,
+				Threshold: 3,
+				Reason:    gradePlanReasonAllAbove,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := planGrade(tt.results, nil, defaultGradingPolicy, gradeNamed(t, tt.target), false)
+
+			if got.Current.Grade.Grade != tt.wantCurrent {
+				t.Errorf("planGrade() current = %s, want %s", got.Current.Grade.Grade, tt.wantCurrent)
+			}
+
+			if got.AlreadyAchieved != tt.wantAlreadyAchieved {
+				t.Errorf("planGrade() already achieved = %t, want %t", got.AlreadyAchieved, tt.wantAlreadyAchieved)
+			}
+
+			if got.Achievable != tt.wantAchievable {
+				t.Errorf("planGrade() achievable = %t, want %t", got.Achievable, tt.wantAchievable)
+			}
+
+			if len(tt.wantProjected) < 1 && got.Projected != nil {
+				t.Errorf("planGrade() projected = %s, want none", got.Projected.Grade.Grade)
+			} else if len(tt.wantProjected) > 0 &&
+				(got.Projected == nil || got.Projected.Grade.Grade != tt.wantProjected) {
+				t.Errorf("planGrade() projected = %+v, want %s", got.Projected, tt.wantProjected)
+			}
+
+			if !reflect.DeepEqual(got.LimitingOutcome, tt.wantLimiting) {
+				t.Errorf("planGrade() limiting outcome = %+v, want %+v", got.LimitingOutcome, tt.wantLimiting)
+			}
+
+			if len(got.Requirements) != len(tt.wantRequirements) {
+				t.Fatalf("planGrade() requirements = %+v, want %+v", got.Requirements, tt.wantRequirements)
+			}
+
+			for i, r := range got.Requirements {
+				w := tt.wantRequirements[i]
+				if r.OutcomeID != w.OutcomeID ||
+					r.Times != w.Times ||
+					r.Reason != w.Reason ||
+					!roughlyEqual(r.MinScore, w.MinScore) ||
+					!roughlyEqual(r.Threshold, w.Threshold) ||
+					!roughlyEqual(r.CurrentAverage, w.CurrentAverage) ||
+					!roughlyEqual(r.ProjectedAverage, w.ProjectedAverage) {
+					t.Errorf("planGrade() requirement %d = %+v, want %+v", i, r, w)
+				}
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package gradesapi
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,23 +11,27 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"reflect"
 	"strings"
-	"sync"
 	"time"
 )
 
 const canvasPerPage = "100"
 
+// canvasMaxPages is the most pages forEachCanvasPage will get from one list. Past it, something is probably
+// wrong, like a Link header that loops.
+const canvasMaxPages = 500
+
+var canvasErrorTooManyPages = errors.New("the Canvas list has more pages than canvasMaxPages")
+
 var (
 	canvasErrorNoErrors                             = errors.New("a non-200 status code was received but no errors were present")
 	canvasErrorUnknownError                         = errors.New("a non-200 status code was received from Canvas, but the error is unknown")
 	canvasErrorInvalidAccessTokenError              = errors.New("the Canvas access token is invalid")
 	canvasErrorInsufficientScopesOnAccessTokenError = errors.New("there are insufficient scopes on the Canvas access token")
+	canvasErrorRateLimitExceededError               = errors.New("the Canvas rate limit for the access token was exceeded")
 
 	canvasOAuth2ErrorRefreshTokenNotFound = errors.New("the specified refresh token was not found")
-
-	handleRequestWithTokenRefreshMutex = sync.RWMutex{}
-	lockedTokens                       = map[uint64]struct{}{}
 )
 
 var httpClient = func() http.Client {
@@ -36,9 +41,12 @@ var httpClient = func() http.Client {
 			panic(fmt.Errorf("error parsing HTTP_PROXY_URL: %w", err))
 		}
 
-		return http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+		return http.Client{
+			Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+			Timeout:   env.CanvasRequestTimeout,
+		}
 	} else {
-		return http.Client{}
+		return http.Client{Timeout: env.CanvasRequestTimeout}
 	}
 }()
 
@@ -52,13 +60,30 @@ type requestDetails struct {
 	// ScopeVersion represents the scopes that the token has.
 	// Learn more at https://go.canvascbl.com/internal/scope-versions
 	ScopeVersion uint64
+	// ExpiresAt is when Token expires. It's zero if unknown.
+	ExpiresAt time.Time
+	// InstitutionID is the institution whose Canvas the token is for. 0 is the default instance.
+	InstitutionID uint64
+	// Ctx cancels every request made with these details. Use ctx() to read it, as it may be nil.
+	Ctx context.Context
+}
+
+// ctx returns rd.Ctx, or context.Background() if it isn't set.
+func (rd requestDetails) ctx() context.Context {
+	if rd.Ctx == nil {
+		return context.Background()
+	}
+
+	return rd.Ctx
 }
 
 /*
 handleRequestWithTokenRefresh takes in a task function along with your requestDetails,
 runs the task, and, if necessary, refreshes the token and retries the task.
+Tokens that are about to expire are refreshed before running the task.
 
 It is also safe for concurrent use-- meaning this is the only way to make canvas requests.
+If rd's context is done, the task isn't retried.
 
 Your task function should take requestDetails and return an error. This error, if wrapped,
 should be from fmt.Errorf using the %w verb. For things like requestDetails and parameters,
@@ -66,62 +91,37 @@ they should be scoped in from your function.
 
 */
 func handleRequestWithTokenRefresh(task func(rd *requestDetails) error, rd *requestDetails, userID uint64) (requestDetails, error) {
+	rd.refreshIfExpiresSoon()
+
 	err := task(rd)
 	if err != nil {
-		if errors.Is(err, canvasErrorInvalidAccessTokenError) {
-			// we need to use the refresh token
+		if !errors.Is(err, canvasErrorInvalidAccessTokenError) {
+			return requestDetails{}, fmt.Errorf("error in task from handleRequestWithTokenRefresh: %w", err)
+		}
 
-			shouldRefresh := false
+		if ctxErr := rd.ctx().Err(); ctxErr != nil {
+			return requestDetails{}, fmt.Errorf("error in task from handleRequestWithTokenRefresh: %w", ctxErr)
+		}
 
-			handleRequestWithTokenRefreshMutex.Lock()
-			// if the token isn't being refreshed
-			if _, ok := lockedTokens[rd.TokenID]; !ok {
-				// mark that it is
-				lockedTokens[rd.TokenID] = struct{}{}
-				shouldRefresh = true
-			}
-			handleRequestWithTokenRefreshMutex.Unlock()
-
-			if shouldRefresh {
-				// refresh the token
-				refreshErr := rd.refreshAccessToken()
-				if refreshErr != nil {
-					return requestDetails{}, fmt.Errorf("error refreshing token id %d: %w", rd.TokenID, refreshErr)
-				}
-				// mark that it's no longer being refreshed
-				handleRequestWithTokenRefreshMutex.Lock()
-				delete(lockedTokens, rd.TokenID)
-				handleRequestWithTokenRefreshMutex.Unlock()
-			} else {
-				// poll the map every 2ms for updates
-				for {
-					// check map
-					handleRequestWithTokenRefreshMutex.RLock()
-					if _, ok := lockedTokens[rd.TokenID]; ok {
-						// still working, check back in 2ms
-						handleRequestWithTokenRefreshMutex.RUnlock()
-						time.Sleep(2 * time.Millisecond)
-					} else {
-						handleRequestWithTokenRefreshMutex.RUnlock()
-
-						// get new token from db
-						newRd, err := rdFromUserID(userID)
-						if err != nil {
-							return requestDetails{}, fmt.Errorf("error getting rd from canvas user id: %w", err)
-						}
-
-						rd = &newRd
-						break
-					}
-				}
-			}
+		// another server may have already refreshed the token
+		latestRd, err := rdFromUserID(userID)
+		if err != nil {
+			return requestDetails{}, fmt.Errorf("error getting rd from user id: %w", err)
+		}
 
-			retryErr := task(rd)
-			if retryErr != nil {
-				return requestDetails{}, fmt.Errorf("error retrying task with refreshed token id %d: %w", rd.TokenID, retryErr)
-			}
+		if latestRd.TokenID == rd.TokenID && latestRd.Token != rd.Token {
+			latestRd.Ctx = rd.Ctx
+			rd = &latestRd
 		} else {
-			return requestDetails{}, fmt.Errorf("error in task from handleRequestWithTokenRefresh: %w", err)
+			refreshErr := rd.refreshAccessToken()
+			if refreshErr != nil {
+				return requestDetails{}, fmt.Errorf("error refreshing token id %d: %w", rd.TokenID, refreshErr)
+			}
+		}
+
+		retryErr := task(rd)
+		if retryErr != nil {
+			return requestDetails{}, fmt.Errorf("error retrying task with refreshed token id %d: %w", rd.TokenID, retryErr)
 		}
 	}
 
@@ -139,23 +139,39 @@ func getCanvasProfile(rd requestDetails, userID string) (*canvasUserProfileRespo
 }
 
 func getCanvasCourses(rd requestDetails) (*canvasCoursesResponse, error) {
-	var courses canvasCoursesResponse
-	_, err := makeCanvasGetRequest("api/v1/courses?per_page=100&enrollment_state=active&include[]=total_scores&include[]=observed_users&include[]=course_image", rd, &courses)
+	q := url.Values{}
+	q.Add("per_page", canvasPerPage)
+	q.Add("enrollment_state", "active")
+	q.Add("include[]", "total_scores")
+	q.Add("include[]", "observed_users")
+	q.Add("include[]", "course_image")
+
+	var allCourses, courses canvasCoursesResponse
+	err := forEachCanvasPage("api/v1/courses?"+q.Encode(), rd, &courses, func() error {
+		allCourses = append(allCourses, courses...)
+		return nil
+	})
 	if err != nil {
 		return nil, fmt.Errorf("error getting canvas courses: %w", err)
 	}
 
-	return &courses, nil
+	return &allCourses, nil
 }
 
 func getCanvasUserObservees(rd requestDetails, userID string) (*canvasUserObserveesResponse, error) {
-	var observees canvasUserObserveesResponse
-	_, err := makeCanvasGetRequest("api/v1/users/"+userID+"/observees", rd, &observees)
+	q := url.Values{}
+	q.Add("per_page", canvasPerPage)
+
+	var allObservees, observees canvasUserObserveesResponse
+	err := forEachCanvasPage("api/v1/users/"+userID+"/observees?"+q.Encode(), rd, &observees, func() error {
+		allObservees = append(allObservees, observees...)
+		return nil
+	})
 	if err != nil {
 		return nil, fmt.Errorf("error getting canvas user observees: %w", err)
 	}
 
-	return &observees, nil
+	return &allObservees, nil
 }
 
 type getCanvasCourseSubmissionsRequest struct {
@@ -167,6 +183,7 @@ type getCanvasCourseSubmissionsRequest struct {
 func getCanvasCourseSubmissions(rd requestDetails, req *getCanvasCourseSubmissionsRequest) (*canvasSubmissionsResponse, error) {
 	q := url.Values{}
 	q.Add("per_page", canvasPerPage)
+	q.Add("include[]", "rubric_assessment")
 	for _, id := range req.studentIDs {
 		q.Add("student_ids[]", id)
 	}
@@ -176,56 +193,41 @@ func getCanvasCourseSubmissions(rd requestDetails, req *getCanvasCourseSubmissio
 		q.Add("submitted_since", (*req.submittedSince).Format("2006-01-02T15:04:05Z"))
 	}
 
-	var (
-		allSubmissions canvasSubmissionsResponse
-		u              = "api/v1/courses/" + req.courseID + "/students/submissions?" + q.Encode()
+	var allSubmissions, submissions canvasSubmissionsResponse
+	err := forEachCanvasPage(
+		"api/v1/courses/"+req.courseID+"/students/submissions?"+q.Encode(),
+		rd,
+		&submissions,
+		func() error {
+			allSubmissions = append(allSubmissions, submissions...)
+			return nil
+		},
 	)
-
-	for {
-		var submissions canvasSubmissionsResponse
-		resp, err := makeCanvasGetRequest(
-			u,
-			rd,
-			&submissions,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("error getting canvas submissions for course "+
-				"%s and for students %v: %w", req.courseID, req.studentIDs, err)
-		}
-
-		allSubmissions = append(allSubmissions, submissions...)
-
-		nu := nextPageUrl(resp.Header.Get("link"))
-		if nu == nil {
-			return &allSubmissions, nil
-		} else {
-			u = *nu
-		}
+	if err != nil {
+		return nil, fmt.Errorf("error getting canvas submissions for course "+
+			"%s and for students %v: %w", req.courseID, req.studentIDs, err)
 	}
+
+	return &allSubmissions, nil
 }
 
-// getCanvasOutcomeAlignments is not currently needed, but may be useful in the future.
-//func getCanvasOutcomeAlignments(rd requestDetails, courseID string, studentID string) (*canvasOutcomeAlignmentsResponse, error) {
-//	var alignments canvasOutcomeAlignmentsResponse
-//	_, err := makeCanvasGetRequest(
-//		"api/v1/courses/"+courseID+"/outcome_alignments?student_id="+studentID,
-//		rd,
-//		&alignments,
-//	)
-//	if err != nil {
-//		return nil, fmt.Errorf("error getting canvas outcome alignments for course %s for student %s: %w", courseID, studentID, err)
-//	}
-//
-//	return &alignments, nil
-//}
+// getCanvasOutcomeAlignments gets every outcome alignment for a student in a course.
+// They're kept as raw JSON, so they can be sent to the client exactly as Canvas sent them.
+func getCanvasOutcomeAlignments(rd requestDetails, courseID string, studentID string) ([]json.RawMessage, error) {
+	q := url.Values{}
+	q.Add("per_page", canvasPerPage)
+	q.Add("student_id", studentID)
 
-func proxyCanvasOutcomeAlignments(rd requestDetails, courseID string, studentID string) (*http.Response, error) {
-	resp, err := proxyCanvasGetRequest("api/v1/courses/"+courseID+"/outcome_alignments?per_page=100&student_id="+studentID, rd)
+	var allAlignments, alignments []json.RawMessage
+	err := forEachCanvasPage("api/v1/courses/"+courseID+"/outcome_alignments?"+q.Encode(), rd, &alignments, func() error {
+		allAlignments = append(allAlignments, alignments...)
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error getting canvas outcome alignments for course %s: %w", courseID, err)
+		return nil, fmt.Errorf("error getting canvas outcome alignments for course %s for student %s: %w", courseID, studentID, err)
 	}
 
-	return resp, nil
+	return allAlignments, nil
 }
 
 // getCanvasOutcomeRollups is currently deprecated but still here because it may be useful in the future.
@@ -250,41 +252,27 @@ func proxyCanvasOutcomeAlignments(rd requestDetails, courseID string, studentID
 //	return &rollups, nil
 //}
 
-// getCanvasOutcomeResults gets outcome results. Paginated.
+// getCanvasOutcomeResults gets outcome results, along with their outcomes. Paginated.
 func getCanvasOutcomeResults(rd requestDetails, courseID string, userIDs []string) (*canvasOutcomeResultsResponse, error) {
 	q := url.Values{}
 	q.Add("per_page", canvasPerPage)
+	q.Add("include[]", "outcomes")
 	for _, id := range userIDs {
 		q.Add("user_ids[]", id)
 	}
 
-	var (
-		allResults canvasOutcomeResultsResponse
-		u          = "api/v1/courses/" + courseID + "/outcome_results?" + q.Encode()
-	)
-
-	for {
-		var results canvasOutcomeResultsResponse
-		resp, err := makeCanvasGetRequest(
-			u,
-			rd,
-			&results,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("error getting canvas outcome results for course "+
-				"%s and for students %v: %w", courseID, userIDs, err)
-		}
-
+	var allResults, results canvasOutcomeResultsResponse
+	err := forEachCanvasPage("api/v1/courses/"+courseID+"/outcome_results?"+q.Encode(), rd, &results, func() error {
 		allResults.OutcomeResults = append(allResults.OutcomeResults, results.OutcomeResults...)
-
-		nu := nextPageUrl(resp.Header.Get("link"))
-		if nu == nil {
-			return &allResults, nil
-		} else {
-			u = *nu
-		}
+		allResults.Linked.Outcomes = append(allResults.Linked.Outcomes, results.Linked.Outcomes...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting canvas outcome results for course "+
+			"%s and for students %v: %w", courseID, userIDs, err)
 	}
 
+	return &allResults, nil
 }
 
 func getCanvasCourseAssignments(rd requestDetails, courseID string, assignmentIDs []string) (*canvasAssignmentsResponse, error) {
@@ -294,27 +282,16 @@ func getCanvasCourseAssignments(rd requestDetails, courseID string, assignmentID
 		q.Add("assignment_ids[]", aID)
 	}
 
-	var (
-		allAssignments canvasAssignmentsResponse
-		u              = "api/v1/courses/" + courseID + "/assignments?" + q.Encode()
-	)
-
-	for {
-		var assignments canvasAssignmentsResponse
-		resp, err := makeCanvasGetRequest(u, rd, &assignments)
-		if err != nil {
-			return nil, fmt.Errorf("error getting canvas assignments for course %s: %w", courseID, err)
-		}
-
+	var allAssignments, assignments canvasAssignmentsResponse
+	err := forEachCanvasPage("api/v1/courses/"+courseID+"/assignments?"+q.Encode(), rd, &assignments, func() error {
 		allAssignments = append(allAssignments, assignments...)
-
-		nu := nextPageUrl(resp.Header.Get("link"))
-		if nu == nil {
-			return &allAssignments, nil
-		} else {
-			u = *nu
-		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting canvas assignments for course %s: %w", courseID, err)
 	}
+
+	return &allAssignments, nil
 }
 
 type getCanvasCourseEnrollmentsRequest struct {
@@ -347,27 +324,16 @@ func getCanvasCourseEnrollments(rd requestDetails, req *getCanvasCourseEnrollmen
 		q.Add("include[]", in)
 	}
 
-	var (
-		allEnrollments canvasEnrollmentsResponse
-		u              = "api/v1/courses/" + req.courseID + "/enrollments?" + q.Encode()
-	)
-
-	for {
-		var enrollments canvasEnrollmentsResponse
-		resp, err := makeCanvasGetRequest(u, rd, &enrollments)
-		if err != nil {
-			return nil, fmt.Errorf("error getting canvas enrollments for course %s: %w", req.courseID, err)
-		}
-
+	var allEnrollments, enrollments canvasEnrollmentsResponse
+	err := forEachCanvasPage("api/v1/courses/"+req.courseID+"/enrollments?"+q.Encode(), rd, &enrollments, func() error {
 		allEnrollments = append(allEnrollments, enrollments...)
-
-		nu := nextPageUrl(resp.Header.Get("link"))
-		if nu == nil {
-			return &allEnrollments, nil
-		} else {
-			u = *nu
-		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting canvas enrollments for course %s: %w", req.courseID, err)
 	}
+
+	return &allEnrollments, nil
 }
 
 func getCanvasOutcome(rd requestDetails, outcomeID string) (*canvasOutcomeResponse, error) {
@@ -380,11 +346,21 @@ func getCanvasOutcome(rd requestDetails, outcomeID string) (*canvasOutcomeRespon
 	return &outcome, err
 }
 
-func getTokenFromAuthorizationCode(code string) (*canvasTokenGrantResponse, error) {
+func getCanvasOutcomeProficiency(rd requestDetails, accountID string) (*canvasOutcomeProficiencyResponse, error) {
+	var proficiency canvasOutcomeProficiencyResponse
+	_, err := makeCanvasGetRequest("api/v1/accounts/"+accountID+"/outcome_proficiency", rd, &proficiency)
+	if err != nil {
+		return nil, fmt.Errorf("error getting canvas outcome proficiency for account %s: %w", accountID, err)
+	}
+
+	return &proficiency, nil
+}
+
+func getTokenFromAuthorizationCode(inst canvasInstance, code string) (*canvasTokenGrantResponse, error) {
 	q := url.Values{}
 	q.Set("grant_type", "authorization_code")
-	q.Set("client_id", env.CanvasOAuth2ClientID)
-	q.Set("client_secret", env.CanvasOAuth2ClientSecret)
+	q.Set("client_id", inst.OAuth2ClientID)
+	q.Set("client_secret", inst.OAuth2ClientSecret)
 	q.Set("code", code)
 
 	var grantResp canvasTokenGrantResponse
@@ -392,7 +368,7 @@ func getTokenFromAuthorizationCode(code string) (*canvasTokenGrantResponse, erro
 		"login/oauth2/token?"+q.Encode(),
 		http.MethodPost,
 		nil,
-		requestDetails{},
+		requestDetails{InstitutionID: inst.InstitutionID},
 		&grantResp,
 	)
 	if err != nil {
@@ -403,14 +379,19 @@ func getTokenFromAuthorizationCode(code string) (*canvasTokenGrantResponse, erro
 }
 
 func getTokenFromRefreshToken(rd requestDetails) (*canvasRefreshTokenResponse, error) {
+	inst, err := canvasInstanceForInstitution(rd.InstitutionID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting canvas instance for refresh token: %w", err)
+	}
+
 	q := url.Values{}
-	q.Set("client_id", env.CanvasOAuth2ClientID)
-	q.Set("client_secret", env.CanvasOAuth2ClientSecret)
+	q.Set("client_id", inst.OAuth2ClientID)
+	q.Set("client_secret", inst.OAuth2ClientSecret)
 	q.Set("grant_type", "refresh_token")
 	q.Set("refresh_token", rd.RefreshToken)
 
 	var rtResponse canvasRefreshTokenResponse
-	_, err := makeCanvasRequest(
+	_, err = makeCanvasRequest(
 		"login/oauth2/token?"+q.Encode(),
 		http.MethodPost,
 		nil,
@@ -425,10 +406,18 @@ func getTokenFromRefreshToken(rd requestDetails) (*canvasRefreshTokenResponse, e
 }
 
 func categorizeCanvasError(err canvasErrorArrayResponse, resp *http.Response) error {
+	if isCanvasRateLimitResponse(resp) {
+		return canvasErrorRateLimitExceededError
+	}
+
 	if len(err.Errors) < 1 {
 		return canvasErrorNoErrors
 	}
 
+	if strings.Contains(strings.ToLower(err.Errors[0].Message), "rate limit exceeded") {
+		return canvasErrorRateLimitExceededError
+	}
+
 	switch strings.ToLower(err.Errors[0].Message) {
 	case "invalid access token.":
 		return canvasErrorInvalidAccessTokenError
@@ -473,6 +462,51 @@ func nextPageUrl(link string) *string {
 	return nil
 }
 
+/*
+forEachCanvasPage gets every page of a Canvas list, starting at path and following the next link
+in each page's Link header.
+
+Each page is decoded into page, which must be a pointer, and then onPage is called. page is reset to its
+zero value before each page, so onPage must copy anything it keeps (appending does). If onPage returns
+an error, no more pages are fetched and the error is returned.
+
+If the list has more than canvasMaxPages pages, it stops with canvasErrorTooManyPages instead of
+returning part of the list.
+*/
+func forEachCanvasPage(path string, rd requestDetails, page interface{}, onPage func() error) error {
+	pageValue := reflect.ValueOf(page)
+	if pageValue.Kind() != reflect.Ptr || pageValue.IsNil() {
+		return fmt.Errorf("error getting canvas pages: page must be a non-nil pointer, not %T", page)
+	}
+	zero := reflect.Zero(pageValue.Elem().Type())
+
+	u := path
+	for n := 1; ; n++ {
+		if n > canvasMaxPages {
+			return fmt.Errorf("error getting page %d of %s: %w", n, path, canvasErrorTooManyPages)
+		}
+
+		pageValue.Elem().Set(zero)
+
+		resp, err := makeCanvasGetRequest(u, rd, page)
+		if err != nil {
+			return err
+		}
+
+		err = onPage()
+		if err != nil {
+			return err
+		}
+
+		nu := nextPageUrl(resp.Header.Get("link"))
+		if nu == nil {
+			return nil
+		}
+
+		u = *nu
+	}
+}
+
 // makeCanvasGetRequest will WRITE TO YOUR bodyDestination.
 // While it returns an http.Response, it is foolish to read the body because
 // it's already in your bodyDestination. Ensure that bodyDestination is
@@ -484,15 +518,20 @@ func makeCanvasRequest(
 	rd requestDetails,
 	bodyDestination interface{},
 ) (*http.Response, error) {
+	inst, err := canvasInstanceForInstitution(rd.InstitutionID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting canvas instance: %w", err)
+	}
+
 	// this system allows for the Link header so full URLs can be passed in
-	fURL := "https://" + env.CanvasDomain + "/"
+	fURL := inst.baseURL()
 	if strings.HasPrefix(path, fURL) {
 		fURL = path
 	} else {
 		fURL += path
 	}
 
-	req, err := http.NewRequest(method, fURL, body)
+	req, err := http.NewRequestWithContext(rd.ctx(), method, fURL, body)
 	if err != nil {
 		return nil, fmt.Errorf("error creating http request: %w", err)
 	}
@@ -501,7 +540,12 @@ func makeCanvasRequest(
 		req.Header.Add("Authorization", "Bearer "+rd.Token)
 	}
 
-	resp, err := httpClient.Do(req)
+	// every body sent to Canvas is JSON
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := doCanvasRequest(req, rd)
 	if err != nil {
 		return nil, fmt.Errorf("error making an http request: %w", err)
 	}
@@ -526,111 +570,98 @@ func makeCanvasRequest(
 			)
 		}
 
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf(
-				"error reading the canvas error body (canvas status code %d): %w",
-				resp.StatusCode,
-				err)
-		}
+		return nil, canvasResponseError(resp, canvasErrorFromResponse(resp))
+	}
 
-		var canvasArrayErr canvasErrorArrayResponse
-		err = json.Unmarshal(body, &canvasArrayErr)
-		if err != nil {
-			return nil, fmt.Errorf(
-				"error decoding into canvasArrayErr (canvas status code %d): %w",
-				resp.StatusCode,
-				err)
-		}
+	err = json.NewDecoder(resp.Body).Decode(&bodyDestination)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding into bodyDestination: %w", err)
+	}
 
-		if len(canvasArrayErr.Errors) < 1 {
-			var canvasErr canvasErrorResponse
-			err = json.Unmarshal(body, &canvasErr)
-			if err != nil {
-				return nil, fmt.Errorf(
-					"error decoding into canvasErr (canvas status code %d): %w",
-					resp.StatusCode,
-					err)
-			}
+	return resp, nil
+}
 
-			if len(canvasErr.Error) > 0 {
-				canvasArrayErr = canvasErr.toCanvasErrorArrayResponse()
-			}
-		}
+// canvasErrorFromResponse reads and categorizes the error in a non-200 response from Canvas.
+func canvasErrorFromResponse(resp *http.Response) error {
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf(
+			"error reading the canvas error body (canvas status code %d): %w",
+			resp.StatusCode,
+			err)
+	}
 
-		return nil, fmt.Errorf(
+	// rate limit errors are plain text, like "403 Forbidden (Rate Limit Exceeded)"
+	if resp.StatusCode == http.StatusForbidden && !json.Valid(body) {
+		return fmt.Errorf(
 			"error from canvas (canvas status code %d): %w",
 			resp.StatusCode,
-			categorizeCanvasError(canvasArrayErr, resp),
+			categorizeCanvasError(
+				canvasErrorResponse{Error: strings.TrimSpace(string(body))}.toCanvasErrorArrayResponse(),
+				resp,
+			),
 		)
 	}
 
-	err = json.NewDecoder(resp.Body).Decode(&bodyDestination)
+	var canvasArrayErr canvasErrorArrayResponse
+	err = json.Unmarshal(body, &canvasArrayErr)
 	if err != nil {
-		return nil, fmt.Errorf("error decoding into bodyDestination: %w", err)
+		return fmt.Errorf(
+			"error decoding into canvasArrayErr (canvas status code %d): %w",
+			resp.StatusCode,
+			err)
 	}
 
-	return resp, nil
+	if len(canvasArrayErr.Errors) < 1 {
+		var canvasErr canvasErrorResponse
+		err = json.Unmarshal(body, &canvasErr)
+		if err != nil {
+			return fmt.Errorf(
+				"error decoding into canvasErr (canvas status code %d): %w",
+				resp.StatusCode,
+				err)
+		}
+
+		if len(canvasErr.Error) > 0 {
+			canvasArrayErr = canvasErr.toCanvasErrorArrayResponse()
+		}
+	}
+
+	return fmt.Errorf(
+		"error from canvas (canvas status code %d): %w",
+		resp.StatusCode,
+		categorizeCanvasError(canvasArrayErr, resp),
+	)
 }
 
 // proxyCanvasGetRequest expects you to read resp.Body. So, it doesn't close the body.
 // REMEMBER TO CLOSE IT!
 func proxyCanvasGetRequest(path string, rd requestDetails) (*http.Response, error) {
+	inst, err := canvasInstanceForInstitution(rd.InstitutionID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting canvas instance: %w", err)
+	}
+
 	// this system allows for the Link header so full URLs can be passed in
-	fURL := "https://" + env.CanvasDomain + "/"
+	fURL := inst.baseURL()
 	if !strings.HasPrefix(path, fURL) {
 		fURL += path
 	}
-	req, err := http.NewRequest(http.MethodGet, fURL, nil)
+	req, err := http.NewRequestWithContext(rd.ctx(), http.MethodGet, fURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error creating http request: %w", err)
 	}
 
 	req.Header.Add("Authorization", "Bearer "+rd.Token)
 
-	resp, err := httpClient.Do(req)
+	resp, err := doCanvasRequest(req, rd)
 	if err != nil {
 		return nil, fmt.Errorf("error making an http request: %w", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf(
-				"error reading the canvas error body (canvas status code %d): %w",
-				resp.StatusCode,
-				err)
-		}
-
-		var canvasArrayErr canvasErrorArrayResponse
-		err = json.Unmarshal(body, &canvasArrayErr)
-		if err != nil {
-			return nil, fmt.Errorf(
-				"error decoding into canvasArrayErr (canvas status code %d): %w",
-				resp.StatusCode,
-				err)
-		}
-
-		if len(canvasArrayErr.Errors) < 1 {
-			var canvasErr canvasErrorResponse
-			err = json.Unmarshal(body, &canvasErr)
-			if err != nil {
-				return nil, fmt.Errorf(
-					"error decoding into canvasErr (canvas status code %d): %w",
-					resp.StatusCode,
-					err)
-			}
-
-			if len(canvasErr.Error) > 0 {
-				canvasArrayErr = canvasErr.toCanvasErrorArrayResponse()
-			}
-		}
-
-		return nil, fmt.Errorf(
-			"error from canvas (canvas status code %d): %w",
-			resp.StatusCode,
-			categorizeCanvasError(canvasArrayErr, resp),
-		)
+		defer resp.Body.Close()
+		return nil, canvasResponseError(resp, canvasErrorFromResponse(resp))
 	}
 
 	return resp, nil
@@ -0,0 +1,160 @@
+package gradesapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_forEachCanvasPage(t *testing.T) {
+	errStop := errors.New("stop")
+
+	tests := []struct {
+		name  string
+		path  string
+		token string
+		// stopAfter makes onPage return errStop after that many pages, if it's set
+		stopAfter    int
+		wantIDs      []uint64
+		wantPages    int
+		wantRequests int
+		wantErr      error
+	}{
+		{
+			name:         "one_page",
+			path:         "api/v1/courses?per_page=10",
+			wantIDs:      []uint64{1001, 1002, 1003},
+			wantPages:    1,
+			wantRequests: 1,
+		},
+		{
+			name:         "several_pages",
+			path:         "api/v1/courses?per_page=2",
+			wantIDs:      []uint64{1001, 1002, 1003},
+			wantPages:    2,
+			wantRequests: 2,
+		},
+		{
+			name:         "page_per_item",
+			path:         "api/v1/courses?per_page=1",
+			wantIDs:      []uint64{1001, 1002, 1003},
+			wantPages:    3,
+			wantRequests: 3,
+		},
+		{
+			name:         "on_page_error",
+			path:         "api/v1/courses?per_page=1",
+			stopAfter:    2,
+			wantIDs:      []uint64{1001, 1002},
+			wantPages:    2,
+			wantRequests: 2,
+			wantErr:      errStop,
+		},
+		{
+			name:         "invalid_token",
+			path:         "api/v1/courses?per_page=1",
+			token:        "not-a-token",
+			wantPages:    0,
+			wantRequests: 1,
+			wantErr:      canvasErrorInvalidAccessTokenError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, done := useCanvasFake(t)
+			defer done()
+
+			var (
+				ids   []uint64
+				pages int
+				page  []struct {
+					ID uint64 `json:"id"`
+				}
+			)
+			token := tt.token
+			if len(token) < 1 {
+				token = "student-token"
+			}
+
+			err := forEachCanvasPage(tt.path, requestDetails{Token: token}, &page, func() error {
+				pages++
+				for _, c := range page {
+					ids = append(ids, c.ID)
+				}
+
+				if pages == tt.stopAfter {
+					return errStop
+				}
+				return nil
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("forEachCanvasPage() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("forEachCanvasPage() got ids %v, want %v", ids, tt.wantIDs)
+			}
+			if pages != tt.wantPages {
+				t.Errorf("forEachCanvasPage() got %d pages, want %d", pages, tt.wantPages)
+			}
+			if got := s.Requests(); got != tt.wantRequests {
+				t.Errorf("forEachCanvasPage() made %d requests, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func Test_forEachCanvasPage_tooManyPages(t *testing.T) {
+	// every page of this list has a next page
+	var (
+		mutex    sync.Mutex
+		requests int
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests++
+		n := requests
+		mutex.Unlock()
+
+		w.Header().Set("Link", fmt.Sprintf(`<http://%s/api/v1/endless?page=%d>; rel="next"`, r.Host, n+1))
+		_, _ = fmt.Fprintf(w, `[{"id":%d}]`, n)
+	}))
+	defer ts.Close()
+
+	instance, responses := defaultCanvasInstance, canvasResponses
+	defaultCanvasInstance.Scheme = "http"
+	defaultCanvasInstance.Domain = strings.TrimPrefix(ts.URL, "http://")
+	canvasResponses = nil
+	defer func() {
+		defaultCanvasInstance, canvasResponses = instance, responses
+	}()
+
+	var (
+		pages int
+		page  []struct {
+			ID int `json:"id"`
+		}
+	)
+	err := forEachCanvasPage("api/v1/endless", requestDetails{}, &page, func() error {
+		pages++
+		return nil
+	})
+	if !errors.Is(err, canvasErrorTooManyPages) {
+		t.Fatalf("forEachCanvasPage() error = %v, want %v", err, canvasErrorTooManyPages)
+	}
+
+	if pages != canvasMaxPages {
+		t.Errorf("forEachCanvasPage() got %d pages, want %d", pages, canvasMaxPages)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if requests != canvasMaxPages {
+		t.Errorf("forEachCanvasPage() made %d requests, want %d", requests, canvasMaxPages)
+	}
+}
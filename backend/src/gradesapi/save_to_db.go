@@ -7,6 +7,7 @@ import (
 	"github.com/iamtheyammer/canvascbl/backend/src/db/services/enrollments"
 	"github.com/iamtheyammer/canvascbl/backend/src/db/services/gpas"
 	"github.com/iamtheyammer/canvascbl/backend/src/db/services/grades"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/outcome_proficiencies"
 	"github.com/iamtheyammer/canvascbl/backend/src/db/services/outcomes"
 	"github.com/iamtheyammer/canvascbl/backend/src/db/services/submissions"
 	"github.com/iamtheyammer/canvascbl/backend/src/db/services/users"
@@ -16,24 +17,25 @@ import (
 	"time"
 )
 
-func prepareProfileForDB(p *canvasUserProfile) *users.UpsertRequest {
+func prepareProfileForDB(p *canvasUserProfile, institutionID uint64) *users.UpsertRequest {
 	return &users.UpsertRequest{
-		Name:         p.Name,
-		Email:        p.PrimaryEmail,
-		LTIUserID:    p.LtiUserID,
-		CanvasUserID: int64(p.ID),
+		Name:          p.Name,
+		Email:         p.PrimaryEmail,
+		LTIUserID:     p.LtiUserID,
+		CanvasUserID:  int64(p.ID),
+		InstitutionID: institutionID,
 	}
 }
 
-func saveProfileToDB(p *canvasUserProfile) {
-	_, err := users.UpsertProfile(db, prepareProfileForDB(p), false)
+func saveProfileToDB(p *canvasUserProfile, institutionID uint64) {
+	_, err := users.UpsertProfile(db, prepareProfileForDB(p, institutionID), false)
 	if err != nil {
 		util.HandleError(fmt.Errorf("error saving user profile to db: %w", err))
 		return
 	}
 }
 
-func prepareCoursesForDB(cs *[]canvasCourse) (*[]courses.UpsertRequest, *[]enrollments.UpsertRequest) {
+func prepareCoursesForDB(cs *[]canvasCourse, institutionID uint64) (*[]courses.UpsertRequest, *[]enrollments.UpsertRequest) {
 	var (
 		cReq []courses.UpsertRequest
 		eReq []enrollments.UpsertRequest
@@ -45,6 +47,10 @@ func prepareCoursesForDB(cs *[]canvasCourse) (*[]courses.UpsertRequest, *[]enrol
 			State:      c.WorkflowState,
 			UUID:       c.UUID,
 			CourseID:   int64(c.ID),
+
+			EnrollmentTermID: uint64(c.EnrollmentTermID),
+			RootAccountID:    uint64(c.RootAccountID),
+			InstitutionID:    institutionID,
 		})
 
 		for _, e := range c.Enrollments {
@@ -55,6 +61,7 @@ func prepareCoursesForDB(cs *[]canvasCourse) (*[]courses.UpsertRequest, *[]enrol
 				Type:                   enrollments.Type(e.Type),
 				Role:                   enrollments.Role(e.Role),
 				State:                  e.EnrollmentState,
+				InstitutionID:          institutionID,
 			})
 		}
 	}
@@ -62,8 +69,8 @@ func prepareCoursesForDB(cs *[]canvasCourse) (*[]courses.UpsertRequest, *[]enrol
 	return &cReq, &eReq
 }
 
-func saveCoursesToDB(cs *[]canvasCourse) {
-	cReqs, eReqs := prepareCoursesForDB(cs)
+func saveCoursesToDB(cs *[]canvasCourse, institutionID uint64) {
+	cReqs, eReqs := prepareCoursesForDB(cs, institutionID)
 
 	trx, err := db.Begin()
 	if err != nil {
@@ -88,7 +95,7 @@ func saveCoursesToDB(cs *[]canvasCourse) {
 
 		rbErr := trx.Rollback()
 		if rbErr != nil {
-			util.HandleError(fmt.Errorf("error rolling back save ️courses to db transaction at enrollments: %w"))
+			util.HandleError(fmt.Errorf("error rolling back save ️courses to db transaction at enrollments: %w", rbErr))
 		}
 
 		return
@@ -109,7 +116,7 @@ func saveCoursesToDB(cs *[]canvasCourse) {
 	return
 }
 
-func saveObserveesToDB(cObs *[]canvasObservee, requestingUserID uint64) {
+func saveObserveesToDB(cObs *[]canvasObservee, requestingUserID uint64, institutionID uint64) {
 	var obs []users.Observee
 	for _, o := range *cObs {
 		obs = append(obs, users.Observee{
@@ -127,7 +134,10 @@ func saveObserveesToDB(cObs *[]canvasObservee, requestingUserID uint64) {
 	}
 
 	// get the user's current observees
-	dbObserveesP, err := users.ListObservees(trx, &users.ListObserveesRequest{ObserverCanvasUserID: requestingUserID})
+	dbObserveesP, err := users.ListObservees(trx, &users.ListObserveesRequest{
+		ObserverCanvasUserID: requestingUserID,
+		InstitutionID:        institutionID,
+	})
 	if err != nil {
 		util.HandleError(fmt.Errorf("error listing user observees: %w", err))
 		return
@@ -185,7 +195,7 @@ func saveObserveesToDB(cObs *[]canvasObservee, requestingUserID uint64) {
 	}
 
 	if len(toSoftDelete) > 0 {
-		err := users.SoftDeleteUserObservees(trx, toSoftDelete)
+		err := users.SoftDeleteUserObservees(trx, institutionID, toSoftDelete)
 		if err != nil {
 			util.HandleError(fmt.Errorf("error soft deleting user observees: %w", err))
 			rollbackErr := trx.Rollback()
@@ -197,7 +207,7 @@ func saveObserveesToDB(cObs *[]canvasObservee, requestingUserID uint64) {
 	}
 
 	if len(toUnSoftDelete) > 0 {
-		err := users.UnSoftDeleteUserObservees(trx, toUnSoftDelete)
+		err := users.UnSoftDeleteUserObservees(trx, institutionID, toUnSoftDelete)
 		if err != nil {
 			util.HandleError(fmt.Errorf("error un-soft deleting user observees: %w", err))
 			rollbackErr := trx.Rollback()
@@ -212,6 +222,7 @@ func saveObserveesToDB(cObs *[]canvasObservee, requestingUserID uint64) {
 		err := users.UpsertUserObservees(trx, &users.UpsertObserveesRequest{
 			Observees:            toUpsert,
 			ObserverCanvasUserID: requestingUserID,
+			InstitutionID:        institutionID,
 		})
 		if err != nil {
 			util.HandleError(fmt.Errorf("error upserting user observees: %w", err))
@@ -236,7 +247,10 @@ func saveObserveesToDB(cObs *[]canvasObservee, requestingUserID uint64) {
 	return
 }
 
-func prepareOutcomeResultsForDB(results processedOutcomeResults) (*[]courses.OutcomeResultInsertRequest, error) {
+func prepareOutcomeResultsForDB(
+	results processedOutcomeResults,
+	institutionID uint64,
+) (*[]courses.OutcomeResultInsertRequest, error) {
 	var req []courses.OutcomeResultInsertRequest
 	for cID, us := range results {
 		for uID, os := range us {
@@ -257,6 +271,7 @@ func prepareOutcomeResultsForDB(results processedOutcomeResults) (*[]courses.Out
 						Score:           r.Score,
 						Possible:        r.Possible,
 						SubmissionTime:  r.SubmittedOrAssessedAt,
+						InstitutionID:   institutionID,
 					})
 				}
 			}
@@ -266,8 +281,8 @@ func prepareOutcomeResultsForDB(results processedOutcomeResults) (*[]courses.Out
 	return &req, nil
 }
 
-func saveOutcomeResultsToDB(results processedOutcomeResults) {
-	req, err := prepareOutcomeResultsForDB(results)
+func saveOutcomeResultsToDB(results processedOutcomeResults, institutionID uint64) {
+	req, err := prepareOutcomeResultsForDB(results, institutionID)
 	if err != nil {
 		util.HandleError(fmt.Errorf("error preparing outcome results for db: %w", err))
 		return
@@ -344,7 +359,8 @@ func saveOutcomeResultsToDB(results processedOutcomeResults) {
 
 func prepareGradesForDB(
 	grds detailedGrades,
-	manualFetch bool) (
+	manualFetch bool,
+	institutionID uint64) (
 	*[]grades.InsertRequest,
 	*[]courses.OutcomeRollupInsertRequest,
 ) {
@@ -352,15 +368,16 @@ func prepareGradesForDB(
 	var rs []courses.OutcomeRollupInsertRequest
 	for uID, cs := range grds {
 		for cID, grd := range cs {
-			if grd.Grade == naGrade {
+			if grd.Grade == naGrade || grd.reused {
 				continue
 			}
 
 			req = append(req, grades.InsertRequest{
-				Grade:        grd.Grade.Grade,
-				CourseID:     int(cID),
-				UserCanvasID: int(uID),
-				ManualFetch:  manualFetch,
+				Grade:         grd.Grade.Grade,
+				CourseID:      int(cID),
+				UserCanvasID:  int(uID),
+				ManualFetch:   manualFetch,
+				InstitutionID: institutionID,
 			})
 
 			for oID, avg := range grd.Averages {
@@ -377,8 +394,8 @@ func prepareGradesForDB(
 	return &req, &rs
 }
 
-func saveGradesToDB(grds detailedGrades, manualFetch bool) {
-	req, rs := prepareGradesForDB(grds, manualFetch)
+func saveGradesToDB(grds detailedGrades, manualFetch bool, institutionID uint64) {
+	req, rs := prepareGradesForDB(grds, manualFetch, institutionID)
 
 	if len(*req) > 0 {
 		go func(request *[]grades.InsertRequest) {
@@ -443,13 +460,15 @@ func saveAssignmentsToDB(ass []canvasAssignment, courseID string) {
 
 func prepareOutcomeForDB(o *canvasOutcome) *outcomes.InsertRequest {
 	return &outcomes.InsertRequest{
-		CanvasID:       o.ID,
-		CourseID:       &o.ContextID,
-		ContextID:      o.ContextID,
-		DisplayName:    o.DisplayName,
-		Title:          o.Title,
-		MasteryPoints:  o.MasteryPoints,
-		PointsPossible: o.PointsPossible,
+		CanvasID:          o.ID,
+		CourseID:          &o.ContextID,
+		ContextID:         o.ContextID,
+		DisplayName:       o.DisplayName,
+		Title:             o.Title,
+		MasteryPoints:     o.MasteryPoints,
+		PointsPossible:    o.PointsPossible,
+		CalculationMethod: o.CalculationMethod,
+		CalculationInt:    o.CalculationInt,
 	}
 }
 
@@ -467,7 +486,7 @@ func saveOutcomeToDB(o *canvasOutcome) {
 	}
 }
 
-func prepareGPAForDB(g gpa, manualFetch bool) *[]gpas.InsertRequest {
+func prepareGPAForDB(g gpa, manualFetch bool, institutionID uint64) *[]gpas.InsertRequest {
 	var req []gpas.InsertRequest
 
 	for cuID, cGPA := range g {
@@ -477,40 +496,49 @@ func prepareGPAForDB(g gpa, manualFetch bool) *[]gpas.InsertRequest {
 			GPA:              cGPA.Unweighted.Default,
 			GPAWithSubgrades: cGPA.Unweighted.Subgrades,
 			ManualFetch:      manualFetch,
+			InstitutionID:    institutionID,
+		}, gpas.InsertRequest{
+			CanvasUserID:     cuID,
+			Weighted:         true,
+			GPA:              cGPA.Weighted.Default,
+			GPAWithSubgrades: cGPA.Weighted.Subgrades,
+			ManualFetch:      manualFetch,
+			InstitutionID:    institutionID,
 		})
 	}
 
 	return &req
 }
 
-func saveGPAToDB(g gpa, manualFetch bool) {
-	err := gpas.InsertMultiple(db, prepareGPAForDB(g, manualFetch))
+func saveGPAToDB(g gpa, manualFetch bool, institutionID uint64) {
+	err := gpas.InsertMultiple(db, prepareGPAForDB(g, manualFetch, institutionID))
 	if err != nil {
 		util.HandleError(fmt.Errorf("error saving gpa to db: %w", err))
 		return
 	}
 }
 
-func prepareCanvasOAuth2GrantForDB(grant *canvasTokenGrantResponse) *canvas_tokens.InsertRequest {
+func prepareCanvasOAuth2GrantForDB(grant *canvasTokenGrantResponse, institutionID uint64) *canvas_tokens.InsertRequest {
 	expAt := time.Now().Add(time.Duration(grant.ExpiresIn) * time.Second)
 
 	return &canvas_tokens.InsertRequest{
-		CanvasUserID: grant.User.ID,
-		Token:        grant.AccessToken,
-		RefreshToken: grant.RefreshToken,
-		ExpiresAt:    &expAt,
+		CanvasUserID:  grant.User.ID,
+		Token:         grant.AccessToken,
+		RefreshToken:  grant.RefreshToken,
+		ExpiresAt:     &expAt,
+		InstitutionID: institutionID,
 	}
 }
 
-func saveCanvasOAuth2GrantToDB(grant *canvasTokenGrantResponse) {
-	err := canvas_tokens.Insert(db, prepareCanvasOAuth2GrantForDB(grant))
+func saveCanvasOAuth2GrantToDB(grant *canvasTokenGrantResponse, institutionID uint64) {
+	err := canvas_tokens.Insert(db, prepareCanvasOAuth2GrantForDB(grant, institutionID))
 	if err != nil {
 		util.HandleError(fmt.Errorf("error saving canvas oauth2 grant to db: %w", err))
 		return
 	}
 }
 
-func prepareEnrollmentsForDB(req []canvasFullEnrollment) *[]enrollments.UpsertRequest {
+func prepareEnrollmentsForDB(req []canvasFullEnrollment, institutionID uint64) *[]enrollments.UpsertRequest {
 	var reqs []enrollments.UpsertRequest
 
 	for _, e := range req {
@@ -524,21 +552,26 @@ func prepareEnrollmentsForDB(req []canvasFullEnrollment) *[]enrollments.UpsertRe
 			State:                  e.EnrollmentState,
 			CreatedAt:              e.CreatedAt,
 			UpdatedAt:              e.UpdatedAt,
+			InstitutionID:          institutionID,
 		})
 	}
 
 	return &reqs
 }
 
-func saveEnrollmentsToDB(req []canvasFullEnrollment) {
-	err := enrollments.Upsert(db, prepareEnrollmentsForDB(req))
+func saveEnrollmentsToDB(req []canvasFullEnrollment, institutionID uint64) {
+	err := enrollments.Upsert(db, prepareEnrollmentsForDB(req, institutionID))
 	if err != nil {
 		util.HandleError(fmt.Errorf("error saving enrollments to db: %w", err))
 		return
 	}
 }
 
-func prepareSubmissionsForDB(req []canvasSubmission, courseID uint64) (*[]submissions.UpsertRequest, *[]submissions.AttachmentUpsertRequest) {
+func prepareSubmissionsForDB(
+	req []canvasSubmission,
+	courseID uint64,
+	institutionID uint64,
+) (*[]submissions.UpsertRequest, *[]submissions.AttachmentUpsertRequest) {
 	var ss []submissions.UpsertRequest
 	var as []submissions.AttachmentUpsertRequest
 	for _, s := range req {
@@ -563,6 +596,7 @@ func prepareSubmissionsForDB(req []canvasSubmission, courseID uint64) (*[]submis
 			SecondsLate:      s.SecondsLate,
 			ExtraAttempts:    s.ExtraAttempts,
 			PostedAt:         s.PostedAt,
+			InstitutionID:    institutionID,
 		})
 
 		for _, a := range s.Attachments {
@@ -582,8 +616,8 @@ func prepareSubmissionsForDB(req []canvasSubmission, courseID uint64) (*[]submis
 	return &ss, &as
 }
 
-func saveSubmissionsToDB(req []canvasSubmission, courseID uint64) {
-	ss, as := prepareSubmissionsForDB(req, courseID)
+func saveSubmissionsToDB(req []canvasSubmission, courseID uint64, institutionID uint64) {
+	ss, as := prepareSubmissionsForDB(req, courseID, institutionID)
 
 	if len(*ss) > 0 {
 		err := submissions.Upsert(db, ss)
@@ -603,393 +637,392 @@ func saveSubmissionsToDB(req []canvasSubmission, courseID uint64) {
 
 }
 
-// handleBatchGradesDBRequests handles tons of UserGradesDBRequests from fetch_all. It handles chunking and more.
-func handleBatchGradesDBRequests(dbReqs []UserGradesDBRequests) {
-	go func() {
-		var (
-			profiles []users.UpsertRequest
-			// keeps out duplicates
-			coursesMap = make(map[int64]struct{})
-			cs         []courses.UpsertRequest
-			// keeps out duplicates
-			outcomeResultsMap = make(map[uint64]struct{})
-			// chunked in 7281 due to postgres's 65535 parameter limit
-			// 9 params each
-			chunkedOutcomeResults           = [][]courses.OutcomeResultInsertRequest{{}}
-			currentOutcomeResultChunk       = 0
-			currentOutcomeResultChunkLength = 0
-			// chunk in 16383 due to postgres's 65535 parameter limit
-			// 4 params each
-			chunkedGrades            = [][]grades.InsertRequest{{}}
-			currentGradesChunk       = 0
-			currentGradesChunkLength = 0
-			// chunk in 13107 due to postgres's 65535 parameter limit
-			// 5 params each
-			chunkedRollupScores           = [][]courses.OutcomeRollupInsertRequest{{}}
-			currentRollupScoreChunk       = 0
-			currentRollupScoreChunkLength = 0
-
-			gpaReqs []gpas.InsertRequest
-
-			// chunked in 7281 due to postgres's 65535 parameter limit
-			// 9 params each
-			chunkedEnrollments            = [][]enrollments.UpsertRequest{{}}
-			currentEnrollmentsChunk       = 0
-			currentEnrollmentsChunkLength = 0
-			// enrollmentsCache makes sure we don't get the "can't DO UPDATE more than once" error
-			enrollmentsCache = make(map[uint64]map[uint64]struct{})
-
-			chunkedAssignments            = [][]courses.AssignmentUpsertRequest{{}}
-			currentAssignmentsChunk       = 0
-			currentAssignmentsChunkLength = 0
-			assignmentsCache              = make(map[uint64]struct{})
-
-			chunkedSubmissions            = [][]submissions.UpsertRequest{{}}
-			currentSubmissionsChunk       = 0
-			currentSubmissionsChunkLength = 0
-			submissionsCache              = map[uint64]struct{}{}
-
-			chunkedAttachments            = [][]submissions.AttachmentUpsertRequest{{}}
-			currentAttachmentsChunk       = 0
-			currentAttachmentsChunkLength = 0
-			attachmentsCache              = map[uint64]struct{}{}
-		)
-
-		for _, r := range dbReqs {
-			if r.Profile != nil {
-				profiles = append(profiles, *r.Profile)
-			}
+// saveBatchGradesDBRequests saves tons of UserGradesDBRequests from fetch_all in one transaction. It handles chunking and more.
+func saveBatchGradesDBRequests(dbReqs []UserGradesDBRequests) error {
+	var (
+		profiles []users.UpsertRequest
+		// keeps out duplicates
+		coursesMap = make(map[int64]struct{})
+		cs         []courses.UpsertRequest
+		// keeps out duplicates
+		outcomeResultsMap = make(map[uint64]struct{})
+		// chunked in 6553 due to postgres's 65535 parameter limit
+		// 10 params each
+		chunkedOutcomeResults           = [][]courses.OutcomeResultInsertRequest{{}}
+		currentOutcomeResultChunk       = 0
+		currentOutcomeResultChunkLength = 0
+		// chunk in 13107 due to postgres's 65535 parameter limit
+		// 5 params each
+		chunkedGrades            = [][]grades.InsertRequest{{}}
+		currentGradesChunk       = 0
+		currentGradesChunkLength = 0
+		// chunk in 13107 due to postgres's 65535 parameter limit
+		// 5 params each
+		chunkedRollupScores           = [][]courses.OutcomeRollupInsertRequest{{}}
+		currentRollupScoreChunk       = 0
+		currentRollupScoreChunkLength = 0
+
+		gpaReqs []gpas.InsertRequest
+
+		// chunked in 6553 due to postgres's 65535 parameter limit
+		// 10 params each
+		chunkedEnrollments            = [][]enrollments.UpsertRequest{{}}
+		currentEnrollmentsChunk       = 0
+		currentEnrollmentsChunkLength = 0
+		// enrollmentsCache makes sure we don't get the "can't DO UPDATE more than once" error
+		enrollmentsCache = make(map[uint64]map[uint64]struct{})
+
+		chunkedAssignments            = [][]courses.AssignmentUpsertRequest{{}}
+		currentAssignmentsChunk       = 0
+		currentAssignmentsChunkLength = 0
+		assignmentsCache              = make(map[uint64]struct{})
+
+		chunkedSubmissions            = [][]submissions.UpsertRequest{{}}
+		currentSubmissionsChunk       = 0
+		currentSubmissionsChunkLength = 0
+		submissionsCache              = map[uint64]struct{}{}
+
+		chunkedAttachments            = [][]submissions.AttachmentUpsertRequest{{}}
+		currentAttachmentsChunk       = 0
+		currentAttachmentsChunkLength = 0
+		attachmentsCache              = map[uint64]struct{}{}
+	)
 
-			if r.Courses != nil {
-				// keeps out duplicate courses
-				for _, c := range *r.Courses {
-					if _, ok := coursesMap[c.CourseID]; !ok {
-						cs = append(cs, c)
-						coursesMap[c.CourseID] = struct{}{}
-					}
-				}
-			}
+	for _, r := range dbReqs {
+		if r.Profile != nil {
+			profiles = append(profiles, *r.Profile)
+		}
 
-			if r.OutcomeResults != nil {
-				for _, or := range *r.OutcomeResults {
-					// keeps out duplicate outcome results
-					if _, ok := outcomeResultsMap[or.ID]; !ok {
-						// if we are over the max per chunk, move to next chunk
-						if currentOutcomeResultChunkLength >= 7281 {
-							currentOutcomeResultChunk++
-							chunkedOutcomeResults = append(chunkedOutcomeResults, []courses.OutcomeResultInsertRequest{})
-							currentOutcomeResultChunkLength = 0
-						}
-
-						// add to the current chunk
-						chunkedOutcomeResults[currentOutcomeResultChunk] =
-							append(chunkedOutcomeResults[currentOutcomeResultChunk], or)
-
-						// no duplicates
-						outcomeResultsMap[or.ID] = struct{}{}
-
-						// add to the number in the current chunk
-						currentOutcomeResultChunkLength++
-					}
+		if r.Courses != nil {
+			// keeps out duplicate courses
+			for _, c := range *r.Courses {
+				if _, ok := coursesMap[c.CourseID]; !ok {
+					cs = append(cs, c)
+					coursesMap[c.CourseID] = struct{}{}
 				}
 			}
+		}
 
-			if r.Grades != nil {
-				for _, g := range *r.Grades {
+		if r.OutcomeResults != nil {
+			for _, or := range *r.OutcomeResults {
+				// keeps out duplicate outcome results
+				if _, ok := outcomeResultsMap[or.ID]; !ok {
 					// if we are over the max per chunk, move to next chunk
-					if currentGradesChunkLength >= 16383 {
-						currentGradesChunk++
-						chunkedGrades = append(chunkedGrades, []grades.InsertRequest{})
-						currentGradesChunkLength = 0
+					if currentOutcomeResultChunkLength >= 6553 {
+						currentOutcomeResultChunk++
+						chunkedOutcomeResults = append(chunkedOutcomeResults, []courses.OutcomeResultInsertRequest{})
+						currentOutcomeResultChunkLength = 0
 					}
 
 					// add to the current chunk
-					chunkedGrades[currentGradesChunk] =
-						append(chunkedGrades[currentGradesChunk], g)
+					chunkedOutcomeResults[currentOutcomeResultChunk] =
+						append(chunkedOutcomeResults[currentOutcomeResultChunk], or)
+
+					// no duplicates
+					outcomeResultsMap[or.ID] = struct{}{}
 
 					// add to the number in the current chunk
-					currentGradesChunkLength++
+					currentOutcomeResultChunkLength++
 				}
 			}
+		}
 
-			if r.RollupScores != nil {
-				for _, rs := range *r.RollupScores {
-					// if we are over the max per chunk, move to next chunk
-					if currentRollupScoreChunkLength >= 13107 {
-						currentRollupScoreChunk++
-						chunkedRollupScores = append(chunkedRollupScores, []courses.OutcomeRollupInsertRequest{})
-						currentRollupScoreChunkLength = 0
-					}
+		if r.Grades != nil {
+			for _, g := range *r.Grades {
+				// if we are over the max per chunk, move to next chunk
+				if currentGradesChunkLength >= 13107 {
+					currentGradesChunk++
+					chunkedGrades = append(chunkedGrades, []grades.InsertRequest{})
+					currentGradesChunkLength = 0
+				}
 
-					// add to the current chunk
-					chunkedRollupScores[currentRollupScoreChunk] =
-						append(chunkedRollupScores[currentRollupScoreChunk], rs)
+				// add to the current chunk
+				chunkedGrades[currentGradesChunk] =
+					append(chunkedGrades[currentGradesChunk], g)
 
-					// add to the number in the current chunk
-					currentRollupScoreChunkLength++
-				}
+				// add to the number in the current chunk
+				currentGradesChunkLength++
 			}
+		}
 
-			if r.GPA != nil {
-				gpaReqs = append(gpaReqs, *r.GPA...)
-			}
+		if r.RollupScores != nil {
+			for _, rs := range *r.RollupScores {
+				// if we are over the max per chunk, move to next chunk
+				if currentRollupScoreChunkLength >= 13107 {
+					currentRollupScoreChunk++
+					chunkedRollupScores = append(chunkedRollupScores, []courses.OutcomeRollupInsertRequest{})
+					currentRollupScoreChunkLength = 0
+				}
 
-			if r.Enrollments != nil {
-				for _, es := range r.Enrollments {
-					if _, ok := enrollmentsCache[es.UserCanvasID]; ok {
-						// user => course
-						if enrollmentsCache[es.UserCanvasID] == nil {
-							enrollmentsCache[es.UserCanvasID] = map[uint64]struct{}{}
-						}
-
-						if _, ok := enrollmentsCache[es.UserCanvasID][es.CourseID]; ok {
-							continue
-						} else {
-							enrollmentsCache[es.UserCanvasID][es.CourseID] = struct{}{}
-						}
-					} else {
-						enrollmentsCache[es.UserCanvasID] = map[uint64]struct{}{es.CourseID: {}}
-					}
+				// add to the current chunk
+				chunkedRollupScores[currentRollupScoreChunk] =
+					append(chunkedRollupScores[currentRollupScoreChunk], rs)
 
-					// if we are over the max per chunk, move to next chunk
-					if currentEnrollmentsChunkLength >= 7281 {
-						currentEnrollmentsChunk++
-						chunkedEnrollments = append(chunkedEnrollments, []enrollments.UpsertRequest{})
-						currentEnrollmentsChunkLength = 0
-					}
+				// add to the number in the current chunk
+				currentRollupScoreChunkLength++
+			}
+		}
 
-					// add to the current chunk
-					chunkedEnrollments[currentEnrollmentsChunk] =
-						append(chunkedEnrollments[currentEnrollmentsChunk], es)
+		if r.GPA != nil {
+			gpaReqs = append(gpaReqs, *r.GPA...)
+		}
 
-					// add to the number in the current chunk
-					currentEnrollmentsChunkLength++
-				}
-			}
+		if r.Enrollments != nil {
+			for _, es := range r.Enrollments {
+				if _, ok := enrollmentsCache[es.UserCanvasID]; ok {
+					// user => course
+					if enrollmentsCache[es.UserCanvasID] == nil {
+						enrollmentsCache[es.UserCanvasID] = map[uint64]struct{}{}
+					}
 
-			if r.Assignments != nil {
-				for _, a := range r.Assignments {
-					if _, ok := assignmentsCache[a.CanvasID]; ok {
+					if _, ok := enrollmentsCache[es.UserCanvasID][es.CourseID]; ok {
 						continue
 					} else {
-						assignmentsCache[a.CanvasID] = struct{}{}
+						enrollmentsCache[es.UserCanvasID][es.CourseID] = struct{}{}
 					}
+				} else {
+					enrollmentsCache[es.UserCanvasID] = map[uint64]struct{}{es.CourseID: {}}
+				}
 
-					// if we are over the max per chunk, move to next chunk
-					if currentAssignmentsChunkLength >= courses.MultipleAssignmentsChunkSize {
-						currentAssignmentsChunk++
-						chunkedAssignments = append(chunkedAssignments, []courses.AssignmentUpsertRequest{})
-						currentAssignmentsChunkLength = 0
-					}
+				// if we are over the max per chunk, move to next chunk
+				if currentEnrollmentsChunkLength >= 6553 {
+					currentEnrollmentsChunk++
+					chunkedEnrollments = append(chunkedEnrollments, []enrollments.UpsertRequest{})
+					currentEnrollmentsChunkLength = 0
+				}
 
-					// add to the current chunk
-					chunkedAssignments[currentAssignmentsChunk] =
-						append(chunkedAssignments[currentAssignmentsChunk], a)
+				// add to the current chunk
+				chunkedEnrollments[currentEnrollmentsChunk] =
+					append(chunkedEnrollments[currentEnrollmentsChunk], es)
 
-					// add to the number in the current chunk
-					currentAssignmentsChunkLength++
-				}
+				// add to the number in the current chunk
+				currentEnrollmentsChunkLength++
 			}
+		}
 
-			if r.Submissions != nil {
-				for _, s := range r.Submissions {
-					if _, ok := submissionsCache[s.CanvasID]; ok {
-						continue
-					} else {
-						submissionsCache[s.CanvasID] = struct{}{}
-					}
+		if r.Assignments != nil {
+			for _, a := range r.Assignments {
+				if _, ok := assignmentsCache[a.CanvasID]; ok {
+					continue
+				} else {
+					assignmentsCache[a.CanvasID] = struct{}{}
+				}
 
-					// if we are over the max per chunk, move to next chunk
-					if currentSubmissionsChunkLength >= submissions.UpsertChunkSize {
-						currentSubmissionsChunk++
-						chunkedSubmissions = append(chunkedSubmissions, []submissions.UpsertRequest{})
-						currentSubmissionsChunkLength = 0
-					}
+				// if we are over the max per chunk, move to next chunk
+				if currentAssignmentsChunkLength >= courses.MultipleAssignmentsChunkSize {
+					currentAssignmentsChunk++
+					chunkedAssignments = append(chunkedAssignments, []courses.AssignmentUpsertRequest{})
+					currentAssignmentsChunkLength = 0
+				}
 
-					// add to the current chunk
-					chunkedSubmissions[currentSubmissionsChunk] =
-						append(chunkedSubmissions[currentSubmissionsChunk], s)
+				// add to the current chunk
+				chunkedAssignments[currentAssignmentsChunk] =
+					append(chunkedAssignments[currentAssignmentsChunk], a)
 
-					// add to the number in the current chunk
-					currentSubmissionsChunkLength++
-				}
+				// add to the number in the current chunk
+				currentAssignmentsChunkLength++
 			}
+		}
 
-			if r.SubmissionAttachments != nil {
-				for _, a := range r.SubmissionAttachments {
-					if _, ok := attachmentsCache[a.CanvasID]; ok {
-						continue
-					} else {
-						attachmentsCache[a.CanvasID] = struct{}{}
-					}
+		if r.Submissions != nil {
+			for _, s := range r.Submissions {
+				if _, ok := submissionsCache[s.CanvasID]; ok {
+					continue
+				} else {
+					submissionsCache[s.CanvasID] = struct{}{}
+				}
 
-					// if we are over the max per chunk, move to next chunk
-					if currentAttachmentsChunk >= submissions.AttachmentsUpsertChunkSize {
-						currentAttachmentsChunk++
-						chunkedAttachments = append(chunkedAttachments, []submissions.AttachmentUpsertRequest{})
-						currentAttachmentsChunkLength = 0
-					}
+				// if we are over the max per chunk, move to next chunk
+				if currentSubmissionsChunkLength >= submissions.UpsertChunkSize {
+					currentSubmissionsChunk++
+					chunkedSubmissions = append(chunkedSubmissions, []submissions.UpsertRequest{})
+					currentSubmissionsChunkLength = 0
+				}
 
-					// add to the current chunk
-					chunkedAttachments[currentAttachmentsChunk] =
-						append(chunkedAttachments[currentAttachmentsChunk], a)
+				// add to the current chunk
+				chunkedSubmissions[currentSubmissionsChunk] =
+					append(chunkedSubmissions[currentSubmissionsChunk], s)
 
-					// add to the number in the current chunk
-					currentAttachmentsChunkLength++
+				// add to the number in the current chunk
+				currentSubmissionsChunkLength++
+			}
+		}
+
+		if r.SubmissionAttachments != nil {
+			for _, a := range r.SubmissionAttachments {
+				if _, ok := attachmentsCache[a.CanvasID]; ok {
+					continue
+				} else {
+					attachmentsCache[a.CanvasID] = struct{}{}
 				}
+
+				// if we are over the max per chunk, move to next chunk
+				if currentAttachmentsChunk >= submissions.AttachmentsUpsertChunkSize {
+					currentAttachmentsChunk++
+					chunkedAttachments = append(chunkedAttachments, []submissions.AttachmentUpsertRequest{})
+					currentAttachmentsChunkLength = 0
+				}
+
+				// add to the current chunk
+				chunkedAttachments[currentAttachmentsChunk] =
+					append(chunkedAttachments[currentAttachmentsChunk], a)
+
+				// add to the number in the current chunk
+				currentAttachmentsChunkLength++
 			}
 		}
+	}
 
-		// we'll request one at a time-- these are big, big requests
-		trx, err := db.Begin()
+	// we'll request one at a time-- these are big, big requests
+	trx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error beginning insert grades fetch_all data transaction: %w", err)
+	}
+
+	rb := func(at string) {
+		err := trx.Rollback()
 		if err != nil {
-			util.HandleError(fmt.Errorf("error beginning insert grades fetch_all data transaction: %w", err))
-			return
+			util.HandleError(
+				fmt.Errorf("error rolling back insert grades fetch_all data transaction at %s: %w", at, err),
+			)
 		}
+	}
 
-		rb := func(at string) {
-			err := trx.Rollback()
-			if err != nil {
-				util.HandleError(
-					fmt.Errorf("error rolling back insert grades fetch_all data transaction at %s: %w", at, err),
-				)
-			}
+	_, err = users.UpsertMultipleProfiles(trx, &profiles, false)
+	if err != nil {
+		rb("profiles")
+		return fmt.Errorf("error upserting multiple profiles in insert fetch_all data: %w", err)
+	}
+
+	err = courses.UpsertMultiple(trx, &cs)
+	if err != nil {
+		rb("courses")
+		return fmt.Errorf("error upserting multiple courses in insert fetch_all data: %w", err)
+	}
+
+	for i, req := range chunkedOutcomeResults {
+		if len(req) < 1 {
+			continue
 		}
 
-		_, err = users.UpsertMultipleProfiles(trx, &profiles, false)
+		err = courses.InsertMultipleOutcomeResults(trx, &req)
 		if err != nil {
-			rb("profiles")
-			util.HandleError(fmt.Errorf("error upserting multiple profiles in insert fetch_all data: %w", err))
-			return
+			rb("outcome results")
+			return fmt.Errorf("error inserting multiple outcome results in insert fetch_all data (chunk %d): %w", i, err)
 		}
+	}
 
-		err = courses.UpsertMultiple(trx, &cs)
+	for i, req := range chunkedGrades {
+		if len(req) < 1 {
+			continue
+		}
+
+		err = grades.Insert(trx, &req)
 		if err != nil {
-			rb("courses")
-			util.HandleError(fmt.Errorf("error upserting multiple courses in insert fetch_all data: %w", err))
-			return
+			rb("grades")
+			return fmt.Errorf("error inserting multiple grades in insert fetch_all data (chunk %d): %w", i, err)
 		}
+	}
 
-		for i, req := range chunkedOutcomeResults {
-			if len(req) < 1 {
-				continue
-			}
+	for i, req := range chunkedRollupScores {
+		if len(req) < 1 {
+			continue
+		}
 
-			err = courses.InsertMultipleOutcomeResults(trx, &req)
-			if err != nil {
-				rb("outcome results")
-				util.HandleError(
-					fmt.Errorf("error inserting multiple outcome results in insert fetch_all data (chunk %d): %w", i, err),
-				)
-				return
-			}
+		err = courses.InsertMultipleOutcomeRollups(trx, &req)
+		if err != nil {
+			rb("rollup scores")
+			return fmt.Errorf("error inserting multiple rollup scores in insert fetch_all data (chunk %d): %w", i, err)
 		}
+	}
 
-		for i, req := range chunkedGrades {
-			if len(req) < 1 {
-				continue
-			}
+	err = gpas.InsertMultiple(trx, &gpaReqs)
+	if err != nil {
+		rb("gpas")
+		return fmt.Errorf("error inserting multiple gpas in insert fetch_all data: %w", err)
+	}
 
-			err = grades.Insert(trx, &req)
-			if err != nil {
-				rb("grades")
-				util.HandleError(
-					fmt.Errorf("error inserting multiple grades in insert fetch_all data (chunk %d): %w", i, err),
-				)
-				return
-			}
+	for i, req := range chunkedEnrollments {
+		if len(req) < 1 {
+			continue
 		}
 
-		for i, req := range chunkedRollupScores {
-			if len(req) < 1 {
-				continue
-			}
+		err = enrollments.Upsert(trx, &req)
+		if err != nil {
+			rb("enrollments")
+			return fmt.Errorf("error inserting multiple enrollments in insert fetch_all data (chunk %d): %w", i, err)
+		}
+	}
 
-			err = courses.InsertMultipleOutcomeRollups(trx, &req)
-			if err != nil {
-				rb("rollup scores")
-				util.HandleError(
-					fmt.Errorf("error inserting multiple rollup scores in insert fetch_all data (chunk %d): %w", i, err),
-				)
-				return
-			}
+	for i, req := range chunkedAssignments {
+		if len(req) < 1 {
+			continue
 		}
 
-		err = gpas.InsertMultiple(trx, &gpaReqs)
+		err = courses.UpsertMultipleAssignments(trx, &req)
 		if err != nil {
-			rb("gpas")
-			util.HandleError(fmt.Errorf("error inserting multiple gpas in insert fetch_all data: %w", err))
-			return
+			rb("assignments")
+			return fmt.Errorf("error inserting multiple assignments in insert fetch_all data (chunk %d): %w", i, err)
 		}
+	}
 
-		for i, req := range chunkedEnrollments {
-			if len(req) < 1 {
-				continue
-			}
+	for i, req := range chunkedSubmissions {
+		if len(req) < 1 {
+			continue
+		}
 
-			err = enrollments.Upsert(trx, &req)
-			if err != nil {
-				rb("enrollments")
-				util.HandleError(
-					fmt.Errorf("error inserting multiple enrollments in insert fetch_all data (chunk %d): %w", i, err),
-				)
-				return
-			}
+		err = submissions.Upsert(trx, &req)
+		if err != nil {
+			rb("submissions")
+			return fmt.Errorf("error inserting multiple submissions in insert fetch_all data (chunk %d): %w", i, err)
 		}
+	}
 
-		for i, req := range chunkedAssignments {
-			if len(req) < 1 {
-				continue
-			}
+	for i, req := range chunkedAttachments {
+		if len(req) < 1 {
+			continue
+		}
 
-			err = courses.UpsertMultipleAssignments(trx, &req)
-			if err != nil {
-				rb("assignments")
-				util.HandleError(
-					fmt.Errorf("error inserting multiple assignments in insert fetch_all data (chunk %d): %w", i, err),
-				)
-				return
-			}
+		err = submissions.UpsertAttachments(trx, &req)
+		if err != nil {
+			rb("submission attachments")
+			return fmt.Errorf("error inserting multiple submission attachments in insert fetch_all data (chunk %d): %w", i, err)
 		}
+	}
 
-		for i, req := range chunkedSubmissions {
-			if len(req) < 1 {
-				continue
-			}
+	err = trx.Commit()
+	if err != nil {
+		rb("commit")
+		return fmt.Errorf("error commiting insert fetch_all data transaction: %w", err)
+	}
 
-			err = submissions.Upsert(trx, &req)
-			if err != nil {
-				rb("submissions")
-				util.HandleError(
-					fmt.Errorf("error inserting multiple submissions in insert fetch_all data (chunk %d): %w", i, err),
-				)
-				return
-			}
-		}
+	return nil
+}
 
-		for i, req := range chunkedAttachments {
-			if len(req) < 1 {
-				continue
-			}
+func saveProficiencyRatingsToDB(rootAccountID uint64, rs []outcome_proficiencies.Rating) {
+	trx, err := db.Begin()
+	if err != nil {
+		util.HandleError(fmt.Errorf("error beginning save proficiency ratings to db transaction: %w", err))
+		return
+	}
 
-			err = submissions.UpsertAttachments(trx, &req)
-			if err != nil {
-				rb("submission attachments")
-				util.HandleError(
-					fmt.Errorf("error inserting multiple submission attachments in insert fetch_all data (chunk %d): %w", i, err),
-				)
-				return
-			}
-		}
+	err = outcome_proficiencies.Replace(trx, &outcome_proficiencies.ReplaceRequest{
+		RootAccountID: rootAccountID,
+		Ratings:       rs,
+	})
+	if err != nil {
+		util.HandleError(fmt.Errorf("error saving proficiency ratings for root account %d to db: %w", rootAccountID, err))
 
-		err = trx.Commit()
-		if err != nil {
-			rb("commit")
-			util.HandleError(fmt.Errorf("error commiting insert fetch_all data transaction: %w", err))
-			return
+		rbErr := trx.Rollback()
+		if rbErr != nil {
+			util.HandleError(fmt.Errorf("error rolling back save proficiency ratings to db transaction: %w", rbErr))
 		}
+		return
+	}
 
-		// success
+	err = trx.Commit()
+	if err != nil {
+		util.HandleError(fmt.Errorf("error committing save proficiency ratings transaction to db: %w", err))
 		return
-	}()
+	}
 }
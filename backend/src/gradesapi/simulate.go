@@ -0,0 +1,422 @@
+package gradesapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	userssvc "github.com/iamtheyammer/canvascbl/backend/src/db/services/users"
+	"github.com/iamtheyammer/canvascbl/backend/src/middlewares"
+	"github.com/iamtheyammer/canvascbl/backend/src/oauth2"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"github.com/julienschmidt/httprouter"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+type gradeSimulationChangeType string
+
+const (
+	gradesErrorCourseNotFound    = "the course was not found or you are not enrolled in it"
+	gradesErrorCantSeeUserGrades = "you can only see grades in a course for yourself, users you observe " +
+		"and students you teach"
+
+	// gradeSimulationChangeAdd adds a hypothetical score to an outcome.
+	gradeSimulationChangeAdd = gradeSimulationChangeType("add")
+	// gradeSimulationChangeChange changes the score of an existing outcome result.
+	gradeSimulationChangeChange = gradeSimulationChangeType("change")
+	// gradeSimulationChangeRemove removes an existing outcome result.
+	gradeSimulationChangeRemove = gradeSimulationChangeType("remove")
+)
+
+var (
+	// errCourseNotFound is returned when a user doesn't have a course.
+	errCourseNotFound = errors.New("course not found")
+	// errCantSeeUserGrades is returned when a user asks for someone else's grades in a course without being
+	// their observer or teacher.
+	errCantSeeUserGrades = errors.New("can't see the user's grades in the course")
+)
+
+type gradeSimulationChange struct {
+	Type gradeSimulationChangeType `json:"type"`
+	// OutcomeID is required for add.
+	OutcomeID uint64 `json:"outcome_id"`
+	// OutcomeResultID is required for change and remove.
+	OutcomeResultID uint64 `json:"outcome_result_id"`
+	// Score is required for add and change.
+	Score float64 `json:"score"`
+}
+
+type simulateGradeRequest struct {
+	// UserID is the Canvas user ID to simulate for. Defaults to the calling user.
+	UserID  uint64                  `json:"user_id"`
+	Changes []gradeSimulationChange `json:"changes"`
+}
+
+type simulateGradeResponse struct {
+	Current   computedGrade `json:"current"`
+	Simulated computedGrade `json:"simulated"`
+}
+
+// SimulateGradeHandler handles POST /api/v1/courses/:courseID/grades/simulate
+func SimulateGradeHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	courseID := ps.ByName("courseID")
+	if len(courseID) < 1 || !util.ValidateIntegerString(courseID) {
+		util.SendBadRequest(w, "missing or invalid courseID as url param")
+		return
+	}
+
+	var body simulateGradeRequest
+	err := middlewares.DecodeJSONBody(r.Body, &body)
+	if err != nil {
+		util.SendBadRequest(w, "malformed body")
+		return
+	}
+
+	for _, c := range body.Changes {
+		switch c.Type {
+		case gradeSimulationChangeAdd:
+			if c.OutcomeID < 1 {
+				util.SendBadRequest(w, "missing outcome_id in add change")
+				return
+			}
+		case gradeSimulationChangeChange, gradeSimulationChangeRemove:
+			if c.OutcomeResultID < 1 {
+				util.SendBadRequest(w, fmt.Sprintf("missing outcome_result_id in %s change", c.Type))
+				return
+			}
+		default:
+			util.SendBadRequest(w, "invalid change type")
+			return
+		}
+
+		if c.Score < 0 {
+			util.SendBadRequest(w, "scores can't be negative")
+			return
+		}
+	}
+
+	userID, rdP, sess, errCtx := authorizer(w, r, []oauth2.Scope{oauth2.ScopeDetailedGrades}, &oauth2.AuthorizerAPICall{
+		Method:    "POST",
+		RoutePath: "courses/:courseID/grades/simulate",
+	})
+	if (userID == nil || rdP == nil || errCtx == nil) && sess == nil {
+		return
+	}
+
+	errCtx.AddCustomFields(map[string]interface{}{
+		"course_id":   courseID,
+		"user_id":     body.UserID,
+		"num_changes": len(body.Changes),
+	})
+
+	canvasUserID, err := canvasUserIDForGradeRequest(*userID, body.UserID)
+	if err != nil {
+		handleISE(w, errCtx.Apply(fmt.Errorf("error getting canvas user id for grade simulation: %w", err)))
+		return
+	}
+
+	course, results, outcomes, err := getCourseOutcomeResultsForUser(rdP, *userID, courseID, canvasUserID)
+	if errors.Is(err, errCourseNotFound) {
+		handleError(w, GradesErrorResponse{
+			Error: gradesErrorCourseNotFound,
+		}, http.StatusNotFound)
+		return
+	} else if errors.Is(err, errCantSeeUserGrades) {
+		handleError(w, GradesErrorResponse{
+			Error: gradesErrorCantSeeUserGrades,
+		}, http.StatusForbidden)
+		return
+	} else if errors.Is(err, canvasErrorInvalidAccessTokenError) ||
+		errors.Is(err, canvasErrorInsufficientScopesOnAccessTokenError) {
+		handleError(w, GradesErrorResponse{
+			Error:  gradesErrorRevokedToken,
+			Action: gradesErrorActionRedirectToOAuth,
+		}, http.StatusForbidden)
+		return
+	} else if errors.Is(err, canvasErrorRateLimitExceededError) {
+		handleError(w, gradesErrorCanvasRateLimitedResponse, http.StatusTooManyRequests)
+		return
+	} else if errors.Is(err, canvasErrorUnknownError) {
+		handleError(w, gradesErrorUnknownCanvasErrorResponse, util.CanvasProxyErrorCode)
+		return
+	} else if err != nil {
+		handleISE(w, errCtx.Apply(fmt.Errorf("error getting outcome results for grade simulation: %w", err)))
+		return
+	}
+
+	simulatedResults, err := applyGradeSimulationChanges(results, outcomesPointsPossible(outcomes), body.Changes)
+	if err != nil {
+		util.SendBadRequest(w, err.Error())
+		return
+	}
+
+	policies, err := getGradingPolicySet()
+	if err != nil {
+		handleISE(w, errCtx.Apply(fmt.Errorf("error getting grading policies for grade simulation: %w", err)))
+		return
+	}
+
+	ratings := getProficiencyRatingsForCourses(*rdP, []canvasCourse{*course})[uint64(course.RootAccountID)]
+	policy := gradingPolicyForCourse(policies, rdP.InstitutionID, *course, ratings)
+
+	cutoffs, err := getDropCutoffSet()
+	if err != nil {
+		handleISE(w, errCtx.Apply(fmt.Errorf("error getting drop cutoffs for grade simulation: %w", err)))
+		return
+	}
+
+	isAfterCutoff, cutoff := dropCutoffForCourse(cutoffs, rdP.InstitutionID, *course)
+
+	calcs := outcomeCalculationsFromOutcomes(outcomes)
+	resp := simulateGradeResponse{
+		Current:   *calculateGradeFromOutcomeResults(results, calcs, policy, isAfterCutoff),
+		Simulated: *calculateGradeFromOutcomeResults(simulatedResults, calcs, policy, isAfterCutoff),
+	}
+
+	resp.Current.Cutoff = cutoff
+	resp.Simulated.Cutoff = cutoff
+
+	labelAveragesWithProficiencyRatings(&resp.Current, ratings)
+	labelAveragesWithProficiencyRatings(&resp.Simulated, ratings)
+
+	sendJSON(w, &resp)
+	return
+}
+
+// canvasUserIDForGradeRequest returns requestedCanvasUserID if it's set, or the calling user's Canvas user ID.
+// It doesn't check that the calling user can see requestedCanvasUserID's grades: see canSeeUserGradesInCourse.
+func canvasUserIDForGradeRequest(userID uint64, requestedCanvasUserID uint64) (uint64, error) {
+	if requestedCanvasUserID > 0 {
+		return requestedCanvasUserID, nil
+	}
+
+	users, err := userssvc.List(db, &userssvc.ListRequest{ID: userID})
+	if err != nil {
+		return 0, fmt.Errorf("error listing calling user: %w", err)
+	}
+
+	if len(*users) < 1 {
+		return 0, fmt.Errorf("couldn't find calling user with user ID %d", userID)
+	}
+
+	return (*users)[0].CanvasUserID, nil
+}
+
+/*
+getCourseOutcomeResultsForUser gets a course and a single user's outcome results in it,
+as map[outcomeID<uint64>][]canvasOutcomeResult, along with every outcome aligned in the course for them,
+including ones without results.
+
+If the calling user doesn't have the course, errCourseNotFound is returned. If they can't see canvasUserID's grades
+in it, errCantSeeUserGrades is returned.
+*/
+func getCourseOutcomeResultsForUser(
+	rd *requestDetails,
+	userID uint64,
+	courseID string,
+	canvasUserID uint64,
+) (*canvasCourse, map[uint64][]canvasOutcomeResult, []canvasOutcome, error) {
+	var (
+		course   *canvasCourse
+		results  map[uint64][]canvasOutcomeResult
+		outcomes []canvasOutcome
+	)
+
+	_, err := handleRequestWithTokenRefresh(func(reqD *requestDetails) error {
+		cs, csErr := getCanvasCourses(*reqD)
+		if csErr != nil {
+			return fmt.Errorf("error getting canvas courses: %w", csErr)
+		}
+
+		for _, c := range *cs {
+			if strconv.Itoa(int(c.ID)) == courseID {
+				cc := c
+				course = &cc
+				break
+			}
+		}
+
+		if course == nil {
+			return errCourseNotFound
+		}
+
+		if !canSeeUserGradesInCourse(*course, canvasUserID) {
+			return errCantSeeUserGrades
+		}
+
+		rs, rsErr := getCanvasOutcomeResults(*reqD, courseID, []string{strconv.Itoa(int(canvasUserID))})
+		if rsErr != nil {
+			return fmt.Errorf("error getting canvas outcome results for course %s: %w", courseID, rsErr)
+		}
+
+		processed, processErr := processOutcomeResults(&rs.OutcomeResults)
+		if processErr != nil {
+			return fmt.Errorf("error processing outcome results for course %s: %w", courseID, processErr)
+		}
+
+		results = (*processed)[canvasUserID]
+		outcomes = rs.Linked.Outcomes
+
+		// outcomes without results aren't linked to any, so they're found through alignments
+		as, asErr := getCanvasOutcomeAlignments(*reqD, courseID, strconv.Itoa(int(canvasUserID)))
+		if asErr != nil {
+			return fmt.Errorf("error getting canvas outcome alignments for course %s: %w", courseID, asErr)
+		}
+
+		linked := make(map[uint64]struct{}, len(outcomes))
+		for _, o := range outcomes {
+			linked[o.ID] = struct{}{}
+		}
+
+		for _, raw := range as {
+			var a canvasOutcomeAlignment
+			if jErr := json.Unmarshal(raw, &a); jErr != nil {
+				return fmt.Errorf("error unmarshaling canvas outcome alignment for course %s: %w", courseID, jErr)
+			}
+
+			oID := uint64(a.LearningOutcomeID)
+			if _, ok := linked[oID]; ok || oID < 1 {
+				continue
+			}
+
+			o, oErr := getCanvasOutcome(*reqD, strconv.Itoa(int(oID)))
+			if oErr != nil {
+				return fmt.Errorf("error getting aligned canvas outcome for course %s: %w", courseID, oErr)
+			}
+
+			outcomes = append(outcomes, canvasOutcome(*o))
+			linked[oID] = struct{}{}
+		}
+
+		return nil
+	}, rd, userID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if results == nil {
+		results = map[uint64][]canvasOutcomeResult{}
+	}
+
+	return course, results, outcomes, nil
+}
+
+// outcomesPointsPossible returns the top of each outcome's mastery scale, by outcome ID.
+// It's its points possible, or its highest rating if that isn't set.
+func outcomesPointsPossible(outcomes []canvasOutcome) map[uint64]float64 {
+	possible := make(map[uint64]float64, len(outcomes))
+	for _, o := range outcomes {
+		p := o.PointsPossible
+		if p <= 0 {
+			for _, r := range o.Ratings {
+				p = math.Max(p, r.Points)
+			}
+		}
+
+		possible[o.ID] = p
+	}
+
+	return possible
+}
+
+/*
+canSeeUserGradesInCourse is whether the calling user, whose enrollments are in c, can see canvasUserID's grades in
+it: their own as a student, their observees' as an observer, and anyone's as a teacher or TA.
+
+A student's token can't be trusted to stop them from asking Canvas for someone else's outcome results, so this
+is checked before asking.
+*/
+func canSeeUserGradesInCourse(c canvasCourse, canvasUserID uint64) bool {
+	for _, e := range c.Enrollments {
+		switch e.Type {
+		case canvasEnrollmentTypeTeacherEnrollment, canvasEnrollmentTypeTAEnrollment:
+			return true
+		case canvasEnrollmentTypeStudentEnrollment:
+			if e.UserID == canvasUserID {
+				return true
+			}
+		case canvasEnrollmentTypeObserverEnrollment:
+			if e.AssociatedUserID == canvasUserID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+/*
+applyGradeSimulationChanges returns a copy of results (map[outcomeID<uint64>][]canvasOutcomeResult)
+with changes applied. results is not modified.
+
+possible is the points possible of every outcome in the course, by outcome ID (see outcomesPointsPossible).
+Results can only be added to outcomes in it, even ones without results, and get their Possible from it.
+Scores can't be more than their result's Possible, unless it's 0.
+*/
+func applyGradeSimulationChanges(
+	results map[uint64][]canvasOutcomeResult,
+	possible map[uint64]float64,
+	changes []gradeSimulationChange,
+) (map[uint64][]canvasOutcomeResult, error) {
+	simulated := make(map[uint64][]canvasOutcomeResult, len(results))
+	for oID, rs := range results {
+		simulated[oID] = append([]canvasOutcomeResult{}, rs...)
+	}
+
+	// findResult returns the outcome ID and index of a result by its ID
+	findResult := func(resultID uint64) (uint64, int, bool) {
+		for oID, rs := range simulated {
+			for i, r := range rs {
+				if r.ID == resultID {
+					return oID, i, true
+				}
+			}
+		}
+
+		return 0, 0, false
+	}
+
+	for _, c := range changes {
+		switch c.Type {
+		case gradeSimulationChangeAdd:
+			p, ok := possible[c.OutcomeID]
+			if !ok {
+				return nil, fmt.Errorf("no outcome with id %d in the course", c.OutcomeID)
+			}
+
+			if p > 0 && c.Score > p {
+				return nil, fmt.Errorf("score %v is more than outcome %d's %v points possible", c.Score, c.OutcomeID, p)
+			}
+
+			r := canvasOutcomeResult{Score: c.Score, Possible: p}
+			r.Links.LearningOutcome = strconv.Itoa(int(c.OutcomeID))
+
+			simulated[c.OutcomeID] = append(simulated[c.OutcomeID], r)
+		case gradeSimulationChangeChange:
+			oID, i, ok := findResult(c.OutcomeResultID)
+			if !ok {
+				return nil, fmt.Errorf("no outcome result with id %d", c.OutcomeResultID)
+			}
+
+			if p := simulated[oID][i].Possible; p > 0 && c.Score > p {
+				return nil, fmt.Errorf("score %v is more than outcome result %d's %v points possible",
+					c.Score, c.OutcomeResultID, p)
+			}
+
+			simulated[oID][i].Score = c.Score
+		case gradeSimulationChangeRemove:
+			oID, i, ok := findResult(c.OutcomeResultID)
+			if !ok {
+				return nil, fmt.Errorf("no outcome result with id %d", c.OutcomeResultID)
+			}
+
+			simulated[oID] = append(simulated[oID][:i], simulated[oID][i+1:]...)
+			if len(simulated[oID]) < 1 {
+				delete(simulated, oID)
+			}
+		}
+	}
+
+	return simulated, nil
+}
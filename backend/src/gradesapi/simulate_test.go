@@ -0,0 +1,313 @@
+package gradesapi
+
+import (
+	"reflect"
+	"testing"
+)
+
+// resultOf makes an outcome result, for tests.
+func resultOf(id uint64, outcomeID string, score, possible float64) canvasOutcomeResult {
+	r := canvasOutcomeResult{ID: id, Score: score, Possible: possible}
+	r.Links.LearningOutcome = outcomeID
+	return r
+}
+
+func Test_applyGradeSimulationChanges(t *testing.T) {
+	// makeResults is called per test so that one test can't modify another's results
+	makeResults := func() map[uint64][]canvasOutcomeResult {
+		return map[uint64][]canvasOutcomeResult{
+			1: {resultOf(10, "1", 3, 4), resultOf(11, "1", 2, 4)},
+			2: {resultOf(20, "2", 4, 5)},
+		}
+	}
+	// outcome 3 is in the course, but doesn't have any results
+	possible := map[uint64]float64{1: 4, 2: 5, 3: 4}
+
+	tests := []struct {
+		name    string
+		changes []gradeSimulationChange
+		want    map[uint64][]canvasOutcomeResult
+		wantErr bool
+	}{
+		{
+			name:    "no_changes",
+			changes: nil,
+			want:    makeResults(),
+		},
+		{
+			name: "add",
+			changes: []gradeSimulationChange{
+				{Type: gradeSimulationChangeAdd, OutcomeID: 2, Score: 1},
+			},
+			want: map[uint64][]canvasOutcomeResult{
+				1: {resultOf(10, "1", 3, 4), resultOf(11, "1", 2, 4)},
+				// Possible is from the outcome's mastery scale
+				2: {resultOf(20, "2", 4, 5), resultOf(0, "2", 1, 5)},
+			},
+		},
+		{
+			name: "change",
+			changes: []gradeSimulationChange{
+				{Type: gradeSimulationChangeChange, OutcomeResultID: 11, Score: 4},
+			},
+			want: map[uint64][]canvasOutcomeResult{
+				1: {resultOf(10, "1", 3, 4), resultOf(11, "1", 4, 4)},
+				2: {resultOf(20, "2", 4, 5)},
+			},
+		},
+		{
+			name: "remove",
+			changes: []gradeSimulationChange{
+				{Type: gradeSimulationChangeRemove, OutcomeResultID: 10},
+			},
+			want: map[uint64][]canvasOutcomeResult{
+				1: {resultOf(11, "1", 2, 4)},
+				2: {resultOf(20, "2", 4, 5)},
+			},
+		},
+		{
+			name: "remove_last_result",
+			changes: []gradeSimulationChange{
+				{Type: gradeSimulationChangeRemove, OutcomeResultID: 20},
+			},
+			want: map[uint64][]canvasOutcomeResult{
+				1: {resultOf(10, "1", 3, 4), resultOf(11, "1", 2, 4)},
+			},
+		},
+		{
+			name: "remove_last_result_then_add",
+			changes: []gradeSimulationChange{
+				{Type: gradeSimulationChangeRemove, OutcomeResultID: 20},
+				{Type: gradeSimulationChangeAdd, OutcomeID: 2, Score: 3},
+			},
+			want: map[uint64][]canvasOutcomeResult{
+				1: {resultOf(10, "1", 3, 4), resultOf(11, "1", 2, 4)},
+				2: {resultOf(0, "2", 3, 5)},
+			},
+		},
+		{
+			name: "add_change_and_remove",
+			changes: []gradeSimulationChange{
+				{Type: gradeSimulationChangeAdd, OutcomeID: 1, Score: 4},
+				{Type: gradeSimulationChangeChange, OutcomeResultID: 10, Score: 1},
+				{Type: gradeSimulationChangeRemove, OutcomeResultID: 11},
+			},
+			want: map[uint64][]canvasOutcomeResult{
+				1: {resultOf(10, "1", 1, 4), resultOf(0, "1", 4, 4)},
+				2: {resultOf(20, "2", 4, 5)},
+			},
+		},
+		{
+			name: "add_outcome_without_results",
+			changes: []gradeSimulationChange{
+				{Type: gradeSimulationChangeAdd, OutcomeID: 3, Score: 2},
+			},
+			want: map[uint64][]canvasOutcomeResult{
+				1: {resultOf(10, "1", 3, 4), resultOf(11, "1", 2, 4)},
+				2: {resultOf(20, "2", 4, 5)},
+				3: {resultOf(0, "3", 2, 4)},
+			},
+		},
+		{
+			name: "add_score_equal_to_possible",
+			changes: []gradeSimulationChange{
+				{Type: gradeSimulationChangeAdd, OutcomeID: 1, Score: 4},
+			},
+			want: map[uint64][]canvasOutcomeResult{
+				1: {resultOf(10, "1", 3, 4), resultOf(11, "1", 2, 4), resultOf(0, "1", 4, 4)},
+				2: {resultOf(20, "2", 4, 5)},
+			},
+		},
+		{
+			name: "add_unknown_outcome",
+			changes: []gradeSimulationChange{
+				{Type: gradeSimulationChangeAdd, OutcomeID: 4, Score: 4},
+			},
+			wantErr: true,
+		},
+		{
+			name: "add_score_over_possible",
+			changes: []gradeSimulationChange{
+				{Type: gradeSimulationChangeAdd, OutcomeID: 1, Score: 5},
+			},
+			wantErr: true,
+		},
+		{
+			name: "change_score_over_possible",
+			changes: []gradeSimulationChange{
+				{Type: gradeSimulationChangeChange, OutcomeResultID: 20, Score: 5.5},
+			},
+			wantErr: true,
+		},
+		{
+			name: "change_unknown_result",
+			changes: []gradeSimulationChange{
+				{Type: gradeSimulationChangeChange, OutcomeResultID: 30, Score: 4},
+			},
+			wantErr: true,
+		},
+		{
+			name: "remove_unknown_result",
+			changes: []gradeSimulationChange{
+				{Type: gradeSimulationChangeRemove, OutcomeResultID: 30},
+			},
+			wantErr: true,
+		},
+		{
+			name: "change_removed_result",
+			changes: []gradeSimulationChange{
+				{Type: gradeSimulationChangeRemove, OutcomeResultID: 10},
+				{Type: gradeSimulationChangeChange, OutcomeResultID: 10, Score: 4},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := makeResults()
+
+			got, err := applyGradeSimulationChanges(results, possible, tt.changes)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyGradeSimulationChanges() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("applyGradeSimulationChanges() got = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(results, makeResults()) {
+				t.Errorf("applyGradeSimulationChanges() modified results: %v", results)
+			}
+		})
+	}
+}
+
+func Test_outcomesPointsPossible(t *testing.T) {
+	outcomeWith := func(id uint64, pointsPossible float64, ratings ...float64) canvasOutcome {
+		o := canvasOutcome{ID: id, PointsPossible: pointsPossible}
+		for _, r := range ratings {
+			o.Ratings = append(o.Ratings, struct {
+				Points float64 `json:"points"`
+			}{Points: r})
+		}
+		return o
+	}
+
+	tests := []struct {
+		name     string
+		outcomes []canvasOutcome
+		want     map[uint64]float64
+	}{
+		{
+			name:     "points_possible",
+			outcomes: []canvasOutcome{outcomeWith(1, 4, 4, 3, 2, 1)},
+			want:     map[uint64]float64{1: 4},
+		},
+		{
+			name:     "highest_rating_without_points_possible",
+			outcomes: []canvasOutcome{outcomeWith(1, 0, 3, 5, 1)},
+			want:     map[uint64]float64{1: 5},
+		},
+		{
+			name:     "no_scale",
+			outcomes: []canvasOutcome{outcomeWith(1, 0)},
+			want:     map[uint64]float64{1: 0},
+		},
+		{
+			name:     "several",
+			outcomes: []canvasOutcome{outcomeWith(1, 4), outcomeWith(2, 5)},
+			want:     map[uint64]float64{1: 4, 2: 5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := outcomesPointsPossible(tt.outcomes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("outcomesPointsPossible() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_canSeeUserGradesInCourse(t *testing.T) {
+	courseWith := func(es ...canvasEnrollment) canvasCourse {
+		return canvasCourse{ID: 1, Enrollments: es}
+	}
+
+	tests := []struct {
+		name         string
+		course       canvasCourse
+		canvasUserID uint64
+		want         bool
+	}{
+		{
+			name:         "student_self",
+			course:       courseWith(canvasEnrollment{Type: canvasEnrollmentTypeStudentEnrollment, UserID: 5}),
+			canvasUserID: 5,
+			want:         true,
+		},
+		{
+			name:         "student_other_student",
+			course:       courseWith(canvasEnrollment{Type: canvasEnrollmentTypeStudentEnrollment, UserID: 5}),
+			canvasUserID: 6,
+			want:         false,
+		},
+		{
+			name: "observer_observee",
+			course: courseWith(canvasEnrollment{
+				Type:             canvasEnrollmentTypeObserverEnrollment,
+				UserID:           5,
+				AssociatedUserID: 6,
+			}),
+			canvasUserID: 6,
+			want:         true,
+		},
+		{
+			name: "observer_other_student",
+			course: courseWith(canvasEnrollment{
+				Type:             canvasEnrollmentTypeObserverEnrollment,
+				UserID:           5,
+				AssociatedUserID: 6,
+			}),
+			canvasUserID: 7,
+			want:         false,
+		},
+		{
+			name: "observer_of_several",
+			course: courseWith(
+				canvasEnrollment{Type: canvasEnrollmentTypeObserverEnrollment, UserID: 5, AssociatedUserID: 6},
+				canvasEnrollment{Type: canvasEnrollmentTypeObserverEnrollment, UserID: 5, AssociatedUserID: 7},
+			),
+			canvasUserID: 7,
+			want:         true,
+		},
+		{
+			name:         "teacher",
+			course:       courseWith(canvasEnrollment{Type: canvasEnrollmentTypeTeacherEnrollment, UserID: 5}),
+			canvasUserID: 6,
+			want:         true,
+		},
+		{
+			name:         "ta",
+			course:       courseWith(canvasEnrollment{Type: canvasEnrollmentTypeTAEnrollment, UserID: 5}),
+			canvasUserID: 6,
+			want:         true,
+		},
+		{
+			name:         "designer",
+			course:       courseWith(canvasEnrollment{Type: canvasEnrollmentType("designer"), UserID: 5}),
+			canvasUserID: 6,
+			want:         false,
+		},
+		{
+			name:         "no_enrollments",
+			course:       courseWith(),
+			canvasUserID: 5,
+			want:         false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canSeeUserGradesInCourse(tt.course, tt.canvasUserID); got != tt.want {
+				t.Errorf("canSeeUserGradesInCourse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package gradesapi
+
+import (
+	"fmt"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/terms"
+	"github.com/iamtheyammer/canvascbl/backend/src/env"
+	"sync"
+	"time"
+)
+
+// currentTermValidFor is how long the current term is memoized for.
+const currentTermValidFor = time.Minute * 5
+
+// memoizedCurrentTerms is map[institutionID<uint64>]memoizedCurrentTerm
+var memoizedCurrentTerms = struct {
+	sync.Mutex
+	Terms map[uint64]memoizedCurrentTerm
+}{Terms: make(map[uint64]memoizedCurrentTerm)}
+
+type memoizedCurrentTerm struct {
+	EnrollmentTermID int
+	ValidUntil       time.Time
+}
+
+/*
+currentEnrollmentTermID returns the Canvas enrollment term ID of the term in session right now
+at an institution. Courses in earlier terms aren't fetched.
+
+It uses the institution's stored terms. If none are in session, the institution's current enrollment term ID
+is used, or env.CanvasCurrentEnrollmentTermID for the default Canvas instance (institution ID 0).
+*/
+func currentEnrollmentTermID(institutionID uint64) (int, error) {
+	memoizedCurrentTerms.Lock()
+	defer memoizedCurrentTerms.Unlock()
+
+	if m, ok := memoizedCurrentTerms.Terms[institutionID]; ok && m.ValidUntil.After(time.Now()) {
+		return m.EnrollmentTermID, nil
+	}
+
+	now := time.Now()
+	ts, err := terms.List(db, &terms.ListRequest{At: &now, InstitutionID: &institutionID})
+	if err != nil {
+		return 0, fmt.Errorf("error listing current terms: %w", err)
+	}
+
+	var termID int
+	if len(*ts) > 0 {
+		// if terms overlap, we want the earliest one so we don't skip any courses
+		termID = int((*ts)[0].CanvasEnrollmentTermID)
+	} else if institutionID > 0 {
+		inst, err := canvasInstanceForInstitution(institutionID)
+		if err != nil {
+			return 0, fmt.Errorf("error getting canvas instance for current term: %w", err)
+		}
+
+		termID = inst.CurrentEnrollmentTermID
+	} else {
+		termID = env.CanvasCurrentEnrollmentTermID
+	}
+
+	memoizedCurrentTerms.Terms[institutionID] = memoizedCurrentTerm{
+		EnrollmentTermID: termID,
+		ValidUntil:       now.Add(currentTermValidFor),
+	}
+
+	return termID, nil
+}
@@ -0,0 +1,128 @@
+package gradesapi
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// canvasTokenRefreshAhead is how long before a token expires that it's refreshed.
+	canvasTokenRefreshAhead = time.Minute * 5
+	// canvasTokenRefreshTimeout is the longest to wait for another request's refresh of the same token.
+	canvasTokenRefreshTimeout = time.Minute
+	// canvasTokenRefreshResultTTL is how long a finished refresh is given to requests still using the old token,
+	// instead of refreshing it again.
+	canvasTokenRefreshResultTTL = time.Minute
+)
+
+var canvasErrorTokenRefreshTimedOut = errors.New("timed out waiting for the Canvas token to be refreshed")
+
+// canvasTokenRefresher gets and stores a new access token for a refresh. Tests replace it.
+var canvasTokenRefresher = refreshCanvasToken
+
+// canvasTokenRefresh is a refresh of a single token. Its results are only valid once done is closed.
+type canvasTokenRefresh struct {
+	// from is the access token being refreshed
+	from string
+	done chan struct{}
+
+	token     string
+	expiresAt time.Time
+	err       error
+	// finishedAt is zero until the refresh is done. It's protected by canvasTokenRefreshes.
+	finishedAt time.Time
+}
+
+var canvasTokenRefreshes = struct {
+	sync.Mutex
+	// map[tokenID]*canvasTokenRefresh
+	Refreshes map[uint64]*canvasTokenRefresh
+}{
+	Refreshes: map[uint64]*canvasTokenRefresh{},
+}
+
+/*
+refreshAccessToken uses the refresh token to get a new access token, and puts it in rd.
+
+Only one refresh per token runs at once. If the token is already being refreshed, this waits for that
+refresh (up to canvasTokenRefreshTimeout) and gets its result, including its error. A refresh that just
+finished is reused by requests that still have the old token.
+
+Waiting stops when rd's context is done, but a refresh that started keeps going, as others may be waiting for it
+and Canvas may have already replaced the refresh token.
+*/
+func (rd *requestDetails) refreshAccessToken() error {
+	canvasTokenRefreshes.Lock()
+	r, ok := canvasTokenRefreshes.Refreshes[rd.TokenID]
+	isLeader := !ok || (!r.finishedAt.IsZero() &&
+		(r.from != rd.Token || time.Since(r.finishedAt) > canvasTokenRefreshResultTTL))
+	if isLeader {
+		r = &canvasTokenRefresh{
+			from: rd.Token,
+			done: make(chan struct{}),
+		}
+		canvasTokenRefreshes.Refreshes[rd.TokenID] = r
+	}
+	canvasTokenRefreshes.Unlock()
+
+	if isLeader {
+		r.run(*rd)
+	} else {
+		select {
+		case <-r.done:
+		case <-time.After(canvasTokenRefreshTimeout):
+			return fmt.Errorf("error waiting for token id %d to be refreshed: %w", rd.TokenID, canvasErrorTokenRefreshTimedOut)
+		case <-rd.ctx().Done():
+			return fmt.Errorf("error waiting for token id %d to be refreshed: %w", rd.TokenID, rd.ctx().Err())
+		}
+	}
+
+	if r.err != nil {
+		return r.err
+	}
+
+	rd.Token = r.token
+	rd.ExpiresAt = r.expiresAt
+	return nil
+}
+
+// run refreshes the token and wakes everyone waiting on r.
+func (r *canvasTokenRefresh) run(rd requestDetails) {
+	defer close(r.done)
+
+	// the refresh is shared, so it shouldn't be canceled with the request that happened to start it
+	rd.Ctx = nil
+	r.token, r.expiresAt, r.err = canvasTokenRefresher(rd)
+
+	canvasTokenRefreshes.Lock()
+	defer canvasTokenRefreshes.Unlock()
+
+	now := time.Now()
+	r.finishedAt = now
+
+	for id, fr := range canvasTokenRefreshes.Refreshes {
+		if !fr.finishedAt.IsZero() && now.Sub(fr.finishedAt) > canvasTokenRefreshResultTTL {
+			delete(canvasTokenRefreshes.Refreshes, id)
+		}
+	}
+}
+
+// expiresSoon is whether rd's token expires within canvasTokenRefreshAhead. It's false if the expiry is unknown
+// or there's no refresh token.
+func (rd requestDetails) expiresSoon() bool {
+	if rd.ExpiresAt.IsZero() || len(rd.RefreshToken) < 1 {
+		return false
+	}
+
+	return time.Until(rd.ExpiresAt) < canvasTokenRefreshAhead
+}
+
+// refreshIfExpiresSoon refreshes rd's token ahead of time if it expires soon. Every path that makes Canvas requests
+// with a token calls it first. If the refresh fails, the token might still work, and the next request finds out.
+func (rd *requestDetails) refreshIfExpiresSoon() {
+	if rd.expiresSoon() {
+		_ = rd.refreshAccessToken()
+	}
+}
@@ -0,0 +1,128 @@
+package gradesapi
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_refreshAccessToken(t *testing.T) {
+	errRefresh := errors.New("refresh token revoked")
+	expiresAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		// tokenID must be different for each test, so they don't share finished refreshes
+		tokenID uint64
+		callers int
+		// tokens are the tokens callers have, one per batch of callers; each batch waits for the one before it
+		tokens        []string
+		refreshErr    error
+		wantRefreshes int
+	}{
+		{
+			name:          "one_caller",
+			tokenID:       1,
+			callers:       1,
+			tokens:        []string{"old"},
+			wantRefreshes: 1,
+		},
+		{
+			name:          "concurrent_callers_share_one_refresh",
+			tokenID:       2,
+			callers:       10,
+			tokens:        []string{"old"},
+			wantRefreshes: 1,
+		},
+		{
+			name:          "late_callers_with_the_old_token_get_the_finished_refresh",
+			tokenID:       3,
+			callers:       5,
+			tokens:        []string{"old", "old"},
+			wantRefreshes: 1,
+		},
+		{
+			name:          "callers_with_a_newer_token_refresh_again",
+			tokenID:       4,
+			callers:       5,
+			tokens:        []string{"old", "newer"},
+			wantRefreshes: 2,
+		},
+		{
+			name:          "error_reaches_every_waiter",
+			tokenID:       5,
+			callers:       10,
+			tokens:        []string{"old"},
+			refreshErr:    errRefresh,
+			wantRefreshes: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mutex     sync.Mutex
+				refreshes int
+				release   = make(chan struct{})
+			)
+
+			refresher := canvasTokenRefresher
+			canvasTokenRefresher = func(rd requestDetails) (string, time.Time, error) {
+				mutex.Lock()
+				refreshes++
+				mutex.Unlock()
+
+				// hold the refresh so the other callers have to wait for it
+				<-release
+				return rd.Token + "-refreshed", expiresAt, tt.refreshErr
+			}
+			defer func() {
+				canvasTokenRefresher = refresher
+
+				canvasTokenRefreshes.Lock()
+				delete(canvasTokenRefreshes.Refreshes, tt.tokenID)
+				canvasTokenRefreshes.Unlock()
+			}()
+
+			// the first batch's refresh is released once its callers have had a chance to start waiting
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				close(release)
+			}()
+
+			for _, token := range tt.tokens {
+				var wg sync.WaitGroup
+				rds := make([]requestDetails, tt.callers)
+				errs := make([]error, tt.callers)
+				for i := range rds {
+					rds[i] = requestDetails{TokenID: tt.tokenID, Token: token, RefreshToken: "refresh"}
+
+					wg.Add(1)
+					go func(i int) {
+						defer wg.Done()
+						errs[i] = rds[i].refreshAccessToken()
+					}(i)
+				}
+				wg.Wait()
+
+				for i := range rds {
+					if !errors.Is(errs[i], tt.refreshErr) {
+						t.Errorf("refreshAccessToken() error = %v, want %v", errs[i], tt.refreshErr)
+					}
+
+					wantToken := token + "-refreshed"
+					if tt.refreshErr != nil {
+						wantToken = token
+					}
+					if rds[i].Token != wantToken {
+						t.Errorf("refreshAccessToken() token = %s, want %s", rds[i].Token, wantToken)
+					}
+				}
+			}
+
+			if refreshes != tt.wantRefreshes {
+				t.Errorf("refreshAccessToken() refreshed %d times, want %d", refreshes, tt.wantRefreshes)
+			}
+		})
+	}
+}
@@ -0,0 +1,343 @@
+package gradesapi
+
+import (
+	"fmt"
+	coursessvc "github.com/iamtheyammer/canvascbl/backend/src/db/services/courses"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/final_grades"
+	gradessvc "github.com/iamtheyammer/canvascbl/backend/src/db/services/grades"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/grading_policies"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/terms"
+	userssvc "github.com/iamtheyammer/canvascbl/backend/src/db/services/users"
+	"github.com/iamtheyammer/canvascbl/backend/src/oauth2"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const gradesErrorNotObservingUser = "you can only see transcripts for yourself and users you observe"
+
+type transcriptCourse struct {
+	CourseID         uint64  `json:"course_id"`
+	Name             string  `json:"name"`
+	CourseCode       string  `json:"course_code"`
+	Grade            string  `json:"grade"`
+	GPAValue         float64 `json:"gpa_value"`
+	SubgradeGPAValue float64 `json:"subgrade_gpa_value"`
+	Weight           float64 `json:"weight"`
+}
+
+type transcriptTerm struct {
+	ID                     uint64    `json:"id"`
+	Name                   string    `json:"name"`
+	CanvasEnrollmentTermID uint64    `json:"canvas_enrollment_term_id"`
+	StartAt                time.Time `json:"start_at"`
+	EndAt                  time.Time `json:"end_at"`
+	// Frozen is whether grades are final. If not, they're the most recent grades.
+	Frozen  bool               `json:"frozen"`
+	Courses []transcriptCourse `json:"courses"`
+	GPA     calculatedGPA      `json:"gpa"`
+}
+
+type transcriptResponse struct {
+	UserID        uint64           `json:"user_id"`
+	Terms         []transcriptTerm `json:"terms"`
+	CumulativeGPA calculatedGPA    `json:"cumulative_gpa"`
+}
+
+// TranscriptHandler handles /api/v1/transcript
+func TranscriptHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var requestedUserID uint64
+	if uID := r.URL.Query().Get("user_id"); len(uID) > 0 {
+		id, err := strconv.Atoi(uID)
+		if err != nil || id < 1 {
+			util.SendBadRequest(w, "invalid user_id as query param")
+			return
+		}
+
+		requestedUserID = uint64(id)
+	}
+
+	userID, rdP, sess, errCtx := authorizer(w, r, []oauth2.Scope{oauth2.ScopeTranscript}, &oauth2.AuthorizerAPICall{
+		Method:    "GET",
+		RoutePath: "transcript",
+		Query:     &r.URL.RawQuery,
+	})
+	if (userID == nil || rdP == nil || errCtx == nil) && sess == nil {
+		return
+	}
+
+	errCtx.AddCustomField("user_id", requestedUserID)
+
+	selfCanvasUserID, err := canvasUserIDForGradeRequest(*userID, 0)
+	if err != nil {
+		handleISE(w, errCtx.Apply(fmt.Errorf("error getting canvas user id for transcript: %w", err)))
+		return
+	}
+
+	canvasUserID := selfCanvasUserID
+	if requestedUserID > 0 && requestedUserID != selfCanvasUserID {
+		isObserving, err := isObservingUser(rdP.InstitutionID, selfCanvasUserID, requestedUserID)
+		if err != nil {
+			handleISE(w, errCtx.Apply(fmt.Errorf("error checking observees for transcript: %w", err)))
+			return
+		}
+
+		if !isObserving {
+			handleError(w, GradesErrorResponse{
+				Error: gradesErrorNotObservingUser,
+			}, http.StatusForbidden)
+			return
+		}
+
+		canvasUserID = requestedUserID
+	}
+
+	t, err := getTranscript(rdP.InstitutionID, canvasUserID)
+	if err != nil {
+		handleISE(w, errCtx.Apply(fmt.Errorf("error getting transcript: %w", err)))
+		return
+	}
+
+	sendJSON(w, t)
+	return
+}
+
+// isObservingUser returns whether observerCanvasUserID actively observes canvasUserID at an institution.
+func isObservingUser(institutionID, observerCanvasUserID, canvasUserID uint64) (bool, error) {
+	obs, err := userssvc.ListObservees(db, &userssvc.ListObserveesRequest{
+		ObserverCanvasUserID: observerCanvasUserID,
+		InstitutionID:        institutionID,
+		ActiveOnly:           true,
+	})
+	if err != nil {
+		return false, fmt.Errorf("error listing observees: %w", err)
+	}
+
+	for _, o := range *obs {
+		if o.CanvasUserID == canvasUserID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+/*
+getTranscript builds a transcript from the user's frozen final grades at their institution. Terms without
+final grades for the user use their most recent stored grades instead.
+
+Courses that aren't in a stored term are left out.
+*/
+func getTranscript(institutionID uint64, canvasUserID uint64) (*transcriptResponse, error) {
+	finalGrades, err := final_grades.List(db, &final_grades.ListRequest{
+		CanvasUserIDs: []uint64{canvasUserID},
+		InstitutionID: institutionID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing final grades: %w", err)
+	}
+
+	latestGrades, err := gradessvc.List(db, &gradessvc.ListRequest{
+		UserCanvasIDs: &[]uint64{canvasUserID},
+		InstitutionID: &institutionID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing latest grades: %w", err)
+	}
+
+	// get every course the user has a grade in
+	var courseIDs []uint64
+	for _, fg := range *finalGrades {
+		courseIDs = append(courseIDs, fg.CourseID)
+	}
+
+	for _, g := range *latestGrades {
+		courseIDs = append(courseIDs, g.CourseID)
+	}
+
+	resp := transcriptResponse{
+		UserID: canvasUserID,
+		Terms:  []transcriptTerm{},
+	}
+
+	if len(courseIDs) < 1 {
+		return &resp, nil
+	}
+
+	cs, err := coursessvc.List(db, &coursessvc.ListRequest{
+		CourseIDs:     courseIDs,
+		InstitutionID: institutionID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing transcript courses: %w", err)
+	}
+
+	coursesByID := make(map[uint64]coursessvc.Course, len(*cs))
+	var canvasTermIDs []uint64
+	for _, c := range *cs {
+		coursesByID[c.CourseID] = c
+		if c.EnrollmentTermID > 0 {
+			canvasTermIDs = append(canvasTermIDs, c.EnrollmentTermID)
+		}
+	}
+
+	var termIDs []uint64
+	for _, fg := range *finalGrades {
+		termIDs = append(termIDs, fg.TermID)
+	}
+
+	var ts []terms.Term
+	if len(termIDs) > 0 {
+		byID, err := terms.List(db, &terms.ListRequest{IDs: termIDs})
+		if err != nil {
+			return nil, fmt.Errorf("error listing transcript terms by id: %w", err)
+		}
+
+		ts = append(ts, *byID...)
+	}
+
+	if len(canvasTermIDs) > 0 {
+		byCanvasID, err := terms.List(db, &terms.ListRequest{
+			CanvasEnrollmentTermIDs: canvasTermIDs,
+			InstitutionID:           &institutionID,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error listing transcript terms by canvas enrollment term id: %w", err)
+		}
+
+		ts = append(ts, *byCanvasID...)
+	}
+
+	policies, err := getGradingPolicySet()
+	if err != nil {
+		return nil, fmt.Errorf("error getting grading policies for transcript: %w", err)
+	}
+
+	weights, err := getCourseWeightSet()
+	if err != nil {
+		return nil, fmt.Errorf("error getting course weights for transcript: %w", err)
+	}
+
+	// map[termID]final grades
+	finalByTerm := make(map[uint64][]final_grades.FinalGrade)
+	for _, fg := range *finalGrades {
+		finalByTerm[fg.TermID] = append(finalByTerm[fg.TermID], fg)
+	}
+
+	// map[canvasEnrollmentTermID]latest grades
+	latestByCanvasTerm := make(map[uint64][]gradessvc.Grade)
+	for _, g := range *latestGrades {
+		c, ok := coursesByID[g.CourseID]
+		if !ok {
+			continue
+		}
+
+		latestByCanvasTerm[c.EnrollmentTermID] = append(latestByCanvasTerm[c.EnrollmentTermID], g)
+	}
+
+	var allCourses []transcriptCourse
+	seenTerms := make(map[uint64]struct{}, len(ts))
+	for _, t := range ts {
+		if _, ok := seenTerms[t.ID]; ok {
+			continue
+		}
+		seenTerms[t.ID] = struct{}{}
+
+		tt := transcriptTerm{
+			ID:                     t.ID,
+			Name:                   t.Name,
+			CanvasEnrollmentTermID: t.CanvasEnrollmentTermID,
+			StartAt:                t.StartAt,
+			EndAt:                  t.EndAt,
+			Courses:                []transcriptCourse{},
+		}
+
+		if fgs, ok := finalByTerm[t.ID]; ok {
+			tt.Frozen = true
+			for _, fg := range fgs {
+				c := coursesByID[fg.CourseID]
+				tt.Courses = append(tt.Courses, transcriptCourse{
+					CourseID:         fg.CourseID,
+					Name:             c.Name,
+					CourseCode:       c.CourseCode,
+					Grade:            fg.Grade,
+					GPAValue:         fg.GPAValue,
+					SubgradeGPAValue: fg.SubgradeGPAValue,
+					Weight:           fg.Weight,
+				})
+			}
+		} else {
+			for _, g := range latestByCanvasTerm[t.CanvasEnrollmentTermID] {
+				c := coursesByID[g.CourseID]
+
+				policy := policies.ForCourse(institutionID, c.CourseID, c.EnrollmentTermID, c.RootAccountID)
+				if policy == nil {
+					policy = &grading_policies.Default
+				}
+
+				tc := transcriptCourse{
+					CourseID:   g.CourseID,
+					Name:       c.Name,
+					CourseCode: c.CourseCode,
+					Grade:      g.Grade,
+					Weight:     weights.WeightForCourse(institutionID, c.CourseID, c.CourseCode),
+				}
+
+				if b, ok := policy.Band(g.Grade); ok {
+					tc.GPAValue = b.GPAVal
+					tc.SubgradeGPAValue = b.SubgradeGPAVal
+				}
+
+				tt.Courses = append(tt.Courses, tc)
+			}
+		}
+
+		if len(tt.Courses) < 1 {
+			continue
+		}
+
+		tt.GPA = calculateTranscriptGPA(tt.Courses)
+		allCourses = append(allCourses, tt.Courses...)
+		resp.Terms = append(resp.Terms, tt)
+	}
+
+	sort.Slice(resp.Terms, func(i, j int) bool {
+		return resp.Terms[i].StartAt.Before(resp.Terms[j].StartAt)
+	})
+
+	resp.CumulativeGPA = calculateTranscriptGPA(allCourses)
+
+	return &resp, nil
+}
+
+// calculateTranscriptGPA averages the GPA values of courses, adding each course's weight
+// to the weighted GPA when the grade is worth any GPA points.
+func calculateTranscriptGPA(cs []transcriptCourse) calculatedGPA {
+	var g calculatedGPA
+	if len(cs) < 1 {
+		return g
+	}
+
+	for _, c := range cs {
+		g.Unweighted.Default += c.GPAValue
+		g.Unweighted.Subgrades += c.SubgradeGPAValue
+		g.Weighted.Default += c.GPAValue
+		g.Weighted.Subgrades += c.SubgradeGPAValue
+
+		if c.GPAValue > 0 {
+			g.Weighted.Default += c.Weight
+			g.Weighted.Subgrades += c.Weight
+		}
+	}
+
+	n := float64(len(cs))
+	g.Unweighted.Default /= n
+	g.Unweighted.Subgrades /= n
+	g.Weighted.Default /= n
+	g.Weighted.Subgrades /= n
+
+	return g
+}
@@ -9,6 +9,7 @@ type canvasEnrollmentType string
 
 const (
 	canvasEnrollmentTypeTeacherEnrollment  = canvasEnrollmentType("teacher")
+	canvasEnrollmentTypeTAEnrollment       = canvasEnrollmentType("ta")
 	canvasEnrollmentTypeStudentEnrollment  = canvasEnrollmentType("student")
 	canvasEnrollmentTypeObserverEnrollment = canvasEnrollmentType("observer")
 )
@@ -277,41 +278,26 @@ type canvasAssignment struct {
 		CanView     bool   `json:"can_view"`
 		LockAt      string `json:"lock_at"`
 	} `json:"lock_info"`
-	LockedForUser          bool        `json:"locked_for_user"`
-	MaxNameLength          int64       `json:"max_name_length"`
-	ModeratedGrading       bool        `json:"moderated_grading"`
-	Muted                  bool        `json:"muted"`
-	Name                   string      `json:"name"`
-	OmitFromFinalGrade     bool        `json:"omit_from_final_grade"`
-	OnlyVisibleToOverrides bool        `json:"only_visible_to_overrides"`
-	OriginalAssignmentID   interface{} `json:"original_assignment_id"`
-	OriginalAssignmentName interface{} `json:"original_assignment_name"`
-	OriginalCourseID       interface{} `json:"original_course_id"`
-	OriginalQuizID         interface{} `json:"original_quiz_id"`
-	PeerReviews            bool        `json:"peer_reviews"`
-	PointsPossible         float64     `json:"points_possible"`
-	Position               int64       `json:"position"`
-	PostManually           bool        `json:"post_manually"`
-	PostToSis              bool        `json:"post_to_sis"`
-	Published              bool        `json:"published"`
-	QuizID                 int64       `json:"quiz_id"`
-	Rubric                 []struct {
-		CriterionUseRange bool `json:"criterion_use_range"`
-		//Description       string  `json:"description"`
-		ID               string `json:"id"`
-		IgnoreForScoring bool   `json:"ignore_for_scoring"`
-		//LongDescription   string  `json:"long_description"`
-		OutcomeID int64   `json:"outcome_id"`
-		Points    float64 `json:"points"`
-		Ratings   []struct {
-			//Description     string  `json:"description"`
-			ID string `json:"id"`
-			//LongDescription string  `json:"long_description"`
-			Points float64 `json:"points"`
-		} `json:"ratings"`
-		VendorGUID interface{} `json:"vendor_guid"`
-	} `json:"rubric"`
-	RubricSettings struct {
+	LockedForUser          bool                    `json:"locked_for_user"`
+	MaxNameLength          int64                   `json:"max_name_length"`
+	ModeratedGrading       bool                    `json:"moderated_grading"`
+	Muted                  bool                    `json:"muted"`
+	Name                   string                  `json:"name"`
+	OmitFromFinalGrade     bool                    `json:"omit_from_final_grade"`
+	OnlyVisibleToOverrides bool                    `json:"only_visible_to_overrides"`
+	OriginalAssignmentID   interface{}             `json:"original_assignment_id"`
+	OriginalAssignmentName interface{}             `json:"original_assignment_name"`
+	OriginalCourseID       interface{}             `json:"original_course_id"`
+	OriginalQuizID         interface{}             `json:"original_quiz_id"`
+	PeerReviews            bool                    `json:"peer_reviews"`
+	PointsPossible         float64                 `json:"points_possible"`
+	Position               int64                   `json:"position"`
+	PostManually           bool                    `json:"post_manually"`
+	PostToSis              bool                    `json:"post_to_sis"`
+	Published              bool                    `json:"published"`
+	QuizID                 int64                   `json:"quiz_id"`
+	Rubric                 []canvasRubricCriterion `json:"rubric"`
+	RubricSettings         struct {
 		FreeFormCriterionComments bool    `json:"free_form_criterion_comments"`
 		HidePoints                bool    `json:"hide_points"`
 		HideScoreTotal            bool    `json:"hide_score_total"`
@@ -328,9 +314,33 @@ type canvasAssignment struct {
 	WorkflowState          string   `json:"workflow_state"`
 }
 
+// canvasRubricCriterion is a single criterion of an assignment's rubric.
+type canvasRubricCriterion struct {
+	CriterionUseRange bool `json:"criterion_use_range"`
+	//Description       string  `json:"description"`
+	ID               string `json:"id"`
+	IgnoreForScoring bool   `json:"ignore_for_scoring"`
+	//LongDescription   string  `json:"long_description"`
+	OutcomeID  int64                `json:"outcome_id"`
+	Points     float64              `json:"points"`
+	Ratings    []canvasRubricRating `json:"ratings"`
+	VendorGUID interface{}          `json:"vendor_guid"`
+}
+
+type canvasRubricRating struct {
+	//Description     string  `json:"description"`
+	ID string `json:"id"`
+	//LongDescription string  `json:"long_description"`
+	Points float64 `json:"points"`
+}
+
 // /api/v1/courses/:courseID/outcome_results
 type canvasOutcomeResultsResponse struct {
 	OutcomeResults []canvasOutcomeResult `json:"outcome_results"`
+	// Linked is only included with include[]=outcomes
+	Linked struct {
+		Outcomes []canvasOutcome `json:"outcomes"`
+	} `json:"linked"`
 }
 
 type canvasOutcomeResult struct {
@@ -377,6 +387,22 @@ type canvasOutcome struct {
 	VendorGUID interface{} `json:"vendor_guid"`
 }
 
+// /api/v1/accounts/:accountID/outcome_proficiency
+type canvasOutcomeProficiencyResponse canvasOutcomeProficiency
+
+// canvasOutcomeProficiency is an account's proficiency ratings, which label outcome scores.
+// https://canvas.instructure.com/doc/api/outcome_results.html#OutcomeProficiency
+type canvasOutcomeProficiency struct {
+	Ratings []canvasProficiencyRating `json:"ratings"`
+}
+
+type canvasProficiencyRating struct {
+	Description string  `json:"description"`
+	Points      float64 `json:"points"`
+	Mastery     bool    `json:"mastery"`
+	Color       string  `json:"color"`
+}
+
 type canvasOutcomeAlignmentsResponse []canvasOutcomeAlignment
 
 type canvasOutcomeAlignment struct {
@@ -445,6 +471,15 @@ type canvasSubmission struct {
 	URL                           string    `json:"url"`
 	UserID                        uint64    `json:"user_id"`
 	WorkflowState                 string    `json:"workflow_state"`
+	// RubricAssessment is only included with include[]=rubric_assessment. It's keyed by rubric criterion ID.
+	RubricAssessment map[string]canvasRubricAssessmentRating `json:"rubric_assessment"`
+}
+
+// canvasRubricAssessmentRating is how a submission was rated on a single rubric criterion.
+type canvasRubricAssessmentRating struct {
+	RatingID string  `json:"rating_id"`
+	Points   float64 `json:"points"`
+	Comments string  `json:"comments"`
 }
 
 // POST /login/oauth2/token with ?grant_type=code
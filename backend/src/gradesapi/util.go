@@ -12,32 +12,31 @@ import (
 	"time"
 )
 
-// refreshAccessToken attempts to use the refresh token to get a new access token.
-// You should check the returned error and handle it accordingly.
-func (rd *requestDetails) refreshAccessToken() error {
-	newToken, err := getTokenFromRefreshToken(*rd)
+// refreshCanvasToken uses rd's refresh token to get a new access token and stores it.
+// Use rd.refreshAccessToken instead, so concurrent refreshes of the same token are combined.
+func refreshCanvasToken(rd requestDetails) (string, time.Time, error) {
+	newToken, err := getTokenFromRefreshToken(rd)
 	if err != nil {
 		if errors.Is(err, canvasErrorInvalidAccessTokenError) {
 			// there is something wrong with the token
 			deleteErr := canvas_tokens.Delete(db, &canvas_tokens.DeleteRequest{RefreshToken: rd.RefreshToken})
 			if deleteErr != nil {
-				return fmt.Errorf("error deleting a canvas token: %w", deleteErr)
+				return "", time.Time{}, fmt.Errorf("error deleting a canvas token: %w", deleteErr)
 			}
 
-			return fmt.Errorf("error getting a new access token from a refresh token: %w", err)
+			return "", time.Time{}, fmt.Errorf("error getting a new access token from a refresh token: %w", err)
 		}
 
-		return fmt.Errorf("error getting a newProfile: %w", err)
+		return "", time.Time{}, fmt.Errorf("error getting a newProfile: %w", err)
 	}
 
 	newTokenExp := time.Now().UTC().Add(time.Duration(newToken.ExpiresIn) * time.Second)
 	err = canvas_tokens.UpdateFromRefreshToken(db, rd.TokenID, newToken.AccessToken, &newTokenExp)
 	if err != nil {
-		return fmt.Errorf("error updating a canvas token: %w", err)
+		return "", time.Time{}, fmt.Errorf("error updating a canvas token: %w", err)
 	}
 
-	rd.Token = newToken.AccessToken
-	return nil
+	return newToken.AccessToken, newTokenExp, nil
 }
 
 // hasScopeVersion ensures that the token has at least v.
@@ -132,11 +131,14 @@ func processOutcomeResults(results *[]canvasOutcomeResult) (
 	return &rs, nil
 }
 
-func rdFromCanvasUserID(cuID uint64) (requestDetails, error) {
+// rdFromCanvasUserID gets a requestDetails object from the newest token for a Canvas user at an institution
+// (0 for the default Canvas instance).
+func rdFromCanvasUserID(institutionID uint64, cuID uint64) (requestDetails, error) {
 	tokens, err := canvas_tokens.List(db, &canvas_tokens.ListRequest{
-		CanvasUserID: cuID,
-		OrderBys:     []string{"inserted_at DESC"},
-		Limit:        1,
+		CanvasUserID:  cuID,
+		InstitutionID: &institutionID,
+		OrderBys:      []string{"inserted_at DESC"},
+		Limit:         1,
 	})
 	if err != nil {
 		return requestDetails{}, fmt.Errorf("error listing canvas tokens: %w", err)
@@ -149,9 +151,12 @@ func rdFromCanvasUserID(cuID uint64) (requestDetails, error) {
 	token := (*tokens)[0]
 
 	return requestDetails{
-		TokenID:      token.ID,
-		Token:        token.Token,
-		RefreshToken: token.RefreshToken,
+		TokenID:       token.ID,
+		Token:         token.Token,
+		RefreshToken:  token.RefreshToken,
+		ScopeVersion:  token.ScopeVersion,
+		ExpiresAt:     token.ExpiresAt,
+		InstitutionID: token.InstitutionID,
 	}, nil
 }
 
@@ -172,20 +177,24 @@ func rdFromUserID(uID uint64) (requestDetails, error) {
 	token := (*tokens)[0]
 
 	return requestDetails{
-		TokenID:      token.ID,
-		Token:        token.Token,
-		RefreshToken: token.RefreshToken,
-		ScopeVersion: token.ScopeVersion,
+		TokenID:       token.ID,
+		Token:         token.Token,
+		RefreshToken:  token.RefreshToken,
+		ScopeVersion:  token.ScopeVersion,
+		ExpiresAt:     token.ExpiresAt,
+		InstitutionID: token.InstitutionID,
 	}, nil
 }
 
 // rdFromToken gets a requestDetails object from a db canvas token
 func rdFromToken(tok canvas_tokens.CanvasToken) requestDetails {
 	return requestDetails{
-		TokenID:      tok.ID,
-		Token:        tok.Token,
-		RefreshToken: tok.RefreshToken,
-		ScopeVersion: tok.ScopeVersion,
+		TokenID:       tok.ID,
+		Token:         tok.Token,
+		RefreshToken:  tok.RefreshToken,
+		ScopeVersion:  tok.ScopeVersion,
+		ExpiresAt:     tok.ExpiresAt,
+		InstitutionID: tok.InstitutionID,
 	}
 }
 
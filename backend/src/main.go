@@ -4,11 +4,13 @@ import (
 	"fmt"
 	"github.com/getsentry/sentry-go"
 	"github.com/iamtheyammer/canvascbl/backend/src/admin"
+	"github.com/iamtheyammer/canvascbl/backend/src/canvasfake"
 	"github.com/iamtheyammer/canvascbl/backend/src/checkout"
 	"github.com/iamtheyammer/canvascbl/backend/src/env"
 	"github.com/iamtheyammer/canvascbl/backend/src/gradesapi"
 	"github.com/iamtheyammer/canvascbl/backend/src/oauth2"
 	"github.com/iamtheyammer/canvascbl/backend/src/plus"
+	"github.com/iamtheyammer/canvascbl/backend/src/scheduler"
 	"github.com/iamtheyammer/canvascbl/backend/src/util"
 	"github.com/julienschmidt/httprouter"
 	"github.com/pkg/errors"
@@ -98,6 +100,32 @@ func getRouter() *httprouter.Router {
 
 	router.POST("/api/admin/gift_cards", admin.GenerateGiftCardsHandler)
 
+	router.GET("/api/admin/grading_policies", admin.ListGradingPoliciesHandler)
+	router.POST("/api/admin/grading_policies", admin.CreateGradingPolicyHandler)
+	router.PUT("/api/admin/grading_policies/:policyID/assignments", admin.AssignGradingPolicyHandler)
+	router.DELETE("/api/admin/grading_policy_assignments/:assignmentID", admin.UnassignGradingPolicyHandler)
+
+	router.GET("/api/admin/course_weights", admin.ListCourseWeightsHandler)
+	router.POST("/api/admin/course_weights", admin.CreateCourseWeightHandler)
+	router.DELETE("/api/admin/course_weights/:courseWeightID", admin.DeleteCourseWeightHandler)
+
+	router.GET("/api/admin/terms", admin.ListTermsHandler)
+	router.PUT("/api/admin/terms", admin.UpsertTermHandler)
+	router.POST("/api/admin/terms/:termID/freeze", admin.FreezeTermHandler)
+
+	router.GET("/api/admin/drop_cutoffs", admin.ListDropCutoffsHandler)
+	router.POST("/api/admin/drop_cutoffs", admin.CreateDropCutoffHandler)
+	router.DELETE("/api/admin/drop_cutoffs/:dropCutoffID", admin.DeleteDropCutoffHandler)
+
+	router.GET("/api/admin/institutions", admin.ListInstitutionsHandler)
+	router.PUT("/api/admin/institutions", admin.UpsertInstitutionHandler)
+
+	router.GET("/api/admin/fetch_runs", admin.ListFetchRunsHandler)
+	router.GET("/api/admin/fetch_runs/:runID/users", admin.ListFetchRunUsersHandler)
+	router.POST("/api/admin/fetch_runs/:runID/rerun", admin.RerunFetchRunHandler)
+	router.GET("/api/admin/fetch_run_users", admin.ListFetchRunUsersHandler)
+	router.GET("/api/admin/scheduled_jobs", admin.ListScheduledJobsHandler)
+
 	/*
 		Public API
 	*/
@@ -105,6 +133,10 @@ func getRouter() *httprouter.Router {
 	// grades
 	router.GET("/api/v1/grades", gradesapi.GradesHandler)
 	router.GET("/api/v1/grades/fetch_all", gradesapi.GradesForAllHandler)
+	router.GET("/api/v1/grades/history", gradesapi.GradeHistoryHandler)
+
+	// transcript
+	router.GET("/api/v1/transcript", gradesapi.TranscriptHandler)
 
 	// courses
 	router.GET("/api/v1/courses", gradesapi.ListCoursesHandler)
@@ -112,12 +144,15 @@ func getRouter() *httprouter.Router {
 	router.GET("/api/v1/courses/:courseID/outcome_alignments", gradesapi.AlignmentsHandler)
 	router.GET("/api/v1/courses/:courseID/enrollments", gradesapi.CourseEnrollmentsHandler)
 	router.GET("/api/v1/courses/:courseID/submission_summary/users", gradesapi.CourseSubmissionSummaryHandler)
+	router.POST("/api/v1/courses/:courseID/grades/simulate", gradesapi.SimulateGradeHandler)
+	router.GET("/api/v1/courses/:courseID/grades/plan", gradesapi.GradePlanHandler)
 
 	router.PUT("/api/v1/courses/:courseID/hide", gradesapi.HideCourseHandler)
 	router.DELETE("/api/v1/courses/:courseID/hide", gradesapi.ShowCourseHandler)
 
 	// outcomes
 	router.GET("/api/v1/outcomes/:outcomeID", gradesapi.OutcomeHandler)
+	router.GET("/api/v1/courses/:courseID/outcome_proficiency", gradesapi.OutcomeProficiencyHandler)
 
 	// users
 	router.GET("/api/v1/users/:userID/profile", gradesapi.UserProfileHandler)
@@ -170,5 +205,20 @@ func main() {
 	// Flush sentry queue
 	defer sentry.Flush(5 * time.Second)
 
+	if env.Env == env.EnvironmentDevelopment && len(env.CanvasFakeFixtures) > 0 {
+		fixtures, err := canvasfake.LoadFixtures(env.CanvasFakeFixtures)
+		if err != nil {
+			panic(errors.Wrap(err, "error loading fake canvas fixtures"))
+		}
+
+		fmt.Println("Using a fake Canvas at " + env.CanvasFakeAddr)
+		go func() {
+			log.Fatal(http.ListenAndServe(env.CanvasFakeAddr, canvasfake.NewServer(*fixtures)))
+		}()
+	}
+
+	gradesapi.StartFetchAllWorkers()
+	scheduler.Start(scheduler.Jobs)
+
 	log.Fatal(http.ListenAndServe(env.HTTPPort, mw))
 }
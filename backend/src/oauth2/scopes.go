@@ -19,6 +19,8 @@ const (
 	ScopeNotifications       = Scope("notifications")
 	ScopeEnrollments         = Scope("enrollments")
 	ScopeSubmissions         = Scope("submissions")
+	ScopeTranscript          = Scope("transcript")
+	ScopeGradeHistory        = Scope("grade_history")
 )
 
 // ValidateScopes ensures that all requested scopes are valid.
@@ -56,6 +58,8 @@ func ValidateScopes(scopes []string) (bool, *string) {
 		case ScopeNotifications:
 		case ScopeEnrollments:
 		case ScopeSubmissions:
+		case ScopeTranscript:
+		case ScopeGradeHistory:
 		default:
 			return false, &s
 		}
@@ -39,7 +39,10 @@ func GetAverageGradeForCourseHandler(w http.ResponseWriter, req *http.Request, p
 		return
 	}
 
-	obsP, err := db.ListObservees(&users.ListObserveesRequest{ObserverCanvasUserID: session.CanvasUserID})
+	obsP, err := db.ListObservees(&users.ListObserveesRequest{
+		ObserverCanvasUserID: session.CanvasUserID,
+		InstitutionID:        session.InstitutionID,
+	})
 	if err != nil {
 		util.HandleError(errors.Wrap(err, "error listing observees"))
 		util.SendInternalServerError(w)
@@ -56,7 +59,7 @@ func GetAverageGradeForCourseHandler(w http.ResponseWriter, req *http.Request, p
 		usersToList = append(usersToList, session.CanvasUserID)
 	}
 
-	avg, numFactors, err := db.GetMemoizedAverageGradeForCourse(uint64(cID), usersToList)
+	avg, numFactors, err := db.GetMemoizedAverageGradeForCourse(session.InstitutionID, uint64(cID), usersToList)
 	if err != nil {
 		util.HandleError(errors.Wrap(err, "error getting memoized average for course"))
 		util.SendInternalServerError(w)
@@ -83,8 +86,15 @@ func GetAverageGradeForCourseHandler(w http.ResponseWriter, req *http.Request, p
 	}
 
 	if len(ret.AverageGrade) < 1 {
+		policy, err := db.GetGradingPolicyForCourse(session.InstitutionID, uint64(cID))
+		if err != nil {
+			util.HandleError(errors.Wrap(err, "error getting grading policy for course"))
+			util.SendInternalServerError(w)
+			return
+		}
+
 		ret.NumFactors = int(*numFactors)
-		ret.AverageGrade = util.ConvertGradeAverageToString(*avg)
+		ret.AverageGrade = policy.GradeForRank(*avg)
 	}
 
 	jret, err := json.Marshal(ret)
@@ -31,6 +31,7 @@ func GetPreviousGradesHandler(w http.ResponseWriter, req *http.Request, _ httpro
 
 	obsP, err := db.ListObservees(&users.ListObserveesRequest{
 		ObserverCanvasUserID: session.CanvasUserID,
+		InstitutionID:        session.InstitutionID,
 		ActiveOnly:           true,
 	})
 	if err != nil {
@@ -52,6 +53,7 @@ func GetPreviousGradesHandler(w http.ResponseWriter, req *http.Request, _ httpro
 	}
 
 	gsP, err := db.GetGradesForUserBeforeDate(
+		session.InstitutionID,
 		userIdsToGetGradesFor,
 		time.Now().Add(-(time.Minute * 5)),
 	)
@@ -40,7 +40,10 @@ func GetAverageOutcomeScoreHandler(w http.ResponseWriter, req *http.Request, ps
 		return
 	}
 
-	obsP, err := db.ListObservees(&users.ListObserveesRequest{ObserverCanvasUserID: session.CanvasUserID})
+	obsP, err := db.ListObservees(&users.ListObserveesRequest{
+		ObserverCanvasUserID: session.CanvasUserID,
+		InstitutionID:        session.InstitutionID,
+	})
 	if err != nil {
 		util.HandleError(errors.Wrap(err, "error listing observees"))
 		util.SendInternalServerError(w)
@@ -0,0 +1,274 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+// bitsOf makes a parseScheduleField bitset, for tests.
+func bitsOf(vs ...int) uint64 {
+	var bits uint64
+	for _, v := range vs {
+		bits |= 1 << uint(v)
+	}
+
+	return bits
+}
+
+func Test_parseScheduleField(t *testing.T) {
+	tests := []struct {
+		name     string
+		field    string
+		min, max int
+		want     uint64
+		wantErr  bool
+	}{
+		{name: "value", field: "5", min: 0, max: 59, want: bitsOf(5)},
+		{name: "star", field: "*", min: 1, max: 12, want: bitsOf(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12)},
+		{name: "star_step", field: "*/15", min: 0, max: 59, want: bitsOf(0, 15, 30, 45)},
+		{name: "value_step", field: "5/15", min: 0, max: 59, want: bitsOf(5, 20, 35, 50)},
+		{name: "range_step", field: "0-12/2", min: 0, max: 23, want: bitsOf(0, 2, 4, 6, 8, 10, 12)},
+		{name: "range", field: "1-5", min: 0, max: 7, want: bitsOf(1, 2, 3, 4, 5)},
+		{name: "list", field: "1,3,10-12", min: 1, max: 31, want: bitsOf(1, 3, 10, 11, 12)},
+		{name: "list_with_steps", field: "0-10/5,*/20", min: 0, max: 59, want: bitsOf(0, 5, 10, 20, 40)},
+		{name: "single_value_range", field: "7-7", min: 0, max: 7, want: bitsOf(7)},
+		{name: "below_min", field: "0", min: 1, max: 31, wantErr: true},
+		{name: "above_max", field: "60", min: 0, max: 59, wantErr: true},
+		{name: "range_above_max", field: "10-24", min: 0, max: 23, wantErr: true},
+		{name: "backwards_range", field: "5-1", min: 0, max: 59, wantErr: true},
+		{name: "zero_step", field: "*/0", min: 0, max: 59, wantErr: true},
+		{name: "negative_step", field: "*/-1", min: 0, max: 59, wantErr: true},
+		{name: "non_numeric_step", field: "*/x", min: 0, max: 59, wantErr: true},
+		{name: "non_numeric_value", field: "a", min: 0, max: 59, wantErr: true},
+		{name: "non_numeric_range", field: "1-a", min: 0, max: 59, wantErr: true},
+		{name: "negative_value", field: "-1", min: 0, max: 59, wantErr: true},
+		{name: "empty_list_item", field: "1,,2", min: 0, max: 59, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseScheduleField(tt.field, tt.min, tt.max)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseScheduleField() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseScheduleField() got = %b, want %b", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseSchedule(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr bool
+	}{
+		{name: "every_minute", expr: "* * * * *"},
+		{name: "extra_whitespace", expr: " 0  0 * *\t1 "},
+		{name: "hourly", expr: "@hourly"},
+		{name: "daily", expr: "@daily"},
+		{name: "weekly", expr: "@weekly"},
+		{name: "monthly", expr: "@monthly"},
+		{name: "unknown_descriptor", expr: "@yearly", wantErr: true},
+		{name: "empty", expr: "", wantErr: true},
+		{name: "too_few_fields", expr: "* * * *", wantErr: true},
+		{name: "too_many_fields", expr: "* * * * * *", wantErr: true},
+		{name: "minute_out_of_range", expr: "60 * * * *", wantErr: true},
+		{name: "hour_out_of_range", expr: "* 24 * * *", wantErr: true},
+		{name: "dom_zero", expr: "* * 0 * *", wantErr: true},
+		{name: "dom_out_of_range", expr: "* * 32 * *", wantErr: true},
+		{name: "month_zero", expr: "* * * 0 *", wantErr: true},
+		{name: "month_out_of_range", expr: "* * * 13 *", wantErr: true},
+		{name: "dow_out_of_range", expr: "* * * * 8", wantErr: true},
+		{name: "month_names", expr: "* * * JAN *", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseSchedule(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_schedule_next(t *testing.T) {
+	// at makes a UTC time. 2020-01-01 is a Wednesday.
+	at := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{
+			name: "every_minute",
+			expr: "* * * * *",
+			from: at(2020, 1, 1, 0, 0),
+			want: at(2020, 1, 1, 0, 1),
+		},
+		{
+			name: "strictly_after",
+			expr: "0 0 * * *",
+			from: at(2020, 1, 1, 0, 0),
+			want: at(2020, 1, 2, 0, 0),
+		},
+		{
+			name: "seconds_are_ignored",
+			expr: "*/15 * * * *",
+			from: at(2020, 1, 1, 0, 14).Add(59 * time.Second),
+			want: at(2020, 1, 1, 0, 15),
+		},
+		{
+			name: "star_step",
+			expr: "*/15 * * * *",
+			from: at(2020, 1, 1, 0, 50),
+			want: at(2020, 1, 1, 1, 0),
+		},
+		{
+			name: "value_step",
+			expr: "5/15 * * * *",
+			from: at(2020, 1, 1, 0, 20),
+			want: at(2020, 1, 1, 0, 35),
+		},
+		{
+			name: "value_step_next_hour",
+			expr: "5/15 * * * *",
+			from: at(2020, 1, 1, 0, 50),
+			want: at(2020, 1, 1, 1, 5),
+		},
+		{
+			name: "range_step",
+			expr: "0 0-12/2 * * *",
+			from: at(2020, 1, 1, 1, 0),
+			want: at(2020, 1, 1, 2, 0),
+		},
+		{
+			name: "range_step_next_day",
+			expr: "0 0-12/2 * * *",
+			from: at(2020, 1, 1, 12, 0),
+			want: at(2020, 1, 2, 0, 0),
+		},
+		{
+			name: "lists",
+			expr: "0,30 9,17 * * *",
+			from: at(2020, 1, 1, 9, 30),
+			want: at(2020, 1, 1, 17, 0),
+		},
+		{
+			name: "dow_range",
+			expr: "0 9 * * 1-5",
+			from: at(2020, 1, 3, 10, 0),
+			want: at(2020, 1, 6, 9, 0),
+		},
+		{
+			name: "dow_0_is_sunday",
+			expr: "0 0 * * 0",
+			from: at(2020, 1, 1, 0, 0),
+			want: at(2020, 1, 5, 0, 0),
+		},
+		{
+			name: "dow_7_is_sunday",
+			expr: "0 0 * * 7",
+			from: at(2020, 1, 1, 0, 0),
+			want: at(2020, 1, 5, 0, 0),
+		},
+		{
+			name: "dom_only",
+			expr: "0 0 13 * *",
+			from: at(2020, 1, 1, 0, 0),
+			want: at(2020, 1, 13, 0, 0),
+		},
+		{
+			// both day fields are restricted, so Friday the 3rd matches even though it isn't the 13th
+			name: "either_day_dow_first",
+			expr: "0 0 13 * 5",
+			from: at(2020, 1, 1, 0, 0),
+			want: at(2020, 1, 3, 0, 0),
+		},
+		{
+			// and Monday the 13th matches even though it isn't a Friday
+			name: "either_day_dom_first",
+			expr: "0 0 13 * 5",
+			from: at(2020, 1, 11, 0, 0),
+			want: at(2020, 1, 13, 0, 0),
+		},
+		{
+			// a stepped star is still unrestricted, so only the 13th matches
+			name: "dow_star_step_is_unrestricted",
+			expr: "0 0 13 * */1",
+			from: at(2020, 1, 1, 0, 0),
+			want: at(2020, 1, 13, 0, 0),
+		},
+		{
+			name: "month",
+			expr: "0 0 1 6 *",
+			from: at(2020, 1, 1, 0, 0),
+			want: at(2020, 6, 1, 0, 0),
+		},
+		{
+			name: "next_year",
+			expr: "0 0 1 1 *",
+			from: at(2020, 1, 1, 0, 0),
+			want: at(2021, 1, 1, 0, 0),
+		},
+		{
+			name: "leap_day",
+			expr: "0 0 29 2 *",
+			from: at(2020, 3, 1, 0, 0),
+			want: at(2024, 2, 29, 0, 0),
+		},
+		{
+			name: "impossible",
+			expr: "0 0 30 2 *",
+			from: at(2020, 1, 1, 0, 0),
+			want: time.Time{},
+		},
+		{
+			name: "hourly",
+			expr: "@hourly",
+			from: at(2020, 1, 1, 0, 30),
+			want: at(2020, 1, 1, 1, 0),
+		},
+		{
+			name: "daily",
+			expr: "@daily",
+			from: at(2020, 1, 1, 0, 30),
+			want: at(2020, 1, 2, 0, 0),
+		},
+		{
+			name: "weekly",
+			expr: "@weekly",
+			from: at(2020, 1, 1, 0, 0),
+			want: at(2020, 1, 5, 0, 0),
+		},
+		{
+			name: "monthly",
+			expr: "@monthly",
+			from: at(2020, 1, 1, 0, 0),
+			want: at(2020, 2, 1, 0, 0),
+		},
+		{
+			// schedules are in UTC: 2020-01-01 23:30 in UTC-5 is 2020-01-02 04:30 UTC
+			name: "from_is_converted_to_utc",
+			expr: "0 5 * * *",
+			from: time.Date(2020, 1, 1, 23, 30, 0, 0, time.FixedZone("UTC-5", -5*60*60)),
+			want: at(2020, 1, 2, 5, 0),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseSchedule(tt.expr)
+			if err != nil {
+				t.Fatalf("parseSchedule() error = %v", err)
+			}
+
+			if got := s.next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("next() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"github.com/iamtheyammer/canvascbl/backend/src/env"
+	"github.com/iamtheyammer/canvascbl/backend/src/gradesapi"
+	"time"
+)
+
+// Jobs are the jobs the backend runs on a schedule. Their schedules are in env, so a deployment can change them,
+// or turn them off with an empty schedule.
+var Jobs = []Job{
+	{
+		Name:     "fetch_all",
+		Schedule: env.ScheduleFetchAll,
+		Timeout:  time.Minute,
+		Run: func(_ context.Context) error {
+			runID, err := gradesapi.EnqueueFetchAllRun()
+			if err != nil {
+				return fmt.Errorf("error queueing fetch_all run: %w", err)
+			}
+
+			fmt.Printf("INFO: Queued fetch_all run %s.\n", runID)
+			return nil
+		},
+	},
+	{
+		Name:     "reconcile_fetch_runs",
+		Schedule: env.ScheduleReconcileFetchRuns,
+		Timeout:  10 * time.Minute,
+		Run:      gradesapi.ReconcileFetchAllRuns,
+	},
+	{
+		Name:     "grade_digests",
+		Schedule: env.ScheduleGradeDigests,
+		Timeout:  30 * time.Minute,
+		Run:      gradesapi.SendGradeDigests,
+	},
+}
@@ -0,0 +1,211 @@
+/*
+Package scheduler runs jobs, like fetch_all, on cron schedules inside the backend.
+
+Every server runs the scheduler, but each job only runs on its leader: the server holding the job's Postgres
+advisory lock. Advisory locks belong to a database connection, so the leader holds one connection per job it leads,
+and if the leader goes away, its connection closes, its locks are released and another server takes over within
+leaderRetryInterval. A run that was due while no server led the job is skipped.
+
+Leaders record their jobs in the scheduled_jobs table, which is how the admin API shows their status.
+*/
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/iamtheyammer/canvascbl/backend/src/db/services/scheduled_jobs"
+	"github.com/iamtheyammer/canvascbl/backend/src/util"
+	"hash/fnv"
+	"os"
+	"time"
+)
+
+const (
+	// leaderRetryInterval is how often a server that doesn't lead a job tries to become its leader.
+	leaderRetryInterval = time.Minute
+	// advisoryLockPrefix namespaces job names, so their locks don't collide with other advisory locks.
+	advisoryLockPrefix = "canvascbl_scheduler:"
+)
+
+// Job is a task that runs on a schedule, on one server at a time.
+type Job struct {
+	// Name identifies the job, and its advisory lock is derived from it, so it must not change.
+	Name string
+	// Schedule is a cron expression (see schedule). If it's empty, the job doesn't run.
+	Schedule string
+	// Timeout, if not zero, is how long a run can take before its context is canceled.
+	Timeout time.Duration
+	Run     func(ctx context.Context) error
+}
+
+var (
+	db = util.DB
+	// leaderName identifies this server in scheduled_jobs.
+	leaderName = func() string {
+		host, err := os.Hostname()
+		if err != nil {
+			host = "unknown"
+		}
+
+		return fmt.Sprintf("%s:%d", host, os.Getpid())
+	}()
+)
+
+// Start starts running every job in jobs with a schedule, until the process exits.
+// It panics if a schedule isn't valid, like env does with invalid variables.
+func Start(jobs []Job) {
+	for _, j := range jobs {
+		if len(j.Schedule) < 1 {
+			continue
+		}
+
+		s, err := parseSchedule(j.Schedule)
+		if err != nil {
+			panic(fmt.Errorf("error parsing schedule for scheduled job %s: %w", j.Name, err))
+		}
+
+		if s.next(time.Now()).IsZero() {
+			panic(fmt.Errorf("the schedule for scheduled job %s (%s) never runs", j.Name, j.Schedule))
+		}
+
+		go lead(j, s)
+	}
+}
+
+// lead runs j on its schedule whenever this server is its leader.
+func lead(j Job, s *schedule) {
+	var conn *sql.Conn
+
+	for {
+		if conn == nil {
+			c, err := acquireLeadership(j.Name)
+			if err != nil {
+				util.HandleError(fmt.Errorf("error trying to lead scheduled job %s: %w", j.Name, err))
+			}
+
+			if c == nil {
+				time.Sleep(leaderRetryInterval)
+				continue
+			}
+
+			fmt.Printf("INFO: Leading scheduled job %s.\n", j.Name)
+			conn = c
+		}
+
+		next := s.next(time.Now())
+		err := scheduled_jobs.Upsert(db, &scheduled_jobs.UpsertRequest{
+			Name:      j.Name,
+			Schedule:  j.Schedule,
+			Leader:    leaderName,
+			NextRunAt: next,
+		})
+		if err != nil {
+			util.HandleError(fmt.Errorf("error recording scheduled job %s: %w", j.Name, err))
+		}
+
+		err = holdUntil(conn, next)
+		if err != nil {
+			util.HandleError(fmt.Errorf("lost leadership of scheduled job %s: %w", j.Name, err))
+			_ = conn.Close()
+			conn = nil
+			continue
+		}
+
+		run(j)
+	}
+}
+
+// acquireLeadership tries to take a job's advisory lock, returning the connection holding it,
+// or nil if another server has it.
+func acquireLeadership(name string) (*sql.Conn, error) {
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("error getting a database connection: %w", err)
+	}
+
+	var locked bool
+	err = conn.QueryRowContext(context.Background(), "SELECT pg_try_advisory_lock($1)", advisoryLockKey(name)).
+		Scan(&locked)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("error executing try advisory lock sql: %w", err)
+	}
+
+	if !locked {
+		return nil, conn.Close()
+	}
+
+	return conn, nil
+}
+
+/*
+holdUntil waits until t, checking the leader's connection every leaderRetryInterval, which also keeps it from
+being closed for being idle. The lock is only held while its connection is open, so if it closed,
+another server may have taken over, and an error is returned.
+*/
+func holdUntil(conn *sql.Conn, t time.Time) error {
+	for {
+		wait := time.Until(t)
+		if wait > leaderRetryInterval {
+			wait = leaderRetryInterval
+		}
+
+		time.Sleep(wait)
+
+		_, err := conn.ExecContext(context.Background(), "SELECT 1")
+		if err != nil {
+			return err
+		}
+
+		if !time.Now().Before(t) {
+			return nil
+		}
+	}
+}
+
+// advisoryLockKey is the key of a job's advisory lock.
+func advisoryLockKey(name string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(advisoryLockPrefix + name))
+	return int64(h.Sum64())
+}
+
+// run runs j once, recording when it started and finished.
+func run(j Job) {
+	err := scheduled_jobs.Start(db, j.Name)
+	if err != nil {
+		util.HandleError(fmt.Errorf("error recording start of scheduled job %s: %w", j.Name, err))
+	}
+
+	ctx := context.Background()
+	if j.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, j.Timeout)
+		defer cancel()
+	}
+
+	req := scheduled_jobs.FinishRequest{Name: j.Name}
+
+	runErr := runRecovered(ctx, j)
+	if runErr != nil {
+		req.Error = runErr.Error()
+		util.HandleError(fmt.Errorf("error running scheduled job %s: %w", j.Name, runErr))
+	}
+
+	err = scheduled_jobs.Finish(db, &req)
+	if err != nil {
+		util.HandleError(fmt.Errorf("error recording finish of scheduled job %s: %w", j.Name, err))
+	}
+}
+
+// runRecovered runs j, turning a panic into an error, as one would take the whole server down.
+func runRecovered(ctx context.Context, j Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return j.Run(ctx)
+}
@@ -1,6 +1,9 @@
 package util
 
-import "strings"
+import (
+	"github.com/iamtheyammer/canvascbl/backend/src/env"
+	"strings"
+)
 
 var scopes = []string{
 	"url:GET|/api/v1/outcomes/:id",
@@ -66,7 +69,16 @@ var scopes = []string{
 	/* END BLOCK 4/15/20 */
 }
 
-var stringScopes = strings.Join(scopes, " ")
+// graphQLScope lets the grades pipeline use Canvas's GraphQL API. It's only requested if env.CanvasGraphQL is set.
+const graphQLScope = "url:POST|/api/graphql"
+
+var stringScopes = func() string {
+	if env.CanvasGraphQL {
+		return strings.Join(append(scopes, graphQLScope), " ")
+	}
+
+	return strings.Join(scopes, " ")
+}()
 
 // GetScopesList gets the static string list of scopes.
 func GetScopesList() string {
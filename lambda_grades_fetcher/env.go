@@ -10,6 +10,9 @@ var (
 	scriptKey   = getEnvOrPanic("SCRIPT_KEY")
 	environment = getEnv("ENVIRONMENT", "unknown")
 	awsRegion   = getEnv("AWS_REGION", "us-east-2")
+	// logSink is where error logs go: "s3" (s3Bucket), "stdout" (CloudWatch on Lambda) or "none".
+	logSink  = getEnv("LOG_SINK", "s3")
+	s3Bucket = getEnv("S3_BUCKET", "canvascbl-fetch-all-grades-logs")
 )
 
 func getEnvOrPanic(key string) string {
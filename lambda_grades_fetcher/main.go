@@ -7,27 +7,19 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 )
 
-const (
-	s3Bucket = "canvascbl-fetch-all-grades-logs"
-)
-
 var (
-	ul = func() *s3manager.Uploader {
-		s, err := session.NewSession(&aws.Config{
-			Region: aws.String(awsRegion),
-		})
-		if err != nil {
-			panic(fmt.Errorf("error creating aws session: %w", err))
-		}
-
-		upl := s3manager.NewUploader(s)
-		return upl
-	}()
+	// ul is created by getUploader, so there's no aws session unless something is uploaded.
+	ul     *s3manager.Uploader
+	ulOnce sync.Once
+	ulErr  error
 	client = http.Client{}
 	apiURL = func() *url.URL {
 		parsedURL, err := url.Parse(apiURLEnv)
@@ -50,33 +42,74 @@ func generateFilename() string {
 	return "lgf-error-" + time.Now().Format(time.RFC3339) + "-" + environment + ".json"
 }
 
+func getUploader() (*s3manager.Uploader, error) {
+	ulOnce.Do(func() {
+		s, err := session.NewSession(&aws.Config{
+			Region: aws.String(awsRegion),
+		})
+		if err != nil {
+			ulErr = fmt.Errorf("error creating aws session: %w", err)
+			return
+		}
+
+		ul = s3manager.NewUploader(s)
+	})
+
+	return ul, ulErr
+}
+
+// writeLog writes body to the configured LOG_SINK.
+func writeLog(name string, body io.Reader) error {
+	switch logSink {
+	case "s3":
+		upl, err := getUploader()
+		if err != nil {
+			return err
+		}
+
+		_, err = upl.Upload(&s3manager.UploadInput{
+			Bucket:      aws.String(s3Bucket),
+			ContentType: aws.String("application/json"),
+			Key:         aws.String(name),
+			Body:        body,
+		})
+		if err != nil {
+			return fmt.Errorf("error uploading to s3: %w", err)
+		}
+	case "stdout":
+		b, err := ioutil.ReadAll(body)
+		if err != nil {
+			return fmt.Errorf("error reading log: %w", err)
+		}
+
+		fmt.Printf("%s: %s\n", name, b)
+	case "none", "":
+	default:
+		return fmt.Errorf("unknown LOG_SINK %q (must be s3, stdout or none)", logSink)
+	}
+
+	return nil
+}
+
 func HandleLambdaEvent() error {
 	// create a new context
 	ctx := context.Background()
 	// add a 1 second deadline (enough to connect and process the request)
 	ctx, cancel := context.WithDeadline(ctx, time.Now().Add(time.Second*1))
+	// you're supposed to always call cancel
+	defer cancel()
 	// add context to request
 	r := req.WithContext(ctx)
 	// make the request
 	resp, err := client.Do(r)
-	// if an error occurs and resp != nil upload error to s3
+	// if an error occurs and resp != nil write the error to the log sink
 	if err != nil && resp != nil {
-		input := &s3manager.UploadInput{
-			Bucket:      aws.String(s3Bucket),
-			ContentType: aws.String("application/json"),
-			Key:         aws.String(generateFilename()),
-			Body:        resp.Body,
-		}
-
-		_, err := ul.Upload(input)
+		err := writeLog(generateFilename(), resp.Body)
 		if err != nil {
-			return fmt.Errorf("error uploading to s3: %w", err)
+			return err
 		}
 	}
 
-	// you're supposed to always call cancel
-	cancel()
-
 	return nil
 }
 